@@ -0,0 +1,564 @@
+package mediawiki
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type ArticleBlockType int
+
+const (
+	ParagraphBlock ArticleBlockType = iota
+	ListBlock
+)
+
+// ArticleDocumentOptions configures which parts of the article are removed
+// by ParseArticleDocument.
+type ArticleDocumentOptions struct {
+	// StripReferences removes reference markers and reference lists.
+	StripReferences bool
+	// StripNavboxes removes navigation boxes.
+	StripNavboxes bool
+	// StripHatnotes removes hatnotes (e.g., "For other uses, see ...").
+	StripHatnotes bool
+	// StripInfoboxes removes infoboxes.
+	StripInfoboxes bool
+	// Namespaces maps additional (e.g., localized) namespace names to namespace IDs,
+	// for InternalLinks. Canonical namespace names are always recognized.
+	Namespaces map[string]int
+	// Entities maps page titles to their Wikidata entity IDs, for Tables.
+	Entities map[string]string
+}
+
+// TextSpan maps a range of ArticleDocument.Text to the HTML text node it comes from.
+//
+// Start and End are byte offsets into ArticleDocument.Text.
+type TextSpan struct {
+	Start int
+	End   int
+	Node  *html.Node
+}
+
+// ArticleListItem is an item of a list, with its nested list items.
+//
+// Start and End are byte offsets of the item's own text in ArticleDocument.Text.
+type ArticleListItem struct {
+	Text  string
+	Start int
+	End   int
+	Node  *html.Node
+	Items []ArticleListItem
+}
+
+// ArticleBlock is a paragraph or a list in an article section.
+//
+// For lists, Text contains all list items, one per line.
+type ArticleBlock struct {
+	Type  ArticleBlockType
+	Text  string
+	Start int
+	End   int
+	Node  *html.Node
+	Items []ArticleListItem
+}
+
+// ArticleSection is a section of an article, with its nested sections.
+//
+// The lead section has Level 0 and no heading. Other sections have the level
+// of their heading (2 for h2, and so on), and Node is the heading element.
+// Start and End are byte offsets in ArticleDocument.Text, spanning the heading,
+// blocks, and nested sections.
+type ArticleSection struct {
+	Heading  string
+	Level    int
+	Anchor   string
+	Start    int
+	End      int
+	Node     *html.Node
+	Blocks   []ArticleBlock
+	Sections []*ArticleSection
+}
+
+// ArticleDocument is an article's Parsoid HTML parsed into a tree of sections.
+//
+// Text is clean plain text of the whole article with headings and blocks
+// separated by empty lines and list items by newlines. Spans map its
+// ranges back to HTML text nodes, in order.
+type ArticleDocument struct {
+	Root     *html.Node
+	Sections []*ArticleSection
+	Text     string
+	Spans    []TextSpan
+
+	options *ArticleDocumentOptions
+}
+
+// NodeAt returns the HTML text node from which comes text at the byte offset
+// in Text, or nil if there is no such node (e.g., at separators).
+func (d *ArticleDocument) NodeAt(offset int) *html.Node {
+	i := sort.Search(len(d.Spans), func(i int) bool {
+		return d.Spans[i].End > offset
+	})
+	if i < len(d.Spans) && d.Spans[i].Start <= offset {
+		return d.Spans[i].Node
+	}
+	return nil
+}
+
+// htmlAttr returns the value of the attribute of the element, or an empty string.
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// htmlHasToken returns true if the space-separated value of the attribute
+// of the element contains any of the tokens.
+func htmlHasToken(n *html.Node, key string, tokens ...string) bool {
+	for _, t := range strings.Fields(htmlAttr(n, key)) {
+		for _, token := range tokens {
+			if t == token {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// htmlHasClass returns true if the element has any of the classes.
+func htmlHasClass(n *html.Node, classes ...string) bool {
+	return htmlHasToken(n, "class", classes...)
+}
+
+// htmlHasTypeOf returns true if the element has any of the RDFa types,
+// like Parsoid's "mw:Extension/ref".
+func htmlHasTypeOf(n *html.Node, types ...string) bool {
+	return htmlHasToken(n, "typeof", types...)
+}
+
+// htmlHasRel returns true if the element has any of the link relations,
+// like Parsoid's "mw:WikiLink".
+func htmlHasRel(n *html.Node, rels ...string) bool {
+	return htmlHasToken(n, "rel", rels...)
+}
+
+func htmlIsReference(n *html.Node) bool {
+	return htmlHasTypeOf(n, "mw:Extension/ref") || (n.DataAtom == atom.Sup && htmlHasClass(n, "reference", "mw-ref"))
+}
+
+func htmlIsReferenceList(n *html.Node) bool {
+	return htmlHasTypeOf(n, "mw:Extension/references") || htmlHasClass(n, "references", "reflist", "mw-references-wrap", "refbegin")
+}
+
+func htmlIsHidden(n *html.Node) bool {
+	style := strings.ReplaceAll(htmlAttr(n, "style"), " ", "")
+	return strings.Contains(style, "display:none")
+}
+
+// htmlFindBody returns the body element, or nil if there is none.
+func htmlFindBody(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Body {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if body := htmlFindBody(c); body != nil {
+			return body
+		}
+	}
+	return nil
+}
+
+// headingLevel returns the level of the heading element, or 0 if it is not a heading.
+func headingLevel(n *html.Node) int {
+	switch n.DataAtom { //nolint:exhaustive
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return int(n.Data[1] - '0')
+	}
+	return 0
+}
+
+func isListElement(n *html.Node) bool {
+	return n.Type == html.ElementNode && (n.DataAtom == atom.Ul || n.DataAtom == atom.Ol || n.DataAtom == atom.Dl)
+}
+
+// isInlineNode returns true for nodes which are part of running text.
+func isInlineNode(n *html.Node) bool {
+	if n.Type == html.TextNode {
+		return true
+	}
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.DataAtom { //nolint:exhaustive
+	case atom.A, atom.Abbr, atom.B, atom.Bdi, atom.Bdo, atom.Big, atom.Br, atom.Cite, atom.Code,
+		atom.Data, atom.Del, atom.Dfn, atom.Em, atom.Font, atom.I, atom.Img, atom.Ins, atom.Kbd,
+		atom.Mark, atom.Math, atom.Q, atom.Rp, atom.Rt, atom.Ruby, atom.S, atom.Samp, atom.Small,
+		atom.Span, atom.Strike, atom.Strong, atom.Sub, atom.Sup, atom.Time, atom.Tt, atom.U, atom.Var, atom.Wbr:
+		return true
+	}
+	return false
+}
+
+type articleDocumentBuilder struct {
+	options  *ArticleDocumentOptions
+	document *ArticleDocument
+	text     strings.Builder
+	// Sections which are currently open, the innermost last.
+	stack []*ArticleSection
+
+	// State of the current block.
+	separator    string
+	blockStart   int
+	pendingSpace bool
+}
+
+// htmlStripped returns true if the element (and its content) should not be part of the text.
+func htmlStripped(n *html.Node, options *ArticleDocumentOptions) bool {
+	if n.Type == html.CommentNode {
+		return true
+	}
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.DataAtom { //nolint:exhaustive
+	case atom.Style, atom.Script, atom.Link, atom.Meta, atom.Noscript, atom.Head, atom.Title:
+		return true
+	}
+	if htmlIsHidden(n) {
+		return true
+	}
+	if options.StripReferences && (htmlIsReference(n) || htmlIsReferenceList(n)) {
+		return true
+	}
+	if options.StripNavboxes && (htmlHasClass(n, "navbox", "vertical-navbox", "navbox-styles") || htmlAttr(n, "role") == "navigation") {
+		return true
+	}
+	if options.StripHatnotes && (htmlHasClass(n, "hatnote", "dablink") || htmlAttr(n, "role") == "note") {
+		return true
+	}
+	if options.StripInfoboxes {
+		for _, class := range strings.Fields(htmlAttr(n, "class")) {
+			if strings.HasPrefix(class, "infobox") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *articleDocumentBuilder) stripped(n *html.Node) bool {
+	return htmlStripped(n, b.options)
+}
+
+// beginBlock starts a new block which is separated from existing text with separator
+// once (and if) the block gets any text.
+func (b *articleDocumentBuilder) beginBlock(separator string) {
+	b.separator = separator
+	b.blockStart = -1
+	b.pendingSpace = false
+}
+
+// endBlock ends the current block and returns its range, or false if it is empty.
+func (b *articleDocumentBuilder) endBlock() (int, int, bool) {
+	start := b.blockStart
+	b.blockStart = -1
+	b.pendingSpace = false
+	if start < 0 {
+		return 0, 0, false
+	}
+	return start, b.text.Len(), true
+}
+
+// write appends text of the text node to the current block, collapsing whitespace.
+func (b *articleDocumentBuilder) write(n *html.Node, s string) {
+	start := -1
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			b.pendingSpace = true
+			continue
+		}
+		if b.blockStart < 0 {
+			if b.text.Len() > 0 {
+				b.text.WriteString(b.separator)
+			}
+			b.blockStart = b.text.Len()
+		} else if b.pendingSpace {
+			b.text.WriteByte(' ')
+		}
+		b.pendingSpace = false
+		if start < 0 {
+			start = b.text.Len()
+		}
+		b.text.WriteRune(r)
+	}
+	if start >= 0 {
+		b.document.Spans = append(b.document.Spans, TextSpan{Start: start, End: b.text.Len(), Node: n})
+	}
+}
+
+// inline appends text of the node and its descendants to the current block.
+// Nested lists are skipped.
+func (b *articleDocumentBuilder) inline(n *html.Node) {
+	switch {
+	case n.Type == html.TextNode:
+		b.write(n, n.Data)
+		return
+	case b.stripped(n), isListElement(n):
+		return
+	case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+		b.pendingSpace = true
+		return
+	case n.Type == html.ElementNode && (n.DataAtom == atom.Table || n.DataAtom == atom.Figure):
+		// Tables and figures are not part of running text, even when nested.
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.inline(c)
+	}
+}
+
+func (b *articleDocumentBuilder) slice(start, end int) string {
+	return b.text.String()[start:end]
+}
+
+func (b *articleDocumentBuilder) current() *ArticleSection {
+	return b.stack[len(b.stack)-1]
+}
+
+func (b *articleDocumentBuilder) heading(n *html.Node, level int) {
+	b.beginBlock("\n\n")
+	b.inline(n)
+	start, end, ok := b.endBlock()
+	section := &ArticleSection{
+		Heading:  "",
+		Level:    level,
+		Anchor:   htmlAttr(n, "id"),
+		Start:    -1,
+		End:      -1,
+		Node:     n,
+		Blocks:   nil,
+		Sections: nil,
+	}
+	if ok {
+		section.Heading = b.slice(start, end)
+		section.Start = start
+		section.End = end
+	}
+	for len(b.stack) > 1 && b.current().Level >= level {
+		b.stack = b.stack[:len(b.stack)-1]
+	}
+	if len(b.stack) == 1 && level > 0 {
+		// Top-level sections are siblings of the lead section.
+		b.document.Sections = append(b.document.Sections, section)
+	} else {
+		b.current().Sections = append(b.current().Sections, section)
+	}
+	b.stack = append(b.stack, section)
+}
+
+func (b *articleDocumentBuilder) paragraph(n *html.Node, inlines []*html.Node) {
+	b.beginBlock("\n\n")
+	for _, c := range inlines {
+		b.inline(c)
+	}
+	start, end, ok := b.endBlock()
+	if !ok {
+		return
+	}
+	b.current().Blocks = append(b.current().Blocks, ArticleBlock{
+		Type:  ParagraphBlock,
+		Text:  b.slice(start, end),
+		Start: start,
+		End:   end,
+		Node:  n,
+		Items: nil,
+	})
+}
+
+// listItems appends list items of the list element and returns them.
+// The first item is separated from existing text with separator.
+func (b *articleDocumentBuilder) listItems(n *html.Node, separator string, listStart *int) []ArticleListItem {
+	var items []ArticleListItem
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || b.stripped(c) {
+			continue
+		}
+		if c.DataAtom != atom.Li && c.DataAtom != atom.Dt && c.DataAtom != atom.Dd {
+			continue
+		}
+		if *listStart >= 0 {
+			separator = "\n"
+		}
+		b.beginBlock(separator)
+		b.inline(c)
+		start, end, ok := b.endBlock()
+		item := ArticleListItem{
+			Text:  "",
+			Start: start,
+			End:   end,
+			Node:  c,
+			Items: nil,
+		}
+		if ok {
+			item.Text = b.slice(start, end)
+			if *listStart < 0 {
+				*listStart = start
+			}
+		} else {
+			item.Start = b.text.Len()
+			item.End = item.Start
+		}
+		for l := c.FirstChild; l != nil; l = l.NextSibling {
+			if isListElement(l) && !b.stripped(l) {
+				item.Items = append(item.Items, b.listItems(l, separator, listStart)...)
+			}
+		}
+		if ok || len(item.Items) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (b *articleDocumentBuilder) list(n *html.Node) {
+	listStart := -1
+	items := b.listItems(n, "\n\n", &listStart)
+	if listStart < 0 {
+		return
+	}
+	end := b.text.Len()
+	b.current().Blocks = append(b.current().Blocks, ArticleBlock{
+		Type:  ListBlock,
+		Text:  b.slice(listStart, end),
+		Start: listStart,
+		End:   end,
+		Node:  n,
+		Items: items,
+	})
+}
+
+// blocks processes children of the node as blocks. Consecutive inline
+// children are processed as one paragraph.
+func (b *articleDocumentBuilder) blocks(n *html.Node) {
+	var inlines []*html.Node
+	flush := func() {
+		if len(inlines) > 0 {
+			b.paragraph(n, inlines)
+			inlines = nil
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if b.stripped(c) {
+			continue
+		}
+		if isInlineNode(c) {
+			inlines = append(inlines, c)
+			continue
+		}
+		flush()
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if level := headingLevel(c); level > 0 {
+			b.heading(c, level)
+			continue
+		}
+		switch c.DataAtom { //nolint:exhaustive
+		case atom.P, atom.Pre:
+			b.paragraph(c, []*html.Node{c})
+		case atom.Ul, atom.Ol, atom.Dl:
+			if !htmlHasClass(c, "gallery") {
+				b.list(c)
+			}
+		case atom.Table, atom.Figure, atom.Hr, atom.Img, atom.Audio, atom.Video:
+			// Not part of the text.
+		default:
+			// Containers like section, div, and blockquote.
+			b.blocks(c)
+		}
+	}
+	flush()
+}
+
+// finishArticleSection sets ranges of the section and its nested sections from their content.
+func finishArticleSection(section *ArticleSection, offset int) {
+	if section.Start < 0 {
+		section.Start = offset
+		section.End = offset
+		if len(section.Blocks) > 0 {
+			section.Start = section.Blocks[0].Start
+		} else if len(section.Sections) > 0 {
+			finishArticleSection(section.Sections[0], offset)
+			section.Start = section.Sections[0].Start
+		}
+	}
+	if len(section.Blocks) > 0 {
+		section.End = section.Blocks[len(section.Blocks)-1].End
+	}
+	for _, s := range section.Sections {
+		finishArticleSection(s, section.End)
+		if s.End > section.End {
+			section.End = s.End
+		}
+	}
+}
+
+// ParseArticleDocument parses Parsoid HTML of an article (e.g., Article.ArticleBody.HTML)
+// into a tree of sections with paragraphs and lists, and clean plain text.
+//
+// Tables and figures are not included in the text.
+func ParseArticleDocument(body string, options *ArticleDocumentOptions) (*ArticleDocument, errors.E) {
+	root, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, errors.WithMessage(err, "html parse")
+	}
+	if options == nil {
+		options = &ArticleDocumentOptions{} //nolint:exhaustruct
+	}
+
+	lead := &ArticleSection{
+		Heading:  "",
+		Level:    0,
+		Anchor:   "",
+		Start:    0,
+		End:      0,
+		Node:     nil,
+		Blocks:   nil,
+		Sections: nil,
+	}
+	document := &ArticleDocument{
+		Root:     root,
+		Sections: []*ArticleSection{lead},
+		Text:     "",
+		Spans:    nil,
+
+		options: options,
+	}
+	b := &articleDocumentBuilder{ //nolint:exhaustruct
+		options:  options,
+		document: document,
+		stack:    []*ArticleSection{lead},
+	}
+	content := htmlFindBody(root)
+	if content == nil {
+		content = root
+	}
+	b.blocks(content)
+
+	document.Text = b.text.String()
+	if len(lead.Blocks) > 0 {
+		lead.End = lead.Blocks[len(lead.Blocks)-1].End
+	}
+	for _, section := range document.Sections[1:] {
+		finishArticleSection(section, lead.End)
+	}
+	return document, nil
+}
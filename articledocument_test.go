@@ -0,0 +1,135 @@
+package mediawiki_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func readArticleHTML(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseArticleDocument(t *testing.T) {
+	t.Parallel()
+
+	document, errE := mediawiki.ParseArticleDocument(readArticleHTML(t, "article.html"), &mediawiki.ArticleDocumentOptions{
+		StripReferences: true,
+		StripNavboxes:   true,
+		StripHatnotes:   true,
+		StripInfoboxes:  true,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, "Ljubljana is the capital and largest city of Slovenia. It is the country's cultural, educational, economic, and political centre.\n\n"+
+		"History\n\nThe area was settled by the Romans as Emona.\n\n"+
+		"Middle Ages\n\nLater rulers included:\n\nHabsburgs\nAustrian line\nSpanish line\nCarniolans\n\n"+
+		"Geography\n\nThe city lies on the Ljubljanica river. It has an area of 163.8 km2.\n\nElevation\n295 m\n\n"+
+		"References", document.Text)
+
+	require.Len(t, document.Sections, 4)
+	lead := document.Sections[0]
+	assert.Equal(t, 0, lead.Level)
+	assert.Empty(t, lead.Heading)
+	require.Len(t, lead.Blocks, 1)
+	assert.Equal(t, mediawiki.ParagraphBlock, lead.Blocks[0].Type)
+	assert.Equal(t, "mwBg", htmlAttr(lead.Blocks[0].Node, "id"))
+
+	history := document.Sections[1]
+	assert.Equal(t, "History", history.Heading)
+	assert.Equal(t, "History", history.Anchor)
+	assert.Equal(t, 2, history.Level)
+	require.Len(t, history.Sections, 1)
+	assert.Equal(t, "History\n\nThe area was settled by the Romans as Emona.\n\nMiddle Ages\n\nLater rulers included:\n\nHabsburgs\nAustrian line\nSpanish line\nCarniolans",
+		document.Text[history.Start:history.End])
+
+	middleAges := history.Sections[0]
+	assert.Equal(t, "Middle Ages", middleAges.Heading)
+	assert.Equal(t, "Middle_Ages", middleAges.Anchor)
+	assert.Equal(t, 3, middleAges.Level)
+	require.Len(t, middleAges.Blocks, 2)
+	list := middleAges.Blocks[1]
+	assert.Equal(t, mediawiki.ListBlock, list.Type)
+	assert.Equal(t, "Habsburgs\nAustrian line\nSpanish line\nCarniolans", list.Text)
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, "Habsburgs", list.Items[0].Text)
+	assert.Equal(t, "Habsburgs", document.Text[list.Items[0].Start:list.Items[0].End])
+	require.Len(t, list.Items[0].Items, 2)
+	assert.Equal(t, "Spanish line", list.Items[0].Items[1].Text)
+	assert.Equal(t, "Carniolans", list.Items[1].Text)
+
+	geography := document.Sections[2]
+	require.Len(t, geography.Blocks, 2)
+	// Loose text is a paragraph of the section element.
+	assert.Equal(t, "mwFg", htmlAttr(geography.Blocks[0].Node, "id"))
+	assert.Equal(t, "Elevation\n295 m", geography.Blocks[1].Text)
+
+	references := document.Sections[3]
+	assert.Equal(t, "References", references.Heading)
+	assert.Empty(t, references.Blocks)
+
+	// Every span maps to its text node.
+	for _, span := range document.Spans {
+		assert.Equal(t, html.TextNode, span.Node.Type)
+		assert.Equal(t, strings.Join(strings.Fields(span.Node.Data), " "), document.Text[span.Start:span.End])
+	}
+	offset := strings.Index(document.Text, "Romans")
+	node := document.NodeAt(offset)
+	require.NotNil(t, node)
+	assert.Equal(t, "./Romans", htmlAttr(node.Parent, "href"))
+	assert.Nil(t, document.NodeAt(strings.Index(document.Text, "\n\nHistory")))
+}
+
+func TestParseArticleDocumentWithoutStripping(t *testing.T) {
+	t.Parallel()
+
+	document, errE := mediawiki.ParseArticleDocument(readArticleHTML(t, "article.html"), nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	lead := document.Sections[0]
+	require.Len(t, lead.Blocks, 2)
+	assert.Equal(t, "This article is about the city. For other uses, see Ljubljana (disambiguation).", lead.Blocks[0].Text)
+	assert.Equal(t, "Ljubljana is the capital and largest city of Slovenia.[1] It is the country's cultural, educational, economic, and political centre.", lead.Blocks[1].Text)
+
+	references := document.Sections[3]
+	require.Len(t, references.Blocks, 2)
+	assert.Equal(t, "↑ Statistical Office.\n↑ History of Emona.", references.Blocks[0].Text)
+	assert.Equal(t, "Vienna", references.Blocks[1].Text)
+	assert.True(t, strings.HasSuffix(document.Text, "\n\nVienna"))
+}
+
+func TestParseArticleDocumentFragment(t *testing.T) {
+	t.Parallel()
+
+	document, errE := mediawiki.ParseArticleDocument("<h2>A</h2><h4>B</h4><p>x</p><h3>C</h3><p>y</p>", nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, "A\n\nB\n\nx\n\nC\n\ny", document.Text)
+	require.Len(t, document.Sections, 2)
+	assert.Empty(t, document.Sections[0].Blocks)
+	a := document.Sections[1]
+	require.Len(t, a.Sections, 2)
+	assert.Equal(t, 4, a.Sections[0].Level)
+	assert.Equal(t, "C", a.Sections[1].Heading)
+	assert.Equal(t, len(document.Text), a.End)
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,479 @@
+package mediawiki
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// canonicalNamespaces maps lower case canonical namespace names (and their
+// common aliases on Wikipedias) to namespace IDs.
+var canonicalNamespaces = map[string]int{ //nolint:gochecknoglobals
+	"media":          -2, //nolint:mnd
+	"special":        -1,
+	"talk":           1,
+	"user":           2, //nolint:mnd
+	"user talk":      3, //nolint:mnd
+	"project":        4, //nolint:mnd
+	"wikipedia":      4, //nolint:mnd
+	"project talk":   5, //nolint:mnd
+	"wikipedia talk": 5, //nolint:mnd
+	"file":           FileNamespace,
+	"image":          FileNamespace,
+	"file talk":      7, //nolint:mnd
+	"image talk":     7, //nolint:mnd
+	"mediawiki":      8, //nolint:mnd
+	"mediawiki talk": 9, //nolint:mnd
+	"template":       TemplateNamespace,
+	"template talk":  11, //nolint:mnd
+	"help":           12, //nolint:mnd
+	"help talk":      13, //nolint:mnd
+	"category":       CategoryNamespace,
+	"category talk":  15,  //nolint:mnd
+	"portal":         100, //nolint:mnd
+	"portal talk":    101, //nolint:mnd
+	"draft":          118, //nolint:mnd
+	"draft talk":     119, //nolint:mnd
+	"module":         828, //nolint:mnd
+	"module talk":    829, //nolint:mnd
+}
+
+// InternalLink is a link to a page on the same wiki.
+//
+// Title is the full title of the linked page (including the namespace prefix)
+// with spaces instead of underscores. Red is true if the linked page does not exist.
+type InternalLink struct {
+	Title     string     `json:"title"`
+	Namespace int        `json:"namespace"`
+	Fragment  string     `json:"fragment,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	Red       bool       `json:"red,omitempty"`
+	Node      *html.Node `json:"-"`
+}
+
+type ExternalLink struct {
+	URL  string     `json:"url"`
+	Text string     `json:"text,omitempty"`
+	Node *html.Node `json:"-"`
+}
+
+// InterwikiLink is a link to a page on another wiki, e.g., "fr:Ljubljana".
+//
+// Language is true for interlanguage links of the article (which have no text).
+type InterwikiLink struct {
+	Prefix   string     `json:"prefix"`
+	Title    string     `json:"title"`
+	URL      string     `json:"url"`
+	Text     string     `json:"text,omitempty"`
+	Language bool       `json:"language,omitempty"`
+	Node     *html.Node `json:"-"`
+}
+
+// ArticleCitation is a citation template used in a reference.
+//
+// Parameters contain wikitext of all template parameters. Title, URL, DOI, ISBN,
+// and Date are copied from them for convenience.
+type ArticleCitation struct {
+	Template   string            `json:"template"`
+	Title      string            `json:"title,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	DOI        string            `json:"doi,omitempty"`
+	ISBN       string            `json:"isbn,omitempty"`
+	Date       string            `json:"date,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// ArticleReference is a reference (footnote) in an article.
+//
+// There is one ArticleReference for every reference marker in the text,
+// Node, so a reference used multiple times is returned multiple times with
+// the same ID.
+type ArticleReference struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name,omitempty"`
+	Group     string            `json:"group,omitempty"`
+	Text      string            `json:"text,omitempty"`
+	Citations []ArticleCitation `json:"citations,omitempty"`
+	Node      *html.Node        `json:"-"`
+}
+
+type parsoidTarget struct {
+	WT   string `json:"wt"`
+	Href string `json:"href,omitempty"`
+}
+
+type parsoidParam struct {
+	WT string `json:"wt"`
+}
+
+type parsoidTemplate struct {
+	Target parsoidTarget           `json:"target"`
+	Params map[string]parsoidParam `json:"params"`
+}
+
+type parsoidPart struct {
+	Template *parsoidTemplate `json:"template,omitempty"`
+}
+
+type parsoidRefAttrs struct {
+	Name  string `json:"name,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+type parsoidRefBody struct {
+	ID   string `json:"id,omitempty"`
+	HTML string `json:"html,omitempty"`
+}
+
+// parsoidDataMW is the data-mw attribute of Parsoid HTML elements.
+// Parts are strings or template parts.
+type parsoidDataMW struct {
+	Name  string            `json:"name,omitempty"`
+	Attrs parsoidRefAttrs   `json:"attrs"`
+	Body  *parsoidRefBody   `json:"body,omitempty"`
+	Parts []json.RawMessage `json:"parts,omitempty"`
+}
+
+// htmlWalk calls fn on the node and its descendants in document order.
+// Descendants of a node are skipped if fn returns false for it.
+func htmlWalk(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		htmlWalk(c, fn)
+	}
+}
+
+// htmlText returns text of the node and its descendants, with whitespace collapsed.
+func htmlText(n *html.Node) string {
+	var text strings.Builder
+	htmlWalk(n, func(n *html.Node) bool {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.DataAtom == atom.Br:
+			text.WriteByte(' ')
+		case n.Type == html.ElementNode && (n.DataAtom == atom.Style || n.DataAtom == atom.Script):
+			return false
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+func parseDataMW(n *html.Node) (*parsoidDataMW, errors.E) {
+	var dataMW parsoidDataMW
+	// We do not disallow unknown fields because we do not need all of them.
+	errE := x.Unmarshal([]byte(htmlAttr(n, "data-mw")), &dataMW)
+	if errE != nil {
+		errE = errors.Prefix(errE, ErrJSONDecode)
+		errors.Details(errE)["id"] = htmlAttr(n, "id")
+		return nil, errE
+	}
+	return &dataMW, nil
+}
+
+// parsoidTitle returns the title (with spaces instead of underscores)
+// and the fragment of a Parsoid link target, e.g., "./Foo_bar#Baz".
+func parsoidTitle(href string) (string, string) {
+	title, fragment, _ := strings.Cut(strings.TrimPrefix(href, "./"), "#")
+	title, _, _ = strings.Cut(title, "?")
+	if t, err := url.PathUnescape(title); err == nil {
+		title = t
+	}
+	if f, err := url.PathUnescape(fragment); err == nil {
+		fragment = f
+	}
+	return strings.ReplaceAll(title, "_", " "), fragment
+}
+
+// namespace returns the namespace ID of the title.
+func (d *ArticleDocument) namespace(title string) int {
+	prefix, _, ok := strings.Cut(title, ":")
+	if !ok {
+		return MainNamespace
+	}
+	prefix = strings.TrimSpace(prefix)
+	for name, id := range d.options.Namespaces {
+		if strings.EqualFold(strings.ReplaceAll(name, "_", " "), prefix) {
+			return id
+		}
+	}
+	if id, ok := canonicalNamespaces[strings.ToLower(prefix)]; ok {
+		return id
+	}
+	return MainNamespace
+}
+
+// InternalLinks returns all links to pages on the same wiki, in document order.
+//
+// Links are extracted from the whole article, irrespective of ArticleDocumentOptions.
+func (d *ArticleDocument) InternalLinks() []InternalLink {
+	links := []InternalLink{}
+	htmlWalk(d.Root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n.DataAtom != atom.A || !htmlHasRel(n, "mw:WikiLink") {
+			return true
+		}
+		href := htmlAttr(n, "href")
+		title, fragment := parsoidTitle(href)
+		links = append(links, InternalLink{
+			Title:     title,
+			Namespace: d.namespace(title),
+			Fragment:  fragment,
+			Text:      htmlText(n),
+			Red:       htmlHasClass(n, "new") || strings.Contains(href, "redlink=1"),
+			Node:      n,
+		})
+		return false
+	})
+	return links
+}
+
+// ExternalLinks returns all links to external websites, in document order.
+//
+// Links are extracted from the whole article, irrespective of ArticleDocumentOptions.
+func (d *ArticleDocument) ExternalLinks() []ExternalLink {
+	links := []ExternalLink{}
+	htmlWalk(d.Root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || n.DataAtom != atom.A || !htmlHasRel(n, "mw:ExtLink") {
+			return true
+		}
+		links = append(links, ExternalLink{
+			URL:  htmlAttr(n, "href"),
+			Text: htmlText(n),
+			Node: n,
+		})
+		return false
+	})
+	return links
+}
+
+// interwikiFromURL returns the language prefix and the title of a page URL,
+// e.g., "https://de.wikipedia.org/wiki/Ljubljana".
+func interwikiFromURL(u string) (string, string) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", ""
+	}
+	prefix, _, _ := strings.Cut(parsed.Hostname(), ".")
+	_, title, _ := strings.Cut(parsed.Path, "/wiki/")
+	return prefix, strings.ReplaceAll(title, "_", " ")
+}
+
+// InterwikiLinks returns all interwiki and interlanguage links, in document order.
+//
+// Links are extracted from the whole article, irrespective of ArticleDocumentOptions.
+func (d *ArticleDocument) InterwikiLinks() []InterwikiLink {
+	links := []InterwikiLink{}
+	htmlWalk(d.Root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		switch {
+		case n.DataAtom == atom.A && htmlHasRel(n, "mw:WikiLink/Interwiki"):
+			u := htmlAttr(n, "href")
+			prefix, title, ok := strings.Cut(htmlAttr(n, "title"), ":")
+			if !ok {
+				prefix, title = interwikiFromURL(u)
+			}
+			links = append(links, InterwikiLink{
+				Prefix:   prefix,
+				Title:    strings.ReplaceAll(title, "_", " "),
+				URL:      u,
+				Text:     htmlText(n),
+				Language: false,
+				Node:     n,
+			})
+			return false
+		case n.DataAtom == atom.Link && htmlHasRel(n, "mw:PageProp/Language"):
+			u := htmlAttr(n, "href")
+			prefix, title := interwikiFromURL(u)
+			links = append(links, InterwikiLink{
+				Prefix:   prefix,
+				Title:    title,
+				URL:      u,
+				Text:     "",
+				Language: true,
+				Node:     n,
+			})
+		}
+		return true
+	})
+	return links
+}
+
+// citationParameter returns the value of the first of the parameters
+// present, matching names case-insensitively.
+func citationParameter(parameters map[string]string, names ...string) string {
+	for _, name := range names {
+		for key, value := range parameters {
+			if strings.EqualFold(key, name) {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// citations returns all citation templates (e.g., "Cite web") transcluded
+// in the node or its descendants.
+func citations(n *html.Node) ([]ArticleCitation, errors.E) {
+	var result []ArticleCitation
+	var errE errors.E
+	htmlWalk(n, func(n *html.Node) bool {
+		if errE != nil {
+			return false
+		}
+		if n.Type != html.ElementNode || !htmlHasTypeOf(n, "mw:Transclusion") || htmlAttr(n, "data-mw") == "" {
+			return true
+		}
+		var dataMW *parsoidDataMW
+		dataMW, errE = parseDataMW(n)
+		if errE != nil {
+			return false
+		}
+		for _, raw := range dataMW.Parts {
+			// Parts which are not templates are strings.
+			if len(raw) == 0 || raw[0] != '{' {
+				continue
+			}
+			var part parsoidPart
+			errE = x.Unmarshal(raw, &part)
+			if errE != nil {
+				errE = errors.Prefix(errE, ErrJSONDecode)
+				errors.Details(errE)["id"] = htmlAttr(n, "id")
+				return false
+			}
+			if part.Template == nil {
+				continue
+			}
+			name := strings.TrimSpace(strings.ReplaceAll(part.Template.Target.WT, "_", " "))
+			if part.Template.Target.Href != "" {
+				name, _ = parsoidTitle(part.Template.Target.Href)
+				name = strings.TrimPrefix(name, "Template:")
+			}
+			lower := strings.ToLower(name)
+			if !strings.HasPrefix(lower, "cite") && !strings.HasPrefix(lower, "citation") {
+				continue
+			}
+			parameters := make(map[string]string, len(part.Template.Params))
+			for key, param := range part.Template.Params {
+				parameters[key] = strings.TrimSpace(param.WT)
+			}
+			result = append(result, ArticleCitation{
+				Template:   name,
+				Title:      citationParameter(parameters, "title"),
+				URL:        citationParameter(parameters, "url"),
+				DOI:        citationParameter(parameters, "doi"),
+				ISBN:       citationParameter(parameters, "isbn"),
+				Date:       citationParameter(parameters, "date", "year"),
+				Parameters: parameters,
+			})
+		}
+		// Citation templates are not nested.
+		return false
+	})
+	return result, errE
+}
+
+// References returns all references in the article, in order of their
+// reference markers, with citation templates they use.
+//
+// References are extracted from the whole article, irrespective of ArticleDocumentOptions.
+//
+// A reference marker without data-mw is returned without name and group.
+// A reference marker with malformed data-mw (or reference content) is skipped
+// and References returns all other references together with an error
+// describing (through the "id" detail) every skipped marker.
+func (d *ArticleDocument) References() ([]ArticleReference, errors.E) {
+	ids := map[string]*html.Node{}
+	markers := []*html.Node{}
+	htmlWalk(d.Root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		if id := htmlAttr(n, "id"); id != "" {
+			ids[id] = n
+		}
+		if htmlHasTypeOf(n, "mw:Extension/ref") {
+			markers = append(markers, n)
+		}
+		return true
+	})
+
+	references := make([]ArticleReference, 0, len(markers))
+	errs := []error{}
+	for _, marker := range markers {
+		dataMW := &parsoidDataMW{} //nolint:exhaustruct
+		if htmlAttr(marker, "data-mw") != "" {
+			var errE errors.E
+			dataMW, errE = parseDataMW(marker)
+			if errE != nil {
+				errs = append(errs, errE)
+				continue
+			}
+		}
+		var id string
+		htmlWalk(marker, func(n *html.Node) bool {
+			if id == "" && n.Type == html.ElementNode && n.DataAtom == atom.A {
+				_, id, _ = strings.Cut(htmlAttr(n, "href"), "#")
+			}
+			return id == ""
+		})
+
+		// Content of the reference is in the reference list or in data-mw.
+		var content *html.Node
+		switch {
+		case dataMW.Body != nil && dataMW.Body.ID != "":
+			content = ids[dataMW.Body.ID]
+		case dataMW.Body != nil && dataMW.Body.HTML != "":
+			content = &html.Node{Type: html.ElementNode, DataAtom: atom.Div, Data: "div"} //nolint:exhaustruct
+			nodes, err := html.ParseFragment(strings.NewReader(dataMW.Body.HTML), content)
+			if err != nil {
+				errE := errors.WithMessage(err, "html parse")
+				errors.Details(errE)["id"] = htmlAttr(marker, "id")
+				errs = append(errs, errE)
+				continue
+			}
+			for _, node := range nodes {
+				content.AppendChild(node)
+			}
+		case ids[id] != nil:
+			// A reused reference, we find its content through the reference list.
+			content = ids[id]
+			htmlWalk(ids[id], func(n *html.Node) bool {
+				if n.Type == html.ElementNode && htmlHasClass(n, "mw-reference-text") {
+					content = n
+					return false
+				}
+				return content == ids[id]
+			})
+		}
+
+		reference := ArticleReference{
+			ID:        id,
+			Name:      dataMW.Attrs.Name,
+			Group:     dataMW.Attrs.Group,
+			Text:      "",
+			Citations: nil,
+			Node:      marker,
+		}
+		if content != nil {
+			var errE errors.E
+			reference.Text = htmlText(content)
+			reference.Citations, errE = citations(content)
+			if errE != nil {
+				errs = append(errs, errE)
+				continue
+			}
+		}
+		references = append(references, reference)
+	}
+	return references, errors.Join(errs...)
+}
@@ -0,0 +1,148 @@
+package mediawiki_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func parseArticleDocument(t *testing.T, name string, options *mediawiki.ArticleDocumentOptions) *mediawiki.ArticleDocument {
+	t.Helper()
+
+	document, errE := mediawiki.ParseArticleDocument(readArticleHTML(t, name), options)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return document
+}
+
+func TestArticleDocumentInternalLinks(t *testing.T) {
+	t.Parallel()
+
+	links := parseArticleDocument(t, "article-links.html", &mediawiki.ArticleDocumentOptions{
+		StripNavboxes: true,
+		// Links are extracted irrespective of options.
+		StripHatnotes: true,
+	}).InternalLinks()
+
+	for i := range links {
+		require.NotNil(t, links[i].Node)
+		links[i].Node = nil
+	}
+	assert.Equal(t, []mediawiki.InternalLink{
+		{Title: "Ljubljana (disambiguation)", Text: "Ljubljana (disambiguation)"},
+		{Title: "Slovenia", Text: "Slovenia"},
+		{Title: "Help:IPA/English", Namespace: 12, Text: "pronunciation"},
+		{Title: "Capital city", Text: "capital"},
+		{Title: "Slovenia", Text: "Slovenia"},
+		{Title: "Romans", Text: "Romans"},
+		{Title: "Emona Forum", Text: "forum", Red: true},
+		{Title: "House of Habsburg", Fragment: "Austrian_branch", Text: "Habsburgs"},
+		{Title: "Ljubljanica", Text: "Ljubljanica"},
+		{Title: "Vienna", Text: "Vienna"},
+	}, links)
+}
+
+func TestArticleDocumentNamespaces(t *testing.T) {
+	t.Parallel()
+
+	document, errE := mediawiki.ParseArticleDocument(
+		`<p><a rel="mw:WikiLink" href="./Pomo%C4%8D:Vsebina">a</a><a rel="mw:WikiLink" href="./category_talk:Foo">b</a>`+
+			`<a rel="mw:WikiLink" href="./Star_Wars:_Episode_I">c</a></p>`,
+		&mediawiki.ArticleDocumentOptions{Namespaces: map[string]int{"pomoč": 12}},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	links := document.InternalLinks()
+	require.Len(t, links, 3)
+	assert.Equal(t, "Pomoč:Vsebina", links[0].Title)
+	assert.Equal(t, 12, links[0].Namespace)
+	assert.Equal(t, 15, links[1].Namespace)
+	assert.Equal(t, mediawiki.MainNamespace, links[2].Namespace)
+}
+
+func TestArticleDocumentExternalAndInterwikiLinks(t *testing.T) {
+	t.Parallel()
+
+	document := parseArticleDocument(t, "article-links.html", nil)
+
+	external := document.ExternalLinks()
+	require.Len(t, external, 2)
+	assert.Equal(t, "https://example.com/emona", external[0].URL)
+	assert.Equal(t, "a guide", external[0].Text)
+	// The second one is in the reference list.
+	assert.Equal(t, `"History of Emona"`, external[1].Text)
+
+	interwiki := document.InterwikiLinks()
+	for i := range interwiki {
+		require.NotNil(t, interwiki[i].Node)
+		interwiki[i].Node = nil
+	}
+	assert.Equal(t, []mediawiki.InterwikiLink{
+		{Prefix: "fr", Title: "Ljubljana", URL: "https://fr.wikipedia.org/wiki/Ljubljana", Text: "French article"},
+		{Prefix: "de", Title: "Ljubljana", URL: "https://de.wikipedia.org/wiki/Ljubljana", Language: true},
+	}, interwiki)
+}
+
+func TestArticleDocumentReferences(t *testing.T) {
+	t.Parallel()
+
+	references, errE := parseArticleDocument(t, "article-links.html", &mediawiki.ArticleDocumentOptions{StripReferences: true}).References()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, references, 4)
+
+	for i := range references {
+		require.NotNil(t, references[i].Node)
+		references[i].Node = nil
+	}
+	assert.Equal(t, []mediawiki.ArticleReference{
+		{ID: "cite_note-stat-1", Name: "stat", Text: "Statistical Office."},
+		{ID: "cite_note-2", Text: `"History of Emona". Example. 2020-05-01.`, Citations: []mediawiki.ArticleCitation{{
+			Template: "Cite web",
+			Title:    "History of Emona",
+			URL:      "https://example.com/emona",
+			DOI:      "10.1000/182",
+			ISBN:     "978-3-16-148410-0",
+			Date:     "2020-05-01",
+			Parameters: map[string]string{
+				"title":     "History of Emona",
+				"url":       "https://example.com/emona",
+				"date":      "2020-05-01",
+				"doi":       "10.1000/182",
+				"isbn":      "978-3-16-148410-0",
+				"publisher": "Example",
+			},
+		}}},
+		// Reused reference.
+		{ID: "cite_note-stat-1", Name: "stat", Text: "Statistical Office."},
+		// Reference with its content in data-mw.
+		{ID: "cite_note-3", Group: "note", Text: "Measured at the castle."},
+	}, references)
+}
+
+func TestArticleDocumentReferencesInvalid(t *testing.T) {
+	t.Parallel()
+
+	document, errE := mediawiki.ParseArticleDocument(
+		`<p>a<sup typeof="mw:Extension/ref" id="x" data-mw="{"><a href="#cite_note-1">[1]</a></sup>`+
+			`b<sup typeof="mw:Extension/ref" id="y"><a href="#cite_note-2">[2]</a></sup>`+
+			`c<sup typeof="mw:Extension/ref" id="z" data-mw='{"attrs":{"name":"z"}}'><a href="#cite_note-z-3">[3]</a></sup></p>`+
+			`<ol class="mw-references"><li id="cite_note-2"><span class="mw-reference-text">Second.</span></li>`+
+			`<li id="cite_note-z-3"><span class="mw-reference-text">Third.</span></li></ol>`,
+		nil,
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	references, errE := document.References()
+	assert.ErrorIs(t, errE, mediawiki.ErrJSONDecode)
+	assert.Equal(t, "x", errors.AllDetails(errE)["id"])
+
+	// Other references are still returned.
+	for i := range references {
+		references[i].Node = nil
+	}
+	assert.Equal(t, []mediawiki.ArticleReference{
+		{ID: "cite_note-2", Text: "Second."},
+		{ID: "cite_note-z-3", Name: "z", Text: "Third."},
+	}, references)
+}
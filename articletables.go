@@ -0,0 +1,302 @@
+package mediawiki
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var wikidataEntityRegex = regexp.MustCompile(`^[QPL][1-9]\d*$`)
+
+// Maximum column span of a table cell, as in browsers.
+const maxColSpan = 1000
+
+// ArticleTableLink is a link in a table cell.
+//
+// Title is the title of the linked page on the same wiki, if any. Entity is the
+// Wikidata entity ID of the linked page, if known (see ArticleDocumentOptions.Entities),
+// or of the linked Wikidata entity.
+type ArticleTableLink struct {
+	Title  string `json:"title,omitempty"`
+	Entity string `json:"entity,omitempty"`
+}
+
+// ArticleTableCell is a cell of a table grid.
+//
+// A cell spanning multiple rows or columns is repeated at all grid positions it covers,
+// with Spanned set at all but the first one.
+type ArticleTableCell struct {
+	Text    string             `json:"text"`
+	Header  bool               `json:"header,omitempty"`
+	Spanned bool               `json:"spanned,omitempty"`
+	Links   []ArticleTableLink `json:"links,omitempty"`
+}
+
+// ArticleTable is a table in an article, with row and column spans resolved
+// into a rectangular grid of Rows.
+//
+// HeaderRows and HeaderColumns are the numbers of leading rows and columns
+// consisting only of header cells. Section contains headings of sections the
+// table is in, from the outermost, and SectionAnchor the anchor of the innermost.
+// Both are empty for tables in the lead section.
+type ArticleTable struct {
+	Caption       string               `json:"caption,omitempty"`
+	Classes       []string             `json:"classes,omitempty"`
+	Section       []string             `json:"section,omitempty"`
+	SectionAnchor string               `json:"section_anchor,omitempty"`
+	HeaderRows    int                  `json:"header_rows"`
+	HeaderColumns int                  `json:"header_columns"`
+	Rows          [][]ArticleTableCell `json:"rows"`
+	Node          *html.Node           `json:"-"`
+}
+
+// Records returns text of all cells, e.g., to be written with csv.Writer.
+func (t *ArticleTable) Records() [][]string {
+	records := make([][]string, len(t.Rows))
+	for i, row := range t.Rows {
+		records[i] = make([]string, len(row))
+		for j, cell := range row {
+			records[i][j] = cell.Text
+		}
+	}
+	return records
+}
+
+// wikidataEntity returns the Wikidata entity ID of a Wikidata page URL,
+// or an empty string.
+func wikidataEntity(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || !strings.HasSuffix(parsed.Hostname(), "wikidata.org") {
+		return ""
+	}
+	id := parsed.Path[strings.LastIndex(parsed.Path, "/")+1:]
+	if wikidataEntityRegex.MatchString(id) {
+		return id
+	}
+	return ""
+}
+
+// tableContentWalk calls fn on content of the table cell or caption,
+// skipping stripped elements and nested tables.
+func (d *ArticleDocument) tableContentWalk(n *html.Node, fn func(*html.Node)) {
+	htmlWalk(n, func(c *html.Node) bool {
+		if c != n && (htmlStripped(c, d.options) || (c.Type == html.ElementNode && c.DataAtom == atom.Table)) {
+			return false
+		}
+		fn(c)
+		return true
+	})
+}
+
+func (d *ArticleDocument) tableText(n *html.Node) string {
+	var text strings.Builder
+	d.tableContentWalk(n, func(c *html.Node) {
+		switch {
+		case c.Type == html.TextNode:
+			text.WriteString(c.Data)
+		case c.Type == html.ElementNode && c.DataAtom == atom.Br:
+			text.WriteByte(' ')
+		}
+	})
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+func (d *ArticleDocument) tableLinks(n *html.Node) []ArticleTableLink {
+	var links []ArticleTableLink
+	d.tableContentWalk(n, func(c *html.Node) {
+		if c.Type != html.ElementNode || c.DataAtom != atom.A {
+			return
+		}
+		switch {
+		case htmlHasRel(c, "mw:WikiLink"):
+			title, _ := parsoidTitle(htmlAttr(c, "href"))
+			links = append(links, ArticleTableLink{Title: title, Entity: d.options.Entities[title]})
+		case htmlHasRel(c, "mw:WikiLink/Interwiki", "mw:ExtLink"):
+			if entity := wikidataEntity(htmlAttr(c, "href")); entity != "" {
+				links = append(links, ArticleTableLink{Title: "", Entity: entity})
+			}
+		}
+	})
+	return links
+}
+
+// tableSpan returns the value of rowspan or colspan attribute of the cell.
+func tableSpan(n *html.Node, key string) int {
+	span, err := strconv.Atoi(strings.TrimSpace(htmlAttr(n, key)))
+	if err != nil || span < 0 {
+		return 1
+	}
+	return span
+}
+
+// tableRows returns rows of the table, and whether each of them is in the table head.
+func tableRows(n *html.Node) ([]*html.Node, []bool) {
+	var rows []*html.Node
+	var head []bool
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.DataAtom { //nolint:exhaustive
+		case atom.Tr:
+			rows = append(rows, c)
+			head = append(head, false)
+		case atom.Thead, atom.Tbody, atom.Tfoot:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.Type == html.ElementNode && r.DataAtom == atom.Tr {
+					rows = append(rows, r)
+					head = append(head, c.DataAtom == atom.Thead)
+				}
+			}
+		}
+	}
+	return rows, head
+}
+
+func (d *ArticleDocument) table(n *html.Node, sections []*ArticleSection) ArticleTable {
+	rows, head := tableRows(n)
+
+	// Cells at grid positions, nil where no cell covers the position.
+	grid := make([][]*ArticleTableCell, len(rows))
+	for r, row := range rows {
+		col := 0
+		for c := row.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || (c.DataAtom != atom.Td && c.DataAtom != atom.Th) {
+				continue
+			}
+			for col < len(grid[r]) && grid[r][col] != nil {
+				col++
+			}
+			rowSpan := tableSpan(c, "rowspan")
+			if rowSpan == 0 || rowSpan > len(rows)-r {
+				// Row span 0 spans all remaining rows.
+				rowSpan = len(rows) - r
+			}
+			colSpan := min(max(tableSpan(c, "colspan"), 1), maxColSpan)
+			cell := ArticleTableCell{
+				Text:    d.tableText(c),
+				Header:  c.DataAtom == atom.Th || head[r],
+				Spanned: false,
+				Links:   d.tableLinks(c),
+			}
+			for i := range rowSpan {
+				for j := range colSpan {
+					for len(grid[r+i]) <= col+j {
+						grid[r+i] = append(grid[r+i], nil)
+					}
+					spanned := cell
+					spanned.Spanned = i > 0 || j > 0
+					grid[r+i][col+j] = &spanned
+				}
+			}
+			col += colSpan
+		}
+	}
+
+	width := 0
+	for _, row := range grid {
+		width = max(width, len(row))
+	}
+	// Header detection ignores positions not covered by any cell.
+	isHeader := func(cell *ArticleTableCell) bool {
+		return cell == nil || cell.Header
+	}
+	headerRows := 0
+	for headerRows < len(grid) && len(grid[headerRows]) > 0 {
+		all := true
+		for _, cell := range grid[headerRows] {
+			all = all && isHeader(cell)
+		}
+		if !all {
+			break
+		}
+		headerRows++
+	}
+	headerColumns := 0
+	if headerRows < len(grid) {
+	Columns:
+		for headerColumns < width {
+			for _, row := range grid[headerRows:] {
+				if headerColumns < len(row) && !isHeader(row[headerColumns]) {
+					break Columns
+				}
+			}
+			headerColumns++
+		}
+	}
+
+	table := ArticleTable{
+		Caption:       "",
+		Classes:       strings.Fields(htmlAttr(n, "class")),
+		Section:       nil,
+		SectionAnchor: "",
+		HeaderRows:    headerRows,
+		HeaderColumns: headerColumns,
+		Rows:          make([][]ArticleTableCell, len(grid)),
+		Node:          n,
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Caption {
+			table.Caption = d.tableText(c)
+			break
+		}
+	}
+	for _, section := range sections {
+		table.Section = append(table.Section, section.Heading)
+		table.SectionAnchor = section.Anchor
+	}
+	for r, row := range grid {
+		table.Rows[r] = make([]ArticleTableCell, width)
+		for col, cell := range row {
+			if cell != nil {
+				table.Rows[r][col] = *cell
+			}
+		}
+	}
+	return table
+}
+
+// Tables returns all tables in the article, in document order, with their section context.
+//
+// Tables removed by ArticleDocumentOptions (e.g., infoboxes) are not returned, and
+// text of cells does not contain removed elements (e.g., references).
+// Nested tables are returned as separate tables.
+func (d *ArticleDocument) Tables() []ArticleTable {
+	// Paths of sections from the outermost, by their heading elements.
+	paths := map[*html.Node][]*ArticleSection{}
+	var index func(sections []*ArticleSection, path []*ArticleSection)
+	index = func(sections []*ArticleSection, path []*ArticleSection) {
+		for _, section := range sections {
+			if section.Node == nil {
+				continue
+			}
+			p := append(append([]*ArticleSection{}, path...), section)
+			paths[section.Node] = p
+			index(section.Sections, p)
+		}
+	}
+	index(d.Sections, nil)
+
+	tables := []ArticleTable{}
+	var current []*ArticleSection
+	htmlWalk(d.Root, func(n *html.Node) bool {
+		if htmlStripped(n, d.options) {
+			return false
+		}
+		if n.Type != html.ElementNode {
+			return true
+		}
+		if path, ok := paths[n]; ok {
+			current = path
+		}
+		if n.DataAtom == atom.Table {
+			tables = append(tables, d.table(n, current))
+		}
+		return true
+	})
+	return tables
+}
@@ -0,0 +1,97 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestArticleDocumentTables(t *testing.T) {
+	t.Parallel()
+
+	tables := parseArticleDocument(t, "article-tables.html", &mediawiki.ArticleDocumentOptions{
+		StripReferences: true,
+		StripNavboxes:   true,
+		StripInfoboxes:  true,
+		Entities:        map[string]string{"City Municipality of Ljubljana": "Q1385254"},
+	}).Tables()
+	require.Len(t, tables, 1)
+
+	table := tables[0]
+	require.NotNil(t, table.Node)
+	assert.Equal(t, "Population of Ljubljana", table.Caption)
+	assert.Equal(t, []string{"wikitable", "sortable"}, table.Classes)
+	assert.Equal(t, []string{"Geography"}, table.Section)
+	assert.Equal(t, "Geography", table.SectionAnchor)
+	assert.Equal(t, 2, table.HeaderRows)
+	assert.Equal(t, 1, table.HeaderColumns)
+
+	mol := []mediawiki.ArticleTableLink{{Title: "City Municipality of Ljubljana", Entity: "Q1385254"}}
+	assert.Equal(t, [][]mediawiki.ArticleTableCell{
+		{{Text: "Year", Header: true}, {Text: "Population", Header: true}, {Text: "Population", Header: true, Spanned: true}},
+		{{Text: "Year", Header: true, Spanned: true}, {Text: "City", Header: true}, {Text: "Municipality", Header: true}},
+		{{Text: "2002", Header: true}, {Text: "258,873"}, {Text: "MOL", Links: mol}},
+		{{Text: "2021", Header: true}, {Text: "285,604"}, {Text: "MOL", Spanned: true, Links: mol}},
+	}, table.Rows)
+
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	require.NoError(t, w.WriteAll(table.Records()))
+	assert.Equal(t, "Year,Population,Population\nYear,City,Municipality\n2002,\"258,873\",MOL\n2021,\"285,604\",MOL\n", buffer.String())
+
+	data, errE := x.MarshalWithoutEscapeHTML(table)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Contains(t, string(data), `"section_anchor":"Geography","header_rows":2,"header_columns":1,`)
+}
+
+func TestArticleDocumentTablesWithoutStripping(t *testing.T) {
+	t.Parallel()
+
+	tables := parseArticleDocument(t, "article-tables.html", nil).Tables()
+	require.Len(t, tables, 3)
+
+	infobox := tables[0]
+	assert.Empty(t, infobox.Section)
+	assert.Equal(t, 1, infobox.HeaderRows)
+	assert.Equal(t, 1, infobox.HeaderColumns)
+	assert.Equal(t, [][]string{{"Ljubljana", "Ljubljana"}, {"Country", "Slovenia"}, {"Wikidata", "Q437"}}, infobox.Records())
+	assert.Equal(t, []mediawiki.ArticleTableLink{{Entity: "Q437"}}, infobox.Rows[2][1].Links)
+
+	assert.Equal(t, "285,604[1]", tables[1].Rows[3][1].Text)
+
+	navbox := tables[2]
+	assert.Equal(t, []string{"References"}, navbox.Section)
+	assert.Equal(t, [][]string{{"Capitals"}}, navbox.Records())
+}
+
+func TestArticleDocumentTablesSpans(t *testing.T) {
+	t.Parallel()
+
+	document, errE := mediawiki.ParseArticleDocument(`<h2 id="A">A</h2><h3 id="B">B</h3><table>`+
+		`<thead><tr><td>h1</td><td>h2</td></tr></thead>`+
+		`<tr><td rowspan="0">a</td><td>b</td><td>c</td></tr>`+
+		`<tr><td colspan="0">d</td></tr>`+
+		`<tr><td rowspan="5">e</td></tr>`+
+		`</table>`, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	tables := document.Tables()
+	require.Len(t, tables, 1)
+	table := tables[0]
+	assert.Equal(t, []string{"A", "B"}, table.Section)
+	assert.Equal(t, "B", table.SectionAnchor)
+	assert.Equal(t, 1, table.HeaderRows)
+	assert.Equal(t, 0, table.HeaderColumns)
+	assert.Equal(t, [][]string{
+		{"h1", "h2", ""},
+		{"a", "b", "c"},
+		{"a", "d", ""},
+		{"a", "e", ""},
+	}, table.Records())
+}
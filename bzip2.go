@@ -0,0 +1,432 @@
+package mediawiki
+
+import (
+	"io"
+	"slices"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	bzip2Level = 9
+	// Maximum size of a block after the initial run-length encoding. It leaves room
+	// for the longest run-length encoded run, as in the reference implementation.
+	bzip2MaxBlockSize  = bzip2Level*100000 - 19
+	bzip2MaxCodeLength = 17
+	bzip2GroupSize     = 50
+	bzip2MaxRun        = 255
+)
+
+//nolint:gochecknoglobals
+var bzip2CRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		c := uint32(i) << 24 //nolint:gosec
+		for range 8 {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7 //nolint:mnd
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c
+	}
+	return table
+}()
+
+// bzip2CRC updates the CRC used by bzip2 (CRC-32 in big-endian bit order)
+// with n copies of b.
+func bzip2CRC(crc uint32, b byte, n int) uint32 {
+	for range n {
+		crc = crc<<8 ^ bzip2CRCTable[byte(crc>>24)^b] //nolint:mnd
+	}
+	return crc
+}
+
+// appendBits appends the first bits bits of data.
+func (w *bitWriter) appendBits(data []byte, bits int64) {
+	for _, b := range data[:bits/8] {
+		w.write(uint64(b), 8) //nolint:mnd
+	}
+	if rest := uint(bits % 8); rest > 0 { //nolint:gosec
+		w.write(uint64(data[bits/8]>>(8-rest)), rest) //nolint:mnd
+	}
+}
+
+// bzip2EncodedBlock is a compressed block which is not byte aligned.
+type bzip2EncodedBlock struct {
+	Data []byte
+	Bits int64
+	CRC  uint32
+}
+
+// bzip2Writer compresses data into a single bzip2 stream. Blocks are compressed
+// in parallel using up to threads goroutines, but written out in order.
+//
+// The encoder is simple: it uses the same Huffman table for all symbols
+// of a block, so compression ratio is lower than that of the reference implementation.
+type bzip2Writer struct {
+	writer  io.Writer
+	threads int
+
+	block   []byte
+	crc     uint32
+	runByte byte
+	runLen  int
+
+	pending  []chan bzip2EncodedBlock
+	out      bitWriter
+	combined uint32
+	started  bool
+	err      errors.E
+}
+
+func newBZIP2Writer(w io.Writer, threads int) *bzip2Writer {
+	return &bzip2Writer{
+		writer:  w,
+		threads: max(threads, 1),
+		crc:     0xFFFFFFFF, //nolint:mnd
+	}
+}
+
+func (z *bzip2Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	for _, b := range p {
+		if z.runLen > 0 && (b != z.runByte || z.runLen == bzip2MaxRun) {
+			z.flushRun()
+			if len(z.block) >= bzip2MaxBlockSize {
+				z.submit()
+				if z.err != nil {
+					return 0, z.err
+				}
+			}
+		}
+		z.runByte = b
+		z.runLen++
+	}
+	return len(p), nil
+}
+
+// flushRun adds the current run to the block using the initial run-length encoding:
+// runs of 4 to 255 equal bytes are stored as 4 bytes and the number of remaining bytes.
+func (z *bzip2Writer) flushRun() {
+	z.crc = bzip2CRC(z.crc, z.runByte, z.runLen)
+	if z.runLen < 4 { //nolint:mnd
+		for range z.runLen {
+			z.block = append(z.block, z.runByte)
+		}
+	} else {
+		z.block = append(z.block, z.runByte, z.runByte, z.runByte, z.runByte, byte(z.runLen-4)) //nolint:gosec
+	}
+	z.runLen = 0
+}
+
+func (z *bzip2Writer) submit() {
+	block := z.block
+	crc := ^z.crc
+	z.block = make([]byte, 0, len(block))
+	z.crc = 0xFFFFFFFF
+	result := make(chan bzip2EncodedBlock, 1)
+	go func() {
+		result <- encodeBZIP2Block(block, crc)
+	}()
+	z.pending = append(z.pending, result)
+	if len(z.pending) >= z.threads {
+		z.writeBlock()
+	}
+}
+
+// writeBlock waits for the oldest pending block and writes it out.
+func (z *bzip2Writer) writeBlock() {
+	block := <-z.pending[0]
+	z.pending = z.pending[1:]
+	z.start()
+	z.combined = (z.combined<<1 | z.combined>>31) ^ block.CRC
+	z.out.appendBits(block.Data, block.Bits)
+	z.writeOut()
+}
+
+func (z *bzip2Writer) start() {
+	if !z.started {
+		z.started = true
+		z.out.out = append(z.out.out, 'B', 'Z', 'h', '0'+bzip2Level)
+	}
+}
+
+// writeOut writes out all complete bytes.
+func (z *bzip2Writer) writeOut() {
+	if z.err != nil || len(z.out.out) == 0 {
+		return
+	}
+	_, err := z.writer.Write(z.out.out)
+	z.err = errors.WithStack(err)
+	z.out.out = z.out.out[:0]
+}
+
+func (z *bzip2Writer) Close() error {
+	if z.runLen > 0 {
+		z.flushRun()
+	}
+	if len(z.block) > 0 {
+		z.submit()
+	}
+	for len(z.pending) > 0 {
+		z.writeBlock()
+	}
+	if z.err != nil {
+		return z.err
+	}
+	z.start()
+	z.out.write(bzip2EOSMagic, 48)      //nolint:mnd
+	z.out.write(uint64(z.combined), 32) //nolint:mnd
+	z.out.flush()
+	z.writeOut()
+	return z.err
+}
+
+// bwt computes the Burrows-Wheeler transform of the block by sorting its
+// rotations using prefix doubling. It returns the last column and the row of
+// the original block.
+func bwt(block []byte) ([]byte, int) {
+	n := len(block)
+	sa := make([]int, n)
+	rank := make([]int, n)
+	tmp := make([]int, n)
+	count := make([]int, max(n, 256)) //nolint:mnd
+
+	for _, b := range block {
+		count[b]++
+	}
+	for i := 1; i < 256; i++ {
+		count[i] += count[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		count[block[i]]--
+		sa[count[block[i]]] = i
+	}
+	classes := 1
+	rank[sa[0]] = 0
+	for j := 1; j < n; j++ {
+		if block[sa[j]] != block[sa[j-1]] {
+			classes++
+		}
+		rank[sa[j]] = classes - 1
+	}
+
+	for k := 1; k < n && classes < n; k <<= 1 {
+		// Rotations ordered by their second half.
+		for j := range n {
+			tmp[j] = (sa[j] - k + n) % n
+		}
+		// Stable counting sort by the first half.
+		clear(count[:classes])
+		for i := range n {
+			count[rank[i]]++
+		}
+		for r := 1; r < classes; r++ {
+			count[r] += count[r-1]
+		}
+		for j := n - 1; j >= 0; j-- {
+			i := tmp[j]
+			count[rank[i]]--
+			sa[count[rank[i]]] = i
+		}
+		classes = 1
+		tmp[sa[0]] = 0
+		for j := 1; j < n; j++ {
+			cur, prev := sa[j], sa[j-1]
+			if rank[cur] != rank[prev] || rank[(cur+k)%n] != rank[(prev+k)%n] {
+				classes++
+			}
+			tmp[cur] = classes - 1
+		}
+		rank, tmp = tmp, rank
+	}
+
+	last := make([]byte, n)
+	origPtr := 0
+	for j, i := range sa {
+		last[j] = block[(i+n-1)%n]
+		if i == 0 {
+			origPtr = j
+		}
+	}
+	return last, origPtr
+}
+
+// huffmanLengths computes lengths of Huffman codes for the frequencies, limited to maxLength.
+// Symbols with zero frequency get a code, too.
+func huffmanLengths(frequencies []int, maxLength int) []int {
+	weights := make([]int, len(frequencies))
+	for i, f := range frequencies {
+		weights[i] = max(f, 1)
+	}
+	for {
+		parents := make([]int, len(weights), 2*len(weights))
+		nodes := slices.Clone(weights)
+		active := make([]int, len(weights))
+		for i := range active {
+			parents[i] = -1
+			active[i] = i
+		}
+		for len(active) > 1 {
+			slices.SortStableFunc(active, func(a, b int) int {
+				return nodes[b] - nodes[a]
+			})
+			a, b := active[len(active)-1], active[len(active)-2]
+			parent := len(nodes)
+			nodes = append(nodes, nodes[a]+nodes[b])
+			parents = append(parents, -1)
+			parents[a] = parent
+			parents[b] = parent
+			active = append(active[:len(active)-2], parent)
+		}
+		lengths := make([]int, len(weights))
+		longest := 0
+		for i := range lengths {
+			for p := parents[i]; p != -1; p = parents[p] {
+				lengths[i]++
+			}
+			longest = max(longest, lengths[i])
+		}
+		if longest <= maxLength {
+			return lengths
+		}
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2 //nolint:mnd
+		}
+	}
+}
+
+// encodeBZIP2Block compresses a run-length encoded block, starting with the block magic number.
+func encodeBZIP2Block(block []byte, crc uint32) bzip2EncodedBlock {
+	last, origPtr := bwt(block)
+
+	var inUse [256]bool
+	for _, b := range last {
+		inUse[b] = true
+	}
+	unseqToSeq := [256]byte{}
+	mtf := []byte{}
+	for b, used := range inUse {
+		if used {
+			unseqToSeq[b] = byte(len(mtf))
+			mtf = append(mtf, byte(len(mtf)))
+		}
+	}
+	endOfBlock := len(mtf) + 1
+	alphabetSize := len(mtf) + 2 //nolint:mnd
+
+	// Move-to-front transform with zero runs encoded using RUNA (0) and RUNB (1).
+	symbols := make([]uint16, 0, len(last)+1)
+	zeros := 0
+	flushZeros := func() {
+		if zeros == 0 {
+			return
+		}
+		zeros--
+		for {
+			symbols = append(symbols, uint16(zeros&1)) //nolint:gosec
+			if zeros < 2 {                             //nolint:mnd
+				break
+			}
+			zeros = (zeros - 2) / 2 //nolint:mnd
+		}
+		zeros = 0
+	}
+	for _, b := range last {
+		seq := unseqToSeq[b]
+		j := slices.Index(mtf, seq)
+		if j == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		copy(mtf[1:j+1], mtf[:j])
+		mtf[0] = seq
+		symbols = append(symbols, uint16(j+1)) //nolint:gosec
+	}
+	flushZeros()
+	symbols = append(symbols, uint16(endOfBlock)) //nolint:gosec
+
+	frequencies := make([]int, alphabetSize)
+	for _, s := range symbols {
+		frequencies[s]++
+	}
+	lengths := huffmanLengths(frequencies, bzip2MaxCodeLength)
+	codes := make([]uint64, alphabetSize)
+	code := uint64(0)
+	for length := 1; length <= bzip2MaxCodeLength; length++ {
+		for s, l := range lengths {
+			if l == length {
+				codes[s] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+
+	w := &bitWriter{out: make([]byte, 0, len(block)/2)} //nolint:mnd
+	w.write(bzip2BlockMagic, 48)                        //nolint:mnd
+	w.write(uint64(crc), 32)                            //nolint:mnd
+	w.write(0, 1)
+	w.write(uint64(origPtr), 24) //nolint:mnd,gosec
+
+	var ranges uint64
+	for r := range 16 {
+		if slices.Contains(inUse[r*16:r*16+16], true) {
+			ranges |= 1 << (15 - r)
+		}
+	}
+	w.write(ranges, 16) //nolint:mnd
+	for r := range 16 {
+		if ranges&(1<<(15-r)) == 0 {
+			continue
+		}
+		var bits uint64
+		for i := range 16 {
+			if inUse[r*16+i] {
+				bits |= 1 << (15 - i)
+			}
+		}
+		w.write(bits, 16) //nolint:mnd
+	}
+
+	// At least two Huffman tables are required, we use the same table twice
+	// and select the first one for all groups.
+	const tables = 2
+	w.write(tables, 3) //nolint:mnd
+	selectors := (len(symbols) + bzip2GroupSize - 1) / bzip2GroupSize
+	w.write(uint64(selectors), 15) //nolint:mnd,gosec
+	for range selectors {
+		w.write(0, 1)
+	}
+	for range tables {
+		current := lengths[0]
+		w.write(uint64(current), 5) //nolint:mnd,gosec
+		for _, length := range lengths {
+			for current < length {
+				w.write(2, 2) //nolint:mnd
+				current++
+			}
+			for current > length {
+				w.write(3, 2) //nolint:mnd
+				current--
+			}
+			w.write(0, 1)
+		}
+	}
+	for _, s := range symbols {
+		w.write(codes[s], uint(lengths[s])) //nolint:gosec
+	}
+
+	bits := int64(len(w.out))*8 + int64(w.n) //nolint:gosec
+	w.flush()
+	return bzip2EncodedBlock{
+		Data: w.out,
+		Bits: bits,
+		CRC:  crc,
+	}
+}
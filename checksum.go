@@ -0,0 +1,119 @@
+package mediawiki
+
+import (
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// Checksum is an expected checksum of a dump file. Wikimedia dumps are
+// published with MD5 and SHA1 checksums (see DumpFile).
+//
+// Checksums are in hex. Any of them can be set and all which are
+// set are verified. Zero value means no verification.
+type Checksum struct {
+	MD5  string
+	SHA1 string
+}
+
+// IsZero returns true if no checksum is set.
+func (c Checksum) IsZero() bool {
+	return c.MD5 == "" && c.SHA1 == ""
+}
+
+// Checksum returns the checksum of the dump file.
+func (f DumpFile) Checksum() Checksum {
+	return Checksum{
+		MD5:  f.MD5,
+		SHA1: f.SHA1,
+	}
+}
+
+// checksumHasher hashes all data written to it with algorithms of
+// the set checksums.
+type checksumHasher struct {
+	checksum Checksum
+	md5      hash.Hash
+	sha1     hash.Hash
+	writer   io.Writer
+}
+
+func newChecksumHasher(checksum Checksum) *checksumHasher {
+	h := &checksumHasher{
+		checksum: checksum,
+		md5:      nil,
+		sha1:     nil,
+		writer:   nil,
+	}
+	writers := []io.Writer{}
+	if checksum.MD5 != "" {
+		h.md5 = md5.New() //nolint:gosec
+		writers = append(writers, h.md5)
+	}
+	if checksum.SHA1 != "" {
+		h.sha1 = sha1.New() //nolint:gosec
+		writers = append(writers, h.sha1)
+	}
+	h.writer = io.MultiWriter(writers...)
+	return h
+}
+
+func (h *checksumHasher) Write(p []byte) (int, error) {
+	return h.writer.Write(p) //nolint:wrapcheck
+}
+
+// verify returns ErrChecksumMismatch if hashed data does not match the checksum.
+func (h *checksumHasher) verify() errors.E {
+	for _, c := range []struct {
+		algorithm string
+		expected  string
+		hash      hash.Hash
+	}{
+		{"md5", h.checksum.MD5, h.md5},
+		{"sha1", h.checksum.SHA1, h.sha1},
+	} {
+		if c.hash == nil {
+			continue
+		}
+		actual := hex.EncodeToString(c.hash.Sum(nil))
+		if actual != strings.ToLower(c.expected) {
+			errE := errors.WithDetails(ErrChecksumMismatch, "algorithm", c.algorithm)
+			errors.Details(errE)["expected"] = strings.ToLower(c.expected)
+			errors.Details(errE)["actual"] = actual
+			return errE
+		}
+	}
+	return nil
+}
+
+// VerifyDumpFile verifies that the dump file at path matches the checksum.
+// It returns ErrChecksumMismatch if it does not.
+func VerifyDumpFile(path string, checksum Checksum) errors.E {
+	file, err := os.Open(path)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = path
+		return errE
+	}
+	defer file.Close() //nolint:errcheck
+
+	hasher := newChecksumHasher(checksum)
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["path"] = path
+		return errE
+	}
+	errE := hasher.verify()
+	if errE != nil {
+		errors.Details(errE)["path"] = path
+		return errE
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package mediawiki_test
+
+import (
+	"context"
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func checksumOf(data []byte) mediawiki.Checksum {
+	md5sum := md5.Sum(data)   //nolint:gosec
+	sha1sum := sha1.Sum(data) //nolint:gosec
+	return mediawiki.Checksum{
+		MD5:  hex.EncodeToString(md5sum[:]),
+		SHA1: hex.EncodeToString(sha1sum[:]),
+	}
+}
+
+func TestVerifyDumpFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join("testdata", "wikidata-testdata-index.json.bz2")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	checksum := checksumOf(data)
+
+	errE := mediawiki.VerifyDumpFile(path, checksum)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = mediawiki.VerifyDumpFile(path, mediawiki.Checksum{SHA1: strings.ToUpper(checksum.SHA1)})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = mediawiki.VerifyDumpFile(path, mediawiki.Checksum{MD5: checksum.MD5})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = mediawiki.VerifyDumpFile(path, mediawiki.Checksum{MD5: checksum.MD5, SHA1: strings.Repeat("0", 40)})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.Equal(t, map[string]interface{}{
+		"algorithm": "sha1",
+		"expected":  strings.Repeat("0", 40),
+		"actual":    checksum.SHA1,
+		"path":      path,
+	}, errors.AllDetails(errE))
+
+	errE = mediawiki.VerifyDumpFile(filepath.Join("testdata", "missing"), checksum)
+	assert.ErrorIs(t, errE, os.ErrNotExist)
+}
+
+func processNDJSON(t *testing.T, config *mediawiki.ProcessConfig[json.RawMessage]) (int64, errors.E) {
+	t.Helper()
+
+	var count int64
+	config.Process = func(_ context.Context, _ json.RawMessage) errors.E {
+		atomic.AddInt64(&count, 1)
+		return nil
+	}
+	config.FileType = mediawiki.NDJSON
+	config.Compression = mediawiki.NoCompression
+	errE := mediawiki.Process(context.Background(), config)
+	return count, errE
+}
+
+func TestProcessChecksum(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n")
+	checksum := checksumOf(data)
+	wrong := mediawiki.Checksum{SHA1: strings.Repeat("0", 40)}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.ndjson")
+
+	// Download with mismatching checksum is not saved.
+	_, errE := processNDJSON(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: wrong})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.Equal(t, ts.URL, errors.AllDetails(errE)["url"])
+	assert.NoFileExists(t, path)
+
+	count, errE := processNDJSON(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(3), count)
+	assert.FileExists(t, path)
+
+	// Cached file is verified, too.
+	count, errE = processNDJSON(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(3), count)
+
+	// Corrupted cached file is rejected and removed.
+	require.NoError(t, os.WriteFile(path, []byte("{\"id\":1}\n{\"id\":2}\n"), 0o600))
+	_, errE = processNDJSON(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.Equal(t, path, errors.AllDetails(errE)["path"])
+	assert.NoFileExists(t, path)
+
+	// Without URL, the file is not removed.
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	_, errE = processNDJSON(t, &mediawiki.ProcessConfig[json.RawMessage]{Path: path, Checksum: wrong})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.FileExists(t, path)
+}
+
+func processBZIP2JSONArray(t *testing.T, config *mediawiki.ProcessConfig[json.RawMessage]) (int64, errors.E) {
+	t.Helper()
+
+	var count int64
+	config.Process = func(_ context.Context, _ json.RawMessage) errors.E {
+		atomic.AddInt64(&count, 1)
+		return nil
+	}
+	config.FileType = mediawiki.JSONArray
+	config.Compression = mediawiki.BZIP2
+	errE := mediawiki.Process(context.Background(), config)
+	return count, errE
+}
+
+func TestProcessChecksumCorrupted(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "wikidata-testdata-index.json.bz2"))
+	require.NoError(t, err)
+	checksum := checksumOf(data)
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 0x01
+	truncated := data[:len(data)/2]
+
+	var served atomic.Value
+	served.Store(data)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		body := served.Load().([]byte) //nolint:forcetypeassert
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(ts.Close)
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.json.bz2")
+
+	// Bit-flipped cached file is rejected before any item is processed, and removed.
+	require.NoError(t, os.WriteFile(path, flipped, 0o600))
+	count, errE := processBZIP2JSONArray(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.Equal(t, path, errors.AllDetails(errE)["path"])
+	assert.Equal(t, ts.URL, errors.AllDetails(errE)["url"])
+	assert.Equal(t, int64(0), count)
+	assert.NoFileExists(t, path)
+
+	// Truncated cached file is rejected, too.
+	require.NoError(t, os.WriteFile(path, truncated, 0o600))
+	count, errE = processBZIP2JSONArray(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.Equal(t, int64(0), count)
+	assert.NoFileExists(t, path)
+
+	// Decompression error of a corrupted download is reported as checksum mismatch.
+	served.Store(flipped)
+	_, errE = processBZIP2JSONArray(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.ErrorIs(t, errE, mediawiki.ErrChecksumMismatch)
+	assert.Equal(t, path, errors.AllDetails(errE)["path"])
+	assert.NoFileExists(t, path)
+
+	served.Store(data)
+	count, errE = processBZIP2JSONArray(t, &mediawiki.ProcessConfig[json.RawMessage]{URL: ts.URL, Path: path, Client: client, Checksum: checksum})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(2550), count)
+	assert.FileExists(t, path)
+}
@@ -0,0 +1,458 @@
+package mediawiki
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"io"
+	"slices"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const classGraphMagic = "MWCLASSGRAPH1\n"
+
+// PartOfProperty is Wikidata's "part of" property.
+const PartOfProperty = "P361"
+
+type classGraphEdge struct {
+	From uint32
+	To   uint32
+}
+
+// adjacency is a compressed sparse row representation of edges.
+type adjacency struct {
+	Offsets []uint32
+	Targets []uint32
+}
+
+func (a adjacency) neighbors(node uint32) []uint32 {
+	if int(node)+1 >= len(a.Offsets) {
+		return nil
+	}
+	return a.Targets[a.Offsets[node]:a.Offsets[node+1]]
+}
+
+func (a adjacency) edges() []classGraphEdge {
+	edges := make([]classGraphEdge, 0, len(a.Targets))
+	for node := range len(a.Offsets) - 1 {
+		for _, target := range a.neighbors(uint32(node)) { //nolint:gosec
+			edges = append(edges, classGraphEdge{uint32(node), target}) //nolint:gosec
+		}
+	}
+	return edges
+}
+
+func newAdjacency(nodes int, edges []classGraphEdge) adjacency {
+	edges = slices.Clone(edges)
+	slices.SortFunc(edges, func(a, b classGraphEdge) int {
+		return cmp.Or(cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To))
+	})
+	edges = slices.Compact(edges)
+	a := adjacency{
+		Offsets: make([]uint32, nodes+1),
+		Targets: make([]uint32, len(edges)),
+	}
+	for i, edge := range edges {
+		a.Offsets[edge.From+1]++
+		a.Targets[i] = edge.To
+	}
+	for i := 1; i <= nodes; i++ {
+		a.Offsets[i] += a.Offsets[i-1]
+	}
+	return a
+}
+
+func reverseEdges(edges []classGraphEdge) []classGraphEdge {
+	reversed := make([]classGraphEdge, len(edges))
+	for i, edge := range edges {
+		reversed[i] = classGraphEdge{edge.To, edge.From}
+	}
+	return reversed
+}
+
+// ClassGraph is a transitive class hierarchy built from "instance of"
+// and "subclass of" statements.
+//
+// Add entities to it (e.g., by passing Add to ProcessWikidataDump) and
+// then query it. Edges are stored in a compact adjacency structure which
+// is rebuilt on the first query after new entities have been added.
+// Cycles in the hierarchy are supported.
+//
+// InstanceOfProperties default to P31 and SubclassOfProperties to P279.
+// Other relations can be used as well, e.g., P361 (part of) as a subclass
+// relation. Only non-deprecated statements are used.
+type ClassGraph struct {
+	InstanceOfProperties []string
+	SubclassOfProperties []string
+
+	mu    sync.RWMutex
+	nodes map[string]uint32
+	names []string
+	// New edges are collected until the next query.
+	pending    bool
+	instanceOf []classGraphEdge
+	subclassOf []classGraphEdge
+	// Compact adjacency, valid when pending is false.
+	instanceOfAdjacency adjacency
+	instancesAdjacency  adjacency
+	subclassOfAdjacency adjacency
+	subclassesAdjacency adjacency
+}
+
+// node returns the node for the ID, creating it if necessary. Caller has to hold the write lock.
+func (g *ClassGraph) node(id string) uint32 {
+	if n, ok := g.nodes[id]; ok {
+		return n
+	}
+	if g.nodes == nil {
+		g.nodes = map[string]uint32{}
+	}
+	n := uint32(len(g.names)) //nolint:gosec
+	g.nodes[id] = n
+	g.names = append(g.names, id)
+	return n
+}
+
+func propertiesOrDefault(properties []string, property string) []string {
+	if properties == nil {
+		return []string{property}
+	}
+	return properties
+}
+
+// Add adds edges from the entity's statements to the graph.
+//
+// It is safe to call Add concurrently.
+func (g *ClassGraph) Add(_ context.Context, entity Entity) errors.E {
+	instanceOf := []string{}
+	for _, property := range propertiesOrDefault(g.InstanceOfProperties, InstanceOfProperty) {
+		instanceOf = append(instanceOf, itemValues(entity, property)...)
+	}
+	subclassOf := []string{}
+	for _, property := range propertiesOrDefault(g.SubclassOfProperties, SubclassOfProperty) {
+		subclassOf = append(subclassOf, itemValues(entity, property)...)
+	}
+	if len(instanceOf) == 0 && len(subclassOf) == 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.pending {
+		// Continue from compacted edges.
+		g.instanceOf = g.instanceOfAdjacency.edges()
+		g.subclassOf = g.subclassOfAdjacency.edges()
+	}
+	from := g.node(entity.ID)
+	for _, id := range instanceOf {
+		g.instanceOf = append(g.instanceOf, classGraphEdge{from, g.node(id)})
+	}
+	for _, id := range subclassOf {
+		g.subclassOf = append(g.subclassOf, classGraphEdge{from, g.node(id)})
+	}
+	g.pending = true
+	return nil
+}
+
+// compact rebuilds adjacency structures if needed and returns with the read lock held.
+func (g *ClassGraph) compact() {
+	g.mu.RLock()
+	if !g.pending {
+		return
+	}
+	g.mu.RUnlock()
+
+	g.mu.Lock()
+	if g.pending {
+		nodes := len(g.names)
+		g.instanceOfAdjacency = newAdjacency(nodes, g.instanceOf)
+		g.instancesAdjacency = newAdjacency(nodes, reverseEdges(g.instanceOf))
+		g.subclassOfAdjacency = newAdjacency(nodes, g.subclassOf)
+		g.subclassesAdjacency = newAdjacency(nodes, reverseEdges(g.subclassOf))
+		// Edges are now stored in adjacency structures.
+		g.instanceOf = nil
+		g.subclassOf = nil
+		g.pending = false
+	}
+	g.mu.Unlock()
+	g.mu.RLock()
+}
+
+// reachable returns all nodes reachable from start nodes, excluding start nodes
+// unless they are reachable through a cycle. Caller has to hold the read lock.
+func reachable(a adjacency, start []uint32, visited map[uint32]bool) map[uint32]bool {
+	if visited == nil {
+		visited = map[uint32]bool{}
+	}
+	queue := []uint32{}
+	for _, node := range start {
+		queue = append(queue, a.neighbors(node)...)
+	}
+	for len(queue) > 0 {
+		node := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if visited[node] {
+			continue
+		}
+		visited[node] = true
+		queue = append(queue, a.neighbors(node)...)
+	}
+	return visited
+}
+
+func (g *ClassGraph) sortedNames(nodes map[uint32]bool) []string {
+	names := make([]string, 0, len(nodes))
+	for node := range nodes {
+		names = append(names, g.names[node])
+	}
+	slices.SortFunc(names, CompareEntityIDs)
+	return names
+}
+
+// IsSubclassOf returns true if class is transitively a subclass of superclass.
+//
+// A class is considered a subclass of itself, the same as P279* in SPARQL.
+func (g *ClassGraph) IsSubclassOf(class, superclass string) bool {
+	if class == superclass {
+		return true
+	}
+	g.compact()
+	defer g.mu.RUnlock()
+
+	c, ok := g.nodes[class]
+	if !ok {
+		return false
+	}
+	s, ok := g.nodes[superclass]
+	if !ok {
+		return false
+	}
+	return reachable(g.subclassOfAdjacency, []uint32{c}, nil)[s]
+}
+
+// IsInstanceOf returns true if item is an instance of class or of any of its subclasses.
+func (g *ClassGraph) IsInstanceOf(item, class string) bool {
+	g.compact()
+	defer g.mu.RUnlock()
+
+	i, ok := g.nodes[item]
+	if !ok {
+		return false
+	}
+	c, ok := g.nodes[class]
+	if !ok {
+		return false
+	}
+	classes := g.instanceOfAdjacency.neighbors(i)
+	visited := map[uint32]bool{}
+	for _, node := range classes {
+		visited[node] = true
+	}
+	return reachable(g.subclassOfAdjacency, classes, visited)[c]
+}
+
+// Classes returns classes the item is directly an instance of.
+func (g *ClassGraph) Classes(item string) []string {
+	g.compact()
+	defer g.mu.RUnlock()
+
+	i, ok := g.nodes[item]
+	if !ok {
+		return nil
+	}
+	nodes := map[uint32]bool{}
+	for _, node := range g.instanceOfAdjacency.neighbors(i) {
+		nodes[node] = true
+	}
+	return g.sortedNames(nodes)
+}
+
+// AllSuperclasses returns all transitive superclasses of classes, sorted by ID.
+//
+// Classes themselves are included only if they are part of a cycle.
+func (g *ClassGraph) AllSuperclasses(classes ...string) []string {
+	g.compact()
+	defer g.mu.RUnlock()
+
+	start := []uint32{}
+	for _, class := range classes {
+		if c, ok := g.nodes[class]; ok {
+			start = append(start, c)
+		}
+	}
+	return g.sortedNames(reachable(g.subclassOfAdjacency, start, nil))
+}
+
+// AllSubclasses returns all transitive subclasses of the class, sorted by ID.
+//
+// The class itself is included only if it is part of a cycle.
+func (g *ClassGraph) AllSubclasses(class string) []string {
+	g.compact()
+	defer g.mu.RUnlock()
+
+	c, ok := g.nodes[class]
+	if !ok {
+		return nil
+	}
+	return g.sortedNames(reachable(g.subclassesAdjacency, []uint32{c}, nil))
+}
+
+// AllInstancesOf returns all items which are instances of the class
+// or of any of its transitive subclasses, sorted by ID.
+func (g *ClassGraph) AllInstancesOf(class string) []string {
+	g.compact()
+	defer g.mu.RUnlock()
+
+	c, ok := g.nodes[class]
+	if !ok {
+		return nil
+	}
+	classes := reachable(g.subclassesAdjacency, []uint32{c}, map[uint32]bool{c: true})
+	instances := map[uint32]bool{}
+	for class := range classes {
+		for _, instance := range g.instancesAdjacency.neighbors(class) {
+			instances[instance] = true
+		}
+	}
+	return g.sortedNames(instances)
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	_, _ = w.Write(buf[:n])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	_, _ = w.WriteString(s)
+}
+
+func writeStrings(w *bufio.Writer, ss []string) {
+	writeUvarint(w, uint64(len(ss)))
+	for _, s := range ss {
+		writeString(w, s)
+	}
+}
+
+func writeEdges(w *bufio.Writer, edges []classGraphEdge) {
+	writeUvarint(w, uint64(len(edges)))
+	for _, edge := range edges {
+		writeUvarint(w, uint64(edge.From))
+		writeUvarint(w, uint64(edge.To))
+	}
+}
+
+// Save writes the graph to w in a compact binary format which can be read with LoadClassGraph.
+func (g *ClassGraph) Save(w io.Writer) errors.E {
+	g.compact()
+	defer g.mu.RUnlock()
+
+	buf := bufio.NewWriter(w)
+	_, _ = buf.WriteString(classGraphMagic)
+	writeStrings(buf, propertiesOrDefault(g.InstanceOfProperties, InstanceOfProperty))
+	writeStrings(buf, propertiesOrDefault(g.SubclassOfProperties, SubclassOfProperty))
+	writeStrings(buf, g.names)
+	writeEdges(buf, g.instanceOfAdjacency.edges())
+	writeEdges(buf, g.subclassOfAdjacency.edges())
+	return errors.WithStack(buf.Flush())
+}
+
+func readUvarint(r *bufio.Reader, limit uint64) (uint64, errors.E) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if v > limit {
+		errE := errors.WithMessage(ErrInvalidValue, "class graph")
+		errors.Details(errE)["value"] = v
+		return 0, errE
+	}
+	return v, nil
+}
+
+func readStrings(r *bufio.Reader) ([]string, errors.E) {
+	n, errE := readUvarint(r, 1<<32) //nolint:mnd
+	if errE != nil {
+		return nil, errE
+	}
+	ss := []string{}
+	for range n {
+		l, errE := readUvarint(r, 1<<16) //nolint:mnd
+		if errE != nil {
+			return nil, errE
+		}
+		b := make([]byte, l)
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ss = append(ss, string(b))
+	}
+	return ss, nil
+}
+
+func readEdges(r *bufio.Reader, nodes int) ([]classGraphEdge, errors.E) {
+	n, errE := readUvarint(r, 1<<40) //nolint:mnd
+	if errE != nil {
+		return nil, errE
+	}
+	edges := []classGraphEdge{}
+	for range n {
+		from, errE := readUvarint(r, uint64(nodes-1)) //nolint:gosec
+		if errE != nil {
+			return nil, errE
+		}
+		to, errE := readUvarint(r, uint64(nodes-1)) //nolint:gosec
+		if errE != nil {
+			return nil, errE
+		}
+		edges = append(edges, classGraphEdge{uint32(from), uint32(to)})
+	}
+	return edges, nil
+}
+
+// LoadClassGraph reads the graph written by ClassGraph.Save.
+func LoadClassGraph(r io.Reader) (*ClassGraph, errors.E) {
+	buf := bufio.NewReader(r)
+	magic := make([]byte, len(classGraphMagic))
+	_, err := io.ReadFull(buf, magic)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if string(magic) != classGraphMagic {
+		return nil, errors.WithMessage(ErrInvalidValue, "class graph magic")
+	}
+	g := &ClassGraph{}
+	var errE errors.E
+	g.InstanceOfProperties, errE = readStrings(buf)
+	if errE != nil {
+		return nil, errE
+	}
+	g.SubclassOfProperties, errE = readStrings(buf)
+	if errE != nil {
+		return nil, errE
+	}
+	g.names, errE = readStrings(buf)
+	if errE != nil {
+		return nil, errE
+	}
+	g.nodes = make(map[string]uint32, len(g.names))
+	for i, name := range g.names {
+		g.nodes[name] = uint32(i) //nolint:gosec
+	}
+	g.instanceOf, errE = readEdges(buf, len(g.names))
+	if errE != nil {
+		return nil, errE
+	}
+	g.subclassOf, errE = readEdges(buf, len(g.names))
+	if errE != nil {
+		return nil, errE
+	}
+	g.pending = true
+	return g, nil
+}
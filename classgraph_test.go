@@ -0,0 +1,111 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func classGraphTestEntity(id string, relations map[string][]string) mediawiki.Entity {
+	entity := mediawiki.Entity{ID: id, Claims: map[string][]mediawiki.Statement{}}
+	for property, values := range relations {
+		for _, value := range values {
+			rank := mediawiki.Normal
+			if strings.HasPrefix(value, "-") {
+				value = strings.TrimPrefix(value, "-")
+				rank = mediawiki.Deprecated
+			}
+			entity.Claims[property] = append(entity.Claims[property], mediawiki.Statement{
+				Rank: rank,
+				MainSnak: mediawiki.Snak{
+					SnakType:  mediawiki.Value,
+					Property:  property,
+					DataValue: &mediawiki.DataValue{Value: mediawiki.WikiBaseEntityIDValue{Type: mediawiki.ItemType, ID: value}},
+				},
+			})
+		}
+	}
+	return entity
+}
+
+func TestClassGraph(t *testing.T) {
+	t.Parallel()
+
+	graph := &mediawiki.ClassGraph{}
+	for _, entity := range []mediawiki.Entity{
+		// City is a human settlement, which is a geographic location.
+		classGraphTestEntity("Q515", map[string][]string{"P279": {"Q486972"}}),
+		classGraphTestEntity("Q486972", map[string][]string{"P279": {"Q2221906"}}),
+		// Capital is a city.
+		classGraphTestEntity("Q5119", map[string][]string{"P279": {"Q515"}}),
+		// A cycle.
+		classGraphTestEntity("Q1", map[string][]string{"P279": {"Q2"}}),
+		classGraphTestEntity("Q2", map[string][]string{"P279": {"Q1", "Q3"}}),
+		// Ljubljana is a capital and city (twice).
+		classGraphTestEntity("Q437", map[string][]string{"P31": {"Q5119", "Q515", "Q515", "-Q5"}, "P361": {"Q215"}}),
+		classGraphTestEntity("Q1748", map[string][]string{"P31": {"Q515"}}),
+		classGraphTestEntity("Q42", map[string][]string{"P31": {"Q5"}}),
+	} {
+		errE := graph.Add(context.Background(), entity)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	assert.True(t, graph.IsSubclassOf("Q5119", "Q2221906"))
+	assert.True(t, graph.IsSubclassOf("Q515", "Q515"))
+	assert.False(t, graph.IsSubclassOf("Q486972", "Q515"))
+	assert.False(t, graph.IsSubclassOf("Q999", "Q515"))
+	assert.True(t, graph.IsInstanceOf("Q437", "Q2221906"))
+	assert.True(t, graph.IsInstanceOf("Q437", "Q515"))
+	// Deprecated statements are ignored.
+	assert.False(t, graph.IsInstanceOf("Q437", "Q5"))
+	assert.False(t, graph.IsInstanceOf("Q42", "Q515"))
+
+	assert.Equal(t, []string{"Q515", "Q5119"}, graph.Classes("Q437"))
+	assert.Equal(t, []string{"Q515", "Q486972", "Q2221906"}, graph.AllSuperclasses("Q5119"))
+	assert.Equal(t, []string{"Q1", "Q2", "Q3"}, graph.AllSuperclasses("Q1"))
+	assert.Equal(t, []string{"Q1", "Q2"}, graph.AllSubclasses("Q2"))
+	assert.Equal(t, []string{"Q515", "Q5119"}, graph.AllSubclasses("Q486972"))
+	assert.Equal(t, []string{"Q437", "Q1748"}, graph.AllInstancesOf("Q486972"))
+	assert.Equal(t, []string{"Q437"}, graph.AllInstancesOf("Q5119"))
+	assert.Empty(t, graph.AllInstancesOf("Q999"))
+
+	// Adding after querying works.
+	errE := graph.Add(context.Background(), classGraphTestEntity("Q64", map[string][]string{"P31": {"Q5119"}}))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"Q64", "Q437", "Q1748"}, graph.AllInstancesOf("Q515"))
+
+	var buf bytes.Buffer
+	errE = graph.Save(&buf)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	loaded, errE := mediawiki.LoadClassGraph(&buf)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"P31"}, loaded.InstanceOfProperties)
+	assert.Equal(t, []string{"P279"}, loaded.SubclassOfProperties)
+	assert.Equal(t, graph.AllInstancesOf("Q486972"), loaded.AllInstancesOf("Q486972"))
+	assert.Equal(t, graph.AllSuperclasses("Q1"), loaded.AllSuperclasses("Q1"))
+	assert.True(t, loaded.IsSubclassOf("Q5119", "Q2221906"))
+
+	_, errE = mediawiki.LoadClassGraph(strings.NewReader("invalid"))
+	assert.Error(t, errE)
+
+	// Part of as a relation.
+	partOf := &mediawiki.ClassGraph{
+		InstanceOfProperties: []string{},
+		SubclassOfProperties: []string{mediawiki.PartOfProperty},
+	}
+	for _, entity := range []mediawiki.Entity{
+		classGraphTestEntity("Q437", map[string][]string{"P31": {"Q515"}, "P361": {"Q215"}}),
+		classGraphTestEntity("Q215", map[string][]string{"P361": {"Q46"}}),
+	} {
+		errE := partOf.Add(context.Background(), entity)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	assert.Equal(t, []string{"Q46", "Q215"}, partOf.AllSuperclasses("Q437"))
+	assert.Empty(t, partOf.Classes("Q437"))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"gitlab.com/tozd/go/errors"
+	_ "modernc.org/sqlite"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// filtered calls process on every item in the dump which matches the filter.
+func filtered(
+	ctx context.Context, globals *Globals, input *Input, filter *Filter,
+	process func(context.Context, any) errors.E,
+) errors.E {
+	match, errE := filter.matcher()
+	if errE != nil {
+		return errE
+	}
+	return input.process(ctx, globals, func(ctx context.Context, item any) errors.E {
+		if !match(item) {
+			return nil
+		}
+		return process(ctx, item)
+	})
+}
+
+// printItems prints items matching the filter and for which keep returns true
+// to stdout as NDJSON.
+func printItems(ctx context.Context, globals *Globals, input *Input, filter *Filter, keep func(any) bool) errors.E {
+	writer := &mediawiki.DumpWriter[any]{ //nolint:exhaustruct
+		Writer:   globals.stdout,
+		FileType: mediawiki.NDJSON,
+	}
+	errE := filtered(ctx, globals, input, filter, func(ctx context.Context, item any) errors.E {
+		if keep != nil && !keep(item) {
+			return nil
+		}
+		return writer.Write(ctx, item)
+	})
+	errE2 := writer.Close()
+	return errors.Join(errE, errE2)
+}
+
+// CatCommand prints all items in a dump.
+type CatCommand struct {
+	Input `embed:""`
+}
+
+// Run runs the command.
+func (c *CatCommand) Run(ctx context.Context, globals *Globals) error {
+	return printItems(ctx, globals, &c.Input, &Filter{}, nil) //nolint:exhaustruct
+}
+
+// CountCommand counts items in a dump.
+type CountCommand struct {
+	Input  `embed:""`
+	Filter `embed:""`
+}
+
+// Run runs the command.
+func (c *CountCommand) Run(ctx context.Context, globals *Globals) error {
+	var count int64
+	errE := filtered(ctx, globals, &c.Input, &c.Filter, func(_ context.Context, _ any) errors.E {
+		atomic.AddInt64(&count, 1)
+		return nil
+	})
+	if errE != nil {
+		return errE
+	}
+	_, err := fmt.Fprintln(globals.stdout, count)
+	return errors.WithStack(err)
+}
+
+// SampleCommand prints a sample of items in a dump.
+//
+//nolint:lll
+type SampleCommand struct {
+	Input  `embed:""`
+	Filter `embed:""`
+
+	Rate float64 `help:"Approximate fraction of items to keep, between 0 and 1." placeholder:"FLOAT" required:""`
+	Seed string  `help:"Seed for the sample. The same seed and rate select the same items."  placeholder:"STRING"`
+}
+
+// Run runs the command.
+func (c *SampleCommand) Run(ctx context.Context, globals *Globals) error {
+	if c.Rate <= 0 || c.Rate > 1 {
+		errE := errors.New("rate must be larger than 0 and at most 1")
+		errors.Details(errE)["rate"] = c.Rate
+		return errE
+	}
+	return printItems(ctx, globals, &c.Input, &c.Filter, func(item any) bool {
+		return mediawiki.InSample(itemID(item), c.Seed, c.Rate)
+	})
+}
+
+// GrepCommand prints items in a dump which match the filter.
+type GrepCommand struct {
+	Input  `embed:""`
+	Filter `embed:""`
+}
+
+// Run runs the command.
+func (c *GrepCommand) Run(ctx context.Context, globals *Globals) error {
+	if c.Filter.empty() {
+		return errors.New("at least one of --id, --property, or --title is required")
+	}
+	return printItems(ctx, globals, &c.Input, &c.Filter, nil)
+}
+
+// ConvertCommand writes items in a dump, optionally filtered, to another file type,
+// compression, or to Parquet.
+//
+//nolint:lll
+type ConvertCommand struct {
+	Input  `embed:""`
+	Filter `embed:""`
+
+	Output             string `default:"-"      short:"o"                                                    help:"Path to write to, - for stdout. For Parquet and entities, a directory." placeholder:"PATH" type:"path"`
+	To                 string `default:"ndjson" enum:"json,ndjson,parquet"                                  help:"Output format: ${enum}."`
+	ToCompression      string `default:"none"   enum:"none,tar,bz2,bz2-tar,gzip,gzip-tar,zstd,zstd-tar"    help:"Output compression: ${enum}. Ignored for Parquet."`
+	CompressionThreads int    `default:"0"                                                                   help:"Number of threads to use for compression. Default is the number of CPUs."  placeholder:"INT"`
+}
+
+func (c *ConvertCommand) convertToParquet(ctx context.Context, globals *Globals) errors.E {
+	if c.Output == "-" {
+		return errors.New("output path is required for Parquet")
+	}
+	if c.Dump == "wikipedia" {
+		articles := &mediawiki.ArticlesParquet{Path: c.Output} //nolint:exhaustruct
+		errE := filtered(ctx, globals, &c.Input, &c.Filter, func(ctx context.Context, item any) errors.E {
+			return articles.Add(ctx, item.(mediawiki.Article)) //nolint:forcetypeassert
+		})
+		errE2 := articles.Close()
+		return errors.Join(errE, errE2)
+	}
+	entities := &mediawiki.EntitiesParquet{Path: c.Output} //nolint:exhaustruct
+	errE := filtered(ctx, globals, &c.Input, &c.Filter, func(ctx context.Context, item any) errors.E {
+		return entities.Add(ctx, item.(mediawiki.Entity)) //nolint:forcetypeassert
+	})
+	errE2 := entities.Close()
+	return errors.Join(errE, errE2)
+}
+
+// Run runs the command.
+func (c *ConvertCommand) Run(ctx context.Context, globals *Globals) error {
+	if c.To == "parquet" {
+		return c.convertToParquet(ctx, globals)
+	}
+
+	output := globals.stdout
+	if c.Output != "-" {
+		file, err := os.Create(c.Output)
+		if err != nil {
+			errE := errors.WithMessage(err, "create")
+			errors.Details(errE)["path"] = c.Output
+			return errE
+		}
+		defer file.Close() //nolint:errcheck
+		output = file
+	}
+
+	threads := c.CompressionThreads
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	writer := &mediawiki.DumpWriter[any]{ //nolint:exhaustruct
+		Writer:             output,
+		FileType:           fileTypes[c.To],
+		Compression:        compressions[c.ToCompression],
+		CompressionThreads: threads,
+	}
+	errE := filtered(ctx, globals, &c.Input, &c.Filter, writer.Write)
+	errE2 := writer.Close()
+	errE = errors.Join(errE, errE2)
+	if errE != nil {
+		return errE
+	}
+	if file, ok := output.(*os.File); ok && c.Output != "-" {
+		return errors.WithStack(file.Close())
+	}
+	return nil
+}
+
+// SQLiteCommand loads items in a dump, optionally filtered, into a SQLite database.
+//
+//nolint:lll
+type SQLiteCommand struct {
+	Input  `embed:""`
+	Filter `embed:""`
+
+	Output    string `short:"o"      help:"Path of the SQLite database. It is created if it does not exist. Items already in the database are skipped, so an interrupted load can be resumed." placeholder:"PATH" required:"" type:"path"`
+	BatchSize int    `default:"1000" help:"Number of items to insert in one transaction."                                                                                                         placeholder:"INT"`
+}
+
+// Run runs the command.
+func (c *SQLiteCommand) Run(ctx context.Context, globals *Globals) error {
+	db, err := sql.Open("sqlite", c.Output)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = c.Output
+		return errE
+	}
+	defer db.Close() //nolint:errcheck
+
+	var errE errors.E
+	if c.Dump == "wikipedia" {
+		articles := &mediawiki.ArticlesSQLite{DB: db, BatchSize: c.BatchSize} //nolint:exhaustruct
+		errE = filtered(ctx, globals, &c.Input, &c.Filter, func(ctx context.Context, item any) errors.E {
+			return articles.Add(ctx, item.(mediawiki.Article)) //nolint:forcetypeassert
+		})
+		if errE == nil {
+			errE = articles.Close(ctx)
+		}
+	} else {
+		entities := &mediawiki.EntitiesSQLite{DB: db, BatchSize: c.BatchSize} //nolint:exhaustruct
+		errE = filtered(ctx, globals, &c.Input, &c.Filter, func(ctx context.Context, item any) errors.E {
+			return entities.Add(ctx, item.(mediawiki.Entity)) //nolint:forcetypeassert
+		})
+		if errE == nil {
+			errE = entities.Close(ctx)
+		}
+	}
+	// On error, indexes are not created. They are created when the load is resumed.
+	if errE != nil {
+		return errE
+	}
+	return errors.WithStack(db.Close())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+const downloadProgressRate = 30 * time.Second
+
+// DownloadCommand downloads a dump.
+//
+// The download is first written to a file with ".part" suffix which is renamed
+// to the output path once the download is complete and its checksum verified.
+// If the partial file already exists, the download resumes from where it stopped.
+//
+//nolint:lll
+type DownloadCommand struct {
+	URL    string `arg:""                  help:"URL of the dump to download."`
+	Output string `       short:"o"        help:"Path to save the dump to. Default is the file name from the URL." placeholder:"PATH" type:"path"`
+	SHA1   string `       name:"sha1"      help:"Expected SHA1 checksum of the dump, in hex."                       placeholder:"HEX"`
+	MD5    string `       name:"md5"       help:"Expected MD5 checksum of the dump, in hex."                        placeholder:"HEX"`
+}
+
+func (c *DownloadCommand) checksum() mediawiki.Checksum {
+	return mediawiki.Checksum{
+		MD5:  c.MD5,
+		SHA1: c.SHA1,
+	}
+}
+
+// Run runs the command.
+func (c *DownloadCommand) Run(ctx context.Context, globals *Globals) error { //nolint:maintidx
+	output := c.Output
+	if output == "" {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			errE := errors.WithMessage(err, "parse url")
+			errors.Details(errE)["url"] = c.URL
+			return errE
+		}
+		output = path.Base(u.Path)
+		if output == "/" || output == "." {
+			errE := errors.New("cannot determine output path from URL")
+			errors.Details(errE)["url"] = c.URL
+			return errE
+		}
+	}
+
+	checksum := c.checksum()
+
+	if _, err := os.Stat(output); err == nil {
+		// The dump has already been downloaded. We only verify it.
+		if checksum.IsZero() {
+			return nil
+		}
+		return mediawiki.VerifyDumpFile(output, checksum)
+	}
+
+	partial := output + ".part"
+	file, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0o644) //nolint:gosec,mnd
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = partial
+		return errE
+	}
+	defer file.Close() //nolint:errcheck
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		errE := errors.WithMessage(err, "seek end")
+		errors.Details(errE)["path"] = partial
+		return errE
+	}
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = c.URL
+		return errE
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := globals.client().Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = c.URL
+		return errE
+	}
+	defer resp.Body.Close()              //nolint:errcheck
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	var body io.Reader
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		// We continue where we stopped.
+		body = resp.Body
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is already complete.
+		body = strings.NewReader("")
+		resp.ContentLength = 0
+	case resp.StatusCode == http.StatusOK:
+		// The server does not support ranges or there was no partial file. We start from the beginning.
+		err = file.Truncate(0)
+		if err != nil {
+			errE := errors.WithMessage(err, "truncate")
+			errors.Details(errE)["path"] = partial
+			return errE
+		}
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			errE := errors.WithMessage(err, "seek start")
+			errors.Details(errE)["path"] = partial
+			return errE
+		}
+		offset = 0
+		body = resp.Body
+	default:
+		errE := errors.New("bad response status")
+		errors.Details(errE)["url"] = c.URL
+		errors.Details(errE)["status"] = resp.Status
+		return errE
+	}
+
+	countingReader := x.NewCountingReader(body)
+	if globals.Progress && resp.ContentLength > 0 {
+		ticker := x.NewTicker(ctx, countingReader, x.NewCounter(resp.ContentLength), downloadProgressRate)
+		defer ticker.Stop()
+		progress := globals.progress()
+		go func() {
+			for p := range ticker.C {
+				progress(ctx, p)
+			}
+		}()
+	}
+
+	written, err := io.Copy(file, countingReader)
+	if err != nil {
+		errE := errors.WithMessage(err, "download")
+		errors.Details(errE)["url"] = c.URL
+		errors.Details(errE)["path"] = partial
+		return errE
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		errE := errors.New("incomplete download")
+		errors.Details(errE)["url"] = c.URL
+		errors.Details(errE)["path"] = partial
+		errors.Details(errE)["expected"] = resp.ContentLength
+		errors.Details(errE)["written"] = written
+		return errE
+	}
+	err = file.Close()
+	if err != nil {
+		errE := errors.WithMessage(err, "close")
+		errors.Details(errE)["path"] = partial
+		return errE
+	}
+
+	if !checksum.IsZero() {
+		errE := mediawiki.VerifyDumpFile(partial, checksum)
+		if errE != nil {
+			// We cannot know which part is corrupted, so we start from scratch next time.
+			_ = os.Remove(partial)
+			errors.Details(errE)["url"] = c.URL
+			return errE
+		}
+	}
+
+	err = os.Rename(partial, output)
+	if err != nil {
+		errE := errors.WithMessage(err, "rename")
+		errors.Details(errE)["path"] = partial
+		errors.Details(errE)["output"] = output
+		return errE
+	}
+	return nil
+}
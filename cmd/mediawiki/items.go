@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+const auto = "auto"
+
+//nolint:gochecknoglobals
+var (
+	fileTypes = map[string]mediawiki.FileType{
+		"json":   mediawiki.JSONArray,
+		"ndjson": mediawiki.NDJSON,
+	}
+
+	compressions = map[string]mediawiki.Compression{
+		"none":     mediawiki.NoCompression,
+		"tar":      mediawiki.Tar,
+		"bz2":      mediawiki.BZIP2,
+		"bz2-tar":  mediawiki.BZIP2Tar,
+		"gzip":     mediawiki.GZIP,
+		"gzip-tar": mediawiki.GZIPTar,
+		"zstd":     mediawiki.ZSTD,
+		"zstd-tar": mediawiki.ZSTDTar,
+	}
+)
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Input selects the dump to read.
+//
+// Entities are read from Wikidata and Wikimedia Commons entities JSON dumps, articles
+// from Wikimedia Enterprise HTML dumps. File type and compression can be changed
+// from their usual values to read dumps written by the convert command.
+//
+//nolint:lll
+type Input struct {
+	Source      string `arg:""                                                                   help:"URL or path of the dump."`
+	Dump        string `default:"wikidata" enum:"wikidata,commons,wikipedia"                      help:"Type of the dump: ${enum}."`
+	FileType    string `default:"auto"     enum:"auto,json,ndjson"                                help:"File type of the dump, if different from the usual for the dump type: ${enum}."`
+	Compression string `default:"auto"     enum:"auto,none,tar,bz2,bz2-tar,gzip,gzip-tar,zstd,zstd-tar" help:"Compression of the dump, if different from the usual for the dump type: ${enum}."`
+	SHA1        string `name:"sha1"                                                              help:"Expected SHA1 checksum of the dump, in hex."                                                          placeholder:"HEX"`
+	MD5         string `name:"md5"                                                               help:"Expected MD5 checksum of the dump, in hex."                                                           placeholder:"HEX"`
+	Cache       string `                                                                         help:"Path where to save the dump when downloading it from URL. If it already exists, it is used instead." placeholder:"PATH" type:"path"`
+}
+
+func processItems[T any](
+	ctx context.Context, config *mediawiki.ProcessDumpConfig, fileType mediawiki.FileType,
+	compression mediawiki.Compression, process func(context.Context, any) errors.E,
+) errors.E {
+	return mediawiki.Process(ctx, &mediawiki.ProcessConfig[T]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process: func(ctx context.Context, item T) errors.E {
+			return process(ctx, item)
+		},
+		Progress:       config.Progress,
+		FileType:       fileType,
+		Compression:    compression,
+		Checksum:       config.Checksum,
+		Sources:        config.Sources,
+		SourcesThreads: config.SourcesThreads,
+	})
+}
+
+// process calls process on every item in the dump. Items are
+// either mediawiki.Entity or mediawiki.Article values.
+func (i *Input) process(ctx context.Context, globals *Globals, process func(context.Context, any) errors.E) errors.E {
+	config := globals.processDumpConfig(i)
+
+	switch i.Dump {
+	case "commons":
+		if i.FileType != auto || i.Compression != auto {
+			// Converted Commons dumps use the same JSON as Wikidata dumps.
+			return errors.New("file type and compression cannot be set for Commons dumps, read converted dumps as Wikidata dumps")
+		}
+		return mediawiki.ProcessCommonsEntitiesDump(ctx, config, func(ctx context.Context, entity mediawiki.Entity) errors.E {
+			return process(ctx, entity)
+		})
+	case "wikipedia":
+		fileType, compression := i.format(mediawiki.NDJSON, mediawiki.GZIPTar)
+		return processItems[mediawiki.Article](ctx, config, fileType, compression, process)
+	default:
+		fileType, compression := i.format(mediawiki.JSONArray, mediawiki.BZIP2)
+		return processItems[mediawiki.Entity](ctx, config, fileType, compression, process)
+	}
+}
+
+// format returns the file type and compression of the dump, using provided
+// defaults unless they are set explicitly.
+func (i *Input) format(fileType mediawiki.FileType, compression mediawiki.Compression) (mediawiki.FileType, mediawiki.Compression) {
+	if i.FileType != auto {
+		fileType = fileTypes[i.FileType]
+	}
+	if i.Compression != auto {
+		compression = compressions[i.Compression]
+	}
+	return fileType, compression
+}
+
+// itemID returns the ID of the item used for sampling.
+func itemID(item any) string {
+	switch i := item.(type) {
+	case mediawiki.Entity:
+		return i.ID
+	case mediawiki.Article:
+		return strconv.FormatInt(i.Identifier, 10)
+	}
+	return ""
+}
+
+// Filter selects items by their ID, properties, or title.
+//
+//nolint:lll
+type Filter struct {
+	ID       []string `name:"id"  help:"Keep only items with the ID: entity ID, or article identifier or its main entity ID. Can be repeated." placeholder:"ID"`
+	Property []string `           help:"Keep only entities with statements for the property. Can be repeated to require all properties."        placeholder:"PID"`
+	Title    string   `           help:"Keep only items with title matching the regular expression: entity title or any label, or article name."  placeholder:"REGEXP"`
+}
+
+// empty returns true if no filtering is configured.
+func (f *Filter) empty() bool {
+	return len(f.ID) == 0 && len(f.Property) == 0 && f.Title == ""
+}
+
+// matcher returns a function which returns true for items matching the filter.
+func (f *Filter) matcher() (func(any) bool, errors.E) {
+	var title *regexp.Regexp
+	if f.Title != "" {
+		var err error
+		title, err = regexp.Compile(f.Title)
+		if err != nil {
+			errE := errors.WithMessage(err, "title")
+			errors.Details(errE)["title"] = f.Title
+			return nil, errE
+		}
+	}
+
+	return func(item any) bool {
+		switch i := item.(type) {
+		case mediawiki.Entity:
+			if len(f.ID) > 0 && !slices.Contains(f.ID, i.ID) {
+				return false
+			}
+			for _, property := range f.Property {
+				if len(i.Claims[property]) == 0 {
+					return false
+				}
+			}
+			if title != nil && !title.MatchString(i.Title) && !matchesLabel(title, i.Labels) {
+				return false
+			}
+			return true
+		case mediawiki.Article:
+			if len(f.ID) > 0 && !slices.Contains(f.ID, strconv.FormatInt(i.Identifier, 10)) &&
+				(i.MainEntity == nil || !slices.Contains(f.ID, i.MainEntity.Identifier)) {
+				return false
+			}
+			// Articles do not have properties.
+			if len(f.Property) > 0 {
+				return false
+			}
+			if title != nil && !title.MatchString(i.Name) {
+				return false
+			}
+			return true
+		}
+		return false
+	}, nil
+}
+
+func matchesLabel(title *regexp.Regexp, labels map[string]mediawiki.LanguageValue) bool {
+	for _, label := range labels {
+		if title.MatchString(label.Value) {
+			return true
+		}
+	}
+	return false
+}
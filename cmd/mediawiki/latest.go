@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// LatestCommand prints URL of the latest run of a dump.
+//
+//nolint:lll
+type LatestCommand struct {
+	Dump      string `arg:"" enum:"wikidata,commons,commons-image-metadata,commons-pages,wikipedia,wikipedia-image-metadata" help:"Dump to find: ${enum}."`
+	Language  string `       default:"enwiki"                                                                               help:"Wikipedia to use for Wikipedia dumps."                    placeholder:"WIKI"`
+	Namespace int    `       default:"0"                                                                                    help:"Namespace to use for Wikipedia dumps. Articles are in 0." placeholder:"INT"`
+
+	DumpsURL string        `help:"Base URL of dumps. Default is https://dumps.wikimedia.org/."                           name:"dumps-url" placeholder:"URL"`
+	Mirrors  []string      `help:"Mirror with the same layout to try when a file is missing or slow. Can be repeated."  name:"mirror"    placeholder:"URL"`
+	Timeout  time.Duration `help:"Timeout for each request after which the next mirror is tried. Default is no timeout."                  placeholder:"DURATION"`
+}
+
+// Run runs the command.
+func (c *LatestCommand) Run(ctx context.Context, globals *Globals) error {
+	client := globals.client()
+	source := &mediawiki.DumpSource{
+		BaseURL: c.DumpsURL,
+		Mirrors: c.Mirrors,
+		Timeout: c.Timeout,
+	}
+
+	var url string
+	var errE errors.E
+	switch c.Dump {
+	case "wikidata":
+		url, errE = mediawiki.LatestWikidataEntitiesRun(ctx, client, source)
+	case "commons":
+		url, errE = mediawiki.LatestCommonsEntitiesRun(ctx, client, source)
+	case "commons-image-metadata":
+		url, errE = mediawiki.LatestCommonsImageMetadataRun(ctx, client, source)
+	case "commons-pages":
+		url, errE = mediawiki.LatestCommonsPagesRun(ctx, client, source)
+	case "wikipedia":
+		url, errE = mediawiki.LatestWikipediaRun(ctx, client, source, c.Language, c.Namespace)
+	case "wikipedia-image-metadata":
+		url, errE = mediawiki.LatestWikipediaImageMetadataRun(ctx, client, source, c.Language)
+	}
+	if errE != nil {
+		return errE
+	}
+
+	_, err := fmt.Fprintln(globals.stdout, url)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,133 @@
+// Command mediawiki discovers, downloads, inspects, and converts Wikimedia dumps.
+//
+// Run it with --help to see all commands and their options.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+const defaultUserAgent = "go-mediawiki (https://gitlab.com/tozd/go/mediawiki)"
+
+// Globals are options shared by all commands.
+type Globals struct {
+	UserAgent              string `default:"${defaultUserAgent}" help:"User-Agent header to use for HTTP requests. It should contain your contact information." placeholder:"STRING"`
+	DecompressionThreads   int    `default:"0"                   help:"Number of threads to use for decompression. Default is the number of CPUs."             placeholder:"INT"`
+	DecodingThreads        int    `default:"0"                   help:"Number of threads to use for decoding. Default is the number of CPUs."                  placeholder:"INT"`
+	ItemsProcessingThreads int    `default:"0"                   help:"Number of threads to use for processing items. Default is the number of CPUs."          placeholder:"INT"`
+	Progress               bool   `                              help:"Report progress to stderr."`
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// client returns a HTTP client which sets User-Agent header.
+func (g *Globals) client() *retryablehttp.Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, _ int) {
+		req.Header.Set("User-Agent", g.UserAgent)
+	}
+	return client
+}
+
+// progress returns a function which reports progress to stderr.
+// It returns nil if progress should not be reported.
+func (g *Globals) progress() func(context.Context, x.Progress) {
+	if !g.Progress {
+		return nil
+	}
+	return func(_ context.Context, p x.Progress) {
+		fmt.Fprintf(g.stderr, "progress %0.2f%%, elapsed %s, remaining %s\n", p.Percent(), p.Elapsed.Truncate(time.Second), p.Remaining().Truncate(time.Second)) //nolint:errcheck
+	}
+}
+
+func (g *Globals) processDumpConfig(input *Input) *mediawiki.ProcessDumpConfig {
+	config := &mediawiki.ProcessDumpConfig{
+		URL:                    "",
+		Path:                   input.Source,
+		Client:                 nil,
+		DecompressionThreads:   g.DecompressionThreads,
+		DecodingThreads:        g.DecodingThreads,
+		ItemsProcessingThreads: g.ItemsProcessingThreads,
+		Progress:               g.progress(),
+		Checksum: mediawiki.Checksum{
+			MD5:  input.MD5,
+			SHA1: input.SHA1,
+		},
+	}
+	if isURL(input.Source) {
+		config.URL = input.Source
+		config.Path = input.Cache
+		config.Client = g.client()
+	}
+	return config
+}
+
+// App is the command-line interface.
+//
+//nolint:lll
+type App struct {
+	Globals
+
+	Latest   LatestCommand   `cmd:"" help:"Print URL of the latest run of a dump."`
+	Download DownloadCommand `cmd:"" help:"Download a dump, resuming a partial download and verifying its checksum."`
+	Cat      CatCommand      `cmd:"" help:"Print all entities or articles in a dump as NDJSON."`
+	Count    CountCommand    `cmd:"" help:"Count entities or articles in a dump, optionally filtered by ID, property, or title."`
+	Sample   SampleCommand   `cmd:"" help:"Print a reproducible sample of entities or articles in a dump as NDJSON."`
+	Grep     GrepCommand     `cmd:"" help:"Print entities or articles in a dump with matching ID, property, or title as NDJSON."`
+	Convert  ConvertCommand  `cmd:"" help:"Convert a dump to another file type, compression, or to Parquet."`
+	SQLite   SQLiteCommand   `cmd:"" help:"Load a dump into a SQLite database."                                                name:"sqlite"`
+}
+
+// run parses args and runs the selected command.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) errors.E {
+	var app App
+	app.stdout = stdout
+	app.stderr = stderr
+	parser, err := kong.New(&app,
+		kong.Name("mediawiki"),
+		kong.Description("Discover, download, inspect, and convert Wikimedia dumps."),
+		kong.UsageOnError(),
+		kong.Writers(stdout, stderr),
+		kong.Vars{"defaultUserAgent": defaultUserAgent},
+		kong.BindTo(ctx, (*context.Context)(nil)),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	kongCtx, err := parser.Parse(args)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = kongCtx.Run(&app.Globals)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	errE := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	if errE != nil {
+		fmt.Fprintf(os.Stderr, "error: % -+#.1v", errE) //nolint:errcheck
+		stop()
+		os.Exit(1) //nolint:gocritic
+	}
+}
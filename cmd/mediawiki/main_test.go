@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+var testDump = filepath.Join("..", "..", "testdata", "wikidata-testdata-index.json.bz2") //nolint:gochecknoglobals
+
+func countSQLite(t *testing.T, path, table string) int {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&count))
+	return count
+}
+
+func runCommand(t *testing.T, args ...string) (string, errors.E) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	errE := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), errE
+}
+
+func outputIDs(t *testing.T, output string) []string {
+	t.Helper()
+
+	ids := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		var entity mediawiki.Entity
+		require.NoError(t, json.Unmarshal([]byte(line), &entity))
+		ids = append(ids, entity.ID)
+	}
+	return ids
+}
+
+func testEntities(t *testing.T) []mediawiki.Entity {
+	t.Helper()
+
+	var mu sync.Mutex
+	entities := []mediawiki.Entity{}
+	errE := mediawiki.ProcessWikidataDump(context.Background(), &mediawiki.ProcessDumpConfig{Path: testDump}, func(_ context.Context, entity mediawiki.Entity) errors.E {
+		mu.Lock()
+		defer mu.Unlock()
+		entities = append(entities, entity)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return entities
+}
+
+func TestCountGrepSample(t *testing.T) {
+	t.Parallel()
+
+	entities := testEntities(t)
+
+	output, errE := runCommand(t, "count", testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, strconv.Itoa(len(entities))+"\n", output)
+
+	output, errE = runCommand(t, "grep", "--id", entities[0].ID, "--id", entities[1].ID, testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ElementsMatch(t, []string{entities[0].ID, entities[1].ID}, outputIDs(t, output))
+
+	output, errE = runCommand(t, "grep", "--title", "^"+entities[0].Labels["en"].Value+"$", testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Contains(t, outputIDs(t, output), entities[0].ID)
+
+	_, errE = runCommand(t, "grep", testDump)
+	assert.EqualError(t, errE, "at least one of --id, --property, or --title is required")
+
+	output, errE = runCommand(t, "sample", "--rate=0.1", "--seed=test", testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	sample := outputIDs(t, output)
+	assert.NotEmpty(t, sample)
+	for _, id := range sample {
+		assert.True(t, mediawiki.InSample(id, "test", 0.1))
+	}
+	output, errE = runCommand(t, "sample", "--rate=0.1", "--seed=test", testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ElementsMatch(t, sample, outputIDs(t, output))
+}
+
+func TestProperty(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "entities.ndjson")
+	err := os.WriteFile(path, []byte(`{"type":"item","id":"Q1","lastrevid":1,"modified":"2020-01-01T00:00:00Z","claims":{"P31":[`+
+		`{"mainsnak":{"snaktype":"somevalue","property":"P31","datatype":"wikibase-item"},"type":"statement","id":"Q1$1","rank":"normal"}]}}
+{"type":"item","id":"Q2","lastrevid":1,"modified":"2020-01-01T00:00:00Z","claims":{"P31":[`+
+		`{"mainsnak":{"snaktype":"somevalue","property":"P31","datatype":"wikibase-item"},"type":"statement","id":"Q2$1","rank":"normal"}],"P625":[`+
+		`{"mainsnak":{"snaktype":"novalue","property":"P625","datatype":"globe-coordinate"},"type":"statement","id":"Q2$2","rank":"normal"}]}}
+{"type":"item","id":"Q3","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}
+`), 0o600)
+	require.NoError(t, err)
+
+	args := []string{"--file-type=ndjson", "--compression=none", path}
+
+	output, errE := runCommand(t, append([]string{"count", "--items-processing-threads=2", "--property=P31"}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "2\n", output)
+
+	output, errE = runCommand(t, append([]string{"grep", "--property=P31", "--property=P625"}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"Q2"}, outputIDs(t, output))
+}
+
+func TestCatConvert(t *testing.T) {
+	t.Parallel()
+
+	entities := testEntities(t)
+	ids := []string{}
+	for _, entity := range entities {
+		ids = append(ids, entity.ID)
+	}
+
+	output, errE := runCommand(t, "cat", testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ElementsMatch(t, ids, outputIDs(t, output))
+
+	path := filepath.Join(t.TempDir(), "dump.ndjson.zst")
+	_, errE = runCommand(t, "convert", "--to=ndjson", "--to-compression=zstd", "-o", path, testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	output, errE = runCommand(t, "cat", "--file-type=ndjson", "--compression=zstd", path)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ElementsMatch(t, ids, outputIDs(t, output))
+
+	dir := filepath.Join(t.TempDir(), "parquet")
+	_, errE = runCommand(t, "convert", "--to=parquet", "-o", dir, testDump)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.FileExists(t, filepath.Join(dir, "entities.parquet"))
+	assert.FileExists(t, filepath.Join(dir, "statements.parquet"))
+
+	// Loading again into the same database skips already loaded entities.
+	database := filepath.Join(t.TempDir(), "dump.db")
+	for range 2 {
+		_, errE = runCommand(t, "sqlite", "-o", database, testDump)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, len(entities), countSQLite(t, database, "entity"))
+	}
+
+	_, errE = runCommand(t, "cat", "--dump=commons", "--file-type=ndjson", path)
+	assert.EqualError(t, errE, "file type and compression cannot be set for Commons dumps, read converted dumps as Wikidata dumps")
+}
+
+func TestArticles(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "articles.ndjson")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	writer := &mediawiki.DumpWriter[mediawiki.Article]{Writer: file, FileType: mediawiki.NDJSON}
+	for i, name := range []string{"Berlin", "Paris", "Berlin Wall"} {
+		errE := writer.Write(context.Background(), mediawiki.Article{
+			Name:        name,
+			Identifier:  int64(i + 1),
+			MainEntity:  &mediawiki.EntityRef{Identifier: "Q" + strconv.Itoa(i+100)},
+			DateCreated: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE := writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.NoError(t, file.Close())
+
+	args := []string{"--dump=wikipedia", "--file-type=ndjson", "--compression=none", path}
+
+	output, errE := runCommand(t, append([]string{"count", "--title=^Berlin"}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "2\n", output)
+
+	output, errE = runCommand(t, append([]string{"count", "--id=Q101", "--id=3"}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "2\n", output)
+
+	output, errE = runCommand(t, append([]string{"count", "--property=P31"}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "0\n", output)
+
+	output, errE = runCommand(t, append([]string{"grep", "--title=Paris"}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	var article mediawiki.Article
+	require.NoError(t, json.Unmarshal([]byte(output), &article))
+	assert.Equal(t, "Paris", article.Name)
+
+	database := filepath.Join(t.TempDir(), "articles.db")
+	_, errE = runCommand(t, append([]string{"sqlite", "--title=^Berlin", "-o", database}, args...)...)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 2, countSQLite(t, database, "article"))
+}
+
+func TestLatest(t *testing.T) {
+	t.Parallel()
+
+	primary := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(primary.Close)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/commonswiki/entities/":
+			_, _ = w.Write([]byte(`<html><body><a href="20240101/">20240101/</a></body></html>`))
+		case "/commonswiki/entities/20240101/commons-20240101-mediainfo.json.bz2":
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(mirror.Close)
+
+	output, errE := runCommand(t, "latest", "--dumps-url", primary.URL, "--mirror", mirror.URL, "commons")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, mirror.URL+"/commonswiki/entities/20240101/commons-20240101-mediainfo.json.bz2\n", output)
+}
+
+func TestDownload(t *testing.T) { //nolint:paralleltest
+	data, err := os.ReadFile(testDump)
+	require.NoError(t, err)
+	hash := sha1.Sum(data) //nolint:gosec
+	sha1sum := hex.EncodeToString(hash[:])
+
+	var mu sync.Mutex
+	ranges := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, req.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, req, "dump.json.bz2", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "dump.json.bz2")
+
+	// Partial download is resumed.
+	err = os.WriteFile(output+".part", data[:1000], 0o600)
+	require.NoError(t, err)
+	_, errE := runCommand(t, "download", "--sha1", sha1sum, "-o", output, ts.URL+"/dump.json.bz2")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	downloaded, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+	assert.NoFileExists(t, output+".part")
+	assert.Equal(t, []string{"bytes=1000-"}, ranges)
+
+	// Existing file is verified.
+	_, errE = runCommand(t, "download", "--sha1", sha1sum, "-o", output, ts.URL+"/dump.json.bz2")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	_, errE = runCommand(t, "download", "--sha1", strings.Repeat("0", 40), "-o", output, ts.URL+"/dump.json.bz2")
+	assert.EqualError(t, errE, "checksum mismatch")
+	assert.Len(t, ranges, 1)
+
+	// Corrupted partial download is removed.
+	other := filepath.Join(dir, "other.json.bz2")
+	err = os.WriteFile(other+".part", []byte("corrupted"), 0o600)
+	require.NoError(t, err)
+	_, errE = runCommand(t, "download", "--sha1", sha1sum, "-o", other, ts.URL+"/dump.json.bz2")
+	assert.EqualError(t, errE, "checksum mismatch")
+	assert.NoFileExists(t, other+".part")
+	assert.NoFileExists(t, other)
+
+	// Output path defaults to the file name from the URL.
+	t.Chdir(t.TempDir())
+	_, errE = runCommand(t, "download", ts.URL+"/dumps/dump.json.bz2")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.FileExists(t, "dump.json.bz2")
+}
@@ -12,12 +12,14 @@ import (
 )
 
 // LatestCommonsEntitiesRun returns URL of the latest run of Wikimedia Commons entities JSON dump.
-func LatestCommonsEntitiesRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+// Source can be nil to use https://dumps.wikimedia.org/.
+func LatestCommonsEntitiesRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource) (string, errors.E) {
 	return latestRun(
 		ctx,
 		client,
-		"https://dumps.wikimedia.org/commonswiki/entities/",
-		"https://dumps.wikimedia.org/commonswiki/entities/%s/commons-%s-mediainfo.json.bz2",
+		source,
+		"commonswiki/entities/",
+		"commonswiki/entities/%s/commons-%s-mediainfo.json.bz2",
 	)
 }
 
@@ -37,9 +39,14 @@ func ProcessCommonsEntitiesDump(
 		Process: func(ctx context.Context, i commonsEntity) errors.E {
 			return processEntity(ctx, Entity(i))
 		},
-		Progress:    config.Progress,
-		FileType:    JSONArray,
-		Compression: BZIP2,
+		Progress:       config.Progress,
+		FileType:       JSONArray,
+		Compression:    BZIP2,
+		Checksum:       config.Checksum,
+		Mirrors:        config.Mirrors,
+		Sources:        config.Sources,
+		SourcesThreads: config.SourcesThreads,
+		Index:          config.Index,
 	})
 }
 
@@ -66,12 +73,14 @@ func convertToStringMapsMap(m map[interface{}]interface{}) map[string]interface{
 }
 
 // LatestCommonsImageMetadataRun returns URL of the latest run of Wikimedia Commons image table dump.
-func LatestCommonsImageMetadataRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+// Source can be nil to use https://dumps.wikimedia.org/.
+func LatestCommonsImageMetadataRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource) (string, errors.E) {
 	return latestRun(
 		ctx,
 		client,
-		"https://dumps.wikimedia.org/commonswiki/",
-		"https://dumps.wikimedia.org/commonswiki/%s/commonswiki-%s-image.sql.gz",
+		source,
+		"commonswiki/",
+		"commonswiki/%s/commonswiki-%s-image.sql.gz",
 	)
 }
 
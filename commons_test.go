@@ -32,7 +32,7 @@ func TestProcessCommonsDumpLatest(t *testing.T) {
 		req.Header.Set("User-Agent", testUserAgent)
 	}
 
-	url, errE := mediawiki.LatestCommonsEntitiesRun(context.Background(), client)
+	url, errE := mediawiki.LatestCommonsEntitiesRun(context.Background(), client, nil)
 	require.NoError(t, errE, "% -+#.1v", errE)
 
 	cacheDir := t.TempDir()
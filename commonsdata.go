@@ -0,0 +1,425 @@
+package mediawiki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	geoShapeContentModel    = "GeoJSON.JsonConfig"
+	tabularDataContentModel = "Tabular.JsonConfig"
+)
+
+// LocalizedText is a text in multiple languages, keyed by language code.
+//
+// When JSON representation is a plain string, it is stored under the
+// empty language code.
+type LocalizedText map[string]string
+
+// UnmarshalJSON implements json.Unmarshaler interface for LocalizedText.
+func (t *LocalizedText) UnmarshalJSON(b []byte) error {
+	var s string
+	errE := x.Unmarshal(b, &s)
+	if errE == nil {
+		*t = LocalizedText{"": norm.NFC.String(s)}
+		return nil
+	}
+	var m map[string]string
+	errE = x.Unmarshal(b, &m)
+	if errE != nil {
+		return errE
+	}
+	for language, text := range m {
+		m[language] = norm.NFC.String(text)
+	}
+	*t = m
+	return nil
+}
+
+type DataCategory struct {
+	Name string `json:"name"`
+	Sort string `json:"sort,omitempty"`
+}
+
+// GeoShapeData is the content of a map data page (with .map suffix)
+// in Data namespace on Wikimedia Commons.
+//
+// Data is a GeoJSON FeatureCollection.
+//
+//nolint:tagliatelle
+type GeoShapeData struct {
+	License             string          `json:"license"`
+	Description         LocalizedText   `json:"description,omitempty"`
+	Sources             string          `json:"sources,omitempty"`
+	Zoom                *int            `json:"zoom,omitempty"`
+	Latitude            *float64        `json:"latitude,omitempty"`
+	Longitude           *float64        `json:"longitude,omitempty"`
+	Data                json.RawMessage `json:"data"`
+	MediaWikiCategories []DataCategory  `json:"mediawikiCategories,omitempty"`
+}
+
+// Geometries returns geometries of all features in Data.
+func (d *GeoShapeData) Geometries() ([]GeoJSONGeometry, errors.E) {
+	var collection struct {
+		Features []struct {
+			Geometry *GeoJSONGeometry `json:"geometry"`
+		} `json:"features"`
+	}
+	// We do not use UnmarshalWithoutUnknownFields because
+	// GeoJSON allows foreign members.
+	errE := x.Unmarshal(d.Data, &collection)
+	if errE != nil {
+		return nil, errE
+	}
+	geometries := []GeoJSONGeometry{}
+	for _, feature := range collection.Features {
+		if feature.Geometry != nil {
+			geometries = append(geometries, *feature.Geometry)
+		}
+	}
+	return geometries, nil
+}
+
+type TabularFieldType int
+
+const (
+	TabularNumber TabularFieldType = iota
+	TabularBoolean
+	TabularString
+	TabularLocalized
+)
+
+func (t TabularFieldType) MarshalJSON() ([]byte, error) {
+	switch t {
+	case TabularNumber:
+		return []byte(`"number"`), nil
+	case TabularBoolean:
+		return []byte(`"boolean"`), nil
+	case TabularString:
+		return []byte(`"string"`), nil
+	case TabularLocalized:
+		return []byte(`"localized"`), nil
+	}
+	return []byte(`""`), nil
+}
+
+func (t *TabularFieldType) UnmarshalJSON(b []byte) error {
+	var s string
+	errE := x.Unmarshal(b, &s)
+	if errE != nil {
+		return errE
+	}
+	switch s {
+	case "number":
+		*t = TabularNumber
+	case "boolean":
+		*t = TabularBoolean
+	case "string":
+		*t = TabularString
+	case "localized":
+		*t = TabularLocalized
+	default:
+		errE := errors.WithMessage(ErrInvalidValue, "tabular field type")
+		errors.Details(errE)["value"] = s
+		return errE
+	}
+	return nil
+}
+
+type TabularField struct {
+	Name  string           `json:"name"`
+	Type  TabularFieldType `json:"type"`
+	Title LocalizedText    `json:"title,omitempty"`
+}
+
+type TabularSchema struct {
+	Fields []TabularField `json:"fields"`
+}
+
+// TabularDataContent is the content of a tabular data page (with .tab suffix)
+// in Data namespace on Wikimedia Commons.
+//
+// Each row in Data has one value per schema field. Values are nil (for
+// missing values), float64, bool, string, or LocalizedText, based on
+// the field type.
+//
+//nolint:tagliatelle
+type TabularDataContent struct {
+	License             string          `json:"license"`
+	Description         LocalizedText   `json:"description,omitempty"`
+	Sources             string          `json:"sources,omitempty"`
+	Schema              TabularSchema   `json:"schema"`
+	Data                [][]interface{} `json:"data"`
+	MediaWikiCategories []DataCategory  `json:"mediawikiCategories,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface for TabularDataContent.
+//
+// It decodes row values into Go values based on the schema.
+func (d *TabularDataContent) UnmarshalJSON(b []byte) error {
+	//nolint:tagliatelle
+	var t struct {
+		License             string              `json:"license"`
+		Description         LocalizedText       `json:"description,omitempty"`
+		Sources             string              `json:"sources,omitempty"`
+		Schema              TabularSchema       `json:"schema"`
+		Data                [][]json.RawMessage `json:"data"`
+		MediaWikiCategories []DataCategory      `json:"mediawikiCategories,omitempty"`
+	}
+	errE := x.UnmarshalWithoutUnknownFields(b, &t)
+	if errE != nil {
+		return errE
+	}
+	data := make([][]interface{}, 0, len(t.Data))
+	for i, row := range t.Data {
+		if len(row) != len(t.Schema.Fields) {
+			errE := errors.WithMessage(ErrInvalidValue, "tabular data row length")
+			errors.Details(errE)["row"] = i
+			return errE
+		}
+		values := make([]interface{}, len(row))
+		for j, raw := range row {
+			if string(raw) == "null" {
+				continue
+			}
+			var errE errors.E
+			switch t.Schema.Fields[j].Type {
+			case TabularNumber:
+				var v float64
+				errE = x.Unmarshal(raw, &v)
+				values[j] = v
+			case TabularBoolean:
+				var v bool
+				errE = x.Unmarshal(raw, &v)
+				values[j] = v
+			case TabularString:
+				var v string
+				errE = x.Unmarshal(raw, &v)
+				values[j] = norm.NFC.String(v)
+			case TabularLocalized:
+				var v LocalizedText
+				errE = x.Unmarshal(raw, &v)
+				values[j] = v
+			}
+			if errE != nil {
+				errors.Details(errE)["row"] = i
+				errors.Details(errE)["field"] = t.Schema.Fields[j].Name
+				return errE
+			}
+		}
+		data = append(data, values)
+	}
+	d.License = t.License
+	d.Description = t.Description
+	d.Sources = t.Sources
+	d.Schema = t.Schema
+	d.Data = data
+	d.MediaWikiCategories = t.MediaWikiCategories
+	return nil
+}
+
+// CommonsDataPage is a page from Data namespace on Wikimedia Commons.
+//
+// Exactly one of GeoShape and Tabular is set.
+type CommonsDataPage struct {
+	Title    string
+	GeoShape *GeoShapeData
+	Tabular  *TabularDataContent
+}
+
+// LatestCommonsPagesRun returns URL of the latest run of Wikimedia Commons pages (current revisions) XML dump.
+// Source can be nil to use https://dumps.wikimedia.org/.
+func LatestCommonsPagesRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource) (string, errors.E) {
+	return latestRun(
+		ctx,
+		client,
+		source,
+		"commonswiki/",
+		"commonswiki/%s/commonswiki-%s-pages-articles.xml.bz2",
+	)
+}
+
+// ProcessCommonsDataDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processPage on every map and tabular data page from Data namespace
+// in a Wikimedia Commons pages XML dump. Other pages are skipped.
+func ProcessCommonsDataDump(
+	ctx context.Context, config *ProcessDumpConfig,
+	processPage func(context.Context, CommonsDataPage) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[Page]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process: func(ctx context.Context, page Page) errors.E {
+			if page.Namespace != DataNamespace || page.Redirect != "" || len(page.Revisions) == 0 {
+				return nil
+			}
+			dataPage, ok, errE := decodeCommonsDataPage(page)
+			if errE != nil {
+				errors.Details(errE)["title"] = page.Title
+				return errE
+			}
+			if !ok {
+				return nil
+			}
+			return processPage(ctx, dataPage)
+		},
+		Progress:       config.Progress,
+		FileType:       XMLDump,
+		Compression:    BZIP2,
+		Checksum:       config.Checksum,
+		Mirrors:        config.Mirrors,
+		Sources:        config.Sources,
+		SourcesThreads: config.SourcesThreads,
+		Index:          config.Index,
+	})
+}
+
+func decodeCommonsDataPage(page Page) (CommonsDataPage, bool, errors.E) {
+	// We use the latest revision.
+	revision := page.Revisions[len(page.Revisions)-1]
+	switch revision.Model {
+	case geoShapeContentModel:
+		var data GeoShapeData
+		errE := x.UnmarshalWithoutUnknownFields([]byte(revision.Text), &data)
+		if errE != nil {
+			return CommonsDataPage{}, false, errors.WithMessage(errE, "geo shape")
+		}
+		return CommonsDataPage{Title: page.Title, GeoShape: &data, Tabular: nil}, true, nil
+	case tabularDataContentModel:
+		var data TabularDataContent
+		errE := x.Unmarshal([]byte(revision.Text), &data)
+		if errE != nil {
+			return CommonsDataPage{}, false, errors.WithMessage(errE, "tabular data")
+		}
+		return CommonsDataPage{Title: page.Title, GeoShape: nil, Tabular: &data}, true, nil
+	}
+	return CommonsDataPage{}, false, nil
+}
+
+// normalizeDataTitle normalizes a title in Data namespace the same
+// way as MediaWiki does: underscores become spaces, the first letter
+// is upper case, and the namespace prefix is always present.
+func normalizeDataTitle(title string) string {
+	title = strings.TrimSpace(strings.ReplaceAll(norm.NFC.String(title), "_", " "))
+	title = strings.TrimPrefix(title, "Data:")
+	r, size := utf8.DecodeRuneInString(title)
+	if r != utf8.RuneError {
+		title = string(unicode.ToUpper(r)) + title[size:]
+	}
+	return "Data:" + title
+}
+
+// CommonsData resolves values of GeoShape and TabularData snaks to
+// content of corresponding Data pages on Wikimedia Commons.
+//
+// Populate it with Add, e.g., directly from a ProcessCommonsDataDump
+// callback. It is safe to use concurrently.
+type CommonsData struct {
+	mu        sync.RWMutex
+	geoShapes map[string]*GeoShapeData
+	tabular   map[string]*TabularDataContent
+}
+
+// NewCommonsData returns a new empty CommonsData.
+func NewCommonsData() *CommonsData {
+	return &CommonsData{
+		mu:        sync.RWMutex{},
+		geoShapes: map[string]*GeoShapeData{},
+		tabular:   map[string]*TabularDataContent{},
+	}
+}
+
+// Add adds the page.
+//
+// Its signature matches the callback of ProcessCommonsDataDump so it can be passed to it directly.
+func (c *CommonsData) Add(_ context.Context, page CommonsDataPage) errors.E {
+	title := normalizeDataTitle(page.Title)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if page.GeoShape != nil {
+		c.geoShapes[title] = page.GeoShape
+	}
+	if page.Tabular != nil {
+		c.tabular[title] = page.Tabular
+	}
+	return nil
+}
+
+// GeoShape returns map data for the title, e.g., "Data:Foo.map".
+func (c *CommonsData) GeoShape(title string) (*GeoShapeData, errors.E) {
+	title = normalizeDataTitle(title)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, ok := c.geoShapes[title]
+	if !ok {
+		return nil, errors.WithDetails(ErrNotFound, "title", title)
+	}
+	return data, nil
+}
+
+// TabularData returns tabular data for the title, e.g., "Data:Foo.tab".
+func (c *CommonsData) TabularData(title string) (*TabularDataContent, errors.E) {
+	title = normalizeDataTitle(title)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, ok := c.tabular[title]
+	if !ok {
+		return nil, errors.WithDetails(ErrNotFound, "title", title)
+	}
+	return data, nil
+}
+
+// Resolve resolves the value of a GeoShape or TabularData snak.
+//
+// It returns *GeoShapeData or *TabularDataContent. If snak's DataType is not set,
+// the type is determined by looking up the title in both.
+func (c *CommonsData) Resolve(snak Snak) (interface{}, errors.E) {
+	if snak.SnakType != Value || snak.DataValue == nil {
+		errE := errors.WithMessage(ErrInvalidValue, "snak has no value")
+		errors.Details(errE)["property"] = snak.Property
+		return nil, errE
+	}
+	value, ok := snak.DataValue.Value.(StringValue)
+	if !ok {
+		errE := errors.WithMessage(ErrUnexpectedType, "snak value")
+		errors.Details(errE)["property"] = snak.Property
+		errors.Details(errE)["type"] = fmt.Sprintf("%T", snak.DataValue.Value)
+		return nil, errE
+	}
+	if snak.DataType == nil {
+		if data, errE := c.GeoShape(string(value)); errE == nil {
+			return data, nil
+		}
+		return c.TabularData(string(value))
+	}
+	switch *snak.DataType { //nolint:exhaustive
+	case GeoShape:
+		return c.GeoShape(string(value))
+	case TabularData:
+		return c.TabularData(string(value))
+	}
+	errE := errors.WithMessage(ErrUnexpectedType, "snak data type")
+	errors.Details(errE)["property"] = snak.Property
+	errors.Details(errE)["type"] = *snak.DataType
+	return nil, errE
+}
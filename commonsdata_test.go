@@ -0,0 +1,138 @@
+package mediawiki_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestXMLDump(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	pages := map[string]mediawiki.Page{}
+
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Page]{
+		Path: "testdata/commonswiki-testdata-pages-articles.xml",
+		Process: func(_ context.Context, p mediawiki.Page) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			pages[p.Title] = p
+			return nil
+		},
+		FileType:    mediawiki.XMLDump,
+		Compression: mediawiki.NoCompression,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, pages, 4)
+
+	assert.Equal(t, mediawiki.Page{
+		Title:     "Main Page",
+		Namespace: mediawiki.MainNamespace,
+		ID:        1,
+		Revisions: []mediawiki.Revision{{
+			ID:          100,
+			ParentID:    99,
+			Timestamp:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			Contributor: mediawiki.Contributor{Username: "Example", ID: 5},
+			Minor:       true,
+			Comment:     "Some <comment>",
+			Origin:      100,
+			Model:       "wikitext",
+			Format:      "text/x-wiki",
+			Text:        "Hello world",
+			SHA1:        "abc",
+		}},
+	}, pages["Main Page"])
+	assert.Equal(t, "127.0.0.1", pages["Data:Example area.map"].Revisions[0].Contributor.IP)
+	assert.True(t, pages["Data:Example population.tab"].Revisions[0].Contributor.Deleted)
+	assert.Equal(t, "Data:Example area.map", pages["Data:Redirect.map"].Redirect)
+	assert.True(t, pages["Data:Redirect.map"].Revisions[0].TextDeleted)
+}
+
+func TestProcessCommonsDataDump(t *testing.T) {
+	t.Parallel()
+
+	commonsData := mediawiki.NewCommonsData()
+	pageCounter := 0
+	var mu sync.Mutex
+
+	errE := mediawiki.ProcessCommonsDataDump(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Path: "testdata/commonswiki-testdata-pages-articles.xml.bz2",
+		},
+		func(ctx context.Context, p mediawiki.CommonsDataPage) errors.E {
+			mu.Lock()
+			pageCounter++
+			mu.Unlock()
+			return commonsData.Add(ctx, p)
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 2, pageCounter)
+
+	geoShape, errE := commonsData.GeoShape("Data:Example_area.map")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "CC0-1.0", geoShape.License)
+	assert.Equal(t, mediawiki.LocalizedText{"en": "An example area"}, geoShape.Description)
+	geometries, errE := geoShape.Geometries()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, geometries, 1)
+	assert.Equal(t, "Polygon", geometries[0].Type)
+
+	dataType := mediawiki.TabularData
+	value, errE := commonsData.Resolve(mediawiki.Snak{
+		SnakType:  mediawiki.Value,
+		Property:  "P4179",
+		DataType:  &dataType,
+		DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue("Data:example population.tab")},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	tabular, ok := value.(*mediawiki.TabularDataContent)
+	require.True(t, ok)
+	assert.Equal(t, mediawiki.LocalizedText{"": "Population"}, tabular.Description)
+	require.Len(t, tabular.Schema.Fields, 3)
+	assert.Equal(t, mediawiki.TabularBoolean, tabular.Schema.Fields[1].Type)
+	assert.Equal(t, [][]interface{}{
+		{2020.0, true, mediawiki.LocalizedText{"en": "first"}},
+		{2021.0, nil, nil},
+	}, tabular.Data)
+
+	value, errE = commonsData.Resolve(mediawiki.Snak{
+		SnakType:  mediawiki.Value,
+		Property:  mediawiki.GeoShapeProperty,
+		DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue("Data:Example area.map")},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, geoShape, value)
+
+	_, errE = commonsData.GeoShape("Data:Missing.map")
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+
+	entity := mediawiki.Entity{
+		ID: "Q1",
+		Claims: map[string][]mediawiki.Statement{
+			mediawiki.GeoShapeProperty: {{
+				ID:   "Q1$1",
+				Rank: mediawiki.Normal,
+				MainSnak: mediawiki.Snak{
+					Property:  mediawiki.GeoShapeProperty,
+					DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue("Data:Example area.map")},
+				},
+			}},
+		},
+	}
+	feature, ok := mediawiki.EntityGeoJSONFeature(entity, &mediawiki.GeoJSONConfig{IncludeGeoShapes: true, CommonsData: commonsData})
+	require.True(t, ok)
+	require.NotNil(t, feature.Geometry)
+	assert.Equal(t, "GeometryCollection", feature.Geometry.Type)
+	assert.Len(t, feature.Geometry.Geometries, 1)
+}
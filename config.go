@@ -11,6 +11,11 @@ import (
 //
 // URL or Path are required.
 // If URL is provided and Path does not already exist, Client is required, too.
+// Checksum and Mirrors are optional, see ProcessConfig.
+// Instead of URL, Path, Checksum, and Mirrors, Sources can be provided
+// to process a dump split into multiple files, see ProcessConfig.
+// Index is optional and records positions of items into a dump index
+// while the dump is processed, see ProcessConfig.
 //
 // Client should set User-Agent header with contact information, e.g.:
 //
@@ -26,4 +31,37 @@ type ProcessDumpConfig struct {
 	DecodingThreads        int
 	ItemsProcessingThreads int
 	Progress               func(context.Context, x.Progress)
+	Checksum               Checksum
+	Mirrors                []string
+	Sources                []Source
+	SourcesThreads         int
+	Index                  string
+}
+
+// IncrementalDumpConfig is a configuration for ProcessWikidataIncrementalDump.
+//
+// Client is required, see ProcessDumpConfig. Source can be nil to use
+// https://dumps.wikimedia.org/. If Dir is provided, downloaded dump files
+// are saved there under their original names and are not downloaded again.
+//
+// Since is the date (in YYYYMMDD format) of the oldest daily incremental dump
+// to process. Use the date of the full dump from which data being patched
+// has been built. If empty, all available incremental dumps are processed.
+//
+// API is the URL of the MediaWiki action API from whose deletion log deleted
+// entities are read, because incremental dumps do not contain deletions.
+// If empty, https://www.wikidata.org/w/api.php is used. If SkipDeletions
+// is set, the deletion log is not read and deleted entities (except for
+// those merged into other entities) are not reported.
+type IncrementalDumpConfig struct {
+	Client                 *retryablehttp.Client
+	Source                 *DumpSource
+	Since                  string
+	API                    string
+	SkipDeletions          bool
+	Dir                    string
+	DecompressionThreads   int
+	DecodingThreads        int
+	ItemsProcessingThreads int
+	Progress               func(context.Context, x.Progress)
 }
@@ -0,0 +1,513 @@
+package mediawiki
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+type LanguageValueChange struct {
+	Old LanguageValue `json:"old"`
+	New LanguageValue `json:"new"`
+}
+
+// LanguageValuesDiff is a difference between labels or descriptions, keyed by language.
+type LanguageValuesDiff struct {
+	Added   map[string]LanguageValue       `json:"added,omitempty"`
+	Removed map[string]LanguageValue       `json:"removed,omitempty"`
+	Changed map[string]LanguageValueChange `json:"changed,omitempty"`
+}
+
+// IsEmpty returns true if there is no difference.
+func (d LanguageValuesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// AliasesDiff is a difference between aliases, keyed by language.
+type AliasesDiff struct {
+	Added   map[string][]LanguageValue `json:"added,omitempty"`
+	Removed map[string][]LanguageValue `json:"removed,omitempty"`
+}
+
+// IsEmpty returns true if there is no difference.
+func (d AliasesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// StatementChange is a change of a statement with the same ID.
+//
+// Old and New are the whole statements, other fields describe which parts changed.
+//
+//nolint:tagliatelle
+type StatementChange struct {
+	ID                string      `json:"id"`
+	Old               Statement   `json:"old"`
+	New               Statement   `json:"new"`
+	MainSnakChanged   bool        `json:"main_snak_changed,omitempty"`
+	RankChanged       bool        `json:"rank_changed,omitempty"`
+	AddedQualifiers   []Snak      `json:"added_qualifiers,omitempty"`
+	RemovedQualifiers []Snak      `json:"removed_qualifiers,omitempty"`
+	AddedReferences   []Reference `json:"added_references,omitempty"`
+	RemovedReferences []Reference `json:"removed_references,omitempty"`
+}
+
+// StatementsDiff is a difference between statements, matched by their IDs.
+type StatementsDiff struct {
+	Added   []Statement       `json:"added,omitempty"`
+	Removed []Statement       `json:"removed,omitempty"`
+	Changed []StatementChange `json:"changed,omitempty"`
+}
+
+// IsEmpty returns true if there is no difference.
+func (d StatementsDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+type SiteLinkChange struct {
+	Old SiteLink `json:"old"`
+	New SiteLink `json:"new"`
+}
+
+// SiteLinksDiff is a difference between site links, keyed by site.
+//
+// A moved page (same site, different title) is reported as changed.
+type SiteLinksDiff struct {
+	Added   map[string]SiteLink       `json:"added,omitempty"`
+	Removed map[string]SiteLink       `json:"removed,omitempty"`
+	Changed map[string]SiteLinkChange `json:"changed,omitempty"`
+}
+
+// IsEmpty returns true if there is no difference.
+func (d SiteLinksDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// EntityDiff is a difference between two versions of the same entity.
+//
+// Added is true when the entity exists only in the new version and
+// Removed is true when it exists only in the old version.
+//
+//nolint:tagliatelle
+type EntityDiff struct {
+	ID           string             `json:"id"`
+	Added        bool               `json:"added,omitempty"`
+	Removed      bool               `json:"removed,omitempty"`
+	OldRevID     int64              `json:"old_rev_id,omitempty"`
+	NewRevID     int64              `json:"new_rev_id,omitempty"`
+	Labels       LanguageValuesDiff `json:"labels,omitzero"`
+	Descriptions LanguageValuesDiff `json:"descriptions,omitzero"`
+	Aliases      AliasesDiff        `json:"aliases,omitzero"`
+	Statements   StatementsDiff     `json:"statements,omitzero"`
+	SiteLinks    SiteLinksDiff      `json:"sitelinks,omitzero"`
+}
+
+// IsEmpty returns true if there is no difference.
+func (d EntityDiff) IsEmpty() bool {
+	return !d.Added && !d.Removed && d.Labels.IsEmpty() && d.Descriptions.IsEmpty() &&
+		d.Aliases.IsEmpty() && d.Statements.IsEmpty() && d.SiteLinks.IsEmpty()
+}
+
+func diffLanguageValues(oldValues, newValues map[string]LanguageValue) LanguageValuesDiff {
+	diff := LanguageValuesDiff{}
+	for language, oldValue := range oldValues {
+		newValue, ok := newValues[language]
+		if !ok {
+			if diff.Removed == nil {
+				diff.Removed = map[string]LanguageValue{}
+			}
+			diff.Removed[language] = oldValue
+		} else if oldValue != newValue {
+			if diff.Changed == nil {
+				diff.Changed = map[string]LanguageValueChange{}
+			}
+			diff.Changed[language] = LanguageValueChange{Old: oldValue, New: newValue}
+		}
+	}
+	for language, newValue := range newValues {
+		if _, ok := oldValues[language]; !ok {
+			if diff.Added == nil {
+				diff.Added = map[string]LanguageValue{}
+			}
+			diff.Added[language] = newValue
+		}
+	}
+	return diff
+}
+
+func diffAliases(oldAliases, newAliases map[string][]LanguageValue) AliasesDiff {
+	diff := AliasesDiff{}
+	languages := map[string]bool{}
+	for language := range oldAliases {
+		languages[language] = true
+	}
+	for language := range newAliases {
+		languages[language] = true
+	}
+	for language := range languages {
+		added, removed := diffSlices(oldAliases[language], newAliases[language], func(a, b LanguageValue) bool { return a == b })
+		if len(added) > 0 {
+			if diff.Added == nil {
+				diff.Added = map[string][]LanguageValue{}
+			}
+			diff.Added[language] = added
+		}
+		if len(removed) > 0 {
+			if diff.Removed == nil {
+				diff.Removed = map[string][]LanguageValue{}
+			}
+			diff.Removed[language] = removed
+		}
+	}
+	return diff
+}
+
+// diffSlices returns elements of newValues not in oldValues and elements of
+// oldValues not in newValues. Slices are compared as multisets.
+func diffSlices[T any](oldValues, newValues []T, equal func(a, b T) bool) ([]T, []T) {
+	matched := make([]bool, len(newValues))
+	removed := []T{}
+	for _, o := range oldValues {
+		found := false
+		for i, n := range newValues {
+			if !matched[i] && equal(o, n) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			removed = append(removed, o)
+		}
+	}
+	added := []T{}
+	for i, n := range newValues {
+		if !matched[i] {
+			added = append(added, n)
+		}
+	}
+	return added, removed
+}
+
+// flattenSnaks returns all snaks from a map of snaks, ordered by OrderedProperties.
+func flattenSnaks(snaks map[string][]Snak, order []string) []Snak {
+	properties := OrderedProperties(snaks, order)
+	result := []Snak{}
+	for _, property := range properties {
+		result = append(result, snaks[property]...)
+	}
+	return result
+}
+
+func statementsByID(claims map[string][]Statement) (map[string]Statement, []string) {
+	statements := map[string]Statement{}
+	ids := []string{}
+	properties := make([]string, 0, len(claims))
+	for property := range claims {
+		properties = append(properties, property)
+	}
+	slices.SortFunc(properties, CompareEntityIDs)
+	for _, property := range properties {
+		for _, statement := range claims[property] {
+			statements[statement.ID] = statement
+			ids = append(ids, statement.ID)
+		}
+	}
+	return statements, ids
+}
+
+func diffStatements(oldClaims, newClaims map[string][]Statement) StatementsDiff {
+	diff := StatementsDiff{}
+	oldStatements, oldIDs := statementsByID(oldClaims)
+	newStatements, newIDs := statementsByID(newClaims)
+	for _, id := range oldIDs {
+		oldStatement := oldStatements[id]
+		newStatement, ok := newStatements[id]
+		if !ok {
+			diff.Removed = append(diff.Removed, oldStatement)
+			continue
+		}
+		change := StatementChange{ID: id, Old: oldStatement, New: newStatement}
+		change.MainSnakChanged = !oldStatement.MainSnak.Equal(newStatement.MainSnak)
+		change.RankChanged = oldStatement.Rank != newStatement.Rank
+		added, removed := diffSlices(
+			flattenSnaks(oldStatement.Qualifiers, oldStatement.QualifiersOrder),
+			flattenSnaks(newStatement.Qualifiers, newStatement.QualifiersOrder),
+			Snak.Equal,
+		)
+		if len(added) > 0 {
+			change.AddedQualifiers = added
+		}
+		if len(removed) > 0 {
+			change.RemovedQualifiers = removed
+		}
+		addedReferences, removedReferences := diffSlices(oldStatement.References, newStatement.References, Reference.Equal)
+		if len(addedReferences) > 0 {
+			change.AddedReferences = addedReferences
+		}
+		if len(removedReferences) > 0 {
+			change.RemovedReferences = removedReferences
+		}
+		if change.MainSnakChanged || change.RankChanged || change.AddedQualifiers != nil || change.RemovedQualifiers != nil ||
+			change.AddedReferences != nil || change.RemovedReferences != nil {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	for _, id := range newIDs {
+		if _, ok := oldStatements[id]; !ok {
+			diff.Added = append(diff.Added, newStatements[id])
+		}
+	}
+	return diff
+}
+
+func diffSiteLinks(oldSiteLinks, newSiteLinks map[string]SiteLink) SiteLinksDiff {
+	diff := SiteLinksDiff{}
+	for site, oldSiteLink := range oldSiteLinks {
+		newSiteLink, ok := newSiteLinks[site]
+		if !ok {
+			if diff.Removed == nil {
+				diff.Removed = map[string]SiteLink{}
+			}
+			diff.Removed[site] = oldSiteLink
+		} else if oldSiteLink.Title != newSiteLink.Title || !slices.Equal(oldSiteLink.Badges, newSiteLink.Badges) {
+			if diff.Changed == nil {
+				diff.Changed = map[string]SiteLinkChange{}
+			}
+			diff.Changed[site] = SiteLinkChange{Old: oldSiteLink, New: newSiteLink}
+		}
+	}
+	for site, newSiteLink := range newSiteLinks {
+		if _, ok := oldSiteLinks[site]; !ok {
+			if diff.Added == nil {
+				diff.Added = map[string]SiteLink{}
+			}
+			diff.Added[site] = newSiteLink
+		}
+	}
+	return diff
+}
+
+// DiffEntities computes the difference between two versions of the same entity.
+//
+// Statements are matched by their IDs and data values are compared by their
+// values and not representation (e.g., amounts +1.0 and +1 are equal).
+// Hashes of snaks and references are ignored.
+func DiffEntities(oldEntity, newEntity Entity) EntityDiff {
+	id := newEntity.ID
+	if id == "" {
+		id = oldEntity.ID
+	}
+	return EntityDiff{
+		ID:           id,
+		Added:        oldEntity.ID == "" && newEntity.ID != "",
+		Removed:      oldEntity.ID != "" && newEntity.ID == "",
+		OldRevID:     oldEntity.LastRevID,
+		NewRevID:     newEntity.LastRevID,
+		Labels:       diffLanguageValues(oldEntity.Labels, newEntity.Labels),
+		Descriptions: diffLanguageValues(oldEntity.Descriptions, newEntity.Descriptions),
+		Aliases:      diffAliases(oldEntity.Aliases, newEntity.Aliases),
+		Statements:   diffStatements(oldEntity.Claims, newEntity.Claims),
+		SiteLinks:    diffSiteLinks(oldEntity.SiteLinks, newEntity.SiteLinks),
+	}
+}
+
+// DiffDumpsConfig is a configuration for DiffDumps.
+//
+// Old and New configure reading of the old and the new dump. Their threading
+// options are ignored because entities have to be read in order. Process reads
+// a dump and is by default ProcessWikidataDump.
+type DiffDumpsConfig struct {
+	Old     ProcessDumpConfig
+	New     ProcessDumpConfig
+	Process func(context.Context, *ProcessDumpConfig, func(context.Context, Entity) errors.E) errors.E
+}
+
+// entityStream is a stream of entities of a dump, read in order.
+type entityStream struct {
+	entities chan Entity
+	// err is set before entities channel is closed.
+	err errors.E
+}
+
+// next returns the next entity. It returns false when the stream ended, or
+// the error if the stream ended because reading the dump failed.
+func (s *entityStream) next() (Entity, bool, errors.E) {
+	entity, ok := <-s.entities
+	if !ok && s.err != nil {
+		return Entity{}, false, s.err
+	}
+	return entity, ok, nil
+}
+
+// streamDump reads the dump in order and sends entities to the stream.
+func streamDump(
+	ctx context.Context, process func(context.Context, *ProcessDumpConfig, func(context.Context, Entity) errors.E) errors.E,
+	config ProcessDumpConfig, stream *entityStream,
+) {
+	defer close(stream.entities)
+
+	// Sources are processed one after the other, so with one thread
+	// for everything entities are read in the order of the dump.
+	config.SourcesThreads = 1
+	config.DecodingThreads = 1
+	config.ItemsProcessingThreads = 1
+	previous := ""
+	stream.err = process(ctx, &config, func(ctx context.Context, entity Entity) errors.E {
+		if previous != "" && CompareEntityIDs(previous, entity.ID) >= 0 {
+			errE := errors.New("dump not sorted by ID")
+			errors.Details(errE)["previous"] = previous
+			errors.Details(errE)["id"] = entity.ID
+			return errE
+		}
+		previous = entity.ID
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case stream.entities <- entity:
+			return nil
+		}
+	})
+}
+
+// DiffDumps compares two dumps sorted by entity IDs (in the order of CompareEntityIDs)
+// and calls processDiff for every entity which changed, was added, or was removed.
+//
+// Both dumps are streamed at the same time so memory use does not depend on dump sizes.
+func DiffDumps(ctx context.Context, config *DiffDumpsConfig, processDiff func(context.Context, EntityDiff) errors.E) errors.E {
+	process := config.Process
+	if process == nil {
+		process = ProcessWikidataDump
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	oldStream := &entityStream{entities: make(chan Entity, 1), err: nil}
+	newStream := &entityStream{entities: make(chan Entity, 1), err: nil}
+	var wg sync.WaitGroup
+	wg.Add(2) //nolint:mnd
+	go func() {
+		defer wg.Done()
+		streamDump(ctx, process, config.Old, oldStream)
+		if oldStream.err != nil {
+			cancel()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		streamDump(ctx, process, config.New, newStream)
+		if newStream.err != nil {
+			cancel()
+		}
+	}()
+
+	errE := mergeDiff(ctx, oldStream, newStream, processDiff)
+	if errE != nil {
+		cancel()
+	}
+	// Drain channels so that goroutines can finish.
+	go func() {
+		for range oldStream.entities { //nolint:revive
+		}
+	}()
+	go func() {
+		for range newStream.entities { //nolint:revive
+		}
+	}()
+	wg.Wait()
+
+	if errE == oldStream.err || errE == newStream.err { //nolint:errorlint
+		// mergeDiff returned the error of a stream which is collected below.
+		errE = nil
+	}
+
+	allErrors := []errors.E{}
+	nonCanceledErrors := []error{}
+	for _, err := range []errors.E{errE, oldStream.err, newStream.err} {
+		if err == nil {
+			continue
+		}
+		allErrors = append(allErrors, err)
+		if !errors.Is(err, context.Canceled) {
+			nonCanceledErrors = append(nonCanceledErrors, err)
+		}
+	}
+	if len(nonCanceledErrors) > 0 {
+		// If there is only one error, errors.Join will return it as-is.
+		return errors.Join(nonCanceledErrors...)
+	}
+	if len(allErrors) > 0 {
+		return allErrors[0]
+	}
+	return nil
+}
+
+// mergeDiff merges both streams and calls processDiff for every difference. If a stream
+// fails, its error is returned before the remaining entities of the other stream
+// are reported as added or removed.
+func mergeDiff(ctx context.Context, oldStream, newStream *entityStream, processDiff func(context.Context, EntityDiff) errors.E) errors.E {
+	oldEntity, oldOK, errE := oldStream.next()
+	if errE != nil {
+		return errE
+	}
+	newEntity, newOK, errE := newStream.next()
+	if errE != nil {
+		return errE
+	}
+	for oldOK || newOK {
+		var diff EntityDiff
+		switch {
+		case !newOK || (oldOK && CompareEntityIDs(oldEntity.ID, newEntity.ID) < 0):
+			diff = DiffEntities(oldEntity, Entity{})
+			oldEntity, oldOK, errE = oldStream.next()
+		case !oldOK || CompareEntityIDs(oldEntity.ID, newEntity.ID) > 0:
+			diff = DiffEntities(Entity{}, newEntity)
+			newEntity, newOK, errE = newStream.next()
+		default:
+			diff = DiffEntities(oldEntity, newEntity)
+			oldEntity, oldOK, errE = oldStream.next()
+			if errE == nil {
+				newEntity, newOK, errE = newStream.next()
+			}
+		}
+		if errE != nil {
+			return errE
+		}
+		if diff.IsEmpty() {
+			continue
+		}
+		errE = processDiff(ctx, diff)
+		if errE != nil {
+			return errE
+		}
+	}
+	return nil
+}
+
+// DiffDumpWithLookup streams the new dump and compares every entity with its old
+// version obtained using lookup. It calls processDiff for every entity which changed
+// or was added. Lookup should return an error satisfying errors.Is(err, ErrNotFound)
+// if the entity does not exist in the old version.
+//
+// Because the old dump is only indexed, removed entities are not reported.
+func DiffDumpWithLookup(
+	ctx context.Context, config *ProcessDumpConfig,
+	lookup func(context.Context, string) (Entity, errors.E),
+	processDiff func(context.Context, EntityDiff) errors.E,
+) errors.E {
+	return ProcessWikidataDump(ctx, config, func(ctx context.Context, newEntity Entity) errors.E {
+		oldEntity, errE := lookup(ctx, newEntity.ID)
+		if errors.Is(errE, ErrNotFound) {
+			oldEntity = Entity{}
+		} else if errE != nil {
+			return errE
+		}
+		diff := DiffEntities(oldEntity, newEntity)
+		if diff.IsEmpty() {
+			return nil
+		}
+		return processDiff(ctx, diff)
+	})
+}
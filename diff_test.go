@@ -0,0 +1,276 @@
+package mediawiki_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+const diffOldEntity = `{
+	"id": "Q1", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z",
+	"labels": {"en": {"language": "en", "value": "Old"}, "de": {"language": "de", "value": "Alt"}},
+	"aliases": {"en": [{"language": "en", "value": "A"}, {"language": "en", "value": "B"}]},
+	"claims": {
+		"P1082": [{
+			"id": "Q1$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P1082", "hash": "h1",
+				"datavalue": {"type": "quantity", "value": {"amount": "+1.0", "unit": "1"}}},
+			"references": [{"hash": "r1", "snaks": {"P854": [{"snaktype": "value", "property": "P854",
+				"datavalue": {"type": "string", "value": "https://example.com"}}]}, "snaks-order": ["P854"]}]
+		}],
+		"P569": [{
+			"id": "Q1$2", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P569",
+				"datavalue": {"type": "time", "value": {"time": "+1952-03-11T00:00:00Z", "precision": 9,
+					"calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}
+		}],
+		"P31": [{
+			"id": "Q1$3", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P31",
+				"datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q5"}}}
+		}]
+	},
+	"sitelinks": {"enwiki": {"site": "enwiki", "title": "Old title"}, "dewiki": {"site": "dewiki", "title": "Titel"}}
+}`
+
+const diffNewEntity = `{
+	"id": "Q1", "type": "item", "lastrevid": 2, "modified": "2020-02-01T00:00:00Z",
+	"labels": {"en": {"language": "en", "value": "New"}, "fr": {"language": "fr", "value": "Nouveau"}},
+	"aliases": {"en": [{"language": "en", "value": "B"}, {"language": "en", "value": "C"}]},
+	"claims": {
+		"P1082": [{
+			"id": "Q1$1", "type": "statement", "rank": "preferred",
+			"mainsnak": {"snaktype": "value", "property": "P1082", "hash": "h2",
+				"datavalue": {"type": "quantity", "value": {"amount": "+1", "unit": "1"}}},
+			"qualifiers": {"P585": [{"snaktype": "value", "property": "P585",
+				"datavalue": {"type": "time", "value": {"time": "+2020-00-00T00:00:00Z", "precision": 9,
+					"calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}]},
+			"qualifiers-order": ["P585"],
+			"references": [{"hash": "r2", "snaks": {"P854": [{"snaktype": "value", "property": "P854",
+				"datavalue": {"type": "string", "value": "https://example.com"}}]}, "snaks-order": ["P854"]}]
+		}],
+		"P569": [{
+			"id": "Q1$2", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P569",
+				"datavalue": {"type": "time", "value": {"time": "+1952-00-00T00:00:00Z", "precision": 9,
+					"calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}
+		}],
+		"P106": [{
+			"id": "Q1$4", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "somevalue", "property": "P106"}
+		}]
+	},
+	"sitelinks": {"enwiki": {"site": "enwiki", "title": "New title"}, "dewiki": {"site": "dewiki", "title": "Titel"}}
+}`
+
+func parseEntity(t *testing.T, data string) mediawiki.Entity {
+	t.Helper()
+
+	var entity mediawiki.Entity
+	err := json.Unmarshal([]byte(data), &entity)
+	require.NoError(t, err)
+	return entity
+}
+
+func TestDiffEntities(t *testing.T) {
+	t.Parallel()
+
+	oldEntity := parseEntity(t, diffOldEntity)
+	newEntity := parseEntity(t, diffNewEntity)
+
+	assert.True(t, mediawiki.DiffEntities(oldEntity, oldEntity).IsEmpty())
+
+	diff := mediawiki.DiffEntities(oldEntity, newEntity)
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, "Q1", diff.ID)
+	assert.False(t, diff.Added)
+	assert.False(t, diff.Removed)
+	assert.Equal(t, int64(1), diff.OldRevID)
+	assert.Equal(t, int64(2), diff.NewRevID)
+
+	assert.Equal(t, map[string]mediawiki.LanguageValue{"fr": {Language: "fr", Value: "Nouveau"}}, diff.Labels.Added)
+	assert.Equal(t, map[string]mediawiki.LanguageValue{"de": {Language: "de", Value: "Alt"}}, diff.Labels.Removed)
+	assert.Equal(t, "New", diff.Labels.Changed["en"].New.Value)
+	assert.True(t, diff.Descriptions.IsEmpty())
+
+	assert.Equal(t, map[string][]mediawiki.LanguageValue{"en": {{Language: "en", Value: "C"}}}, diff.Aliases.Added)
+	assert.Equal(t, map[string][]mediawiki.LanguageValue{"en": {{Language: "en", Value: "A"}}}, diff.Aliases.Removed)
+
+	require.Len(t, diff.Statements.Added, 1)
+	assert.Equal(t, "Q1$4", diff.Statements.Added[0].ID)
+	require.Len(t, diff.Statements.Removed, 1)
+	assert.Equal(t, "Q1$3", diff.Statements.Removed[0].ID)
+	// Q1$2 is unchanged because time is compared at year precision.
+	require.Len(t, diff.Statements.Changed, 1)
+	change := diff.Statements.Changed[0]
+	assert.Equal(t, "Q1$1", change.ID)
+	// +1.0 and +1 are the same amount.
+	assert.False(t, change.MainSnakChanged)
+	assert.True(t, change.RankChanged)
+	require.Len(t, change.AddedQualifiers, 1)
+	assert.Equal(t, "P585", change.AddedQualifiers[0].Property)
+	assert.Empty(t, change.RemovedQualifiers)
+	// References differ only in hash.
+	assert.Empty(t, change.AddedReferences)
+	assert.Empty(t, change.RemovedReferences)
+
+	assert.Empty(t, diff.SiteLinks.Added)
+	assert.Empty(t, diff.SiteLinks.Removed)
+	assert.Equal(t, "Old title", diff.SiteLinks.Changed["enwiki"].Old.Title)
+	assert.Equal(t, "New title", diff.SiteLinks.Changed["enwiki"].New.Title)
+
+	data, errE := x.MarshalWithoutEscapeHTML(diff)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotContains(t, string(data), `"descriptions"`)
+	var decoded mediawiki.EntityDiff
+	err := json.Unmarshal(data, &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, diff.Labels, decoded.Labels)
+	assert.Equal(t, diff.SiteLinks, decoded.SiteLinks)
+
+	diff = mediawiki.DiffEntities(mediawiki.Entity{}, newEntity)
+	assert.True(t, diff.Added)
+	assert.Len(t, diff.Statements.Added, 3)
+}
+
+func writeNDJSON(t *testing.T, path string, entities ...string) {
+	t.Helper()
+
+	lines := []string{}
+	for _, entity := range entities {
+		var v interface{}
+		err := json.Unmarshal([]byte(entity), &v)
+		require.NoError(t, err)
+		line, errE := x.MarshalWithoutEscapeHTML(v)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		lines = append(lines, string(line))
+	}
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	require.NoError(t, err)
+}
+
+func TestDiffDumps(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.json")
+	newPath := filepath.Join(dir, "new.json")
+	writeNDJSON(t, oldPath,
+		`{"id":"P5","type":"property","datatype":"string","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+		diffOldEntity,
+		`{"id":"Q2","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+		`{"id":"Q10","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+	)
+	writeNDJSON(t, newPath,
+		`{"id":"P5","type":"property","datatype":"string","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+		diffNewEntity,
+		`{"id":"Q3","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+		`{"id":"Q10","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+	)
+
+	process := func(ctx context.Context, config *mediawiki.ProcessDumpConfig, processEntity func(context.Context, mediawiki.Entity) errors.E) errors.E {
+		return mediawiki.Process(ctx, &mediawiki.ProcessConfig[mediawiki.Entity]{
+			Path:                   config.Path,
+			Sources:                config.Sources,
+			SourcesThreads:         config.SourcesThreads,
+			DecodingThreads:        config.DecodingThreads,
+			ItemsProcessingThreads: config.ItemsProcessingThreads,
+			Process:                processEntity,
+			FileType:               mediawiki.NDJSON,
+			Compression:            mediawiki.NoCompression,
+		})
+	}
+
+	diffs := []mediawiki.EntityDiff{}
+	errE := mediawiki.DiffDumps(context.Background(), &mediawiki.DiffDumpsConfig{
+		Old:     mediawiki.ProcessDumpConfig{Path: oldPath},
+		New:     mediawiki.ProcessDumpConfig{Path: newPath},
+		Process: process,
+	}, func(_ context.Context, diff mediawiki.EntityDiff) errors.E {
+		diffs = append(diffs, diff)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, diffs, 3)
+	assert.Equal(t, "Q1", diffs[0].ID)
+	assert.Equal(t, "Q2", diffs[1].ID)
+	assert.True(t, diffs[1].Removed)
+	assert.Equal(t, "Q3", diffs[2].ID)
+	assert.True(t, diffs[2].Added)
+
+	errE = mediawiki.DiffDumps(context.Background(), &mediawiki.DiffDumpsConfig{
+		Old:     mediawiki.ProcessDumpConfig{Path: newPath},
+		New:     mediawiki.ProcessDumpConfig{Path: oldPath},
+		Process: process,
+	}, func(_ context.Context, _ mediawiki.EntityDiff) errors.E {
+		return errors.New("test error")
+	})
+	assert.EqualError(t, errE, "test error")
+
+	unsortedPath := filepath.Join(dir, "unsorted.json")
+	writeNDJSON(t, unsortedPath,
+		`{"id":"Q10","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+		`{"id":"Q9","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`,
+	)
+	errE = mediawiki.DiffDumps(context.Background(), &mediawiki.DiffDumpsConfig{
+		Old:     mediawiki.ProcessDumpConfig{Path: oldPath},
+		New:     mediawiki.ProcessDumpConfig{Path: unsortedPath},
+		Process: process,
+	}, func(_ context.Context, _ mediawiki.EntityDiff) errors.E {
+		return nil
+	})
+	assert.ErrorContains(t, errE, "dump not sorted by ID")
+
+	// Multi-part dumps are read part after part.
+	parts := []mediawiki.Source{}
+	for i := range 10 {
+		path := filepath.Join(dir, fmt.Sprintf("part%d.json", i))
+		entities := []string{}
+		for j := range 10 {
+			entities = append(entities, fmt.Sprintf(`{"id":"Q%d","type":"item","lastrevid":1,"modified":"2020-01-01T00:00:00Z"}`, 100+i*10+j))
+		}
+		writeNDJSON(t, path, entities...)
+		parts = append(parts, mediawiki.Source{Path: path})
+	}
+	diffs = []mediawiki.EntityDiff{}
+	errE = mediawiki.DiffDumps(context.Background(), &mediawiki.DiffDumpsConfig{
+		Old:     mediawiki.ProcessDumpConfig{Path: oldPath},
+		New:     mediawiki.ProcessDumpConfig{Sources: parts},
+		Process: process,
+	}, func(_ context.Context, diff mediawiki.EntityDiff) errors.E {
+		diffs = append(diffs, diff)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, diffs, 104)
+
+	// When a dump fails, remaining entities of the other dump are not reported.
+	failing := func(ctx context.Context, config *mediawiki.ProcessDumpConfig, processEntity func(context.Context, mediawiki.Entity) errors.E) errors.E {
+		if config.Path == newPath {
+			return errors.New("dump error")
+		}
+		return process(ctx, config, processEntity)
+	}
+	diffs = []mediawiki.EntityDiff{}
+	errE = mediawiki.DiffDumps(context.Background(), &mediawiki.DiffDumpsConfig{
+		Old:     mediawiki.ProcessDumpConfig{Path: oldPath},
+		New:     mediawiki.ProcessDumpConfig{Path: newPath},
+		Process: failing,
+	}, func(_ context.Context, diff mediawiki.EntityDiff) errors.E {
+		diffs = append(diffs, diff)
+		return nil
+	})
+	assert.EqualError(t, errE, "dump error")
+	assert.Empty(t, diffs)
+}
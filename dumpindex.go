@@ -0,0 +1,647 @@
+package mediawiki
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	bzip2BlockMagic uint64 = 0x314159265359
+	bzip2EOSMagic   uint64 = 0x177245385090
+	bzip2MagicMask  uint64 = 1<<48 - 1
+
+	dumpIndexBlocks = "BLOCKS"
+	dumpIndexMagic  = "MWDUMPINDEX1\n"
+
+	// Block magic can appear by chance inside compressed data. A block which fails
+	// to decompress is merged with up to this many following segments.
+	maxBZIP2Merges = 3
+)
+
+// bzip2Segment is a part of a bzip2 stream between two magic numbers,
+// starting with a block or end of stream magic number.
+type bzip2Segment struct {
+	// Start and End are in bits.
+	Start int64
+	End   int64
+	EOS   bool
+	// Data holds bytes containing bits from Start to End.
+	Data []byte
+}
+
+func mergeBZIP2Segments(a, b bzip2Segment) bzip2Segment {
+	data := slices.Clone(a.Data[:b.Start/8-a.Start/8])
+	return bzip2Segment{
+		Start: a.Start,
+		End:   b.End,
+		EOS:   a.EOS,
+		Data:  append(data, b.Data...),
+	}
+}
+
+// bzip2Scanner splits a bzip2 stream into segments by searching
+// for bit-aligned block and end of stream magic numbers.
+type bzip2Scanner struct {
+	reader   *bufio.Reader
+	offset   int64
+	register uint64
+	open     bool
+	current  bzip2Segment
+	ready    []bzip2Segment
+	done     bool
+}
+
+func newBZIP2Scanner(r io.Reader) *bzip2Scanner {
+	return &bzip2Scanner{reader: bufio.NewReaderSize(r, 1<<20)} //nolint:mnd
+}
+
+func (s *bzip2Scanner) Next() (bzip2Segment, errors.E) {
+	for len(s.ready) == 0 {
+		if s.done {
+			return bzip2Segment{}, errors.WithStack(io.EOF)
+		}
+		b, err := s.reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			s.done = true
+			if s.open {
+				s.current.End = s.offset * 8
+				s.ready = append(s.ready, s.current)
+				s.open = false
+			}
+			continue
+		} else if err != nil {
+			return bzip2Segment{}, errors.WithStack(err)
+		}
+		i := s.offset
+		s.offset++
+		s.register = s.register<<8 | uint64(b)
+		if s.open {
+			s.current.Data = append(s.current.Data, b)
+		}
+		// Check all 48-bit windows ending in this byte, earliest first.
+		for shift := 7; shift >= 0; shift-- {
+			start := i*8 - int64(shift) - 40 //nolint:mnd
+			if start < 0 {
+				continue
+			}
+			magic := (s.register >> shift) & bzip2MagicMask
+			if magic == bzip2BlockMagic || magic == bzip2EOSMagic {
+				s.found(start, magic == bzip2EOSMagic, i)
+			}
+		}
+	}
+	segment := s.ready[0]
+	s.ready = s.ready[1:]
+	return segment, nil
+}
+
+// found ends the current segment (if any) and starts a new one at start bit.
+// i is the offset of the last read byte.
+func (s *bzip2Scanner) found(start int64, eos bool, i int64) {
+	first := start / 8
+	var data []byte
+	if s.open {
+		s.current.End = start
+		data = slices.Clone(s.current.Data[first-s.current.Start/8:])
+		s.current.Data = s.current.Data[:(start+7)/8-s.current.Start/8]
+		s.ready = append(s.ready, s.current)
+	} else {
+		for j := first; j <= i; j++ {
+			data = append(data, byte(s.register>>(8*(i-j)))) //nolint:gosec
+		}
+	}
+	s.open = true
+	s.current = bzip2Segment{Start: start, EOS: eos, Data: data}
+}
+
+type bitWriter struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) write(v uint64, bits uint) {
+	w.acc = w.acc<<bits | v&(1<<bits-1)
+	w.n += bits
+	for w.n >= 8 { //nolint:mnd
+		w.n -= 8
+		w.out = append(w.out, byte(w.acc>>w.n)) //nolint:gosec
+	}
+}
+
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.out = append(w.out, byte(w.acc<<(8-w.n))) //nolint:gosec
+		w.n = 0
+	}
+}
+
+func readBits(data []byte, pos int64, n uint) uint64 {
+	var v uint64
+	for k := range int64(n) {
+		bit := pos + k
+		v = v<<1 | uint64(data[bit/8]>>(7-bit%8)&1)
+	}
+	return v
+}
+
+// decompressBZIP2Block decompresses a single block by wrapping it into
+// a stream with only that block.
+func decompressBZIP2Block(segment bzip2Segment) ([]byte, errors.E) {
+	shift := segment.Start % 8
+	bits := segment.End - segment.Start
+	if bits < 80 { //nolint:mnd
+		return nil, errors.New("bzip2 block too short")
+	}
+	full := bits / 8
+	w := &bitWriter{out: make([]byte, 0, full+16)} //nolint:mnd
+	// We always use the largest block size, it works for smaller blocks, too.
+	w.out = append(w.out, "BZh9"...)
+	for j := range full {
+		b := segment.Data[j] << shift
+		if shift > 0 {
+			b |= segment.Data[j+1] >> (8 - shift)
+		}
+		w.out = append(w.out, b)
+	}
+	w.write(readBits(segment.Data, shift+full*8, uint(bits%8)), uint(bits%8)) //nolint:gosec
+	// Stream CRC of a stream with one block equals the block CRC.
+	w.write(bzip2EOSMagic, 48)                        //nolint:mnd
+	w.write(readBits(segment.Data, shift+48, 32), 32) //nolint:mnd
+	w.flush()
+	data, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(w.out)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// decompressBZIP2Blocks decompresses bzip2 blocks in parallel and calls fn for each block in order.
+func decompressBZIP2Blocks(
+	ctx context.Context, r io.Reader, threads int, fn func(bzip2Segment, []byte) errors.E,
+) errors.E {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		segment bzip2Segment
+		data    []byte
+		errE    errors.E
+	}
+
+	jobs := make(chan func(), threads)
+	futures := make(chan chan result, 2*threads) //nolint:mnd
+	for range threads {
+		go func() {
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
+	scanErr := make(chan errors.E, 1)
+	go func() {
+		defer close(futures)
+		defer close(jobs)
+
+		scanner := newBZIP2Scanner(r)
+		for {
+			segment, errE := scanner.Next()
+			if errors.Is(errE, io.EOF) {
+				scanErr <- nil
+				return
+			} else if errE != nil {
+				scanErr <- errE
+				return
+			}
+			future := make(chan result, 1)
+			// Job is queued first so that every future in futures is eventually resolved.
+			select {
+			case <-ctx.Done():
+				scanErr <- errors.WithStack(ctx.Err())
+				return
+			case jobs <- func() {
+				res := result{segment: segment} //nolint:exhaustruct
+				if !segment.EOS {
+					res.data, res.errE = decompressBZIP2Block(segment)
+				}
+				future <- res
+			}:
+			}
+			select {
+			case <-ctx.Done():
+				scanErr <- errors.WithStack(ctx.Err())
+				return
+			case futures <- future:
+			}
+		}
+	}()
+
+	for future := range futures {
+		res := <-future
+		if res.segment.EOS {
+			continue
+		}
+		for range maxBZIP2Merges {
+			if res.errE == nil {
+				break
+			}
+			following, ok := <-futures
+			if !ok {
+				break
+			}
+			res.segment = mergeBZIP2Segments(res.segment, (<-following).segment)
+			res.data, res.errE = decompressBZIP2Block(res.segment)
+		}
+		if res.errE != nil {
+			errors.Details(res.errE)["bit"] = res.segment.Start
+			return res.errE
+		}
+		errE := fn(res.segment, res.data)
+		if errE != nil {
+			return errE
+		}
+	}
+	return <-scanErr
+}
+
+type dumpIndexBlock struct {
+	// Start and End are in bits of the compressed file.
+	Start int64
+	End   int64
+	// Offset is the offset of the block in the decompressed file.
+	Offset int64
+}
+
+// DumpIndex is an index of entity positions in a local JSON entity dump,
+// which allows random access to entities without decompressing the whole dump.
+//
+// For a bzip2-compressed dump it records bzip2 blocks and for each entity
+// its position in the decompressed dump. Lookup then decompresses only the
+// blocks containing the entity. Create it with IndexDump and later open it
+// with OpenDumpIndex. It is safe to use concurrently.
+type DumpIndex struct {
+	file   *os.File
+	store  *Store
+	blocks []dumpIndexBlock
+	bzip2  bool
+}
+
+// IndexDumpConfig is a configuration for IndexDump.
+//
+// Path is a local JSON entity dump in FileType (JSONArray or NDJSON) with
+// Compression (BZIP2 or NoCompression). Index is a directory where the index
+// is stored. The dump itself is not copied into the index.
+type IndexDumpConfig struct {
+	Path                 string
+	Index                string
+	DecompressionThreads int
+	DecodingThreads      int
+	Progress             func(context.Context, x.Progress)
+	FileType             FileType
+	Compression          Compression
+}
+
+type dumpIndexRow struct {
+	Data   []byte
+	Offset int64
+}
+
+func encodeDumpIndexBlocks(compression Compression, blocks []dumpIndexBlock) []byte {
+	data := []byte(dumpIndexMagic)
+	data = binary.AppendUvarint(data, uint64(compression)) //nolint:gosec
+	data = binary.AppendUvarint(data, uint64(len(blocks)))
+	var end, offset int64
+	for _, block := range blocks {
+		data = binary.AppendUvarint(data, uint64(block.Start-end))       //nolint:gosec
+		data = binary.AppendUvarint(data, uint64(block.End-block.Start)) //nolint:gosec
+		data = binary.AppendUvarint(data, uint64(block.Offset-offset))   //nolint:gosec
+		end = block.End
+		offset = block.Offset
+	}
+	return data
+}
+
+func decodeDumpIndexBlocks(data []byte) (Compression, []dumpIndexBlock, errors.E) {
+	if !bytes.HasPrefix(data, []byte(dumpIndexMagic)) {
+		return 0, nil, errors.WithMessage(ErrInvalidValue, "dump index magic")
+	}
+	r := bytes.NewReader(data[len(dumpIndexMagic):])
+	values := []uint64{}
+	for r.Len() > 0 {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, nil, errors.WithStack(err)
+		}
+		values = append(values, v)
+	}
+	if len(values) < 2 || uint64(len(values)-2) != 3*values[1] { //nolint:mnd
+		return 0, nil, errors.WithMessage(ErrInvalidValue, "dump index blocks")
+	}
+	blocks := make([]dumpIndexBlock, 0, values[1])
+	var end, offset int64
+	for i := 2; i < len(values); i += 3 {
+		start := end + int64(values[i])  //nolint:gosec
+		end = start + int64(values[i+1]) //nolint:gosec
+		offset += int64(values[i+2])     //nolint:gosec
+		blocks = append(blocks, dumpIndexBlock{Start: start, End: end, Offset: offset})
+	}
+	return Compression(values[0]), blocks, nil //nolint:gosec
+}
+
+// dumpIndexer records positions of items into a dump index while the dump is
+// processed by Process, see ProcessConfig.Index.
+type dumpIndexer struct {
+	index       string
+	compression Compression
+	store       *Store
+	writer      *StoreWriter
+	rows        chan dumpIndexRow
+
+	// blocks are set by the decompression goroutine and
+	// can be read only after it finished.
+	blocks     []dumpIndexBlock
+	pipeReader *io.PipeReader
+	wg         sync.WaitGroup
+}
+
+func newDumpIndexer[T any](config *ProcessConfig[T]) (*dumpIndexer, errors.E) {
+	if len(config.Sources) > 0 {
+		return nil, errors.New("Index cannot be used together with Sources")
+	}
+	if config.Path == "" {
+		return nil, errors.New("Index requires Path")
+	}
+	if config.Compression != BZIP2 && config.Compression != NoCompression {
+		errE := errors.New("unsupported compression")
+		errors.Details(errE)["compression"] = config.Compression
+		return nil, errE
+	}
+	if config.FileType != JSONArray && config.FileType != NDJSON {
+		errE := errors.New("unsupported file type")
+		errors.Details(errE)["fileType"] = config.FileType
+		return nil, errE
+	}
+
+	_, err := os.Stat(filepath.Join(config.Index, dumpIndexBlocks))
+	if err == nil {
+		errE := errors.New("index already exists")
+		errors.Details(errE)["path"] = config.Index
+		return nil, errE
+	}
+
+	store, errE := OpenStore(config.Index)
+	if errE != nil {
+		return nil, errE
+	}
+	return &dumpIndexer{
+		index:       config.Index,
+		compression: config.Compression,
+		store:       store,
+		writer:      store.NewWriter(),
+		rows:        make(chan dumpIndexRow, config.DecodingThreads),
+		blocks:      []dumpIndexBlock{},
+		pipeReader:  nil,
+		wg:          sync.WaitGroup{},
+	}, nil
+}
+
+// decompress decompresses bzip2 blocks from r, recording their positions.
+// Call stopDecompress when done reading from the returned reader.
+func (i *dumpIndexer) decompress(ctx context.Context, r io.Reader, threads int) io.Reader {
+	pipeReader, pipeWriter := io.Pipe()
+	i.pipeReader = pipeReader
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		var offset int64
+		errE := decompressBZIP2Blocks(ctx, r, threads, func(segment bzip2Segment, data []byte) errors.E {
+			i.blocks = append(i.blocks, dumpIndexBlock{Start: segment.Start, End: segment.End, Offset: offset})
+			offset += int64(len(data))
+			_, err := pipeWriter.Write(data)
+			return errors.WithStack(err)
+		})
+		pipeWriter.CloseWithError(errE)
+	}()
+	return pipeReader
+}
+
+func (i *dumpIndexer) stopDecompress() {
+	// Unblock the decompression if reading rows stopped early.
+	i.pipeReader.Close() //nolint:errcheck,gosec
+	i.wg.Wait()
+}
+
+// add sends the row at the offset (in the decompressed dump) to be recorded.
+func (i *dumpIndexer) add(ctx context.Context, row []byte, offset int64) errors.E {
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case i.rows <- dumpIndexRow{Data: row, Offset: offset}:
+		return nil
+	}
+}
+
+// record records positions of rows until rows channel is closed.
+func (i *dumpIndexer) record(ctx context.Context, wg *sync.WaitGroup, errs chan<- errors.E) {
+	defer wg.Done()
+
+	for {
+		select {
+		case row, ok := <-i.rows:
+			if !ok {
+				return
+			}
+			var entity struct {
+				ID string `json:"id"`
+			}
+			err := json.Unmarshal(row.Data, &entity)
+			if err != nil {
+				errE := errors.Prefix(err, ErrJSONDecode)
+				errors.Details(errE)["offset"] = row.Offset
+				errs <- errE
+				return
+			}
+			position := binary.AppendUvarint(nil, uint64(row.Offset)) //nolint:gosec
+			position = binary.AppendUvarint(position, uint64(len(row.Data)))
+			errE := i.writer.add(storeRecord{ID: entity.ID, Data: position})
+			if errE != nil {
+				errs <- errE
+				return
+			}
+		case <-ctx.Done():
+			errs <- errors.WithStack(ctx.Err())
+			return
+		}
+	}
+}
+
+// finish stores the index after the whole dump has been processed successfully.
+func (i *dumpIndexer) finish(ctx context.Context) errors.E {
+	defer i.store.Close() //nolint:errcheck
+
+	errE := i.writer.Close()
+	if errE != nil {
+		return errE
+	}
+	errE = i.store.Compact(ctx)
+	if errE != nil {
+		return errE
+	}
+	err := os.WriteFile(filepath.Join(i.index, dumpIndexBlocks), encodeDumpIndexBlocks(i.compression, i.blocks), 0o644) //nolint:mnd,gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// abort closes the index without storing it.
+func (i *dumpIndexer) abort() {
+	i.store.Close() //nolint:errcheck,gosec
+}
+
+// IndexDump reads the whole dump and stores position of every entity into the index.
+// It is the same as calling Process with Index set and without processing items,
+// so use Process (or ProcessWikidataDump and ProcessCommonsEntitiesDump) with Index
+// directly to index the dump while processing it.
+//
+// The index can be used for random access to the dump afterwards, as long as
+// the dump file does not change.
+func IndexDump(ctx context.Context, config *IndexDumpConfig) (*DumpIndex, errors.E) {
+	errE := Process(ctx, &ProcessConfig[json.RawMessage]{ //nolint:exhaustruct
+		Path:                   config.Path,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: 1,
+		Process: func(_ context.Context, _ json.RawMessage) errors.E {
+			return nil
+		},
+		Progress:    config.Progress,
+		FileType:    config.FileType,
+		Compression: config.Compression,
+		Index:       config.Index,
+	})
+	if errE != nil {
+		return nil, errE
+	}
+	return OpenDumpIndex(config.Index, config.Path)
+}
+
+// OpenDumpIndex opens the index at directory index for the dump at path.
+func OpenDumpIndex(index, path string) (*DumpIndex, errors.E) {
+	data, err := os.ReadFile(filepath.Join(index, dumpIndexBlocks))
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = index
+		return nil, errE
+	}
+	compression, blocks, errE := decodeDumpIndexBlocks(data)
+	if errE != nil {
+		errors.Details(errE)["path"] = index
+		return nil, errE
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		errE := errors.WithMessage(err, "open")
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	store, errE := OpenStore(index)
+	if errE != nil {
+		file.Close() //nolint:errcheck,gosec
+		return nil, errE
+	}
+	return &DumpIndex{
+		file:   file,
+		store:  store,
+		blocks: blocks,
+		bzip2:  compression == BZIP2,
+	}, nil
+}
+
+// LookupRaw returns the JSON of the entity with the ID as it is in the dump.
+// It returns ErrNotFound if there is no such entity.
+func (d *DumpIndex) LookupRaw(_ context.Context, id string) (json.RawMessage, errors.E) {
+	position, errE := d.store.get(id)
+	if errE != nil {
+		return nil, errE
+	}
+	r := bytes.NewReader(position)
+	offset, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !d.bzip2 {
+		data := make([]byte, length)
+		_, err := d.file.ReadAt(data, int64(offset)) //nolint:gosec
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["id"] = id
+			return nil, errE
+		}
+		return data, nil
+	}
+
+	first := sort.Search(len(d.blocks), func(i int) bool {
+		return d.blocks[i].Offset > int64(offset) //nolint:gosec
+	}) - 1
+	if first < 0 {
+		return nil, errors.WithDetails(ErrInvalidValue, "id", id)
+	}
+	data := []byte{}
+	end := int64(offset + length) //nolint:gosec
+	for i := first; i < len(d.blocks) && d.blocks[i].Offset < end; i++ {
+		block := d.blocks[i]
+		segment := bzip2Segment{Start: block.Start, End: block.End, EOS: false, Data: make([]byte, (block.End+7)/8-block.Start/8)}
+		_, err := d.file.ReadAt(segment.Data, block.Start/8)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["id"] = id
+			return nil, errE
+		}
+		decompressed, errE := decompressBZIP2Block(segment)
+		if errE != nil {
+			errors.Details(errE)["id"] = id
+			return nil, errE
+		}
+		data = append(data, decompressed...)
+	}
+	start := int64(offset) - d.blocks[first].Offset //nolint:gosec
+	if start+int64(length) > int64(len(data)) {     //nolint:gosec
+		return nil, errors.WithDetails(ErrInvalidValue, "id", id)
+	}
+	return data[start : start+int64(length)], nil //nolint:gosec
+}
+
+// Lookup returns the entity with the ID. It returns ErrNotFound if there is no such entity.
+//
+// Its signature matches the lookup of DiffDumpWithLookup.
+func (d *DumpIndex) Lookup(ctx context.Context, id string) (Entity, errors.E) {
+	data, errE := d.LookupRaw(ctx, id)
+	if errE != nil {
+		return Entity{}, errE
+	}
+	return decodeStoreRecord(storeRecord{ID: id, Data: data})
+}
+
+// Close closes the index and the dump file.
+func (d *DumpIndex) Close() errors.E {
+	return errors.Join(d.store.Close(), d.file.Close())
+}
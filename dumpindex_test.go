@@ -0,0 +1,195 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestIndexDump(t *testing.T) {
+	t.Parallel()
+
+	// The dump consists of two concatenated bzip2 streams with multiple blocks each.
+	path := filepath.Join("testdata", "wikidata-testdata-index.json.bz2")
+	compressed, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		compressed.Close() //nolint:errcheck,gosec
+	})
+	decompressed, err := io.ReadAll(bzip2.NewReader(compressed))
+	require.NoError(t, err)
+	var rows []json.RawMessage
+	err = json.Unmarshal(decompressed, &rows)
+	require.NoError(t, err)
+	require.Len(t, rows, 2550)
+	// Streams were split in the middle of a row.
+	split := len(decompressed)/2 + 7
+
+	ndjsonPath := filepath.Join(t.TempDir(), "dump.json")
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		lines = append(lines, string(row))
+	}
+	err = os.WriteFile(ndjsonPath, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	require.NoError(t, err)
+
+	for _, config := range []mediawiki.IndexDumpConfig{
+		{Path: path, FileType: mediawiki.JSONArray, Compression: mediawiki.BZIP2, DecompressionThreads: 3},
+		{Path: ndjsonPath, FileType: mediawiki.NDJSON, Compression: mediawiki.NoCompression},
+	} {
+		t.Run(filepath.Base(config.Path), func(t *testing.T) {
+			t.Parallel()
+
+			config.Index = filepath.Join(t.TempDir(), "index")
+			index, errE := mediawiki.IndexDump(context.Background(), &config)
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			var wg sync.WaitGroup
+			for i, row := range rows {
+				offset := bytes.Index(decompressed, row)
+				spansStreams := offset < split && split < offset+len(row)
+				if i%25 != 0 && i != len(rows)-1 && !spansStreams {
+					continue
+				}
+				var entity struct {
+					ID string `json:"id"`
+				}
+				require.NoError(t, json.Unmarshal(row, &entity))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					data, errE := index.LookupRaw(context.Background(), entity.ID)
+					if assert.NoError(t, errE, "% -+#.1v", errE) {
+						assert.Equal(t, string(row), string(data))
+					}
+				}()
+			}
+			wg.Wait()
+
+			errE = index.Close()
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			_, errE = mediawiki.IndexDump(context.Background(), &config)
+			assert.EqualError(t, errE, "index already exists")
+
+			index, errE = mediawiki.OpenDumpIndex(config.Index, config.Path)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			t.Cleanup(func() {
+				errE := index.Close()
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			})
+
+			entity, errE := index.Lookup(context.Background(), "P31")
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, "P31", entity.ID)
+			if assert.NotNil(t, entity.DataType) {
+				assert.Equal(t, mediawiki.String, *entity.DataType)
+			}
+			_, errE = index.Lookup(context.Background(), "Q999999")
+			assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+		})
+	}
+
+	_, errE := mediawiki.IndexDump(context.Background(), &mediawiki.IndexDumpConfig{
+		Path:        path,
+		Index:       filepath.Join(t.TempDir(), "index"),
+		Compression: mediawiki.GZIP,
+	})
+	assert.EqualError(t, errE, "unsupported compression")
+
+	// Corrupted data fails.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	corruptedPath := filepath.Join(t.TempDir(), "dump.json.bz2")
+	err = os.WriteFile(corruptedPath, []byte(strings.Replace(string(data), string(data[1000:1010]), "0123456789", 1)), 0o600)
+	require.NoError(t, err)
+	_, errE = mediawiki.IndexDump(context.Background(), &mediawiki.IndexDumpConfig{
+		Path:        corruptedPath,
+		Index:       filepath.Join(t.TempDir(), "index"),
+		FileType:    mediawiki.JSONArray,
+		Compression: mediawiki.BZIP2,
+	})
+	assert.Error(t, errE)
+}
+
+func TestProcessIndex(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "wikidata-testdata-index.json.bz2"))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(ts.Close)
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.json.bz2")
+	indexPath := filepath.Join(dir, "index")
+
+	// The dump is indexed while it is downloaded, saved, and processed.
+	var count int64
+	errE := mediawiki.ProcessWikidataDump(context.Background(), &mediawiki.ProcessDumpConfig{
+		URL:    ts.URL,
+		Path:   path,
+		Client: client,
+		Index:  indexPath,
+	}, func(_ context.Context, _ mediawiki.Entity) errors.E {
+		atomic.AddInt64(&count, 1)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(2550), count)
+
+	index, errE := mediawiki.OpenDumpIndex(indexPath, path)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	t.Cleanup(func() {
+		errE := index.Close()
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	})
+	entity, errE := index.Lookup(context.Background(), "P31")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "P31", entity.ID)
+
+	// Index is not stored if processing fails.
+	failedIndexPath := filepath.Join(dir, "failed")
+	errE = mediawiki.ProcessWikidataDump(context.Background(), &mediawiki.ProcessDumpConfig{
+		Path:  path,
+		Index: failedIndexPath,
+	}, func(_ context.Context, _ mediawiki.Entity) errors.E {
+		return errors.New("test")
+	})
+	assert.EqualError(t, errE, "test")
+	_, errE = mediawiki.OpenDumpIndex(failedIndexPath, path)
+	assert.Error(t, errE)
+
+	errE = mediawiki.ProcessWikidataDump(context.Background(), &mediawiki.ProcessDumpConfig{
+		URL:    ts.URL,
+		Client: client,
+		Index:  filepath.Join(dir, "other"),
+	}, func(_ context.Context, _ mediawiki.Entity) errors.E {
+		return nil
+	})
+	assert.EqualError(t, errE, "Index requires Path")
+}
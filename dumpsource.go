@@ -0,0 +1,133 @@
+package mediawiki
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+)
+
+// DumpSource configures from where dumps are discovered and downloaded.
+//
+// All base URLs are expected to have the same layout as
+// https://dumps.wikimedia.org/ so that the same paths can be used on all of them.
+// This is true for official mirrors (e.g., https://dumps.wikimedia.your.org/).
+//
+// Discovery (Latest*Run functions, ListRuns, RunForDate, LatestCompleteRun) fails
+// over to mirrors as described for Mirrors and Timeout. To fail over also when
+// downloading dump files, pass MirrorURLs of a file's URL as Mirrors in ProcessConfig
+// or ProcessDumpConfig (DumpJob.Sources does that for files of a dump run).
+// Downloads fail over only when they cannot be started (e.g., the file is missing);
+// Timeout does not apply to them and a download which is slow or stalls once
+// it has started is not switched to a mirror.
+//
+// Zero value (and nil) uses https://dumps.wikimedia.org/ without mirrors.
+type DumpSource struct {
+	// Base URL of dumps. Default is https://dumps.wikimedia.org/.
+	BaseURL string
+
+	// Mirrors are base URLs which are tried in order when a discovery request to
+	// the base URL (or the previous mirror) fails, the file is missing,
+	// or it does not complete in Timeout.
+	Mirrors []string
+
+	// Timeout for each discovery request to a base URL or a mirror after
+	// which the next mirror is tried. Default is no timeout.
+	// It does not apply to downloads of dump files.
+	Timeout time.Duration
+}
+
+// baseURLs returns the base URL and mirrors in order.
+func (s *DumpSource) baseURLs() []string {
+	if s == nil {
+		return []string{dumpsURL}
+	}
+	baseURL := s.BaseURL
+	if baseURL == "" {
+		baseURL = dumpsURL
+	}
+	return append([]string{baseURL}, s.Mirrors...)
+}
+
+// MirrorURLs returns URLs of the same file on mirrors, in order, for the URL
+// of a file on the base URL or one of the mirrors. The URL itself is not included.
+// It returns nil if the URL is not under any of them.
+func (s *DumpSource) MirrorURLs(u string) []string {
+	baseURLs := s.baseURLs()
+	for i, baseURL := range baseURLs {
+		prefix := strings.TrimSuffix(baseURL, "/") + "/"
+		if !strings.HasPrefix(u, prefix) {
+			continue
+		}
+		path := strings.TrimPrefix(u, prefix)
+		var urls []string
+		for j, other := range baseURLs {
+			if j != i {
+				urls = append(urls, joinURL(other, path))
+			}
+		}
+		return urls
+	}
+	return nil
+}
+
+// failover calls fn with base URLs in order until fn succeeds.
+// If fn fails for all of them, the error for the first one is returned.
+func (s *DumpSource) failover(ctx context.Context, fn func(ctx context.Context, baseURL string) errors.E) errors.E {
+	var firstErrE errors.E
+	for _, baseURL := range s.baseURLs() {
+		errE := s.try(ctx, baseURL, fn)
+		if errE == nil {
+			return nil
+		}
+		if firstErrE == nil {
+			firstErrE = errE
+		}
+		if ctx.Err() != nil {
+			// The context is canceled, not just the request has timed out.
+			break
+		}
+	}
+	return firstErrE
+}
+
+func (s *DumpSource) try(ctx context.Context, baseURL string, fn func(ctx context.Context, baseURL string) errors.E) errors.E {
+	if s != nil && s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return fn(ctx, baseURL)
+}
+
+// joinURL returns the URL of path (relative to the base URL).
+func joinURL(baseURL, path string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// head checks that the URL exists. It returns ErrNotFound if it does not.
+func head(ctx context.Context, client *retryablehttp.Client, u string) errors.E {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = u
+		return errE
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "head")
+		errors.Details(errE)["url"] = u
+		return errE
+	}
+	resp.Body.Close() //nolint:errcheck,gosec
+
+	if resp.StatusCode != http.StatusOK {
+		errE := errors.WithDetails(ErrNotFound, "url", u)
+		errors.Details(errE)["status"] = resp.Status
+		return errE
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package mediawiki_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func newMirror(t *testing.T, delay time.Duration, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		content, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestDumpSource(t *testing.T) {
+	t.Parallel()
+
+	listing := `<html><body><a href="../">../</a><a href="20240101/">20240101/</a><a href="20240108/">20240108/</a></body></html>`
+
+	// Primary does not yet have the latest file.
+	primary := newMirror(t, 0, map[string]string{
+		"/wikidatawiki/entities/": listing,
+		"/wikidatawiki/entities/20240101/wikidata-20240101-all.json.bz2": "data",
+		"/enwiki/": listing,
+	})
+	mirror := newMirror(t, 0, map[string]string{
+		"/wikidatawiki/entities/": listing,
+		"/wikidatawiki/entities/20240101/wikidata-20240101-all.json.bz2": "data",
+		"/wikidatawiki/entities/20240108/wikidata-20240108-all.json.bz2": "data",
+		"/enwiki/20240108/dumpstatus.json": `{"jobs": {"articlesdump": {"status": "done", "files": {
+			"enwiki-20240108-pages-articles.xml.bz2": {"size": 100}
+		}}}, "version": "0.8"}`,
+	})
+	slow := newMirror(t, time.Minute, map[string]string{})
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 0
+	ctx := context.Background()
+
+	url, errE := mediawiki.LatestWikidataEntitiesRun(ctx, client, &mediawiki.DumpSource{BaseURL: primary.URL})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, primary.URL+"/wikidatawiki/entities/20240101/wikidata-20240101-all.json.bz2", url)
+
+	source := &mediawiki.DumpSource{
+		BaseURL: slow.URL,
+		Mirrors: []string{primary.URL + "/", mirror.URL},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	url, errE = mediawiki.LatestWikidataEntitiesRun(ctx, client, source)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, mirror.URL+"/wikidatawiki/entities/20240108/wikidata-20240108-all.json.bz2", url)
+
+	dates, errE := mediawiki.ListRuns(ctx, client, source, "enwiki")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"20240101", "20240108"}, dates)
+
+	run, errE := mediawiki.LatestCompleteRun(ctx, client, source, "enwiki", "articlesdump")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "20240108", run.Date)
+	assert.Equal(t, mirror.URL+"/enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2", run.Jobs["articlesdump"].Files[0].URL)
+
+	// When all fail, the error for the base URL is returned.
+	_, errE = mediawiki.RunForDate(ctx, client, &mediawiki.DumpSource{BaseURL: primary.URL, Mirrors: []string{mirror.URL}}, "enwiki", "20240101")
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+	assert.Equal(t, primary.URL+"/enwiki/20240101/dumpstatus.json", errors.AllDetails(errE)["url"])
+}
+
+func TestDumpSourcePathPrefix(t *testing.T) {
+	t.Parallel()
+
+	data := "{\"id\":1}\n{\"id\":2}\n"
+
+	primary := newMirror(t, 0, map[string]string{
+		"/enwiki/20240108/dumpstatus.json": `{"jobs": {"articlesdump": {"status": "done", "files": {
+			"enwiki-20240108-pages-articles.xml.bz2": {"size": 100, "url": "/enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2"}
+		}}}, "version": "0.8"}`,
+	})
+	// Mirror hosts dumps under a path prefix and has the file which is missing on the primary.
+	mirror := newMirror(t, 0, map[string]string{
+		"/mirror/wikimedia.org/dumps/enwiki/20240108/dumpstatus.json": `{"jobs": {"articlesdump": {"status": "done", "files": {
+			"enwiki-20240108-pages-articles.xml.bz2": {"size": 100, "url": "/enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2"}
+		}}}, "version": "0.8"}`,
+		"/mirror/wikimedia.org/dumps/enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2": data,
+	})
+	mirrorURL := mirror.URL + "/mirror/wikimedia.org/dumps/"
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 0
+	ctx := context.Background()
+
+	run, errE := mediawiki.RunForDate(ctx, client, &mediawiki.DumpSource{BaseURL: mirrorURL}, "enwiki", "20240108")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, mirrorURL+"enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2", run.Jobs["articlesdump"].Files[0].URL)
+	assert.Nil(t, run.Jobs["articlesdump"].Files[0].Mirrors)
+
+	source := &mediawiki.DumpSource{BaseURL: primary.URL, Mirrors: []string{mirrorURL}}
+	run, errE = mediawiki.RunForDate(ctx, client, source, "enwiki", "20240108")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	file := run.Jobs["articlesdump"].Files[0]
+	assert.Equal(t, primary.URL+"/enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2", file.URL)
+	assert.Equal(t, []string{mirrorURL + "enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2"}, file.Mirrors)
+
+	assert.Equal(t, []string{primary.URL + "/enwiki/20240108/enwiki-20240108-pages-articles.xml.bz2"}, source.MirrorURLs(file.Mirrors[0]))
+	assert.Nil(t, source.MirrorURLs("https://example.com/enwiki/"))
+
+	// The file is missing on the primary, so it is downloaded from the mirror.
+	sources := run.Jobs["articlesdump"].Sources("")
+	var count int64
+	errE = mediawiki.Process(ctx, &mediawiki.ProcessConfig[json.RawMessage]{
+		Client:  client,
+		Sources: sources,
+		Process: func(_ context.Context, _ json.RawMessage) errors.E {
+			atomic.AddInt64(&count, 1)
+			return nil
+		},
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(2), count)
+
+	// When all fail, the error for the URL is returned.
+	errE = mediawiki.Process(ctx, &mediawiki.ProcessConfig[json.RawMessage]{
+		URL:     primary.URL + "/enwiki/20240108/missing.json",
+		Mirrors: []string{mirrorURL + "enwiki/20240108/missing.json"},
+		Client:  client,
+		Process: func(_ context.Context, _ json.RawMessage) errors.E {
+			return nil
+		},
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.NoCompression,
+	})
+	require.Error(t, errE)
+	assert.Equal(t, primary.URL+"/enwiki/20240108/missing.json", errors.AllDetails(errE)["url"])
+}
@@ -0,0 +1,412 @@
+package mediawiki
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const dumpsURL = "https://dumps.wikimedia.org/"
+
+var pageRangeRegex = regexp.MustCompile(`-p(\d+)p(\d+)\.`)
+
+// DumpStatus is a status of a dump job.
+type DumpStatus string
+
+// Statuses of dump jobs as used in dumpstatus.json.
+const (
+	DumpStatusDone       DumpStatus = "done"
+	DumpStatusInProgress DumpStatus = "in-progress"
+	DumpStatusWaiting    DumpStatus = "waiting"
+	DumpStatusFailed     DumpStatus = "failed"
+	DumpStatusSkipped    DumpStatus = "skipped"
+)
+
+// DumpFile is a file produced by a dump job.
+//
+// Files of large wikis are split into parts by page ID ranges, e.g.,
+// enwiki-20240101-pages-articles1.xml-p1p41242.bz2 contains pages with
+// IDs from 1 to 41242. FirstPageID and LastPageID are then set to the
+// range, otherwise they are zero.
+//
+// MD5 and SHA1 checksums are in hex and are empty when not (yet) known.
+//
+// Mirrors are URLs of the file on mirrors of the DumpSource, see DumpSource.MirrorURLs.
+type DumpFile struct {
+	Name        string
+	URL         string
+	Mirrors     []string
+	Size        int64
+	MD5         string
+	SHA1        string
+	FirstPageID int64
+	LastPageID  int64
+}
+
+// DumpJob is a dump job of a dump run, e.g., "articlesdump" which
+// produces pages-articles XML dump files.
+//
+// Files are sorted by their page ranges and names.
+type DumpJob struct {
+	Name    string
+	Status  DumpStatus
+	Updated time.Time
+	Files   []DumpFile
+}
+
+// Sources returns job's files as sources to process them as one dump, see
+// ProcessConfig. If dir is not empty, each file is cached in the directory
+// under its name.
+func (j DumpJob) Sources(dir string) []Source {
+	sources := make([]Source, 0, len(j.Files))
+	for _, file := range j.Files {
+		path := ""
+		if dir != "" {
+			path = filepath.Join(dir, file.Name)
+		}
+		sources = append(sources, Source{
+			URL:      file.URL,
+			Path:     path,
+			Checksum: file.Checksum(),
+			Mirrors:  file.Mirrors,
+		})
+	}
+	return sources
+}
+
+// DumpRun is a dump run of a wiki at a date, as described by its dumpstatus.json.
+type DumpRun struct {
+	Wiki    string
+	Date    string
+	URL     string
+	Version string
+	Jobs    map[string]DumpJob
+}
+
+// Status returns the overall status of the dump run: failed if any job failed,
+// done if all jobs are done (or skipped), waiting if no job has started yet,
+// and in progress otherwise.
+func (r *DumpRun) Status() DumpStatus {
+	done := 0
+	waiting := 0
+	for _, job := range r.Jobs {
+		switch job.Status { //nolint:exhaustive
+		case DumpStatusFailed:
+			return DumpStatusFailed
+		case DumpStatusDone, DumpStatusSkipped:
+			done++
+		case DumpStatusWaiting:
+			waiting++
+		}
+	}
+	switch {
+	case done == len(r.Jobs):
+		return DumpStatusDone
+	case waiting == len(r.Jobs):
+		return DumpStatusWaiting
+	default:
+		return DumpStatusInProgress
+	}
+}
+
+type dumpStatusFile struct {
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+	MD5  string `json:"md5"`
+	SHA1 string `json:"sha1"`
+}
+
+type dumpStatusJob struct {
+	Status  DumpStatus                `json:"status"`
+	Updated string                    `json:"updated"`
+	Files   map[string]dumpStatusFile `json:"files"`
+}
+
+type dumpStatus struct {
+	Jobs    map[string]dumpStatusJob `json:"jobs"`
+	Version string                   `json:"version"`
+}
+
+// get fetches the URL. It returns ErrNotFound if the URL does not exist.
+func get(ctx context.Context, client *retryablehttp.Client, u string) ([]byte, errors.E) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = u
+		return nil, errE
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = u
+		return nil, errE
+	}
+	defer resp.Body.Close()              //nolint:errcheck
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.WithDetails(ErrNotFound, "url", u)
+	}
+	if resp.StatusCode != http.StatusOK {
+		errE := errors.New("bad response status")
+		errors.Details(errE)["url"] = u
+		errors.Details(errE)["status"] = resp.Status
+		return nil, errE
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["url"] = u
+		return nil, errE
+	}
+	return data, nil
+}
+
+// parsePageRange returns the page ID range from the file name, if it has it.
+func parsePageRange(name string) (int64, int64) {
+	match := pageRangeRegex.FindStringSubmatch(name)
+	if match == nil {
+		return 0, 0
+	}
+	first, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	last, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+	return first, last
+}
+
+func fetchDumpRun(
+	ctx context.Context, client *retryablehttp.Client, source *DumpSource, baseURL, wiki, date string,
+) (*DumpRun, errors.E) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		errE := errors.WithMessage(err, "parse url")
+		errors.Details(errE)["url"] = baseURL
+		return nil, errE
+	}
+	runURL := base.JoinPath(wiki, date).String() + "/"
+	statusURL := runURL + "dumpstatus.json"
+
+	data, errE := get(ctx, client, statusURL)
+	if errE != nil {
+		return nil, errE
+	}
+	var status dumpStatus
+	errE = x.Unmarshal(data, &status)
+	if errE != nil {
+		errE = errors.Prefix(errE, ErrJSONDecode)
+		errors.Details(errE)["url"] = statusURL
+		return nil, errE
+	}
+
+	run := &DumpRun{
+		Wiki:    wiki,
+		Date:    date,
+		URL:     runURL,
+		Version: status.Version,
+		Jobs:    make(map[string]DumpJob, len(status.Jobs)),
+	}
+	for name, job := range status.Jobs {
+		var updated time.Time
+		if job.Updated != "" {
+			updated, err = time.Parse(time.DateTime, job.Updated)
+			if err != nil {
+				errE := errors.WithMessage(err, "parse updated")
+				errors.Details(errE)["url"] = statusURL
+				errors.Details(errE)["job"] = name
+				return nil, errE
+			}
+		}
+		files := make([]DumpFile, 0, len(job.Files))
+		for fileName, file := range job.Files {
+			fileURL := runURL + fileName
+			if file.URL != "" {
+				u, err := url.Parse(file.URL)
+				if err != nil {
+					errE := errors.WithMessage(err, "parse file url")
+					errors.Details(errE)["url"] = statusURL
+					errors.Details(errE)["file"] = fileName
+					return nil, errE
+				}
+				if u.IsAbs() {
+					fileURL = u.String()
+				} else {
+					// URLs in dumpstatus.json are paths relative to the root of dumps
+					// (e.g., "/enwiki/20240101/..."), so we join them with the base URL
+					// to keep its path (e.g., for mirrors which host dumps under a prefix).
+					fileURL = joinURL(baseURL, u.Path)
+				}
+			}
+			first, last := parsePageRange(fileName)
+			files = append(files, DumpFile{
+				Name:        fileName,
+				URL:         fileURL,
+				Mirrors:     source.MirrorURLs(fileURL),
+				Size:        file.Size,
+				MD5:         strings.ToLower(file.MD5),
+				SHA1:        strings.ToLower(file.SHA1),
+				FirstPageID: first,
+				LastPageID:  last,
+			})
+		}
+		slices.SortFunc(files, func(a, b DumpFile) int {
+			return cmp.Or(cmp.Compare(a.FirstPageID, b.FirstPageID), strings.Compare(a.Name, b.Name))
+		})
+		run.Jobs[name] = DumpJob{
+			Name:    name,
+			Status:  job.Status,
+			Updated: updated,
+			Files:   files,
+		}
+	}
+
+	return run, nil
+}
+
+// parseChecksums parses output of md5sum and sha1sum tools into a map
+// between file names and their (lower case) checksums.
+func parseChecksums(data []byte) map[string]string {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		checksum, name, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		// Names can be prefixed with "*" for binary mode.
+		checksums[strings.TrimPrefix(strings.TrimSpace(name), "*")] = strings.ToLower(checksum)
+	}
+	return checksums
+}
+
+// addChecksums fills missing checksums of files from *-md5sums.txt and *-sha1sums.txt
+// files of the run. Missing checksum files are ignored.
+func addChecksums(ctx context.Context, client *retryablehttp.Client, run *DumpRun) errors.E {
+	for _, algorithm := range []string{"md5", "sha1"} {
+		checksumsURL := fmt.Sprintf("%s%s-%s-%ssums.txt", run.URL, run.Wiki, run.Date, algorithm)
+		data, errE := get(ctx, client, checksumsURL)
+		if errors.Is(errE, ErrNotFound) {
+			continue
+		} else if errE != nil {
+			return errE
+		}
+		checksums := parseChecksums(data)
+		for jobName, job := range run.Jobs {
+			for i, file := range job.Files {
+				checksum, ok := checksums[file.Name]
+				if !ok {
+					continue
+				}
+				if algorithm == "md5" && file.MD5 == "" {
+					job.Files[i].MD5 = checksum
+				} else if algorithm == "sha1" && file.SHA1 == "" {
+					job.Files[i].SHA1 = checksum
+				}
+			}
+			run.Jobs[jobName] = job
+		}
+	}
+	return nil
+}
+
+// ListRuns returns dates (in YYYYMMDD format) of all dump runs of the wiki
+// (e.g., "enwiki"), sorted from the oldest to the newest. Runs can be
+// unfinished or failed. Source can be nil to use https://dumps.wikimedia.org/.
+func ListRuns(ctx context.Context, client *retryablehttp.Client, source *DumpSource, wiki string) ([]string, errors.E) {
+	var dates []string
+	errE := source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+		var errE errors.E
+		dates, errE = runDates(ctx, client, joinURL(baseURL, wiki+"/"))
+		return errE
+	})
+	if errE != nil {
+		return nil, errE
+	}
+	slices.Sort(dates)
+	return slices.Compact(dates), nil
+}
+
+// RunForDate returns the dump run of the wiki (e.g., "enwiki") at the date
+// (in YYYYMMDD format), with its jobs and their files with checksums.
+// Source can be nil to use https://dumps.wikimedia.org/.
+//
+// It returns ErrNotFound if the run does not (yet) exist.
+func RunForDate(ctx context.Context, client *retryablehttp.Client, source *DumpSource, wiki, date string) (*DumpRun, errors.E) {
+	var run *DumpRun
+	errE := source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+		var errE errors.E
+		run, errE = fetchDumpRun(ctx, client, source, baseURL, wiki, date)
+		if errE != nil {
+			return errE
+		}
+		return addChecksums(ctx, client, run)
+	})
+	if errE != nil {
+		return nil, errE
+	}
+	return run, nil
+}
+
+// LatestCompleteRun returns the latest dump run of the wiki (e.g., "enwiki")
+// in which the job (e.g., "articlesdump" or "metacurrentdump") is done.
+// Other jobs of the run might still be in progress.
+// Source can be nil to use https://dumps.wikimedia.org/.
+//
+// It returns ErrNotFound if there is no such run.
+func LatestCompleteRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource, wiki, job string) (*DumpRun, errors.E) {
+	dates, errE := ListRuns(ctx, client, source, wiki)
+	if errE != nil {
+		return nil, errE
+	}
+
+	// We start with the last run.
+	for i := len(dates) - 1; i >= 0; i-- {
+		var run *DumpRun
+		errE := source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+			var errE errors.E
+			run, errE = fetchDumpRun(ctx, client, source, baseURL, wiki, dates[i])
+			if errE != nil {
+				return errE
+			}
+			if run.Jobs[job].Status != DumpStatusDone {
+				// We do not fetch checksums for runs we skip.
+				return nil
+			}
+			return addChecksums(ctx, client, run)
+		})
+		if errors.Is(errE, ErrNotFound) {
+			// Run has not started yet.
+			continue
+		} else if errE != nil {
+			return nil, errE
+		}
+		if run.Jobs[job].Status != DumpStatusDone {
+			continue
+		}
+		return run, nil
+	}
+
+	errE = errors.WithDetails(ErrNotFound, "wiki", wiki)
+	errors.Details(errE)["job"] = job
+	return nil, errE
+}
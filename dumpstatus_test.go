@@ -0,0 +1,141 @@
+package mediawiki
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+)
+
+func newDumpsServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		content, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestDumpRuns(t *testing.T) {
+	t.Parallel()
+
+	ts := newDumpsServer(t, map[string]string{
+		"/enwiki/": `<html><body><a href="../">../</a><a href="20240101/">20240101/</a>` +
+			`<a href="20240120/">20240120/</a><a href="20240201/">20240201/</a><a href="latest/">latest/</a></body></html>`,
+		"/enwiki/20240101/dumpstatus.json": `{"jobs": {
+			"articlesdump": {"status": "done", "updated": "2024-01-02 10:11:12", "files": {
+				"enwiki-20240101-pages-articles2.xml-p41243p151573.bz2": {"size": 200, "url": "/enwiki/20240101/enwiki-20240101-pages-articles2.xml-p41243p151573.bz2", "md5": "BB", "sha1": "22"},
+				"enwiki-20240101-pages-articles1.xml-p1p41242.bz2": {"size": 100, "url": "/enwiki/20240101/enwiki-20240101-pages-articles1.xml-p1p41242.bz2"}
+			}},
+			"sitestatstable": {"status": "done", "updated": "2024-01-01 01:00:00", "files": {
+				"enwiki-20240101-site_stats.sql.gz": {"size": 10, "url": "/enwiki/20240101/enwiki-20240101-site_stats.sql.gz", "md5": "cc", "sha1": "33"}
+			}}
+		}, "version": "0.8"}`,
+		"/enwiki/20240101/enwiki-20240101-md5sums.txt": "aa  enwiki-20240101-pages-articles1.xml-p1p41242.bz2\n" +
+			"ff  enwiki-20240101-pages-articles2.xml-p41243p151573.bz2\n",
+		"/enwiki/20240120/dumpstatus.json": `{"jobs": {
+			"articlesdump": {"status": "in-progress", "updated": "2024-01-21 00:00:00", "files": {
+				"enwiki-20240120-pages-articles1.xml-p1p41242.bz2": {}
+			}},
+			"sitestatstable": {"status": "done", "updated": "2024-01-20 01:00:00", "files": {}}
+		}, "version": "0.8"}`,
+	})
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	ctx := context.Background()
+	source := &DumpSource{BaseURL: ts.URL}
+
+	dates, errE := ListRuns(ctx, client, source, "enwiki")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"20240101", "20240120", "20240201"}, dates)
+
+	run, errE := RunForDate(ctx, client, source, "enwiki", "20240120")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, DumpStatusInProgress, run.Status())
+	assert.Equal(t, ts.URL+"/enwiki/20240120/enwiki-20240120-pages-articles1.xml-p1p41242.bz2", run.Jobs["articlesdump"].Files[0].URL)
+
+	_, errE = RunForDate(ctx, client, source, "enwiki", "20240201")
+	assert.ErrorIs(t, errE, ErrNotFound)
+
+	run, errE = LatestCompleteRun(ctx, client, source, "enwiki", "sitestatstable")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "20240120", run.Date)
+
+	run, errE = LatestCompleteRun(ctx, client, source, "enwiki", "articlesdump")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, &DumpRun{
+		Wiki:    "enwiki",
+		Date:    "20240101",
+		URL:     ts.URL + "/enwiki/20240101/",
+		Version: "0.8",
+		Jobs: map[string]DumpJob{
+			"articlesdump": {
+				Name:    "articlesdump",
+				Status:  DumpStatusDone,
+				Updated: time.Date(2024, 1, 2, 10, 11, 12, 0, time.UTC),
+				Files: []DumpFile{
+					{
+						Name:        "enwiki-20240101-pages-articles1.xml-p1p41242.bz2",
+						URL:         ts.URL + "/enwiki/20240101/enwiki-20240101-pages-articles1.xml-p1p41242.bz2",
+						Size:        100,
+						MD5:         "aa",
+						FirstPageID: 1,
+						LastPageID:  41242,
+					},
+					{
+						Name: "enwiki-20240101-pages-articles2.xml-p41243p151573.bz2",
+						URL:  ts.URL + "/enwiki/20240101/enwiki-20240101-pages-articles2.xml-p41243p151573.bz2",
+						Size: 200,
+						// Checksums from dumpstatus.json take precedence.
+						MD5:         "bb",
+						SHA1:        "22",
+						FirstPageID: 41243,
+						LastPageID:  151573,
+					},
+				},
+			},
+			"sitestatstable": {
+				Name:    "sitestatstable",
+				Status:  DumpStatusDone,
+				Updated: time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+				Files: []DumpFile{
+					{
+						Name: "enwiki-20240101-site_stats.sql.gz",
+						URL:  ts.URL + "/enwiki/20240101/enwiki-20240101-site_stats.sql.gz",
+						Size: 10,
+						MD5:  "cc",
+						SHA1: "33",
+					},
+				},
+			},
+		},
+	}, run)
+	assert.Equal(t, DumpStatusDone, run.Status())
+
+	assert.Equal(t, []Source{
+		{
+			URL:      ts.URL + "/enwiki/20240101/enwiki-20240101-site_stats.sql.gz",
+			Path:     filepath.Join("dumps", "enwiki-20240101-site_stats.sql.gz"),
+			Checksum: Checksum{MD5: "cc", SHA1: "33"},
+		},
+	}, run.Jobs["sitestatstable"].Sources("dumps"))
+	assert.Equal(t, "", run.Jobs["articlesdump"].Sources("")[1].Path)
+
+	_, errE = LatestCompleteRun(ctx, client, source, "enwiki", "metahistorybz2dump")
+	assert.ErrorIs(t, errE, ErrNotFound)
+	assert.Equal(t, "metahistorybz2dump", errors.Details(errE)["job"])
+}
@@ -0,0 +1,238 @@
+package mediawiki
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const defaultTarFileSize = 64 << 20
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// DumpWriter writes items into a dump at Writer which can be read back by Process
+// using the same FileType and Compression. It is the reverse of Process.
+//
+// Supported file types are JSONArray and NDJSON. JSONArray is written in the
+// same layout as Wikidata entities JSON dumps: opening bracket on its own line,
+// then one item per line with a comma at the end of all but the last item,
+// and a closing bracket on its own line. Items are marshaled to JSON
+// without escaping HTML characters and json.RawMessage items are compacted.
+//
+// All compressions supported by Process are supported. Compression is done in
+// parallel using CompressionThreads goroutines (default 1). With tar compressions,
+// items are split into multiple files inside the tar, as in Wikimedia Enterprise
+// HTML dumps. Each file contains approximately TarFileSize bytes (default 64 MiB)
+// and is buffered in memory before it is written out. TarFileName is formatted
+// with the index of the file to obtain its name (default "dump_%d.ndjson" or
+// "dump_%d.json" for JSONArray).
+//
+// Write's signature matches the callback of Process and Process*Dump functions
+// so it can be passed to them directly, e.g., to write a filtered subset of a dump.
+// Call Close when done. It does not close Writer.
+// It is safe to use concurrently.
+type DumpWriter[T any] struct {
+	Writer             io.Writer
+	FileType           FileType
+	Compression        Compression
+	CompressionThreads int
+	TarFileName        string
+	TarFileSize        int
+
+	mu          sync.Mutex
+	initialized bool
+	compressor  io.WriteCloser
+	tar         *tar.Writer
+	// out is where the current file is written to.
+	out io.Writer
+	// buffer buffers the current file inside tar.
+	buffer bytes.Buffer
+	files  int
+	items  int
+	err    errors.E
+}
+
+func (w *DumpWriter[T]) init() errors.E {
+	if w.initialized {
+		return w.err
+	}
+	w.initialized = true
+
+	if w.FileType != JSONArray && w.FileType != NDJSON {
+		errE := errors.New("unsupported file type")
+		errors.Details(errE)["fileType"] = w.FileType
+		w.err = errE
+		return w.err
+	}
+
+	threads := max(w.CompressionThreads, 1)
+	switch w.Compression {
+	case NoCompression, Tar:
+		w.compressor = nopWriteCloser{w.Writer}
+	case BZIP2, BZIP2Tar:
+		w.compressor = newBZIP2Writer(w.Writer, threads)
+	case GZIP, GZIPTar:
+		gzipWriter := gzip.NewWriter(w.Writer)
+		err := gzipWriter.SetConcurrency(1<<20, threads) //nolint:mnd
+		if err != nil {
+			w.err = errors.WithMessage(err, "gzip set concurrency")
+			return w.err
+		}
+		w.compressor = gzipWriter
+	case ZSTD, ZSTDTar:
+		zstdWriter, err := zstd.NewWriter(w.Writer, zstd.WithEncoderConcurrency(threads))
+		if err != nil {
+			w.err = errors.WithMessage(err, "new zstd writer")
+			return w.err
+		}
+		w.compressor = zstdWriter
+	default:
+		errE := errors.New("unsupported compression")
+		errors.Details(errE)["compression"] = w.Compression
+		w.err = errE
+		return w.err
+	}
+
+	if w.Compression == Tar || w.Compression == GZIPTar || w.Compression == BZIP2Tar || w.Compression == ZSTDTar {
+		w.tar = tar.NewWriter(w.compressor)
+		w.out = &w.buffer
+	} else {
+		w.out = w.compressor
+	}
+	return nil
+}
+
+// write writes to the current file, recording any error.
+func (w *DumpWriter[T]) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	_, err := w.out.Write(data)
+	if err != nil {
+		w.err = errors.WithStack(err)
+	}
+}
+
+// endFile finishes the current file and, inside tar, writes it out.
+func (w *DumpWriter[T]) endFile() {
+	if w.FileType == JSONArray {
+		if w.items == 0 {
+			w.write([]byte("[\n]\n"))
+		} else {
+			w.write([]byte("\n]\n"))
+		}
+	}
+	if w.tar == nil || w.err != nil {
+		return
+	}
+
+	name := w.TarFileName
+	if name == "" {
+		name = "dump_%d.ndjson"
+		if w.FileType == JSONArray {
+			name = "dump_%d.json"
+		}
+	}
+	err := w.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     fmt.Sprintf(name, w.files),
+		Mode:     0o644, //nolint:mnd
+		Size:     int64(w.buffer.Len()),
+	})
+	if err != nil {
+		w.err = errors.WithMessage(err, "tar write header")
+		return
+	}
+	_, err = w.tar.Write(w.buffer.Bytes())
+	if err != nil {
+		w.err = errors.WithMessage(err, "tar write")
+		return
+	}
+	w.buffer.Reset()
+	w.files++
+	w.items = 0
+}
+
+// Write writes the item.
+func (w *DumpWriter[T]) Write(_ context.Context, item T) errors.E {
+	data, errE := x.MarshalWithoutEscapeHTML(item)
+	if errE != nil {
+		return errE
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	errE = w.init()
+	if errE != nil {
+		return errE
+	}
+
+	switch {
+	case w.FileType == NDJSON:
+		w.write(data)
+		w.write([]byte("\n"))
+	case w.items == 0:
+		w.write([]byte("[\n"))
+		w.write(data)
+	default:
+		w.write([]byte(",\n"))
+		w.write(data)
+	}
+	w.items++
+
+	tarFileSize := w.TarFileSize
+	if tarFileSize == 0 {
+		tarFileSize = defaultTarFileSize
+	}
+	if w.tar != nil && w.buffer.Len() >= tarFileSize {
+		w.endFile()
+	}
+	return w.err
+}
+
+// Close finishes the dump and flushes any buffered data to Writer.
+func (w *DumpWriter[T]) Close() errors.E {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	errE := w.init()
+	if errE != nil {
+		return errE
+	}
+
+	// An empty tar contains no files.
+	if w.tar == nil || w.items > 0 {
+		w.endFile()
+	}
+	if w.tar != nil && w.err == nil {
+		err := w.tar.Close()
+		if err != nil {
+			w.err = errors.WithMessage(err, "tar close")
+		}
+	}
+	err := w.compressor.Close()
+	if w.err == nil && err != nil {
+		w.err = errors.WithMessage(err, "compressor close")
+	}
+	errE = w.err
+	if errE == nil {
+		// Further writes fail.
+		w.err = errors.New("dump writer closed")
+	}
+	return errE
+}
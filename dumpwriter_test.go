@@ -0,0 +1,187 @@
+package mediawiki_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func readDump(t *testing.T, path string, fileType mediawiki.FileType, compression mediawiki.Compression) []string {
+	t.Helper()
+
+	var mu sync.Mutex
+	rows := []string{}
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[json.RawMessage]{
+		Path: path,
+		Process: func(_ context.Context, row json.RawMessage) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			rows = append(rows, string(row))
+			return nil
+		},
+		FileType:    fileType,
+		Compression: compression,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	slices.Sort(rows)
+	return rows
+}
+
+func TestDumpWriter(t *testing.T) {
+	t.Parallel()
+
+	source := filepath.Join("testdata", "wikidata-testdata-index.json.bz2")
+	expected := readDump(t, source, mediawiki.JSONArray, mediawiki.BZIP2)
+	require.Len(t, expected, 2550)
+
+	for _, fileType := range []mediawiki.FileType{mediawiki.JSONArray, mediawiki.NDJSON} {
+		for _, compression := range []mediawiki.Compression{
+			mediawiki.NoCompression, mediawiki.Tar, mediawiki.BZIP2, mediawiki.BZIP2Tar,
+			mediawiki.GZIP, mediawiki.GZIPTar, mediawiki.ZSTD, mediawiki.ZSTDTar,
+		} {
+			t.Run(fmt.Sprintf("%d/%d", fileType, compression), func(t *testing.T) {
+				t.Parallel()
+
+				path := filepath.Join(t.TempDir(), "dump")
+				file, err := os.Create(path)
+				require.NoError(t, err)
+				writer := &mediawiki.DumpWriter[json.RawMessage]{
+					Writer:             file,
+					FileType:           fileType,
+					Compression:        compression,
+					CompressionThreads: 4,
+					TarFileSize:        1 << 20,
+				}
+				errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[json.RawMessage]{
+					Path:                   source,
+					Process:                writer.Write,
+					ItemsProcessingThreads: 4,
+					FileType:               mediawiki.JSONArray,
+					Compression:            mediawiki.BZIP2,
+				})
+				require.NoError(t, errE, "% -+#.1v", errE)
+				errE = writer.Close()
+				require.NoError(t, errE, "% -+#.1v", errE)
+				require.NoError(t, file.Close())
+
+				assert.Equal(t, expected, readDump(t, path, fileType, compression))
+
+				errE = writer.Write(context.Background(), json.RawMessage(`{}`))
+				assert.EqualError(t, errE, "dump writer closed")
+			})
+		}
+	}
+}
+
+func TestDumpWriterLayout(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	writer := &mediawiki.DumpWriter[json.RawMessage]{Writer: &buffer, FileType: mediawiki.JSONArray}
+	for _, row := range []string{`{"id": "Q1",` + "\n" + `"type": "item"}`, `{"id": "Q2"}`, `{"id": "<Q3>"}`} {
+		errE := writer.Write(context.Background(), json.RawMessage(row))
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE := writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "[\n"+`{"id":"Q1","type":"item"},`+"\n"+`{"id":"Q2"},`+"\n"+`{"id":"<Q3>"}`+"\n]\n", buffer.String())
+
+	buffer.Reset()
+	writer = &mediawiki.DumpWriter[json.RawMessage]{Writer: &buffer, FileType: mediawiki.JSONArray}
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "[\n]\n", buffer.String())
+
+	// Tar contains multiple files.
+	buffer.Reset()
+	ndjson := &mediawiki.DumpWriter[mediawiki.Entity]{
+		Writer:      &buffer,
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.Tar,
+		TarFileName: "wikidata_%d.ndjson",
+		TarFileSize: 1,
+	}
+	for _, id := range []string{"Q1", "Q2"} {
+		errE = ndjson.Write(context.Background(), mediawiki.Entity{ID: id, Type: mediawiki.Item})
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE = ndjson.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	reader := tar.NewReader(&buffer)
+	names := []string{}
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), `{"id":"Q`), string(data))
+		assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	}
+	assert.Equal(t, []string{"wikidata_0.ndjson", "wikidata_1.ndjson"}, names)
+
+	errE = (&mediawiki.DumpWriter[json.RawMessage]{Writer: &buffer, FileType: mediawiki.SQLDump}).Close()
+	assert.EqualError(t, errE, "unsupported file type")
+}
+
+func TestDumpWriterEntities(t *testing.T) {
+	t.Parallel()
+
+	// Entities are written in a form which is decoded back into equal entities.
+	source := filepath.Join("testdata", "wikidata-testdata-index.json.bz2")
+	path := filepath.Join(t.TempDir(), "dump.json.bz2")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	writer := &mediawiki.DumpWriter[mediawiki.Entity]{Writer: file, FileType: mediawiki.JSONArray, Compression: mediawiki.BZIP2}
+	var mu sync.Mutex
+	entities := map[string]mediawiki.Entity{}
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Entity]{
+		Path: source,
+		Process: func(ctx context.Context, entity mediawiki.Entity) errors.E {
+			mu.Lock()
+			entities[entity.ID] = entity
+			mu.Unlock()
+			return writer.Write(ctx, entity)
+		},
+		FileType:    mediawiki.JSONArray,
+		Compression: mediawiki.BZIP2,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.NoError(t, file.Close())
+
+	count := 0
+	errE = mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Entity]{
+		Path: path,
+		Process: func(_ context.Context, entity mediawiki.Entity) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			count++
+			assert.Equal(t, entities[entity.ID], entity)
+			return nil
+		},
+		FileType:    mediawiki.JSONArray,
+		Compression: mediawiki.BZIP2,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, len(entities), count)
+}
@@ -0,0 +1,232 @@
+package mediawiki
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+type EnterpriseNamespace struct {
+	Identifier    int64  `json:"identifier"`
+	Name          string `json:"name,omitempty"`
+	AlternateName string `json:"alternate_name,omitempty"`
+	Description   string `json:"description,omitempty"`
+}
+
+type EnterpriseLanguage struct {
+	Identifier    string `json:"identifier"`
+	Name          string `json:"name,omitempty"`
+	AlternateName string `json:"alternate_name,omitempty"`
+	Direction     string `json:"direction,omitempty"`
+}
+
+type EnterpriseProject struct {
+	Identifier string              `json:"identifier"`
+	Code       string              `json:"code,omitempty"`
+	Name       string              `json:"name,omitempty"`
+	URL        string              `json:"url,omitempty"`
+	InLanguage *EnterpriseLanguage `json:"in_language,omitempty"`
+}
+
+type EnterpriseImage struct {
+	ContentURL      string `json:"content_url"`
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+	AlternativeText string `json:"alternative_text,omitempty"`
+	Caption         string `json:"caption,omitempty"`
+}
+
+// EnterpriseVersion is a version of an article in Wikimedia Enterprise API.
+// Compared to Version, it does not have its own event.
+type EnterpriseVersion struct {
+	Identifier          int64            `json:"identifier"`
+	Editor              *Editor          `json:"editor,omitempty"`
+	Comment             string           `json:"comment,omitempty"`
+	Tags                []string         `json:"tags,omitempty"`
+	HasTagNeedsCitation bool             `json:"has_tag_needs_citation,omitempty"`
+	IsMinorEdit         bool             `json:"is_minor_edit,omitempty"`
+	IsFlaggedStable     bool             `json:"is_flagged_stable,omitempty"`
+	Scores              *Scores          `json:"scores,omitempty"`
+	Size                *Size            `json:"size,omitempty"`
+	NumberOfCharacters  int64            `json:"number_of_characters,omitempty"`
+	IsBreakingNews      bool             `json:"is_breaking_news,omitempty"`
+	NoIndex             bool             `json:"noindex,omitempty"`
+	MaintenanceTags     *MaintenanceTags `json:"maintenance_tags,omitempty"`
+}
+
+type EnterprisePreviousVersion struct {
+	Identifier         int64 `json:"identifier"`
+	NumberOfCharacters int64 `json:"number_of_characters,omitempty"`
+}
+
+// EnterpriseArticle is an article in Wikimedia Enterprise API snapshots
+// (and chunked snapshots), on-demand lookups, and realtime updates.
+//
+// Article is the older Wikimedia Enterprise HTML dump article.
+type EnterpriseArticle struct {
+	Name                   string                     `json:"name"`
+	Identifier             int64                      `json:"identifier"`
+	Abstract               string                     `json:"abstract,omitempty"`
+	WatchersCount          int64                      `json:"watchers_count,omitempty"`
+	DateCreated            *time.Time                 `json:"date_created,omitempty"`
+	DateModified           time.Time                  `json:"date_modified"`
+	DatePreviouslyModified *time.Time                 `json:"date_previously_modified,omitempty"`
+	Protection             []Protection               `json:"protection,omitempty"`
+	Version                EnterpriseVersion          `json:"version"`
+	PreviousVersion        *EnterprisePreviousVersion `json:"previous_version,omitempty"`
+	URL                    string                     `json:"url"`
+	Namespace              EnterpriseNamespace        `json:"namespace"`
+	InLanguage             EnterpriseLanguage         `json:"in_language"`
+	MainEntity             *EntityRef                 `json:"main_entity,omitempty"`
+	AdditionalEntities     []EntityRef                `json:"additional_entities,omitempty"`
+	Categories             []Category                 `json:"categories,omitempty"`
+	Templates              []Template                 `json:"templates,omitempty"`
+	Redirects              []Redirect                 `json:"redirects,omitempty"`
+	IsPartOf               EnterpriseProject          `json:"is_part_of"`
+	ArticleBody            ArticleBody                `json:"article_body"`
+	License                []License                  `json:"license,omitempty"`
+	Visibility             *Visibility                `json:"visibility,omitempty"`
+	Image                  *EnterpriseImage           `json:"image,omitempty"`
+	Event                  *Event                     `json:"event,omitempty"`
+}
+
+type StructuredCitation struct {
+	Identifier string `json:"identifier"`
+	Text       string `json:"text,omitempty"`
+}
+
+// TODO: Should Type be enumeration?
+
+// StructuredPart is a part of structured contents: an infobox, a section, a field,
+// a paragraph, a list, a list item, or an image. Parts are nested through HasParts.
+type StructuredPart struct {
+	Name      string               `json:"name,omitempty"`
+	Type      string               `json:"type"`
+	Value     string               `json:"value,omitempty"`
+	Values    []string             `json:"values,omitempty"`
+	HasParts  []StructuredPart     `json:"has_parts,omitempty"`
+	Images    []EnterpriseImage    `json:"images,omitempty"`
+	Links     []Link               `json:"links,omitempty"`
+	Citations []StructuredCitation `json:"citations,omitempty"`
+}
+
+type StructuredTableCell struct {
+	Value string `json:"value"`
+}
+
+type StructuredTable struct {
+	Identifier      string                  `json:"identifier"`
+	Headers         [][]StructuredTableCell `json:"headers,omitempty"`
+	Rows            [][]StructuredTableCell `json:"rows,omitempty"`
+	ConfidenceScore float64                 `json:"confidence_score,omitempty"`
+}
+
+type StructuredText struct {
+	Value string `json:"value"`
+	Links []Link `json:"links,omitempty"`
+}
+
+// TODO: Should Type be enumeration?
+
+type StructuredReference struct {
+	Identifier string            `json:"identifier"`
+	Group      string            `json:"group,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Text       *StructuredText   `json:"text,omitempty"`
+}
+
+// StructuredContent is an article in Wikimedia Enterprise API structured contents
+// snapshots, with parsed infoboxes, sections, tables, and references instead of
+// the article body.
+type StructuredContent struct {
+	Name               string                `json:"name"`
+	Identifier         int64                 `json:"identifier"`
+	Abstract           string                `json:"abstract,omitempty"`
+	Description        string                `json:"description,omitempty"`
+	DateCreated        *time.Time            `json:"date_created,omitempty"`
+	DateModified       time.Time             `json:"date_modified"`
+	Version            EnterpriseVersion     `json:"version"`
+	URL                string                `json:"url"`
+	Namespace          *EnterpriseNamespace  `json:"namespace,omitempty"`
+	InLanguage         EnterpriseLanguage    `json:"in_language"`
+	MainEntity         *EntityRef            `json:"main_entity,omitempty"`
+	AdditionalEntities []EntityRef           `json:"additional_entities,omitempty"`
+	IsPartOf           EnterpriseProject     `json:"is_part_of"`
+	License            []License             `json:"license,omitempty"`
+	Image              *EnterpriseImage      `json:"image,omitempty"`
+	Infoboxes          []StructuredPart      `json:"infoboxes,omitempty"`
+	Sections           []StructuredPart      `json:"sections,omitempty"`
+	Tables             []StructuredTable     `json:"tables,omitempty"`
+	References         []StructuredReference `json:"references,omitempty"`
+	Event              *Event                `json:"event,omitempty"`
+}
+
+// EnterpriseSnapshot is metadata of a Wikimedia Enterprise API snapshot or its chunk.
+//
+// Chunks are set for snapshots which are available also in chunks.
+type EnterpriseSnapshot struct {
+	Identifier   string              `json:"identifier"`
+	Version      string              `json:"version,omitempty"`
+	DateModified time.Time           `json:"date_modified"`
+	IsPartOf     EnterpriseProject   `json:"is_part_of"`
+	InLanguage   EnterpriseLanguage  `json:"in_language"`
+	Namespace    EnterpriseNamespace `json:"namespace"`
+	Size         *Size               `json:"size,omitempty"`
+	Chunks       []string            `json:"chunks,omitempty"`
+}
+
+// ProcessEnterpriseSnapshot downloads (unless already saved), decompresses, decodes JSON,
+// and calls processArticle on every article in a Wikimedia Enterprise API snapshot.
+//
+// For a chunked snapshot, provide its chunks as config.Sources to process them as one snapshot.
+func ProcessEnterpriseSnapshot(
+	ctx context.Context, config *ProcessDumpConfig,
+	processArticle func(context.Context, EnterpriseArticle) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[EnterpriseArticle]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process:                processArticle,
+		Progress:               config.Progress,
+		FileType:               NDJSON,
+		Compression:            GZIPTar,
+		Checksum:               config.Checksum,
+		Mirrors:                config.Mirrors,
+		Sources:                config.Sources,
+		SourcesThreads:         config.SourcesThreads,
+		Index:                  config.Index,
+	})
+}
+
+// ProcessEnterpriseStructuredContents downloads (unless already saved), decompresses, decodes JSON,
+// and calls processContent on every article in a Wikimedia Enterprise API structured contents snapshot.
+//
+// For a chunked snapshot, provide its chunks as config.Sources to process them as one snapshot.
+func ProcessEnterpriseStructuredContents(
+	ctx context.Context, config *ProcessDumpConfig,
+	processContent func(context.Context, StructuredContent) errors.E,
+) errors.E {
+	return Process(ctx, &ProcessConfig[StructuredContent]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process:                processContent,
+		Progress:               config.Progress,
+		FileType:               NDJSON,
+		Compression:            GZIPTar,
+		Checksum:               config.Checksum,
+		Mirrors:                config.Mirrors,
+		Sources:                config.Sources,
+		SourcesThreads:         config.SourcesThreads,
+		Index:                  config.Index,
+	})
+}
@@ -0,0 +1,129 @@
+package mediawiki_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+var enterpriseSnapshotChunks = []mediawiki.Source{ //nolint:gochecknoglobals
+	{Path: filepath.Join("testdata", "enwiki-NS0-testdata-ENTERPRISE-SNAPSHOT-chunk_0.json.tar.gz")},
+	{Path: filepath.Join("testdata", "enwiki-NS0-testdata-ENTERPRISE-SNAPSHOT-chunk_1.json.tar.gz")},
+}
+
+// readEnterpriseJSON returns original JSON of all articles in sources, by their identifier.
+func readEnterpriseJSON(t *testing.T, sources []mediawiki.Source) map[int64]string {
+	t.Helper()
+
+	var mu sync.Mutex
+	rows := map[int64]string{}
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[json.RawMessage]{
+		Process: func(_ context.Context, row json.RawMessage) errors.E {
+			var article struct {
+				Identifier int64 `json:"identifier"`
+			}
+			errE := x.Unmarshal(row, &article)
+			if errE != nil {
+				return errE
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			rows[article.Identifier] = string(row)
+			return nil
+		},
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.GZIPTar,
+		Sources:     sources,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return rows
+}
+
+func TestProcessEnterpriseSnapshot(t *testing.T) {
+	t.Parallel()
+
+	expected := readEnterpriseJSON(t, enterpriseSnapshotChunks)
+
+	var mu sync.Mutex
+	articles := map[string]mediawiki.EnterpriseArticle{}
+	errE := mediawiki.ProcessEnterpriseSnapshot(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Sources: enterpriseSnapshotChunks,
+		},
+		func(_ context.Context, a mediawiki.EnterpriseArticle) errors.E {
+			b, errE := x.MarshalWithoutEscapeHTML(a)
+			if errE != nil {
+				return errE
+			}
+			// We have to use JSONEq instead of Equal so that empty slice is equal to nil slice.
+			assert.JSONEq(t, expected[a.Identifier], string(b))
+			mu.Lock()
+			defer mu.Unlock()
+			articles[a.Name] = a
+			return nil
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, articles, 3)
+
+	berlin := articles["Berlin"]
+	assert.Equal(t, "Q64", berlin.MainEntity.Identifier)
+	assert.Equal(t, int64(2000), berlin.PreviousVersion.Identifier)
+	assert.Equal(t, "English", berlin.InLanguage.Name)
+	assert.Equal(t, "Wikipedia", berlin.IsPartOf.Name)
+	assert.Equal(t, "Berlin", berlin.Image.AlternativeText)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 2, 0, time.UTC), *berlin.Event.DatePublished)
+	assert.InDelta(t, 0.1, berlin.Version.Scores.RevertRisk.Probability.True, 0.0001)
+}
+
+func TestProcessEnterpriseStructuredContents(t *testing.T) {
+	t.Parallel()
+
+	sources := []mediawiki.Source{{Path: filepath.Join("testdata", "enwiki-NS0-testdata-ENTERPRISE-STRUCTURED.json.tar.gz")}}
+	expected := readEnterpriseJSON(t, sources)
+
+	contents := []mediawiki.StructuredContent{}
+	errE := mediawiki.ProcessEnterpriseStructuredContents(
+		context.Background(),
+		&mediawiki.ProcessDumpConfig{
+			Path: sources[0].Path,
+		},
+		func(_ context.Context, c mediawiki.StructuredContent) errors.E {
+			b, errE := x.MarshalWithoutEscapeHTML(c)
+			if errE != nil {
+				return errE
+			}
+			assert.JSONEq(t, expected[c.Identifier], string(b))
+			contents = append(contents, c)
+			return nil
+		},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, contents, 1)
+
+	content := contents[0]
+	assert.Equal(t, "Capital city of Slovenia", content.Description)
+	assert.Equal(t, "Infobox settlement", content.Infoboxes[0].Name)
+	assert.Equal(t, mediawiki.StructuredPart{
+		Type:  "field",
+		Name:  "Country",
+		Value: "Slovenia",
+		Links: []mediawiki.Link{{URL: "https://en.wikipedia.org/wiki/Slovenia", Text: "Slovenia"}},
+	}, content.Infoboxes[0].HasParts[0].HasParts[0])
+	require.Len(t, content.Sections, 2)
+	assert.Equal(t, "cite_note-1", content.Sections[0].HasParts[0].Citations[0].Identifier)
+	assert.Equal(t, "Emona", content.Sections[1].HasParts[1].HasParts[0].HasParts[0].Value)
+	assert.Equal(t, [][]mediawiki.StructuredTableCell{{{Value: "2002"}, {Value: "265,881"}}, {{Value: "2021"}, {Value: "285,604"}}}, content.Tables[0].Rows)
+	assert.Equal(t, "https://www.stat.si/", content.References[0].Metadata["url"])
+}
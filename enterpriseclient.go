@@ -0,0 +1,502 @@
+package mediawiki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	enterpriseAuthURL     = "https://auth.enterprise.wikimedia.com/"
+	enterpriseAPIURL      = "https://api.enterprise.wikimedia.com/"
+	enterpriseRealtimeURL = "https://realtime.enterprise.wikimedia.com/"
+
+	// Access tokens are refreshed when they expire in less than this.
+	enterpriseTokenMargin = time.Minute
+
+	// Maximum line size of the realtime stream.
+	enterpriseMaxLineSize = 64 * 1024 * 1024
+)
+
+type enterpriseLogin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type enterpriseRefresh struct {
+	Username     string `json:"username"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type enterpriseTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type enterpriseFilter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type enterpriseArticleRequest struct {
+	Filters []enterpriseFilter `json:"filters,omitempty"`
+}
+
+type enterpriseRealtimeRequest struct {
+	Since   *time.Time         `json:"since,omitempty"`
+	Parts   []int              `json:"parts,omitempty"`
+	Offsets map[string]int64   `json:"offsets,omitempty"`
+	Filters []enterpriseFilter `json:"filters,omitempty"`
+}
+
+// EnterpriseClient is a client for Wikimedia Enterprise APIs: on-demand article
+// lookups, hourly batches, and the realtime stream of article updates.
+//
+// Client, Username, and Password are required. Client should set User-Agent header,
+// see ProcessDumpConfig. The client logs in with Username and Password and
+// refreshes the access token before it expires.
+//
+// AuthURL, APIURL, and RealtimeURL are base URLs of Wikimedia Enterprise APIs.
+// They default to production APIs and can be changed for testing.
+type EnterpriseClient struct {
+	Client   *retryablehttp.Client
+	Username string
+	Password string
+
+	AuthURL     string
+	APIURL      string
+	RealtimeURL string
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expires      time.Time
+}
+
+func (c *EnterpriseClient) url(base, defaultBase, path string) string {
+	if base == "" {
+		base = defaultBase
+	}
+	return joinURL(base, path)
+}
+
+// auth sends JSON body to the authentication API and decodes JSON response into tokens.
+func (c *EnterpriseClient) auth(ctx context.Context, path string, body interface{}, tokens *enterpriseTokens) errors.E {
+	u := c.url(c.AuthURL, enterpriseAuthURL, path)
+	resp, errE := c.request(ctx, http.MethodPost, u, "", body)
+	if errE != nil {
+		return errE
+	}
+	defer resp.Body.Close()              //nolint:errcheck
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["url"] = u
+		return errE
+	}
+	// We do not disallow unknown fields because we do not need all of them.
+	errE = x.Unmarshal(data, tokens)
+	if errE != nil {
+		errE = errors.Prefix(errE, ErrJSONDecode)
+		errors.Details(errE)["url"] = u
+		return errE
+	}
+	return nil
+}
+
+// request sends the request with JSON body (if not nil) and Authorization header (if token is not empty).
+// It returns ErrUnauthorized for 401 responses and ErrNotFound for 404 responses.
+func (c *EnterpriseClient) request(ctx context.Context, method, u, token string, body interface{}) (*http.Response, errors.E) {
+	var data []byte
+	if body != nil {
+		var errE errors.E
+		data, errE = x.MarshalWithoutEscapeHTML(body)
+		if errE != nil {
+			errors.Details(errE)["url"] = u
+			return nil, errE
+		}
+	}
+	req, err := retryablehttp.NewRequestWithContext(ctx, method, u, data)
+	if err != nil {
+		errE := errors.WithMessage(err, "new request")
+		errors.Details(errE)["url"] = u
+		return nil, errE
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		errE := errors.WithMessage(err, "do")
+		errors.Details(errE)["url"] = u
+		return nil, errE
+	}
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()              //nolint:errcheck
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	var errE errors.E
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		errE = errors.WithDetails(ErrUnauthorized, "url", u)
+	case http.StatusNotFound:
+		errE = errors.WithDetails(ErrNotFound, "url", u)
+	default:
+		errE = errors.New("bad response status")
+		errors.Details(errE)["url"] = u
+	}
+	errors.Details(errE)["status"] = resp.Status
+	return nil, errE
+}
+
+// token returns a valid access token, logging in or refreshing the token if necessary.
+func (c *EnterpriseClient) token(ctx context.Context) (string, errors.E) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken != "" && time.Until(c.expires) > enterpriseTokenMargin {
+		return c.accessToken, nil
+	}
+
+	now := time.Now()
+	var tokens enterpriseTokens
+	var errE errors.E
+	if c.refreshToken != "" {
+		errE = c.auth(ctx, "v1/token-refresh", enterpriseRefresh{
+			Username:     c.Username,
+			RefreshToken: c.refreshToken,
+		}, &tokens)
+	}
+	if c.refreshToken == "" || errors.Is(errE, ErrUnauthorized) {
+		// There is no refresh token or it has expired, so we log in again.
+		tokens = enterpriseTokens{} //nolint:exhaustruct
+		errE = c.auth(ctx, "v1/login", enterpriseLogin{
+			Username: c.Username,
+			Password: c.Password,
+		}, &tokens)
+	}
+	if errE != nil {
+		c.accessToken = ""
+		return "", errE
+	}
+
+	c.accessToken = tokens.AccessToken
+	if tokens.RefreshToken != "" {
+		c.refreshToken = tokens.RefreshToken
+	}
+	c.expires = now.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	return c.accessToken, nil
+}
+
+// invalidate forgets the access token if it is still the current one.
+func (c *EnterpriseClient) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken == token {
+		c.accessToken = ""
+	}
+}
+
+// authorized sends the request with the access token. If the access token
+// is rejected, it obtains a new one and retries once.
+func (c *EnterpriseClient) authorized(ctx context.Context, method, u string, body interface{}) (*http.Response, errors.E) {
+	for i := 0; ; i++ {
+		token, errE := c.token(ctx)
+		if errE != nil {
+			return nil, errE
+		}
+		resp, errE := c.request(ctx, method, u, token, body)
+		if errors.Is(errE, ErrUnauthorized) && i == 0 {
+			c.invalidate(token)
+			continue
+		}
+		return resp, errE
+	}
+}
+
+func (c *EnterpriseClient) authorizedJSON(ctx context.Context, method, u string, body, result interface{}) errors.E {
+	resp, errE := c.authorized(ctx, method, u, body)
+	if errE != nil {
+		return errE
+	}
+	defer resp.Body.Close()              //nolint:errcheck
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["url"] = u
+		return errE
+	}
+	errE = x.UnmarshalWithoutUnknownFields(data, result)
+	if errE != nil {
+		errE = errors.Prefix(errE, ErrJSONDecode)
+		errors.Details(errE)["url"] = u
+		return errE
+	}
+	return nil
+}
+
+// HTTPClient returns a client which sets Authorization header with
+// a valid access token on every request, e.g., to pass it to ProcessEnterpriseSnapshot.
+func (c *EnterpriseClient) HTTPClient() *retryablehttp.Client {
+	requestLogHook := c.Client.RequestLogHook
+	return &retryablehttp.Client{ //nolint:exhaustruct
+		HTTPClient:   c.Client.HTTPClient,
+		Logger:       c.Client.Logger,
+		RetryWaitMin: c.Client.RetryWaitMin,
+		RetryWaitMax: c.Client.RetryWaitMax,
+		RetryMax:     c.Client.RetryMax,
+		RequestLogHook: func(logger retryablehttp.Logger, req *http.Request, retry int) {
+			if requestLogHook != nil {
+				requestLogHook(logger, req, retry)
+			}
+			// On error, the request is made without Authorization header and fails.
+			token, _ := c.token(req.Context())
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		},
+		ResponseLogHook: c.Client.ResponseLogHook,
+		CheckRetry:      c.Client.CheckRetry,
+		Backoff:         c.Client.Backoff,
+		ErrorHandler:    c.Client.ErrorHandler,
+		PrepareRetry:    c.Client.PrepareRetry,
+	}
+}
+
+// Article returns the current version of the article with the name in the project (e.g., "enwiki").
+//
+// It returns ErrNotFound if the article does not exist.
+func (c *EnterpriseClient) Article(ctx context.Context, project, name string) (*EnterpriseArticle, errors.E) {
+	u := c.url(c.APIURL, enterpriseAPIURL, "v2/articles/"+url.PathEscape(strings.ReplaceAll(name, " ", "_")))
+	var articles []EnterpriseArticle
+	errE := c.authorizedJSON(ctx, http.MethodPost, u, enterpriseArticleRequest{
+		Filters: []enterpriseFilter{{Field: "is_part_of.identifier", Value: project}},
+	}, &articles)
+	if errE != nil {
+		errors.Details(errE)["project"] = project
+		errors.Details(errE)["name"] = name
+		return nil, errE
+	}
+	if len(articles) == 0 {
+		errE := errors.WithDetails(ErrNotFound, "url", u)
+		errors.Details(errE)["project"] = project
+		errors.Details(errE)["name"] = name
+		return nil, errE
+	}
+	return &articles[0], nil
+}
+
+func batchPath(hour time.Time) string {
+	hour = hour.UTC()
+	return fmt.Sprintf("v2/batches/%s/%02d/", hour.Format(time.DateOnly), hour.Hour())
+}
+
+// Batches returns metadata of all hourly batches for the hour.
+func (c *EnterpriseClient) Batches(ctx context.Context, hour time.Time) ([]EnterpriseSnapshot, errors.E) {
+	var batches []EnterpriseSnapshot
+	errE := c.authorizedJSON(ctx, http.MethodGet, c.url(c.APIURL, enterpriseAPIURL, batchPath(hour)), nil, &batches)
+	if errE != nil {
+		return nil, errE
+	}
+	return batches, nil
+}
+
+// ProcessBatch downloads (unless already saved at path), decompresses, decodes JSON,
+// and calls processArticle on every article in the hourly batch for the hour with the
+// identifier (e.g., "enwiki_namespace_0"). See ProcessEnterpriseSnapshot.
+//
+// Path is optional.
+func (c *EnterpriseClient) ProcessBatch(
+	ctx context.Context, hour time.Time, identifier, path string,
+	processArticle func(context.Context, EnterpriseArticle) errors.E,
+) errors.E {
+	return ProcessEnterpriseSnapshot(ctx, &ProcessDumpConfig{ //nolint:exhaustruct
+		URL:    c.url(c.APIURL, enterpriseAPIURL, batchPath(hour)+url.PathEscape(identifier)+"/download"),
+		Path:   path,
+		Client: c.HTTPClient(),
+	}, processArticle)
+}
+
+// RealtimeConfig is a configuration for EnterpriseClient.Realtime.
+//
+// Offsets map partitions to offsets of the last processed article in them.
+// The stream resumes after them and they are updated as articles are processed,
+// so they can be stored and used to resume the stream later.
+// For partitions without an offset, the stream starts at Since, or with new
+// updates if Since is zero. Parts limit the stream to a subset of partitions.
+// Project (e.g., "enwiki") limits the stream to articles of one project.
+type RealtimeConfig struct {
+	Since   time.Time
+	Offsets map[int]int64
+	Parts   []int
+	Project string
+}
+
+// setOffset records the offset of the event in its partition.
+func (c *RealtimeConfig) setOffset(event *Event) {
+	if event == nil {
+		return
+	}
+	if c.Offsets == nil {
+		c.Offsets = map[int]int64{}
+	}
+	c.Offsets[event.Partition] = event.Offset
+}
+
+func (c *EnterpriseClient) realtimeRequest(config *RealtimeConfig) enterpriseRealtimeRequest {
+	request := enterpriseRealtimeRequest{
+		Since:   nil,
+		Parts:   config.Parts,
+		Offsets: nil,
+		Filters: nil,
+	}
+	if !config.Since.IsZero() {
+		since := config.Since
+		request.Since = &since
+	}
+	if len(config.Offsets) > 0 {
+		request.Offsets = make(map[string]int64, len(config.Offsets))
+		for partition, offset := range config.Offsets {
+			// We want the next article.
+			request.Offsets[strconv.Itoa(partition)] = offset + 1
+		}
+	}
+	if config.Project != "" {
+		request.Filters = []enterpriseFilter{{Field: "is_part_of.identifier", Value: config.Project}}
+	}
+	return request
+}
+
+// stream reads one connection of the realtime stream. It returns the number of
+// processed articles and an error when the connection ends.
+func (c *EnterpriseClient) stream(
+	ctx context.Context, config *RealtimeConfig,
+	processArticle func(context.Context, EnterpriseArticle) errors.E,
+) (int, bool, errors.E) {
+	u := c.url(c.RealtimeURL, enterpriseRealtimeURL, "v2/articles")
+	resp, errE := c.authorized(ctx, http.MethodPost, u, c.realtimeRequest(config))
+	if errE != nil {
+		return 0, false, errE
+	}
+	// We do not drain the body because the stream does not end.
+	defer resp.Body.Close() //nolint:errcheck
+
+	count := 0
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, enterpriseMaxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		// The stream can be NDJSON or server-sent events with data lines.
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			line = bytes.TrimSpace(data)
+		}
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		// We decode leniently because the stream is live and new fields
+		// can appear in it at any time.
+		var article EnterpriseArticle
+		errE := x.Unmarshal(line, &article)
+		if errE != nil {
+			errE = errors.Prefix(errE, ErrJSONDecode)
+			errors.Details(errE)["url"] = u
+			// We record the offset of the article which could not be decoded
+			// so that the stream can be resumed after it.
+			var event struct {
+				Event *Event `json:"event"`
+			}
+			if x.Unmarshal(line, &event) == nil && event.Event != nil {
+				config.setOffset(event.Event)
+				errors.Details(errE)["partition"] = event.Event.Partition
+				errors.Details(errE)["offset"] = event.Event.Offset
+			}
+			return count, true, errE
+		}
+		errE = processArticle(ctx, article)
+		if errE != nil {
+			return count, true, errE
+		}
+		config.setOffset(article.Event)
+		count++
+	}
+	err := scanner.Err()
+	if err != nil {
+		errE := errors.WithMessage(err, "read")
+		errors.Details(errE)["url"] = u
+		return count, false, errE
+	}
+	return count, false, nil
+}
+
+// Realtime consumes the realtime stream of article updates and calls processArticle
+// on every article, sequentially. When the connection ends or fails, it reconnects
+// (with backoff of the client) and resumes after the last processed article in
+// every partition, see RealtimeConfig.
+//
+// Articles are decoded leniently, ignoring unknown fields, so that new fields in
+// the stream do not stop it.
+//
+// It returns only when processArticle returns an error, the context is canceled,
+// or an article cannot be decoded. In the latter case, config.Offsets include the
+// offset of the article which could not be decoded (when its event could be decoded,
+// the error then has "partition" and "offset" details), so they can be used to resume
+// the stream after it.
+func (c *EnterpriseClient) Realtime(
+	ctx context.Context, config *RealtimeConfig,
+	processArticle func(context.Context, EnterpriseArticle) errors.E,
+) errors.E {
+	backoff := c.Client.Backoff
+	if backoff == nil {
+		backoff = retryablehttp.DefaultBackoff
+	}
+
+	attempt := 0
+	for {
+		count, final, errE := c.stream(ctx, config, processArticle)
+		if final {
+			return errE
+		}
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		if errors.Is(errE, ErrUnauthorized) || errors.Is(errE, ErrNotFound) {
+			// Credentials or the URL are invalid, reconnecting does not help.
+			return errE
+		}
+		if count > 0 {
+			attempt = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-time.After(backoff(c.Client.RetryWaitMin, c.Client.RetryWaitMax, attempt, nil)):
+		}
+		attempt++
+	}
+}
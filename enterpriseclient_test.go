@@ -0,0 +1,382 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// enterpriseServer is a stand-in for Wikimedia Enterprise APIs.
+type enterpriseServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	tokens        map[string]bool
+	refreshTokens map[string]bool
+	issued        int
+	logins        int
+	refreshes     int
+	expiresIn     int
+	realtime      [][]byte
+	requests      []map[string]interface{}
+}
+
+func (s *enterpriseServer) issue(w http.ResponseWriter, refresh bool) {
+	s.issued++
+	token := fmt.Sprintf("token-%d", s.issued)
+	s.tokens[token] = true
+	response := map[string]interface{}{
+		"id_token":     "id",
+		"access_token": token,
+		"expires_in":   s.expiresIn,
+	}
+	if refresh {
+		refreshToken := fmt.Sprintf("refresh-%d", s.issued)
+		s.refreshTokens[refreshToken] = true
+		response["refresh_token"] = refreshToken
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (s *enterpriseServer) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = map[string]bool{}
+	s.refreshTokens = map[string]bool{}
+}
+
+func newEnterpriseServer(t *testing.T, articles map[string]string) *enterpriseServer {
+	t.Helper()
+
+	chunk, err := os.ReadFile(enterpriseSnapshotChunks[0].Path)
+	require.NoError(t, err)
+
+	s := &enterpriseServer{
+		tokens:        map[string]bool{},
+		refreshTokens: map[string]bool{},
+		expiresIn:     3600,
+	}
+
+	authorized := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			s.mu.Lock()
+			ok := s.tokens[strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")]
+			s.mu.Unlock()
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			handler(w, req)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/login", func(w http.ResponseWriter, req *http.Request) {
+		var login map[string]string
+		_ = json.NewDecoder(req.Body).Decode(&login)
+		if login["username"] != "user" || login["password"] != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.logins++
+		s.issue(w, true)
+	})
+	mux.HandleFunc("POST /v1/token-refresh", func(w http.ResponseWriter, req *http.Request) {
+		var refresh map[string]string
+		_ = json.NewDecoder(req.Body).Decode(&refresh)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if refresh["username"] != "user" || !s.refreshTokens[refresh["refresh_token"]] {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.refreshes++
+		s.issue(w, false)
+	})
+	mux.HandleFunc("POST /v2/articles/{name}", authorized(func(w http.ResponseWriter, req *http.Request) {
+		var request struct {
+			Filters []struct {
+				Field string `json:"field"`
+				Value string `json:"value"`
+			} `json:"filters"`
+		}
+		_ = json.NewDecoder(req.Body).Decode(&request)
+		article, ok := articles[req.PathValue("name")]
+		if !ok || len(request.Filters) != 1 || request.Filters[0].Value != "enwiki" {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		_, _ = w.Write([]byte("[" + article + "]"))
+	}))
+	mux.HandleFunc("GET /v2/batches/2024-01-01/10/", authorized(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`[{"identifier":"enwiki_namespace_0","version":"abc","date_modified":"2024-01-01T11:00:00Z",` +
+			`"is_part_of":{"identifier":"enwiki"},"in_language":{"identifier":"en"},"namespace":{"identifier":0},"size":{"value":1126,"unit_text":"KB"}}]`))
+	}))
+	mux.HandleFunc("GET /v2/batches/2024-01-01/10/enwiki_namespace_0/download", authorized(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(chunk))
+	}))
+	mux.HandleFunc("POST /v2/articles", authorized(func(w http.ResponseWriter, req *http.Request) {
+		var request map[string]interface{}
+		_ = json.NewDecoder(req.Body).Decode(&request)
+		s.mu.Lock()
+		s.requests = append(s.requests, request)
+		connection := len(s.requests)
+		s.mu.Unlock()
+		if connection == 1 {
+			// First connection is dropped after two articles.
+			_, _ = w.Write(append(append(s.realtime[0], '\n'), append(s.realtime[1], '\n')...))
+			return
+		}
+		// Later connections use server-sent events.
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, article := range s.realtime[2:] {
+			_, _ = fmt.Fprintf(w, "event: update\ndata: %s\n\n", article)
+		}
+		w.(http.Flusher).Flush() //nolint:forcetypeassert
+		// We keep the connection open.
+		<-req.Context().Done()
+	}))
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newEnterpriseClient(s *enterpriseServer) *mediawiki.EnterpriseClient {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 50 * time.Millisecond
+	return &mediawiki.EnterpriseClient{
+		Client:      client,
+		Username:    "user",
+		Password:    "pass",
+		AuthURL:     s.URL,
+		APIURL:      s.URL,
+		RealtimeURL: s.URL,
+	}
+}
+
+func TestEnterpriseClientArticle(t *testing.T) {
+	t.Parallel()
+
+	articles := readEnterpriseJSON(t, enterpriseSnapshotChunks)
+	s := newEnterpriseServer(t, map[string]string{"Ljubljana": articles[1003]})
+	c := newEnterpriseClient(s)
+	ctx := context.Background()
+
+	article, errE := c.Article(ctx, "enwiki", "Ljubljana")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(1003), article.Identifier)
+	assert.Equal(t, "Q437", article.MainEntity.Identifier)
+
+	_, errE = c.Article(ctx, "enwiki", "Missing")
+	require.ErrorIs(t, errE, mediawiki.ErrNotFound)
+	assert.Equal(t, "Missing", errors.AllDetails(errE)["name"])
+
+	_, errE = c.Article(ctx, "dewiki", "Ljubljana")
+	require.ErrorIs(t, errE, mediawiki.ErrNotFound)
+
+	// The access token is reused.
+	assert.Equal(t, 1, s.logins)
+	assert.Equal(t, 0, s.refreshes)
+
+	// Rejected access token and refresh token lead to a new login.
+	s.revoke()
+	_, errE = c.Article(ctx, "enwiki", "Ljubljana")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 2, s.logins)
+
+	c = newEnterpriseClient(s)
+	c.Password = "wrong"
+	_, errE = c.Article(ctx, "enwiki", "Ljubljana")
+	assert.ErrorIs(t, errE, mediawiki.ErrUnauthorized)
+}
+
+func TestEnterpriseClientTokenRefresh(t *testing.T) {
+	t.Parallel()
+
+	articles := readEnterpriseJSON(t, enterpriseSnapshotChunks)
+	s := newEnterpriseServer(t, map[string]string{"Ljubljana": articles[1003]})
+	// Tokens expire sooner than they are refreshed.
+	s.expiresIn = 30
+	c := newEnterpriseClient(s)
+
+	for range 3 {
+		_, errE := c.Article(context.Background(), "enwiki", "Ljubljana")
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	assert.Equal(t, 1, s.logins)
+	assert.Equal(t, 2, s.refreshes)
+}
+
+func TestEnterpriseClientBatch(t *testing.T) {
+	t.Parallel()
+
+	s := newEnterpriseServer(t, nil)
+	c := newEnterpriseClient(s)
+	hour := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+
+	batches, errE := c.Batches(context.Background(), hour)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "enwiki_namespace_0", batches[0].Identifier)
+
+	path := filepath.Join(t.TempDir(), "batch.tar.gz")
+	var mu sync.Mutex
+	names := []string{}
+	errE = c.ProcessBatch(context.Background(), hour, batches[0].Identifier, path, func(_ context.Context, article mediawiki.EnterpriseArticle) errors.E {
+		mu.Lock()
+		defer mu.Unlock()
+		names = append(names, article.Name)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ElementsMatch(t, []string{"Berlin", "Paris"}, names)
+	assert.FileExists(t, path)
+}
+
+func TestEnterpriseClientRealtime(t *testing.T) {
+	t.Parallel()
+
+	articles := readEnterpriseJSON(t, enterpriseSnapshotChunks)
+	s := newEnterpriseServer(t, nil)
+	for i, event := range []struct {
+		ID        int64
+		Partition int
+		Offset    int64
+	}{{1001, 0, 10}, {1002, 1, 20}, {1003, 0, 11}, {1001, 1, 21}} {
+		var article map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(articles[event.ID]), &article))
+		article["event"] = map[string]interface{}{
+			"identifier":   fmt.Sprintf("event-%d", i),
+			"type":         "update",
+			"date_created": "2024-01-01T10:00:00Z",
+			"partition":    event.Partition,
+			"offset":       event.Offset,
+		}
+		data, errE := x.MarshalWithoutEscapeHTML(article)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		s.realtime = append(s.realtime, data)
+	}
+	c := newEnterpriseClient(s)
+
+	errDone := errors.Base("done")
+	config := &mediawiki.RealtimeConfig{
+		Since:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Project: "enwiki",
+	}
+	events := []string{}
+	errE := c.Realtime(context.Background(), config, func(_ context.Context, article mediawiki.EnterpriseArticle) errors.E {
+		events = append(events, article.Event.Identifier)
+		if len(events) == 4 {
+			return errors.WithStack(errDone)
+		}
+		return nil
+	})
+	assert.ErrorIs(t, errE, errDone)
+	assert.Equal(t, []string{"event-0", "event-1", "event-2", "event-3"}, events)
+	// The last article was not processed successfully.
+	assert.Equal(t, map[int]int64{0: 11, 1: 20}, config.Offsets)
+
+	s.mu.Lock()
+	require.Len(t, s.requests, 2)
+	assert.Nil(t, s.requests[0]["offsets"])
+	assert.Equal(t, "2024-01-01T00:00:00Z", s.requests[0]["since"])
+	// Reconnection resumes after processed articles.
+	assert.Equal(t, map[string]interface{}{"0": 11.0, "1": 21.0}, s.requests[1]["offsets"])
+
+	s.mu.Unlock()
+
+	// Context cancellation stops the stream.
+	ctx, cancel := context.WithCancel(context.Background())
+	errE = c.Realtime(ctx, config, func(_ context.Context, _ mediawiki.EnterpriseArticle) errors.E {
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, errE, context.Canceled)
+}
+
+func TestEnterpriseClientRealtimeDecoding(t *testing.T) {
+	t.Parallel()
+
+	articles := readEnterpriseJSON(t, enterpriseSnapshotChunks)
+	s := newEnterpriseServer(t, nil)
+	for i, event := range []struct {
+		ID        int64
+		Partition int
+		Offset    int64
+	}{{1001, 0, 10}, {1002, 1, 20}, {1003, 0, 11}, {1001, 1, 21}} {
+		var article map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(articles[event.ID]), &article))
+		article["event"] = map[string]interface{}{
+			"identifier":   fmt.Sprintf("event-%d", i),
+			"type":         "update",
+			"date_created": "2024-01-01T10:00:00Z",
+			"partition":    event.Partition,
+			"offset":       event.Offset,
+		}
+		switch i {
+		case 0:
+			// Unknown fields are ignored.
+			article["new_field"] = "value"
+		case 1:
+			// Invalid article.
+			article["name"] = 123
+		}
+		data, errE := x.MarshalWithoutEscapeHTML(article)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		s.realtime = append(s.realtime, data)
+	}
+	c := newEnterpriseClient(s)
+
+	config := &mediawiki.RealtimeConfig{}
+	events := []string{}
+	errE := c.Realtime(context.Background(), config, func(_ context.Context, article mediawiki.EnterpriseArticle) errors.E {
+		events = append(events, article.Event.Identifier)
+		return nil
+	})
+	require.ErrorIs(t, errE, mediawiki.ErrJSONDecode)
+	assert.Equal(t, 1, errors.AllDetails(errE)["partition"])
+	assert.Equal(t, int64(20), errors.AllDetails(errE)["offset"])
+	assert.Equal(t, []string{"event-0"}, events)
+	// The offset of the invalid article is recorded.
+	assert.Equal(t, map[int]int64{0: 10, 1: 20}, config.Offsets)
+
+	// Resuming skips the invalid article.
+	errDone := errors.Base("done")
+	errE = c.Realtime(context.Background(), config, func(_ context.Context, article mediawiki.EnterpriseArticle) errors.E {
+		events = append(events, article.Event.Identifier)
+		if len(events) == 3 {
+			return errors.WithStack(errDone)
+		}
+		return nil
+	})
+	assert.ErrorIs(t, errE, errDone)
+	assert.Equal(t, []string{"event-0", "event-2", "event-3"}, events)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.Len(t, s.requests, 2)
+	assert.Equal(t, map[string]interface{}{"0": 11.0, "1": 21.0}, s.requests[1]["offsets"])
+}
@@ -2,10 +2,13 @@ package mediawiki
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"math/big"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"gitlab.com/tozd/go/errors"
@@ -452,6 +455,10 @@ type MonolingualTextValue struct {
 // Amount is an arbitrary precision number and extends big.Rat.
 type Amount struct {
 	big.Rat
+
+	// stored is the amount as it was in JSON, used to compute hashes
+	// matching those provided by Wikidata.
+	stored string
 }
 
 // MarshalJSON implements json.Marshaler interface for Amount.
@@ -480,6 +487,7 @@ func (a *Amount) UnmarshalJSON(b []byte) error {
 		errors.Details(errE)["value"] = s
 		return errE
 	}
+	a.stored = s
 	return nil
 }
 
@@ -508,6 +516,13 @@ type TimeValue struct {
 	Time      time.Time     `json:"time"`
 	Precision TimePrecision `json:"precision"`
 	Calendar  CalendarModel `json:"calendar"`
+
+	// timestamp, timezone, before, and after are as they were in JSON,
+	// used to compute hashes matching those provided by Wikidata.
+	timestamp string
+	timezone  int64
+	before    int64
+	after     int64
 }
 
 // MarshalJSON implements json.Marshaler interface for TimeValue.
@@ -543,6 +558,7 @@ func (v *TimeValue) UnmarshalJSON(b []byte) error {
 	}
 	v.Precision = d.Precision
 	v.Calendar = d.Calendar
+	v.timestamp = d.Time
 	return nil
 }
 
@@ -786,11 +802,14 @@ func (v *DataValue) UnmarshalJSON(b []byte) error {
 				Time      string        `json:"time"`
 				Precision TimePrecision `json:"precision"`
 				Calendar  CalendarModel `json:"calendarmodel"`
-				// Defined and declared not used, but sometimes still set. We ignore it.
+				// Defined and declared not used, but sometimes still set.
+				// We use it only to compute hashes.
 				Timezone int64 `json:"timezone"`
-				// Defined and declared not used, but sometimes still set. We ignore it.
+				// Defined and declared not used, but sometimes still set.
+				// We use it only to compute hashes.
 				Before int64 `json:"before"`
-				// Defined and declared not used, but sometimes still set. We ignore it.
+				// Defined and declared not used, but sometimes still set.
+				// We use it only to compute hashes.
 				After int64 `json:"after"`
 			} `json:"value"`
 		}
@@ -806,6 +825,10 @@ func (v *DataValue) UnmarshalJSON(b []byte) error {
 				Time:      parsedTime,
 				Precision: t.Value.Precision,
 				Calendar:  t.Value.Calendar,
+				timestamp: t.Value.Time,
+				timezone:  t.Value.Timezone,
+				before:    t.Value.Before,
+				after:     t.Value.After,
 			}
 		}
 	default:
@@ -888,3 +911,65 @@ type commonsEntity struct {
 	SiteLinks    map[string]SiteLink        `json:"sitelinks,omitempty"`
 	LastRevID    int64                      `json:"lastrevid"`
 }
+
+// CompareEntityIDs compares two entity IDs, e.g., "Q42" and "P31".
+//
+// IDs are first compared by their prefix and then by their numeric part,
+// so that "Q9" sorts before "Q10". Suffixes (e.g., "L1-F2") are compared
+// recursively. It returns -1, 0, or +1.
+func CompareEntityIDs(a, b string) int {
+	for a != "" || b != "" {
+		aPrefix, aNumber, aRest := splitEntityID(a)
+		bPrefix, bNumber, bRest := splitEntityID(b)
+		if c := strings.Compare(aPrefix, bPrefix); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(len(aNumber), len(bNumber)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(aNumber, bNumber); c != 0 {
+			return c
+		}
+		a, b = aRest, bRest
+	}
+	return 0
+}
+
+// splitEntityID splits an ID into a non-numeric prefix, numeric part
+// (without leading zeros), and the rest.
+func splitEntityID(id string) (string, string, string) {
+	i := strings.IndexAny(id, "0123456789")
+	if i < 0 {
+		return id, "", ""
+	}
+	j := i
+	for j < len(id) && id[j] >= '0' && id[j] <= '9' {
+		j++
+	}
+	return id[:i], strings.TrimLeft(id[i:j], "0"), id[j:]
+}
+
+// OrderedProperties returns properties of snaks in the given order,
+// followed by any remaining properties sorted by CompareEntityIDs.
+// Properties in order which have no snaks are skipped.
+//
+// Use it with Statement.QualifiersOrder or Reference.SnaksOrder to
+// iterate over qualifiers or reference snaks in the order Wikidata shows them.
+func OrderedProperties(snaks map[string][]Snak, order []string) []string {
+	properties := make([]string, 0, len(snaks))
+	seen := make(map[string]bool, len(snaks))
+	for _, property := range order {
+		if _, ok := snaks[property]; ok && !seen[property] {
+			properties = append(properties, property)
+			seen[property] = true
+		}
+	}
+	rest := []string{}
+	for property := range snaks {
+		if !seen[property] {
+			rest = append(rest, property)
+		}
+	}
+	slices.SortFunc(rest, CompareEntityIDs)
+	return append(properties, rest...)
+}
@@ -0,0 +1,105 @@
+package mediawiki
+
+// Equal compares data values by their values.
+//
+// Amounts are compared as numbers (so +1.0 equals +1), times are compared
+// at their precision, and globe and unit URIs are compared as entity IDs.
+func (v DataValue) Equal(other DataValue) bool {
+	switch value := v.Value.(type) {
+	case ErrorValue:
+		o, ok := other.Value.(ErrorValue)
+		return ok && value == o
+	case StringValue:
+		o, ok := other.Value.(StringValue)
+		return ok && value == o
+	case WikiBaseEntityIDValue:
+		o, ok := other.Value.(WikiBaseEntityIDValue)
+		return ok && value == o
+	case GlobeCoordinateValue:
+		o, ok := other.Value.(GlobeCoordinateValue)
+		return ok && value.Latitude == o.Latitude && value.Longitude == o.Longitude &&
+			value.Precision == o.Precision && NormalizeEntityURI(value.Globe) == NormalizeEntityURI(o.Globe)
+	case MonolingualTextValue:
+		o, ok := other.Value.(MonolingualTextValue)
+		return ok && value == o
+	case QuantityValue:
+		o, ok := other.Value.(QuantityValue)
+		return ok && value.Amount.Cmp(&o.Amount.Rat) == 0 && amountsEqual(value.UpperBound, o.UpperBound) &&
+			amountsEqual(value.LowerBound, o.LowerBound) && NormalizeEntityURI(value.Unit) == NormalizeEntityURI(o.Unit)
+	case TimeValue:
+		o, ok := other.Value.(TimeValue)
+		return ok && value.Precision == o.Precision && value.Calendar == o.Calendar &&
+			formatTime(value.Time, value.Precision) == formatTime(o.Time, o.Precision)
+	}
+	return false
+}
+
+func amountsEqual(a, b *Amount) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Cmp(&b.Rat) == 0
+}
+
+// Equal compares snaks ignoring their hashes.
+//
+// Data types are compared only if both are set because
+// snaks in qualifiers and references might not have them.
+func (s Snak) Equal(other Snak) bool {
+	if s.SnakType != other.SnakType || s.Property != other.Property {
+		return false
+	}
+	if s.DataType != nil && other.DataType != nil && *s.DataType != *other.DataType {
+		return false
+	}
+	if s.DataValue == nil || other.DataValue == nil {
+		return s.DataValue == other.DataValue
+	}
+	return s.DataValue.Equal(*other.DataValue)
+}
+
+// unorderedEqual compares slices as multisets.
+func unorderedEqual[T any](a, b []T, equal func(T, T) bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	used := make([]bool, len(b))
+OUTER:
+	for _, x := range a {
+		for i, y := range b {
+			if !used[i] && equal(x, y) {
+				used[i] = true
+				continue OUTER
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func snakMapsEqual(a, b map[string][]Snak) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for property, as := range a {
+		bs, ok := b[property]
+		if !ok || !unorderedEqual(as, bs, Snak.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal compares references ignoring their hashes and the order of snaks,
+// the same as Wikibase does.
+func (r Reference) Equal(other Reference) bool {
+	return snakMapsEqual(r.Snaks, other.Snaks)
+}
+
+// Equal compares statements ignoring the order of qualifiers and references,
+// the same as Wikibase does. Statement IDs and ranks are compared as well.
+func (s Statement) Equal(other Statement) bool {
+	return s.ID == other.ID && s.Type == other.Type && s.Rank == other.Rank &&
+		s.MainSnak.Equal(other.MainSnak) && snakMapsEqual(s.Qualifiers, other.Qualifiers) &&
+		unorderedEqual(s.References, other.References, Reference.Equal)
+}
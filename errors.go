@@ -10,4 +10,8 @@ var (
 	ErrNotFound       = errors.Base("not found")
 	ErrJSONDecode     = errors.Base("cannot decode json")
 	ErrSQLParse       = errors.Base("cannot parse SQL")
+	ErrXMLDecode      = errors.Base("cannot decode xml")
+
+	ErrChecksumMismatch = errors.Base("checksum mismatch")
+	ErrUnauthorized     = errors.Base("unauthorized")
 )
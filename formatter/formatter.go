@@ -0,0 +1,408 @@
+// Package formatter renders Wikidata snaks and statements in a human-readable way.
+package formatter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+const (
+	wikidataEntityPrefix = "http://www.wikidata.org/entity/"
+	// Language code Wikidata uses for labels valid in all languages.
+	multipleLanguages = "mul"
+)
+
+// locale holds language-specific formatting rules.
+type locale struct {
+	months           [12]string
+	decimalSeparator string
+	groupSeparator   string
+	dayMonthYear     func(day int, month, year string) string
+	monthYear        func(month, year string) string
+	bce              func(year string) string
+	years            func(years string) string
+	decade           func(decade string) string
+	century          func(century int) string
+	millennium       func(millennium int) string
+	unknownValue     string
+	noValue          string
+	gregorian        string
+	julian           string
+}
+
+var locales = map[string]*locale{ //nolint:gochecknoglobals
+	"en": {
+		months: [12]string{
+			"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November", "December",
+		},
+		decimalSeparator: ".",
+		groupSeparator:   ",",
+		dayMonthYear:     func(day int, month, year string) string { return fmt.Sprintf("%d %s %s", day, month, year) },
+		monthYear:        func(month, year string) string { return month + " " + year },
+		bce:              func(year string) string { return year + " BCE" },
+		years:            func(years string) string { return years + " years" },
+		decade:           func(decade string) string { return decade + "s" },
+		century:          func(century int) string { return englishOrdinal(century) + " century" },
+		millennium:       func(millennium int) string { return englishOrdinal(millennium) + " millennium" },
+		unknownValue:     "unknown value",
+		noValue:          "no value",
+		gregorian:        "Gregorian",
+		julian:           "Julian",
+	},
+	"de": {
+		months: [12]string{
+			"Januar", "Februar", "März", "April", "Mai", "Juni",
+			"Juli", "August", "September", "Oktober", "November", "Dezember",
+		},
+		decimalSeparator: ",",
+		groupSeparator:   ".",
+		dayMonthYear:     func(day int, month, year string) string { return fmt.Sprintf("%d. %s %s", day, month, year) },
+		monthYear:        func(month, year string) string { return month + " " + year },
+		bce:              func(year string) string { return year + " v. Chr." },
+		years:            func(years string) string { return years + " Jahre" },
+		decade:           func(decade string) string { return decade + "er" },
+		century:          func(century int) string { return strconv.Itoa(century) + ". Jahrhundert" },
+		millennium:       func(millennium int) string { return strconv.Itoa(millennium) + ". Jahrtausend" },
+		unknownValue:     "unbekannter Wert",
+		noValue:          "kein Wert",
+		gregorian:        "gregorianisch",
+		julian:           "julianisch",
+	},
+	"fr": {
+		months: [12]string{
+			"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre", "décembre",
+		},
+		decimalSeparator: ",",
+		groupSeparator:   "\u202f",
+		dayMonthYear: func(day int, month, year string) string {
+			if day == 1 {
+				return "1er " + month + " " + year
+			}
+			return fmt.Sprintf("%d %s %s", day, month, year)
+		},
+		monthYear:    func(month, year string) string { return month + " " + year },
+		bce:          func(year string) string { return year + " av. J.-C." },
+		years:        func(years string) string { return years + " ans" },
+		decade:       func(decade string) string { return "années " + decade },
+		century:      func(century int) string { return romanNumeral(century) + "e siècle" },
+		millennium:   func(millennium int) string { return romanNumeral(millennium) + "e millénaire" },
+		unknownValue: "valeur inconnue",
+		noValue:      "aucune valeur",
+		gregorian:    "grégorien",
+		julian:       "julien",
+	},
+	"es": {
+		months: [12]string{
+			"enero", "febrero", "marzo", "abril", "mayo", "junio",
+			"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre",
+		},
+		decimalSeparator: ",",
+		groupSeparator:   ".",
+		dayMonthYear:     func(day int, month, year string) string { return fmt.Sprintf("%d de %s de %s", day, month, year) },
+		monthYear:        func(month, year string) string { return month + " de " + year },
+		bce:              func(year string) string { return year + " a. C." },
+		years:            func(years string) string { return years + " años" },
+		decade:           func(decade string) string { return "década de " + decade },
+		century:          func(century int) string { return "siglo " + romanNumeral(century) },
+		millennium:       func(millennium int) string { return romanNumeral(millennium) + " milenio" },
+		unknownValue:     "valor desconocido",
+		noValue:          "sin valor",
+		gregorian:        "gregoriano",
+		julian:           "juliano",
+	},
+}
+
+func englishOrdinal(n int) string {
+	suffix := "th"
+	switch n % 100 { //nolint:mnd
+	case 11, 12, 13: //nolint:mnd
+	default:
+		switch n % 10 { //nolint:mnd
+		case 1:
+			suffix = "st"
+		case 2: //nolint:mnd
+			suffix = "nd"
+		case 3: //nolint:mnd
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+func romanNumeral(n int) string {
+	if n <= 0 || n >= 4000 { //nolint:mnd
+		return strconv.Itoa(n)
+	}
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var b strings.Builder
+	for i, v := range values {
+		for n >= v {
+			b.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return b.String()
+}
+
+// Formatter renders snaks and statements in a human-readable
+// way, similar to how Wikidata's user interface shows them.
+//
+// Language selects both the language of labels and number and date formats.
+// Number and date formats are available for "en", "de", "fr", and "es", for
+// other languages English formats are used. Labels are resolved using Labels,
+// if set, otherwise or when a label is missing entity IDs are shown.
+type Formatter struct {
+	Language string
+	Labels   mediawiki.LabelResolver
+}
+
+func (f *Formatter) locale() *locale {
+	if l, ok := locales[f.Language]; ok {
+		return l
+	}
+	return locales["en"]
+}
+
+// Label returns the label of the entity, or the ID itself if there is no label.
+func (f *Formatter) Label(id string) string {
+	if f.Labels != nil {
+		if label, ok := f.Labels.Label(id, f.Language); ok {
+			return label
+		}
+		if label, ok := f.Labels.Label(id, multipleLanguages); ok {
+			return label
+		}
+	}
+	return id
+}
+
+// FormatNumber formats the decimal number string (as returned by Amount.String)
+// with the locale's group and decimal separators.
+func (f *Formatter) FormatNumber(number string) string {
+	l := f.locale()
+	sign := ""
+	if strings.HasPrefix(number, "-") || strings.HasPrefix(number, "+") {
+		if number[0] == '-' {
+			sign = "-"
+		}
+		number = number[1:]
+	}
+	integer, fraction, hasFraction := strings.Cut(number, ".")
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(l.groupSeparator)
+		}
+		b.WriteRune(c)
+	}
+	if hasFraction {
+		b.WriteString(l.decimalSeparator)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// FormatTime formats the time value according to its precision and calendar.
+func (f *Formatter) FormatTime(value mediawiki.TimeValue) string {
+	l := f.locale()
+	t := value.Time.UTC()
+	year := int64(t.Year())
+	bce := false
+	if year < 1 {
+		// Go uses astronomical numbering, we convert it to historical numbering.
+		bce = true
+		year = 1 - year
+	}
+	withEra := func(s string) string {
+		if bce {
+			return l.bce(s)
+		}
+		return s
+	}
+	yearString := strconv.FormatInt(year, 10)
+
+	var s string
+	switch {
+	case value.Precision >= mediawiki.Day:
+		s = withEra(l.dayMonthYear(t.Day(), l.months[t.Month()-1], yearString))
+		switch value.Precision { //nolint:exhaustive
+		case mediawiki.Hour:
+			s += fmt.Sprintf(" %02d:00", t.Hour())
+		case mediawiki.Minute:
+			s += fmt.Sprintf(" %02d:%02d", t.Hour(), t.Minute())
+		case mediawiki.Second:
+			s += fmt.Sprintf(" %02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
+		}
+	case value.Precision == mediawiki.Month:
+		s = withEra(l.monthYear(l.months[t.Month()-1], yearString))
+	case value.Precision == mediawiki.Year:
+		s = withEra(yearString)
+	case value.Precision == mediawiki.Decade:
+		s = withEra(l.decade(strconv.FormatInt(year/10*10, 10))) //nolint:mnd
+	case value.Precision == mediawiki.Century:
+		s = withEra(l.century(int((year + 99) / 100))) //nolint:mnd
+	case value.Precision == mediawiki.Millennium:
+		s = withEra(l.millennium(int((year + 999) / 1000))) //nolint:mnd
+	default:
+		// TenMillenniums has precision 5, every lower precision is one more power of ten.
+		unit := int64(math.Pow10(int(mediawiki.Millennium-value.Precision) + 3)) //nolint:mnd
+		rounded := (year + unit/2) / unit * unit                                 //nolint:mnd
+		s = withEra(l.years(f.FormatNumber(strconv.FormatInt(rounded, 10))))
+	}
+
+	if value.Precision >= mediawiki.Month {
+		switch value.Calendar {
+		case mediawiki.Gregorian:
+			s += " (" + l.gregorian + ")"
+		case mediawiki.Julian:
+			s += " (" + l.julian + ")"
+		}
+	}
+	return s
+}
+
+// FormatQuantity formats the quantity value with its bounds and unit.
+func (f *Formatter) FormatQuantity(value mediawiki.QuantityValue) string {
+	var b strings.Builder
+	b.WriteString(f.FormatNumber(value.Amount.String()))
+	if value.UpperBound != nil && value.LowerBound != nil {
+		upper := new(big.Rat).Sub(&value.UpperBound.Rat, &value.Amount.Rat)
+		lower := new(big.Rat).Sub(&value.Amount.Rat, &value.LowerBound.Rat)
+		if upper.Cmp(lower) == 0 {
+			if upper.Sign() != 0 {
+				b.WriteString(" ±")
+				b.WriteString(f.FormatNumber((&mediawiki.Amount{Rat: *upper}).String()))
+			}
+		} else {
+			b.WriteString(" +")
+			b.WriteString(f.FormatNumber((&mediawiki.Amount{Rat: *upper}).String()))
+			b.WriteString("/−")
+			b.WriteString(f.FormatNumber((&mediawiki.Amount{Rat: *lower}).String()))
+		}
+	}
+	if value.Unit != "" && value.Unit != "1" {
+		b.WriteString(" ")
+		b.WriteString(f.Label(strings.TrimPrefix(mediawiki.NormalizeEntityURI(value.Unit), wikidataEntityPrefix)))
+	}
+	return b.String()
+}
+
+// formatDegrees formats an angle in degrees, minutes, and seconds,
+// with the detail based on precision (in degrees).
+func formatDegrees(angle, precision float64, positive, negative string) string {
+	hemisphere := positive
+	if angle < 0 {
+		hemisphere = negative
+		angle = -angle
+	}
+	if precision <= 0 {
+		precision = 1.0 / 3600 //nolint:mnd
+	}
+	switch {
+	case precision >= 1:
+		return fmt.Sprintf("%d°%s", int64(math.Round(angle)), hemisphere)
+	case precision >= 1.0/60: //nolint:mnd
+		minutes := int64(math.Round(angle * 60)) //nolint:mnd
+		return fmt.Sprintf("%d°%d'%s", minutes/60, minutes%60, hemisphere)
+	}
+	// Number of decimal places of seconds needed for the precision.
+	decimals := max(0, int(math.Ceil(-math.Log10(precision*3600)))) //nolint:mnd
+	scale := math.Pow10(decimals)
+	total := math.Round(angle*3600*scale) / scale //nolint:mnd
+	degrees := int64(total / 3600)                //nolint:mnd
+	minutes := int64(total/60) % 60               //nolint:mnd
+	seconds := total - float64(degrees*3600+minutes*60)
+	return fmt.Sprintf("%d°%d'%s\"%s", degrees, minutes, strconv.FormatFloat(seconds, 'f', decimals, 64), hemisphere)
+}
+
+// FormatGlobeCoordinate formats the coordinate in degrees, minutes, and seconds.
+// For globes other than Earth, the globe is appended.
+func (f *Formatter) FormatGlobeCoordinate(value mediawiki.GlobeCoordinateValue) string {
+	s := formatDegrees(value.Latitude, value.Precision, "N", "S") + ", " +
+		formatDegrees(value.Longitude, value.Precision, "E", "W")
+	globe := mediawiki.NormalizeEntityURI(value.Globe)
+	if globe != "" && globe != mediawiki.GlobeEarth {
+		s += " (" + f.Label(strings.TrimPrefix(globe, wikidataEntityPrefix)) + ")"
+	}
+	return s
+}
+
+// FormatValue formats the data value.
+func (f *Formatter) FormatValue(value mediawiki.DataValue) string {
+	switch v := value.Value.(type) {
+	case mediawiki.ErrorValue:
+		return "error: " + string(v)
+	case mediawiki.StringValue:
+		return string(v)
+	case mediawiki.WikiBaseEntityIDValue:
+		return f.Label(v.ID)
+	case mediawiki.GlobeCoordinateValue:
+		return f.FormatGlobeCoordinate(v)
+	case mediawiki.MonolingualTextValue:
+		return v.Text + " (" + v.Language + ")"
+	case mediawiki.QuantityValue:
+		return f.FormatQuantity(v)
+	case mediawiki.TimeValue:
+		return f.FormatTime(v)
+	}
+	return fmt.Sprintf("%v", value.Value)
+}
+
+// FormatSnakValue formats only the value of the snak, handling
+// also "unknown value" and "no value" snaks.
+func (f *Formatter) FormatSnakValue(snak mediawiki.Snak) string {
+	switch snak.SnakType {
+	case mediawiki.SomeValue:
+		return f.locale().unknownValue
+	case mediawiki.NoValue:
+		return f.locale().noValue
+	case mediawiki.Value:
+	}
+	if snak.DataValue == nil {
+		return ""
+	}
+	return f.FormatValue(*snak.DataValue)
+}
+
+// FormatSnak formats the snak as "property: value".
+func (f *Formatter) FormatSnak(snak mediawiki.Snak) string {
+	return f.Label(snak.Property) + ": " + f.FormatSnakValue(snak)
+}
+
+// FormatStatement formats the statement's main snak followed by
+// qualifiers in parentheses, e.g., "population: 1,234,567 ±5 (point in time: 2020)".
+func (f *Formatter) FormatStatement(statement mediawiki.Statement) string {
+	s := f.FormatSnak(statement.MainSnak)
+	qualifiers := f.FormatQualifiers(statement.Qualifiers, statement.QualifiersOrder)
+	if qualifiers != "" {
+		s += " (" + qualifiers + ")"
+	}
+	return s
+}
+
+// FormatQualifiers formats qualifiers in the given order, with properties
+// missing from the order formatted at the end. Multiple values for the same
+// property are comma separated and qualifiers for different properties are
+// semicolon separated.
+func (f *Formatter) FormatQualifiers(qualifiers map[string][]mediawiki.Snak, order []string) string {
+	properties := mediawiki.OrderedProperties(qualifiers, order)
+
+	parts := make([]string, 0, len(properties))
+	for _, property := range properties {
+		values := make([]string, 0, len(qualifiers[property]))
+		for _, snak := range qualifiers[property] {
+			values = append(values, f.FormatSnakValue(snak))
+		}
+		parts = append(parts, f.Label(property)+": "+strings.Join(values, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,156 @@
+package formatter_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/go/mediawiki/formatter"
+)
+
+func formatterTestResolver(t *testing.T) *mediawiki.LabelStore {
+	t.Helper()
+
+	resolver := &mediawiki.LabelStore{Languages: []string{"en", "de", "mul"}}
+	for id, labels := range map[string]map[string]string{
+		"P569":   {"en": "date of birth", "de": "Geburtsdatum"},
+		"P1082":  {"en": "population", "de": "Einwohnerzahl"},
+		"P585":   {"en": "point in time", "de": "Zeitpunkt"},
+		"P2048":  {"en": "height"},
+		"Q11573": {"en": "metre", "de": "Meter"},
+		"Q405":   {"en": "Moon", "fr": "Lune"},
+		"Q42":    {"mul": "Douglas Adams"},
+	} {
+		entity := mediawiki.Entity{ID: id, Labels: map[string]mediawiki.LanguageValue{}}
+		for language, label := range labels {
+			entity.Labels[language] = mediawiki.LanguageValue{Language: language, Value: label}
+		}
+		errE := resolver.Add(context.Background(), entity)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	return resolver
+}
+
+func parseDataValue(t *testing.T, data string) mediawiki.DataValue {
+	t.Helper()
+
+	var value mediawiki.DataValue
+	err := json.Unmarshal([]byte(data), &value)
+	require.NoError(t, err)
+	return value
+}
+
+func TestFormatterTime(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		language  string
+		time      string
+		precision int
+		calendar  string
+		expected  string
+	}{
+		{"en", "+1952-03-11T00:00:00Z", 11, "Q1985727", "11 March 1952 (Gregorian)"},
+		{"de", "+1952-03-11T00:00:00Z", 11, "Q1985727", "11. März 1952 (gregorianisch)"},
+		{"fr", "+1952-03-01T00:00:00Z", 11, "Q1985727", "1er mars 1952 (grégorien)"},
+		{"es", "+1952-03-11T00:00:00Z", 11, "Q1985786", "11 de marzo de 1952 (juliano)"},
+		{"en", "+1952-03-00T00:00:00Z", 10, "Q1985727", "March 1952 (Gregorian)"},
+		{"en", "+2020-00-00T00:00:00Z", 9, "Q1985727", "2020"},
+		{"en", "-0044-00-00T00:00:00Z", 9, "Q1985786", "44 BCE"},
+		{"en", "+1950-00-00T00:00:00Z", 8, "Q1985727", "1950s"},
+		{"en", "+1952-00-00T00:00:00Z", 7, "Q1985727", "20th century"},
+		{"fr", "+1952-00-00T00:00:00Z", 7, "Q1985727", "XXe siècle"},
+		{"de", "-0150-00-00T00:00:00Z", 7, "Q1985727", "2. Jahrhundert v. Chr."},
+		{"en", "+2000-00-00T00:00:00Z", 6, "Q1985727", "2nd millennium"},
+		{"en", "-13798000000-00-00T00:00:00Z", 3, "Q1985727", "13,798,000,000 years BCE"},
+		{"en", "+1994-01-01T14:05:00Z", 13, "Q1985727", "1 January 1994 14:05 (Gregorian)"},
+	}
+	for _, test := range tests {
+		t.Run(test.language+test.time, func(t *testing.T) {
+			t.Parallel()
+
+			value := parseDataValue(t, `{"type":"time","value":{"time":"`+test.time+`","precision":`+strconv.Itoa(test.precision)+
+				`,"calendarmodel":"http://www.wikidata.org/entity/`+test.calendar+`"}}`)
+			f := formatter.Formatter{Language: test.language}
+			assert.Equal(t, test.expected, f.FormatValue(value))
+		})
+	}
+}
+
+func TestFormatterQuantity(t *testing.T) {
+	t.Parallel()
+
+	resolver := formatterTestResolver(t)
+
+	value := parseDataValue(t, `{"type":"quantity","value":{"amount":"+1234567","upperBound":"+1234572","lowerBound":"+1234562","unit":"1"}}`)
+	f := formatter.Formatter{Language: "en", Labels: resolver}
+	assert.Equal(t, "1,234,567 ±5", f.FormatValue(value))
+	f = formatter.Formatter{Language: "fr", Labels: resolver}
+	assert.Equal(t, "1 234 567 ±5", f.FormatValue(value))
+
+	value = parseDataValue(t, `{"type":"quantity","value":{"amount":"+1234.5","upperBound":"+1235","lowerBound":"+1234","unit":"http://www.wikidata.org/entity/Q11573"}}`)
+	f = formatter.Formatter{Language: "de", Labels: resolver}
+	assert.Equal(t, "1.234,5 ±0,5 Meter", f.FormatValue(value))
+
+	value = parseDataValue(t, `{"type":"quantity","value":{"amount":"-10","upperBound":"-8","lowerBound":"-11","unit":"http://www.wikidata.org/entity/Q11573"}}`)
+	f = formatter.Formatter{Language: "en", Labels: resolver}
+	assert.Equal(t, "-10 +2/−1 metre", f.FormatValue(value))
+}
+
+func TestFormatterGlobeCoordinate(t *testing.T) {
+	t.Parallel()
+
+	resolver := formatterTestResolver(t)
+	f := formatter.Formatter{Language: "en", Labels: resolver}
+
+	value := parseDataValue(t, `{"type":"globecoordinate","value":{"latitude":46.05,"longitude":14.5083,"precision":0.0001,"globe":"http://www.wikidata.org/entity/Q2"}}`)
+	assert.Equal(t, `46°3'0.0"N, 14°30'29.9"E`, f.FormatValue(value))
+
+	value = parseDataValue(t, `{"type":"globecoordinate","value":{"latitude":-46.5,"longitude":-14.25,"precision":0.016666666666666666,"globe":"http://www.wikidata.org/entity/Q405"}}`)
+	assert.Equal(t, `46°30'S, 14°15'W (Moon)`, f.FormatValue(value))
+
+	value = parseDataValue(t, `{"type":"globecoordinate","value":{"latitude":10.4,"longitude":20.6,"precision":1,"globe":"http://www.wikidata.org/entity/Q111"}}`)
+	assert.Equal(t, `10°N, 21°E (Q111)`, f.FormatValue(value))
+}
+
+func TestFormatterStatement(t *testing.T) {
+	t.Parallel()
+
+	resolver := formatterTestResolver(t)
+
+	var statement mediawiki.Statement
+	err := json.Unmarshal([]byte(`{
+		"id": "Q1$1",
+		"type": "statement",
+		"rank": "normal",
+		"mainsnak": {
+			"snaktype": "value",
+			"property": "P1082",
+			"datavalue": {"type":"quantity","value":{"amount":"+1234567","upperBound":"+1234572","lowerBound":"+1234562","unit":"1"}}
+		},
+		"qualifiers": {
+			"P585": [{"snaktype":"value","property":"P585","datavalue":{"type":"time","value":{"time":"+2020-00-00T00:00:00Z","precision":9,"calendarmodel":"http://www.wikidata.org/entity/Q1985727"}}}],
+			"P2048": [{"snaktype":"somevalue","property":"P2048"},{"snaktype":"novalue","property":"P2048"}]
+		},
+		"qualifiers-order": ["P585"]
+	}`), &statement)
+	require.NoError(t, err)
+
+	f := formatter.Formatter{Language: "en", Labels: resolver}
+	assert.Equal(t, "population: 1,234,567 ±5 (point in time: 2020; height: unknown value, no value)", f.FormatStatement(statement))
+
+	f = formatter.Formatter{Language: "de", Labels: resolver}
+	assert.Equal(t, "Einwohnerzahl: 1.234.567 ±5 (Zeitpunkt: 2020; P2048: unbekannter Wert, kein Wert)", f.FormatStatement(statement))
+
+	f = formatter.Formatter{Language: "en", Labels: resolver}
+	assert.Equal(t, "P31: Douglas Adams", f.FormatSnak(mediawiki.Snak{
+		SnakType:  mediawiki.Value,
+		Property:  "P31",
+		DataValue: &mediawiki.DataValue{Value: mediawiki.WikiBaseEntityIDValue{Type: mediawiki.ItemType, ID: "Q42"}},
+	}))
+}
@@ -0,0 +1,359 @@
+package mediawiki
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// Globe URIs as used in GlobeCoordinateValue.Globe.
+const (
+	GlobeEarth = "http://www.wikidata.org/entity/Q2"
+	GlobeMoon  = "http://www.wikidata.org/entity/Q405"
+	GlobeMars  = "http://www.wikidata.org/entity/Q111"
+)
+
+const (
+	// CoordinateLocationProperty is Wikidata's "coordinate location" property.
+	CoordinateLocationProperty = "P625"
+	// GeoShapeProperty is Wikidata's "geoshape" property.
+	GeoShapeProperty = "P3896"
+
+	commonsDataURL = "https://commons.wikimedia.org/wiki/"
+)
+
+// Mean radii of globes in meters. Used to convert precision in
+// degrees to an uncertainty radius.
+var globeRadii = map[string]float64{ //nolint:gochecknoglobals
+	GlobeEarth: 6371008.8, //nolint:mnd
+	GlobeMoon:  1737400,   //nolint:mnd
+	GlobeMars:  3389500,   //nolint:mnd
+}
+
+// GlobeRadius returns the mean radius in meters of a globe with the
+// given URI. It returns false if the globe is not known.
+func GlobeRadius(globe string) (float64, bool) {
+	r, ok := globeRadii[NormalizeEntityURI(globe)]
+	return r, ok
+}
+
+// NormalizeEntityURI normalizes https and wiki/ variants of Wikidata
+// entity URIs to the canonical http://www.wikidata.org/entity/ form,
+// which is used for units and globes in Wikidata JSON dumps.
+// E.g., "https://www.wikidata.org/wiki/Q11573" becomes
+// "http://www.wikidata.org/entity/Q11573". Other URIs are returned unchanged.
+func NormalizeEntityURI(uri string) string {
+	for _, prefix := range []string{
+		"https://www.wikidata.org/entity/",
+		"https://www.wikidata.org/wiki/",
+		"http://www.wikidata.org/wiki/",
+	} {
+		if strings.HasPrefix(uri, prefix) {
+			return "http://www.wikidata.org/entity/" + strings.TrimPrefix(uri, prefix)
+		}
+	}
+	return uri
+}
+
+// UncertaintyRadius returns the radius in meters which corresponds to
+// the precision (in degrees) of the coordinate on its globe.
+//
+// It returns false if precision is not set or the globe is not known.
+func (v GlobeCoordinateValue) UncertaintyRadius() (float64, bool) {
+	if v.Precision <= 0 {
+		return 0, false
+	}
+	r, ok := GlobeRadius(v.Globe)
+	if !ok {
+		return 0, false
+	}
+	return v.Precision * math.Pi / 180 * r, true //nolint:mnd
+}
+
+// GeoJSONGeometry is a GeoJSON geometry object.
+//
+// Coordinates are in GeoJSON order, i.e., longitude before latitude.
+// Geometries is used only by GeometryCollection type.
+type GeoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates,omitempty"`
+	Geometries  []GeoJSONGeometry `json:"geometries,omitempty"`
+}
+
+// GeoJSONFeature is a GeoJSON feature object.
+//
+// Geometry is nil when the entity has only geo-shape values which have not
+// been resolved. In that case properties contain links to geo-shapes.
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   *GeoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONConfig configures which entities and which data end up in GeoJSON features.
+//
+// Only coordinates on Globe are used. Default is GlobeEarth. To export coordinates
+// on other globes (e.g., GlobeMoon or GlobeMars), use a separate GeoJSONWriter for each.
+// GeoJSON (RFC 7946) is defined only for Earth so features for other globes should be
+// written into separate files.
+//
+// CoordinateProperty defaults to CoordinateLocationProperty. Language selects the label
+// stored as "label" property. Properties lists additional claims to be stored as
+// properties, keyed by property ID. IncludeGeoShapes enables features for entities with
+// GeoShapeProperty claims but no coordinates. If CommonsData is set, geo-shapes are resolved
+// and their geometries are used as a GeometryCollection.
+type GeoJSONConfig struct {
+	Globe              string
+	CoordinateProperty string
+	Language           string
+	Properties         []string
+	IncludeGeoShapes   bool
+	CommonsData        *CommonsData
+}
+
+// EntityGeoJSONFeature converts an entity to a GeoJSON feature.
+//
+// It uses best ranked statements for the coordinate property. If there are
+// multiple coordinates, a MultiPoint geometry is used. The "uncertainty" property
+// holds the largest uncertainty radius in meters, if known.
+//
+// It returns false if the entity has no coordinates (or geo-shapes, if enabled) on the
+// configured globe.
+func EntityGeoJSONFeature(entity Entity, config *GeoJSONConfig) (GeoJSONFeature, bool) {
+	globe := config.Globe
+	if globe == "" {
+		globe = GlobeEarth
+	}
+	globe = NormalizeEntityURI(globe)
+	property := config.CoordinateProperty
+	if property == "" {
+		property = CoordinateLocationProperty
+	}
+
+	coordinates := [][2]float64{}
+	uncertainty := 0.0
+	hasUncertainty := false
+	for _, statement := range bestStatements(entity.Claims[property]) {
+		if statement.MainSnak.DataValue == nil {
+			continue
+		}
+		value, ok := statement.MainSnak.DataValue.Value.(GlobeCoordinateValue)
+		if !ok {
+			continue
+		}
+		if NormalizeEntityURI(value.Globe) != globe {
+			continue
+		}
+		coordinates = append(coordinates, [2]float64{value.Longitude, value.Latitude})
+		if r, ok := value.UncertaintyRadius(); ok {
+			hasUncertainty = true
+			uncertainty = math.Max(uncertainty, r)
+		}
+	}
+
+	properties := map[string]interface{}{
+		"entity": entity.ID,
+	}
+	if config.Language != "" {
+		if label, ok := entity.Labels[config.Language]; ok {
+			properties["label"] = label.Value
+		}
+	}
+	for _, p := range config.Properties {
+		values := []interface{}{}
+		for _, statement := range bestStatements(entity.Claims[p]) {
+			if statement.MainSnak.DataValue == nil {
+				continue
+			}
+			if v := plainValue(statement.MainSnak.DataValue.Value); v != nil {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			properties[p] = values
+		}
+	}
+
+	var geometry *GeoJSONGeometry
+	switch len(coordinates) {
+	case 0:
+		if !config.IncludeGeoShapes || globe != GlobeEarth {
+			return GeoJSONFeature{}, false
+		}
+		shapes := []interface{}{}
+		geometries := []GeoJSONGeometry{}
+		for _, statement := range bestStatements(entity.Claims[GeoShapeProperty]) {
+			if statement.MainSnak.DataValue == nil {
+				continue
+			}
+			value, ok := statement.MainSnak.DataValue.Value.(StringValue)
+			if !ok {
+				continue
+			}
+			shapes = append(shapes, commonsDataURL+strings.ReplaceAll(normalizeDataTitle(string(value)), " ", "_"))
+			if config.CommonsData != nil {
+				if data, errE := config.CommonsData.GeoShape(string(value)); errE == nil {
+					if g, errE := data.Geometries(); errE == nil {
+						geometries = append(geometries, g...)
+					}
+				}
+			}
+		}
+		if len(shapes) == 0 {
+			return GeoJSONFeature{}, false
+		}
+		properties["geoshape"] = shapes
+		if len(geometries) > 0 {
+			geometry = &GeoJSONGeometry{Type: "GeometryCollection", Geometries: geometries}
+		}
+	case 1:
+		c, _ := x.MarshalWithoutEscapeHTML(coordinates[0])
+		geometry = &GeoJSONGeometry{Type: "Point", Coordinates: c}
+	default:
+		c, _ := x.MarshalWithoutEscapeHTML(coordinates)
+		geometry = &GeoJSONGeometry{Type: "MultiPoint", Coordinates: c}
+	}
+	if hasUncertainty {
+		properties["uncertainty"] = uncertainty
+	}
+
+	return GeoJSONFeature{
+		Type:       "Feature",
+		ID:         entity.ID,
+		Geometry:   geometry,
+		Properties: properties,
+	}, true
+}
+
+// bestStatements returns preferred statements if there are any,
+// otherwise normal statements. Deprecated statements are never returned.
+func bestStatements(statements []Statement) []Statement {
+	preferred := []Statement{}
+	normal := []Statement{}
+	for _, statement := range statements {
+		switch statement.Rank {
+		case Preferred:
+			preferred = append(preferred, statement)
+		case Normal:
+			normal = append(normal, statement)
+		case Deprecated:
+		}
+	}
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return normal
+}
+
+// plainValue converts a data value to a simple JSON-friendly Go value.
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case StringValue:
+		return string(v)
+	case WikiBaseEntityIDValue:
+		return v.ID
+	case MonolingualTextValue:
+		return v.Text
+	case QuantityValue:
+		return json.Number(v.Amount.String())
+	case TimeValue:
+		return formatTime(v.Time, v.Precision)
+	case GlobeCoordinateValue:
+		return []float64{v.Longitude, v.Latitude}
+	}
+	return nil
+}
+
+// GeoJSONWriter streams GeoJSON features into a FeatureCollection.
+//
+// It is safe to call WriteEntity concurrently, e.g., directly
+// from a ProcessWikidataDump callback. Close must be called at
+// the end to finish the FeatureCollection.
+type GeoJSONWriter struct {
+	config  GeoJSONConfig
+	mu      sync.Mutex
+	writer  io.Writer
+	started bool
+	closed  bool
+}
+
+// NewGeoJSONWriter returns a new GeoJSONWriter which writes to w.
+func NewGeoJSONWriter(w io.Writer, config *GeoJSONConfig) *GeoJSONWriter {
+	return &GeoJSONWriter{
+		config: *config,
+		writer: w,
+	}
+}
+
+// WriteEntity writes the entity as a feature, if it has coordinates.
+//
+// Its signature matches the callback of ProcessWikidataDump so it can be passed to it directly.
+func (w *GeoJSONWriter) WriteEntity(_ context.Context, entity Entity) errors.E {
+	feature, ok := EntityGeoJSONFeature(entity, &w.config)
+	if !ok {
+		return nil
+	}
+	return w.WriteFeature(feature)
+}
+
+// WriteFeature writes the feature.
+func (w *GeoJSONWriter) WriteFeature(feature GeoJSONFeature) errors.E {
+	data, errE := x.MarshalWithoutEscapeHTML(feature)
+	if errE != nil {
+		return errE
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("writer closed")
+	}
+
+	var prefix string
+	if w.started {
+		prefix = ",\n"
+	} else {
+		prefix = `{"type":"FeatureCollection","features":[` + "\n"
+		w.started = true
+	}
+	_, err := io.WriteString(w.writer, prefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = w.writer.Write(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Close finishes the FeatureCollection. It does not close the underlying writer.
+func (w *GeoJSONWriter) Close() errors.E {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	var data string
+	if w.started {
+		data = "\n]}\n"
+	} else {
+		data = `{"type":"FeatureCollection","features":[]}` + "\n"
+	}
+	_, err := io.WriteString(w.writer, data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
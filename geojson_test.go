@@ -0,0 +1,147 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func coordinateStatement(id string, rank mediawiki.StatementRank, latitude, longitude, precision float64, globe string) mediawiki.Statement {
+	return mediawiki.Statement{
+		ID:   id,
+		Rank: rank,
+		MainSnak: mediawiki.Snak{
+			Property: mediawiki.CoordinateLocationProperty,
+			DataValue: &mediawiki.DataValue{
+				Value: mediawiki.GlobeCoordinateValue{
+					Latitude:  latitude,
+					Longitude: longitude,
+					Precision: precision,
+					Globe:     globe,
+				},
+			},
+		},
+	}
+}
+
+func TestEntityGeoJSONFeature(t *testing.T) {
+	t.Parallel()
+
+	entity := mediawiki.Entity{
+		ID: "Q1",
+		Labels: map[string]mediawiki.LanguageValue{
+			"en": {Language: "en", Value: "Example"},
+		},
+		Claims: map[string][]mediawiki.Statement{
+			mediawiki.CoordinateLocationProperty: {
+				coordinateStatement("Q1$1", mediawiki.Normal, 46.05, 14.5, 0.01, mediawiki.GlobeEarth),
+				coordinateStatement("Q1$2", mediawiki.Deprecated, 0, 0, 1, mediawiki.GlobeEarth),
+				coordinateStatement("Q1$3", mediawiki.Normal, 10, 20, 1, mediawiki.GlobeMoon),
+			},
+			"P31": {
+				{
+					ID:   "Q1$4",
+					Rank: mediawiki.Normal,
+					MainSnak: mediawiki.Snak{
+						Property:  "P31",
+						DataValue: &mediawiki.DataValue{Value: mediawiki.WikiBaseEntityIDValue{Type: mediawiki.ItemType, ID: "Q515"}},
+					},
+				},
+			},
+		},
+	}
+
+	feature, ok := mediawiki.EntityGeoJSONFeature(entity, &mediawiki.GeoJSONConfig{Language: "en", Properties: []string{"P31"}})
+	require.True(t, ok)
+	require.NotNil(t, feature.Geometry)
+	assert.Equal(t, "Point", feature.Geometry.Type)
+	assert.JSONEq(t, `[14.5,46.05]`, string(feature.Geometry.Coordinates))
+	assert.Equal(t, "Example", feature.Properties["label"])
+	assert.Equal(t, []interface{}{"Q515"}, feature.Properties["P31"])
+	assert.InDelta(t, 1111.95, feature.Properties["uncertainty"], 0.01)
+
+	feature, ok = mediawiki.EntityGeoJSONFeature(entity, &mediawiki.GeoJSONConfig{Globe: mediawiki.GlobeMoon})
+	require.True(t, ok)
+	assert.JSONEq(t, `[20,10]`, string(feature.Geometry.Coordinates))
+	assert.InDelta(t, 30323.35, feature.Properties["uncertainty"], 0.01)
+
+	_, ok = mediawiki.EntityGeoJSONFeature(entity, &mediawiki.GeoJSONConfig{Globe: mediawiki.GlobeMars})
+	assert.False(t, ok)
+}
+
+func TestEntityGeoJSONFeatureGeoShape(t *testing.T) {
+	t.Parallel()
+
+	entity := mediawiki.Entity{
+		ID: "Q2",
+		Claims: map[string][]mediawiki.Statement{
+			mediawiki.GeoShapeProperty: {
+				{
+					ID:   "Q2$1",
+					Rank: mediawiki.Normal,
+					MainSnak: mediawiki.Snak{
+						Property:  mediawiki.GeoShapeProperty,
+						DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue("Data:Some area.map")},
+					},
+				},
+			},
+		},
+	}
+
+	_, ok := mediawiki.EntityGeoJSONFeature(entity, &mediawiki.GeoJSONConfig{})
+	assert.False(t, ok)
+
+	feature, ok := mediawiki.EntityGeoJSONFeature(entity, &mediawiki.GeoJSONConfig{IncludeGeoShapes: true})
+	require.True(t, ok)
+	assert.Nil(t, feature.Geometry)
+	assert.Equal(t, []interface{}{"https://commons.wikimedia.org/wiki/Data:Some_area.map"}, feature.Properties["geoshape"])
+}
+
+func TestGeoJSONWriter(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	writer := mediawiki.NewGeoJSONWriter(&buffer, &mediawiki.GeoJSONConfig{})
+
+	for _, entity := range []mediawiki.Entity{
+		{ID: "Q1", Claims: map[string][]mediawiki.Statement{
+			mediawiki.CoordinateLocationProperty: {coordinateStatement("Q1$1", mediawiki.Normal, 1, 2, 0, mediawiki.GlobeEarth)},
+		}},
+		{ID: "Q2"},
+		{ID: "Q3", Claims: map[string][]mediawiki.Statement{
+			mediawiki.CoordinateLocationProperty: {
+				coordinateStatement("Q3$1", mediawiki.Preferred, 3, 4, 0, mediawiki.GlobeEarth),
+				coordinateStatement("Q3$2", mediawiki.Preferred, 5, 6, 0, mediawiki.GlobeEarth),
+			},
+		}},
+	} {
+		errE := writer.WriteEntity(context.Background(), entity)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE := writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	var collection struct {
+		Type     string                     `json:"type"`
+		Features []mediawiki.GeoJSONFeature `json:"features"`
+	}
+	err := json.Unmarshal(buffer.Bytes(), &collection)
+	require.NoError(t, err)
+	assert.Equal(t, "FeatureCollection", collection.Type)
+	require.Len(t, collection.Features, 2)
+	assert.Equal(t, "Q1", collection.Features[0].ID)
+	assert.Equal(t, "MultiPoint", collection.Features[1].Geometry.Type)
+	assert.JSONEq(t, `[[4,3],[6,5]]`, string(collection.Features[1].Geometry.Coordinates))
+
+	buffer.Reset()
+	writer = mediawiki.NewGeoJSONWriter(&buffer, &mediawiki.GeoJSONConfig{})
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"type":"FeatureCollection","features":[]}`, buffer.String())
+}
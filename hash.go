@@ -0,0 +1,333 @@
+package mediawiki
+
+import (
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// Hashes are computed the same as Wikibase computes them: from PHP serialization of
+// its data model objects. This makes snak and reference hashes comparable with
+// hash fields in Wikidata JSON.
+//
+// Wikibase hashes values as they are stored, so snak hashes use amounts and
+// timestamps (with timezone, before, and after) as they were in JSON, when known.
+// Canonical hashes of data values use their normalized form instead.
+
+const (
+	gregorianURI = "http://www.wikidata.org/entity/Q1985727"
+	julianURI    = "http://www.wikidata.org/entity/Q1985786"
+)
+
+// entityIDClasses maps entity types to PHP classes of their IDs.
+//
+// Property IDs use the legacy class name which Wikibase keeps for hashes to be stable.
+var entityIDClasses = map[WikiBaseEntityType]string{ //nolint:gochecknoglobals
+	ItemType:         `Wikibase\DataModel\Entity\ItemId`,
+	PropertyType:     `Wikibase\DataModel\Entity\PropertyId`,
+	LexemeType:       `Wikibase\Lexeme\Domain\Model\LexemeId`,
+	FormType:         `Wikibase\Lexeme\Domain\Model\FormId`,
+	SenseType:        `Wikibase\Lexeme\Domain\Model\SenseId`,
+	EntitySchemaType: `EntitySchema\Domain\Model\EntitySchemaId`,
+}
+
+// phpObject returns PHP serialization of a Serializable object.
+func phpObject(class, data string) string {
+	return fmt.Sprintf(`C:%d:"%s":%d:{%s}`, len(class), class, len(data), data)
+}
+
+// phpString returns PHP serialization of a string.
+func phpString(s string) string {
+	return fmt.Sprintf(`s:%d:"%s";`, len(s), s)
+}
+
+// phpInt returns PHP serialization of an integer.
+func phpInt(i int) string {
+	return fmt.Sprintf(`i:%d;`, i)
+}
+
+// phpArray returns PHP serialization of a list of already serialized values.
+func phpArray(values ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `a:%d:{`, len(values))
+	for i, value := range values {
+		b.WriteString(phpInt(i))
+		b.WriteString(value)
+	}
+	b.WriteString(`}`)
+	return b.String()
+}
+
+// phpJSONFloat formats a float the same as PHP's json_encode does.
+func phpJSONFloat(f float64) string {
+	if f == 0 {
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	// Shortest representation, e.g., "4.605e+01".
+	s := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exponent, _ := strings.Cut(s, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, _ := strconv.Atoi(exponent)
+	// Position of the decimal point relative to the start of digits.
+	point := exp + 1
+	if point < -3 || point > 17 { //nolint:mnd
+		rest := digits[1:]
+		if rest == "" {
+			rest = "0"
+		}
+		expSign := "+"
+		if exp < 0 {
+			expSign = "-"
+			exp = -exp
+		}
+		return fmt.Sprintf("%s%s.%se%s%d", sign, digits[:1], rest, expSign, exp)
+	}
+	if point <= 0 {
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	}
+	if point >= len(digits) {
+		return sign + digits + strings.Repeat("0", point-len(digits))
+	}
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// phpJSONString formats a string the same as PHP's json_encode does.
+func phpJSONString(s string) string {
+	var b strings.Builder
+	b.WriteString(`"`)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '/':
+			b.WriteString(`\/`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r > 0x7e: //nolint:mnd
+			for _, c := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
+
+// phpAmount returns PHP serialization of the amount. If stored is true, the amount
+// as it was in JSON is used, if known and still equal to the amount.
+func phpAmount(a *Amount, stored bool) string {
+	if a == nil {
+		return "N;"
+	}
+	if stored && a.stored != "" {
+		var r big.Rat
+		_, ok := r.SetString(a.stored)
+		if ok && r.Cmp(&a.Rat) == 0 {
+			return phpObject(`DataValues\DecimalValue`, a.stored)
+		}
+	}
+	s := a.String()
+	if a.Sign() >= 0 {
+		s = "+" + s
+	}
+	return phpObject(`DataValues\DecimalValue`, s)
+}
+
+// phpTimestamp returns the timestamp, timezone, before, and after of the time value.
+// If stored is true, they are as they were in JSON, if known and the timestamp
+// still represents the time.
+func phpTimestamp(v TimeValue, stored bool) (string, int64, int64, int64) {
+	if stored && v.timestamp != "" {
+		t, errE := parseTime(v.timestamp)
+		if errE == nil && t.Equal(v.Time) {
+			return v.timestamp, v.timezone, v.before, v.after
+		}
+	}
+	return formatTime(v.Time, v.Precision), 0, 0, 0
+}
+
+// phpSerialize returns PHP serialization of the corresponding Wikibase data value object.
+// If stored is true, amounts and timestamps are serialized as they were in JSON, if known.
+func (v DataValue) phpSerialize(stored bool) (string, errors.E) {
+	switch value := v.Value.(type) {
+	case StringValue:
+		return phpObject(`DataValues\StringValue`, string(value)), nil
+	case WikiBaseEntityIDValue:
+		class, ok := entityIDClasses[value.Type]
+		if !ok {
+			errE := errors.WithMessage(ErrUnexpectedType, "wikibase entity type")
+			errors.Details(errE)["type"] = int(value.Type)
+			return "", errE
+		}
+		return phpObject(`Wikibase\DataModel\Entity\EntityIdValue`, phpObject(class, value.ID)), nil
+	case GlobeCoordinateValue:
+		// Altitude is always null.
+		data := "[" + phpJSONFloat(value.Latitude) + "," + phpJSONFloat(value.Longitude) + ",null," +
+			phpJSONFloat(value.Precision) + "," + phpJSONString(value.Globe) + "]"
+		return phpObject(`DataValues\Geo\Values\GlobeCoordinateValue`, data), nil
+	case MonolingualTextValue:
+		return phpObject(`DataValues\MonolingualTextValue`, phpArray(phpString(value.Language), phpString(value.Text))), nil
+	case QuantityValue:
+		if value.UpperBound == nil && value.LowerBound == nil {
+			return phpObject(`DataValues\UnboundedQuantityValue`, phpArray(phpAmount(&value.Amount, stored), phpString(value.Unit))), nil
+		}
+		return phpObject(`DataValues\QuantityValue`, phpArray(
+			phpAmount(&value.Amount, stored), phpString(value.Unit), phpAmount(value.UpperBound, stored), phpAmount(value.LowerBound, stored),
+		)), nil
+	case TimeValue:
+		calendar := gregorianURI
+		if value.Calendar == Julian {
+			calendar = julianURI
+		}
+		timestamp, timezone, before, after := phpTimestamp(value, stored)
+		return phpObject(`DataValues\TimeValue`, phpArray(
+			phpString(timestamp), phpInt(int(timezone)), phpInt(int(before)), phpInt(int(after)),
+			phpInt(int(value.Precision)), phpString(calendar),
+		)), nil
+	}
+	errE := errors.WithMessage(ErrUnexpectedType, "data value")
+	errors.Details(errE)["type"] = fmt.Sprintf("%T", v.Value)
+	return "", errE
+}
+
+// CanonicalHash returns a canonical hash of the data value, computed the same as Wikibase does.
+//
+// Values are hashed in their normalized form: amounts without trailing zeros,
+// times with insignificant parts set to zero, and calendars as entity URIs.
+// ErrorValue cannot be hashed.
+func (v DataValue) CanonicalHash() (string, errors.E) {
+	data, errE := v.phpSerialize(false)
+	if errE != nil {
+		return "", errE
+	}
+	h := md5.Sum([]byte(data)) //nolint:gosec
+	return hex.EncodeToString(h[:]), nil
+}
+
+// CanonicalHash returns a canonical hash of the snak, computed the same as Wikibase does.
+//
+// The result can be compared with the Hash field as provided by Wikidata.
+// Amounts and timestamps are hashed as they were in JSON (when the snak was
+// decoded from JSON), so snaks with equal values can have different hashes.
+func (s Snak) CanonicalHash() (string, errors.E) {
+	return s.canonicalHash(true)
+}
+
+func (s Snak) canonicalHash(stored bool) (string, errors.E) {
+	var data string
+	switch s.SnakType {
+	case Value:
+		if s.DataValue == nil {
+			errE := errors.New("missing data value")
+			errors.Details(errE)["property"] = s.Property
+			return "", errE
+		}
+		value, errE := s.DataValue.phpSerialize(stored)
+		if errE != nil {
+			return "", errE
+		}
+		data = phpObject(`Wikibase\DataModel\Snak\PropertyValueSnak`, phpArray(phpString(s.Property), value))
+	case SomeValue:
+		data = phpObject(`Wikibase\DataModel\Snak\PropertySomeValueSnak`, s.Property)
+	case NoValue:
+		data = phpObject(`Wikibase\DataModel\Snak\PropertyNoValueSnak`, s.Property)
+	default:
+		errE := errors.WithMessage(ErrUnexpectedType, "snak type")
+		errors.Details(errE)["type"] = int(s.SnakType)
+		return "", errE
+	}
+	h := sha1.Sum([]byte(data)) //nolint:gosec
+	return hex.EncodeToString(h[:]), nil
+}
+
+// hashList combines hashes independently of their order.
+func hashList(hashes []string) string {
+	slices.Sort(hashes)
+	h := sha1.Sum([]byte(strings.Join(hashes, "|"))) //nolint:gosec
+	return hex.EncodeToString(h[:])
+}
+
+func snakMapHash(snaks map[string][]Snak, stored bool) (string, errors.E) {
+	hashes := []string{}
+	for _, ss := range snaks {
+		for _, snak := range ss {
+			hash, errE := snak.canonicalHash(stored)
+			if errE != nil {
+				return "", errE
+			}
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashList(hashes), nil
+}
+
+// CanonicalHash returns a canonical hash of the reference, computed the same as Wikibase does.
+//
+// The result does not depend on the order of snaks and can be compared
+// with the Hash field as provided by Wikidata. Values are hashed as for Snak.CanonicalHash.
+func (r Reference) CanonicalHash() (string, errors.E) {
+	return snakMapHash(r.Snaks, true)
+}
+
+// CanonicalHash returns a canonical hash of the statement, computed the same as Wikibase does.
+//
+// The result does not depend on the order of qualifiers and references.
+// Statement ID is not part of the hash. Wikidata does not provide statement hashes,
+// so values are hashed in their normalized form (see DataValue.CanonicalHash)
+// and equal statements have equal hashes.
+func (s Statement) CanonicalHash() (string, errors.E) {
+	mainSnak, errE := s.MainSnak.canonicalHash(false)
+	if errE != nil {
+		return "", errE
+	}
+	qualifiers, errE := snakMapHash(s.Qualifiers, false)
+	if errE != nil {
+		return "", errE
+	}
+	references := []string{}
+	for _, reference := range s.References {
+		hash, errE := snakMapHash(reference.Snaks, false)
+		if errE != nil {
+			return "", errE
+		}
+		references = append(references, hash)
+	}
+	// Wikibase uses 0 for deprecated, 1 for normal, and 2 for preferred rank.
+	var rank int
+	switch s.Rank {
+	case Preferred:
+		rank = 2
+	case Normal:
+		rank = 1
+	case Deprecated:
+		rank = 0
+	}
+	snaks := sha1.Sum([]byte(mainSnak + qualifiers))                                                            //nolint:gosec
+	h := sha1.Sum([]byte(hex.EncodeToString(snaks[:]) + "|" + strconv.Itoa(rank) + "|" + hashList(references))) //nolint:gosec
+	return hex.EncodeToString(h[:]), nil
+}
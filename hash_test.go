@@ -0,0 +1,244 @@
+package mediawiki
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPHPJSONFloat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{-14.25, "-14.25"},
+		{46.05, "46.05"},
+		{0.0001, "0.0001"},
+		{0.00001, "1.0e-5"},
+		{0.000012, "1.2e-5"},
+		{0.016666666666666666, "0.016666666666666666"},
+		{123456789012345678, "1.2345678901234568e+17"},
+		{1e16, "10000000000000000"},
+		{1e17, "1.0e+17"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, phpJSONFloat(test.value), test.value)
+	}
+
+	assert.Equal(t, `"http:\/\/www.wikidata.org\/entity\/Q2"`, phpJSONString("http://www.wikidata.org/entity/Q2"))
+	assert.Equal(t, `"\u010d\ud83d\ude00"`, phpJSONString("č😀"))
+}
+
+func parseJSON[T any](t *testing.T, data string) T {
+	t.Helper()
+
+	var v T
+	err := json.Unmarshal([]byte(data), &v)
+	require.NoError(t, err)
+	return v
+}
+
+func TestCanonicalHash(t *testing.T) {
+	t.Parallel()
+
+	// Hashes as found in Wikidata.
+	reference := parseJSON[Reference](t, `{
+		"hash": "fa278ebfc458360e5aed63d5058cca83c46134f1",
+		"snaks": {"P143": [{"snaktype": "value", "property": "P143", "hash": "e4f6d9441d0600513c4533c672b5ab472dc73694",
+			"datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "numeric-id": 328, "id": "Q328"}}}]},
+		"snaks-order": ["P143"]
+	}`)
+	hash, errE := reference.Snaks["P143"][0].CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, reference.Snaks["P143"][0].Hash, hash)
+	hash, errE = reference.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, reference.Hash, hash)
+
+	snak := parseJSON[Snak](t, `{"snaktype": "value", "property": "P31", "hash": "ad7d38a03cdd40cdc373de0dc4e7b7fcbccb31d9",
+		"datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "numeric-id": 5, "id": "Q5"}}}`)
+	hash, errE = snak.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, snak.Hash, hash)
+
+	// Equal values have equal hashes.
+	a := parseJSON[DataValue](t, `{"type": "quantity", "value": {"amount": "+1.0", "unit": "1"}}`)
+	b := parseJSON[DataValue](t, `{"type": "quantity", "value": {"amount": "+1", "unit": "1"}}`)
+	assert.True(t, a.Equal(b))
+	hashA, errE := a.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	hashB, errE := b.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, hashA, hashB)
+
+	c := parseJSON[DataValue](t, `{"type": "quantity", "value": {"amount": "+1", "upperBound": "+2", "lowerBound": "+0", "unit": "1"}}`)
+	assert.False(t, a.Equal(c))
+	hashC, errE := c.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEqual(t, hashA, hashC)
+
+	for _, value := range []string{
+		`{"type": "string", "value": "foo"}`,
+		`{"type": "monolingualtext", "value": {"language": "en", "text": "foo"}}`,
+		`{"type": "globecoordinate", "value": {"latitude": 46.05, "longitude": 14.5083, "precision": 0.0001, "globe": "http://www.wikidata.org/entity/Q2"}}`,
+		`{"type": "time", "value": {"time": "+1952-03-11T00:00:00Z", "timezone": 0, "before": 0, "after": 0, "precision": 11, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}`,
+	} {
+		dataValue := parseJSON[DataValue](t, value)
+		assert.True(t, dataValue.Equal(dataValue))
+		hash, errE := dataValue.CanonicalHash()
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Len(t, hash, 32)
+		assert.NotEqual(t, hashA, hash)
+	}
+
+	_, errE = DataValue{Value: ErrorValue("invalid")}.CanonicalHash()
+	assert.ErrorIs(t, errE, ErrUnexpectedType)
+}
+
+func TestPHPSerialize(t *testing.T) {
+	t.Parallel()
+
+	// Values are serialized as stored, the same as Wikibase serializes them for snak hashes.
+	tests := []struct {
+		value      string
+		stored     string
+		normalized string
+	}{
+		{
+			`{"type": "string", "value": "foo"}`,
+			`C:22:"DataValues\StringValue":3:{foo}`,
+			`C:22:"DataValues\StringValue":3:{foo}`,
+		},
+		{
+			`{"type": "monolingualtext", "value": {"language": "sl", "text": "Ljubljana"}}`,
+			`C:31:"DataValues\MonolingualTextValue":39:{a:2:{i:0;s:2:"sl";i:1;s:9:"Ljubljana";}}`,
+			`C:31:"DataValues\MonolingualTextValue":39:{a:2:{i:0;s:2:"sl";i:1;s:9:"Ljubljana";}}`,
+		},
+		{
+			`{"type": "globecoordinate", "value": {"latitude": 46.05, "longitude": 14.5083, "altitude": null, "precision": 0.0001, "globe": "http://www.wikidata.org/entity/Q2"}}`,
+			`C:42:"DataValues\Geo\Values\GlobeCoordinateValue":67:{[46.05,14.5083,null,0.0001,"http:\/\/www.wikidata.org\/entity\/Q2"]}`,
+			`C:42:"DataValues\Geo\Values\GlobeCoordinateValue":67:{[46.05,14.5083,null,0.0001,"http:\/\/www.wikidata.org\/entity\/Q2"]}`,
+		},
+		{
+			`{"type": "quantity", "value": {"amount": "+1.50", "unit": "http://www.wikidata.org/entity/Q11573", "upperBound": "+1.51", "lowerBound": "+1.49"}}`,
+			`C:24:"DataValues\QuantityValue":187:{a:4:{i:0;C:23:"DataValues\DecimalValue":5:{+1.50}i:1;s:37:"http://www.wikidata.org/entity/Q11573";i:2;C:23:"DataValues\DecimalValue":5:{+1.51}i:3;C:23:"DataValues\DecimalValue":5:{+1.49}}}`,
+			`C:24:"DataValues\QuantityValue":186:{a:4:{i:0;C:23:"DataValues\DecimalValue":4:{+1.5}i:1;s:37:"http://www.wikidata.org/entity/Q11573";i:2;C:23:"DataValues\DecimalValue":5:{+1.51}i:3;C:23:"DataValues\DecimalValue":5:{+1.49}}}`,
+		},
+		{
+			`{"type": "quantity", "value": {"amount": "+1.5", "unit": "1"}}`,
+			`C:33:"DataValues\UnboundedQuantityValue":61:{a:2:{i:0;C:23:"DataValues\DecimalValue":4:{+1.5}i:1;s:1:"1";}}`,
+			`C:33:"DataValues\UnboundedQuantityValue":61:{a:2:{i:0;C:23:"DataValues\DecimalValue":4:{+1.5}i:1;s:1:"1";}}`,
+		},
+		{
+			// Older values have month and day set even with year precision, and sometimes timezone.
+			`{"type": "time", "value": {"time": "+1952-01-01T00:00:00Z", "timezone": 60, "before": 0, "after": 0, "precision": 9, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}`,
+			`C:20:"DataValues\TimeValue":123:{a:6:{i:0;s:21:"+1952-01-01T00:00:00Z";i:1;i:60;i:2;i:0;i:3;i:0;i:4;i:9;i:5;s:39:"http://www.wikidata.org/entity/Q1985727";}}`,
+			`C:20:"DataValues\TimeValue":122:{a:6:{i:0;s:21:"+1952-00-00T00:00:00Z";i:1;i:0;i:2;i:0;i:3;i:0;i:4;i:9;i:5;s:39:"http://www.wikidata.org/entity/Q1985727";}}`,
+		},
+		{
+			`{"type": "time", "value": {"time": "-0044-03-15T00:00:00Z", "timezone": 0, "before": 0, "after": 0, "precision": 11, "calendarmodel": "http://www.wikidata.org/entity/Q1985786"}}`,
+			`C:20:"DataValues\TimeValue":123:{a:6:{i:0;s:21:"-0044-03-15T00:00:00Z";i:1;i:0;i:2;i:0;i:3;i:0;i:4;i:11;i:5;s:39:"http://www.wikidata.org/entity/Q1985786";}}`,
+			`C:20:"DataValues\TimeValue":123:{a:6:{i:0;s:21:"-0044-03-15T00:00:00Z";i:1;i:0;i:2;i:0;i:3;i:0;i:4;i:11;i:5;s:39:"http://www.wikidata.org/entity/Q1985786";}}`,
+		},
+	}
+	for _, test := range tests {
+		dataValue := parseJSON[DataValue](t, test.value)
+		data, errE := dataValue.phpSerialize(true)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, test.stored, data)
+		data, errE = dataValue.phpSerialize(false)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, test.normalized, data)
+	}
+
+	// When the value is changed, stored serialization is not used anymore.
+	dataValue := parseJSON[DataValue](t, tests[5].value)
+	value := dataValue.Value.(TimeValue) //nolint:forcetypeassert,errcheck
+	value.Time = value.Time.AddDate(1, 0, 0)
+	data, errE := DataValue{Value: value}.phpSerialize(true)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, `C:20:"DataValues\TimeValue":122:{a:6:{i:0;s:21:"+1953-00-00T00:00:00Z";i:1;i:0;i:2;i:0;i:3;i:0;i:4;i:9;i:5;s:39:"http://www.wikidata.org/entity/Q1985727";}}`, data)
+
+	dataValue = parseJSON[DataValue](t, tests[3].value)
+	quantity := dataValue.Value.(QuantityValue) //nolint:forcetypeassert,errcheck
+	quantity.Amount.SetInt64(2)
+	data, errE = DataValue{Value: quantity}.phpSerialize(true)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Contains(t, data, `{+2}`)
+
+	// Snaks with equal values hash differently when their values were stored differently,
+	// but the same as their canonical data value hashes.
+	a := parseJSON[Snak](t, `{"snaktype": "value", "property": "P569", "datavalue": `+tests[5].value+`}`)
+	b := parseJSON[Snak](t, `{"snaktype": "value", "property": "P569", "datavalue": {"type": "time", "value": {"time": "+1952-00-00T00:00:00Z", "timezone": 0, "before": 0, "after": 0, "precision": 9, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}`)
+	assert.True(t, a.Equal(b))
+	hashA, errE := a.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	hashB, errE := b.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEqual(t, hashA, hashB)
+	hashA, errE = a.DataValue.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	hashB, errE = b.DataValue.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, hashA, hashB)
+}
+
+func TestStatementEqual(t *testing.T) {
+	t.Parallel()
+
+	statement := parseJSON[Statement](t, `{
+		"id": "Q1$1", "type": "statement", "rank": "normal",
+		"mainsnak": {"snaktype": "value", "property": "P1082", "datatype": "quantity",
+			"datavalue": {"type": "quantity", "value": {"amount": "+1.0", "unit": "1"}}},
+		"qualifiers": {
+			"P585": [{"snaktype": "somevalue", "property": "P585"}, {"snaktype": "novalue", "property": "P585"}],
+			"P459": [{"snaktype": "value", "property": "P459", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q1"}}}]
+		},
+		"qualifiers-order": ["P585", "P459"],
+		"references": [
+			{"snaks": {"P143": [{"snaktype": "value", "property": "P143", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q328"}}}]}},
+			{"snaks": {"P854": [{"snaktype": "value", "property": "P854", "datavalue": {"type": "string", "value": "https://example.com"}}]}}
+		]
+	}`)
+	// Same statement with different order of qualifiers and references, and without data type.
+	reordered := parseJSON[Statement](t, `{
+		"id": "Q1$1", "type": "statement", "rank": "normal",
+		"mainsnak": {"snaktype": "value", "property": "P1082",
+			"datavalue": {"type": "quantity", "value": {"amount": "+1", "unit": "1"}}},
+		"qualifiers": {
+			"P459": [{"snaktype": "value", "property": "P459", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q1"}}}],
+			"P585": [{"snaktype": "novalue", "property": "P585"}, {"snaktype": "somevalue", "property": "P585"}]
+		},
+		"qualifiers-order": ["P459", "P585"],
+		"references": [
+			{"snaks": {"P854": [{"snaktype": "value", "property": "P854", "datavalue": {"type": "string", "value": "https://example.com"}}]}},
+			{"snaks": {"P143": [{"snaktype": "value", "property": "P143", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q328"}}}]}}
+		]
+	}`)
+
+	assert.True(t, statement.Equal(reordered))
+	hash, errE := statement.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	reorderedHash, errE := reordered.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, hash, reorderedHash)
+
+	reordered.Rank = Preferred
+	assert.False(t, statement.Equal(reordered))
+	reorderedHash, errE = reordered.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEqual(t, hash, reorderedHash)
+
+	reordered.Rank = Normal
+	reordered.References = reordered.References[:1]
+	assert.False(t, statement.Equal(reordered))
+	reorderedHash, errE = reordered.CanonicalHash()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEqual(t, hash, reorderedHash)
+}
@@ -19,22 +19,33 @@ type runs struct {
 	Links []string `pagser:"a->eachAttr(href)"`
 }
 
-func latestRun(ctx context.Context, client *retryablehttp.Client, runURL, fileFormat string) (string, errors.E) {
+// runDates returns dates of all runs listed at runURL, in the order they are listed.
+func runDates(ctx context.Context, client *retryablehttp.Client, runURL string) ([]string, errors.E) {
 	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, runURL, nil)
 	if err != nil {
 		errE := errors.WithMessage(err, "new request")
 		errors.Details(errE)["url"] = runURL
-		return "", errE
+		return nil, errE
 	}
 	resp, err := client.Do(req)
 	if err != nil {
 		errE := errors.WithMessage(err, "do")
 		errors.Details(errE)["url"] = runURL
-		return "", errE
+		return nil, errE
 	}
 	defer resp.Body.Close()              //nolint:errcheck
 	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.WithDetails(ErrNotFound, "url", runURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		errE := errors.New("bad response status")
+		errors.Details(errE)["url"] = runURL
+		errors.Details(errE)["status"] = resp.Status
+		return nil, errE
+	}
+
 	p := pagser.New()
 
 	var data runs
@@ -42,31 +53,55 @@ func latestRun(ctx context.Context, client *retryablehttp.Client, runURL, fileFo
 	if err != nil {
 		errE := errors.WithMessage(err, "parse")
 		errors.Details(errE)["url"] = runURL
-		return "", errE
+		return nil, errE
 	}
 
-	// We start with the last link.
-	for i := len(data.Links) - 1; i >= 0; i-- {
-		link := data.Links[i]
+	dates := []string{}
+	for _, link := range data.Links {
 		match := runRegex.FindStringSubmatch(link)
 		if match != nil {
-			lastDate := match[1]
-			url := fmt.Sprintf(fileFormat, lastDate, lastDate)
+			dates = append(dates, match[1])
+		}
+	}
+	return dates, nil
+}
 
-			// It can happen that the file is missing in the dump directory. So we check.
-			resp, err := client.Head(url)
-			if err != nil {
-				errE := errors.WithMessage(err, "head")
-				errors.Details(errE)["url"] = url
-				return "", errE
-			}
-			defer resp.Body.Close()              //nolint:errcheck
-			defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
-			if resp.StatusCode == http.StatusOK {
-				return url, nil
+// latestRun returns URL of the file at the latest run listed at runPath which has it.
+// fileFormat is formatted with the date of the run twice to obtain the path of the file.
+// Paths are relative to base URLs of the source.
+func latestRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource, runPath, fileFormat string) (string, errors.E) {
+	var dates []string
+	errE := source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+		var errE errors.E
+		dates, errE = runDates(ctx, client, joinURL(baseURL, runPath))
+		return errE
+	})
+	if errE != nil {
+		return "", errE
+	}
+
+	// We start with the last run.
+	for i := len(dates) - 1; i >= 0; i-- {
+		lastDate := dates[i]
+		path := fmt.Sprintf(fileFormat, lastDate, lastDate)
+
+		// It can happen that the file is missing in the dump directory. So we check.
+		var url string
+		errE := source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+			u := joinURL(baseURL, path)
+			errE := head(ctx, client, u)
+			if errE == nil {
+				url = u
 			}
+			return errE
+		})
+		if errors.Is(errE, ErrNotFound) {
+			continue
+		} else if errE != nil {
+			return "", errE
 		}
+		return url, nil
 	}
 
-	return "", errors.WithDetails(ErrNotFound, "url", runURL)
+	return "", errors.WithDetails(ErrNotFound, "url", joinURL(source.baseURLs()[0], runPath))
 }
@@ -0,0 +1,414 @@
+package mediawiki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	wikidataIncrementalPath = "other/incr/wikidatawiki/"
+	wikidataAPI             = "https://www.wikidata.org/w/api.php"
+)
+
+// wikidataPropertyNamespace is the namespace of Wikidata properties.
+const wikidataPropertyNamespace = 120
+
+// Content models of Wikidata entities we decode.
+const (
+	wikibaseItemContentModel     = "wikibase-item"
+	wikibasePropertyContentModel = "wikibase-property"
+)
+
+// EntityChange is a change of a Wikidata entity found in daily incremental dumps.
+type EntityChange struct {
+	// ID of the changed entity.
+	ID string
+
+	// Entity at its latest revision. It is nil if the entity has been deleted.
+	Entity *Entity
+
+	// Redirect is the ID of the entity into which the entity has been merged.
+	// The entity itself is then deleted.
+	Redirect string
+
+	// Revision ID and timestamp of the latest revision of the entity.
+	// For entities deleted (and not merged), Revision is 0 and Timestamp
+	// is the time of the deletion.
+	Revision  int64
+	Timestamp time.Time
+}
+
+// Deleted returns true if the entity has been deleted.
+func (c EntityChange) Deleted() bool {
+	return c.Entity == nil
+}
+
+// incrementalDump is a daily incremental dump file.
+type incrementalDump struct {
+	Date     string
+	Name     string
+	URL      string
+	Checksum Checksum
+}
+
+// entityRedirect is the revision text of an entity which has been merged into another entity.
+type entityRedirect struct {
+	Entity   string `json:"entity"`
+	Redirect string `json:"redirect"`
+}
+
+// fetchIncrementalDump returns the incremental dump at the date from the base URL.
+// It returns ErrNotFound if the dump does not exist or is not done.
+func fetchIncrementalDump(ctx context.Context, client *retryablehttp.Client, baseURL, date string) (*incrementalDump, errors.E) {
+	runURL := joinURL(baseURL, wikidataIncrementalPath+date+"/")
+
+	status, errE := get(ctx, client, runURL+"status.txt")
+	if errE != nil {
+		return nil, errE
+	}
+	// Status is "done" (or "done:all" in newer runs) once the dump is complete.
+	if !strings.HasPrefix(strings.TrimSpace(string(status)), "done") {
+		errE := errors.WithDetails(ErrNotFound, "url", runURL)
+		errors.Details(errE)["status"] = strings.TrimSpace(string(status))
+		return nil, errE
+	}
+
+	name := fmt.Sprintf("wikidatawiki-%s-pages-meta-hist-incr.xml.bz2", date)
+	dump := &incrementalDump{
+		Date:     date,
+		Name:     name,
+		URL:      runURL + name,
+		Checksum: Checksum{}, //nolint:exhaustruct
+	}
+	data, errE := get(ctx, client, fmt.Sprintf("%swikidatawiki-%s-md5sums.txt", runURL, date))
+	if errE == nil {
+		dump.Checksum.MD5 = parseChecksums(data)[name]
+	} else if !errors.Is(errE, ErrNotFound) {
+		return nil, errE
+	}
+	return dump, nil
+}
+
+// deletionLogEvent is an entry of the deletion log returned by the MediaWiki action API.
+type deletionLogEvent struct {
+	Namespace int       `json:"ns"`
+	Title     string    `json:"title"`
+	Action    string    `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// deletionLogResponse is a response of the MediaWiki action API to a deletion log query.
+type deletionLogResponse struct {
+	Continue map[string]string `json:"continue"`
+	Query    struct {
+		LogEvents []deletionLogEvent `json:"logevents"`
+	} `json:"query"`
+	Error *struct {
+		Code string `json:"code"`
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// fetchDeletedEntities returns IDs of Wikidata entities (items and properties) deleted
+// since the time, with the time of their deletion, from the deletion log available
+// through the MediaWiki action API. Entities which have been restored are not returned.
+func fetchDeletedEntities(ctx context.Context, client *retryablehttp.Client, api string, since time.Time) (map[string]time.Time, errors.E) {
+	deleted := map[string]time.Time{}
+	cont := map[string]string{}
+	for {
+		query := url.Values{}
+		query.Set("action", "query")
+		query.Set("format", "json")
+		query.Set("formatversion", "2")
+		query.Set("list", "logevents")
+		query.Set("letype", "delete")
+		query.Set("leprop", "title|type|timestamp")
+		query.Set("ledir", "newer")
+		query.Set("lestart", since.UTC().Format(time.RFC3339))
+		query.Set("lelimit", "max")
+		for key, value := range cont {
+			query.Set(key, value)
+		}
+		u := api + "?" + query.Encode()
+
+		data, errE := get(ctx, client, u)
+		if errE != nil {
+			return nil, errE
+		}
+		var response deletionLogResponse
+		errE = x.Unmarshal(data, &response)
+		if errE != nil {
+			errE = errors.Prefix(errE, ErrJSONDecode)
+			errors.Details(errE)["url"] = u
+			return nil, errE
+		}
+		if response.Error != nil {
+			errE := errors.New("API error")
+			errors.Details(errE)["url"] = u
+			errors.Details(errE)["code"] = response.Error.Code
+			errors.Details(errE)["info"] = response.Error.Info
+			return nil, errE
+		}
+
+		// Events are in chronological order.
+		for _, event := range response.Query.LogEvents {
+			var id string
+			switch event.Namespace {
+			case MainNamespace:
+				id = event.Title
+			case wikidataPropertyNamespace:
+				id = strings.TrimPrefix(event.Title, "Property:")
+			default:
+				continue
+			}
+			switch event.Action {
+			case "delete":
+				deleted[id] = event.Timestamp
+			case "restore":
+				delete(deleted, id)
+			}
+		}
+
+		if len(response.Continue) == 0 {
+			return deleted, nil
+		}
+		cont = response.Continue
+	}
+}
+
+// normalizeEntityText removes top-level empty arrays from the revision text of an entity.
+// Wikibase stores empty maps (e.g., when an entity has no descriptions) as empty
+// arrays in revision text, while JSON dumps use empty objects or omit them.
+func normalizeEntityText(text string) ([]byte, errors.E) {
+	var fields map[string]json.RawMessage
+	errE := x.Unmarshal([]byte(text), &fields)
+	if errE != nil {
+		return nil, errors.Prefix(errE, ErrJSONDecode)
+	}
+	for name, value := range fields {
+		if string(bytes.TrimSpace(value)) == "[]" {
+			delete(fields, name)
+		}
+	}
+	return x.Marshal(fields)
+}
+
+// decodeEntityChange decodes the latest revision of a page of a Wikidata entity.
+// It returns false if the page is not of an entity or the revision text is not available.
+func decodeEntityChange(page Page) (EntityChange, bool, errors.E) {
+	if len(page.Revisions) == 0 {
+		return EntityChange{}, false, nil
+	}
+	// Revisions are in chronological order, we use the latest one.
+	revision := page.Revisions[len(page.Revisions)-1]
+	if revision.Model != wikibaseItemContentModel && revision.Model != wikibasePropertyContentModel {
+		return EntityChange{}, false, nil
+	}
+	if revision.TextDeleted || revision.Text == "" {
+		return EntityChange{}, false, nil
+	}
+
+	if page.Redirect != "" {
+		var redirect entityRedirect
+		errE := x.UnmarshalWithoutUnknownFields([]byte(revision.Text), &redirect)
+		if errE != nil {
+			return EntityChange{}, false, errors.Prefix(errE, ErrJSONDecode)
+		}
+		return EntityChange{
+			ID:        redirect.Entity,
+			Entity:    nil,
+			Redirect:  redirect.Redirect,
+			Revision:  revision.ID,
+			Timestamp: revision.Timestamp,
+		}, true, nil
+	}
+
+	data, errE := normalizeEntityText(revision.Text)
+	if errE != nil {
+		return EntityChange{}, false, errE
+	}
+	var entity Entity
+	errE = x.UnmarshalWithoutUnknownFields(data, &entity)
+	if errE != nil {
+		return EntityChange{}, false, errors.Prefix(errE, ErrJSONDecode)
+	}
+	// Revision text does not contain metadata which is in JSON dumps, so we add it.
+	entity.PageID = page.ID
+	entity.Namespace = page.Namespace
+	entity.Title = page.Title
+	entity.Modified = revision.Timestamp
+	entity.LastRevID = revision.ID
+	return EntityChange{
+		ID:        entity.ID,
+		Entity:    &entity,
+		Redirect:  "",
+		Revision:  revision.ID,
+		Timestamp: revision.Timestamp,
+	}, true, nil
+}
+
+// ProcessWikidataIncrementalDump downloads (unless already saved), decompresses, decodes XML,
+// and calls processChange for every Wikidata entity (item or property) changed in daily incremental
+// dumps (other/incr/wikidatawiki/) since config.Since, so that data built from a full dump
+// can be patched forward.
+//
+// Every entity is passed to processChange only once, at its latest revision across all processed
+// dumps. Dumps are processed from the newest to the oldest and IDs of already seen entities are
+// kept in memory. processChange is called concurrently.
+//
+// Incremental dumps contain only new revisions, not page deletions. Entities which have been merged
+// into another entity (and turned into redirects) are found in dumps, while other deleted entities are
+// read from the deletion log through the MediaWiki action API (config.API) and passed to processChange
+// after all dumps have been processed. If config.SkipDeletions is set, the deletion log is not read and
+// such deleted entities are not reported.
+//
+// Newest dumps which are not yet done are skipped. If any older dump is not done,
+// ErrNotFound is returned because changes from it would be missing.
+func ProcessWikidataIncrementalDump(
+	ctx context.Context, config *IncrementalDumpConfig,
+	processChange func(context.Context, EntityChange) errors.E,
+) errors.E {
+	var dates []string
+	errE := config.Source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+		var errE errors.E
+		dates, errE = runDates(ctx, config.Client, joinURL(baseURL, wikidataIncrementalPath))
+		return errE
+	})
+	if errE != nil {
+		return errE
+	}
+	slices.Sort(dates)
+	dates = slices.Compact(dates)
+	dates = slices.DeleteFunc(dates, func(date string) bool {
+		return date < config.Since
+	})
+
+	// Entities deleted since the oldest dump, with the time of their deletion.
+	deleted := map[string]time.Time{}
+	if !config.SkipDeletions && len(dates) > 0 {
+		since, err := time.Parse("20060102", dates[0])
+		if err != nil {
+			errE := errors.WithMessage(err, "invalid date")
+			errors.Details(errE)["date"] = dates[0]
+			return errE
+		}
+		api := config.API
+		if api == "" {
+			api = wikidataAPI
+		}
+		deleted, errE = fetchDeletedEntities(ctx, config.Client, api, since)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	// IDs of entities seen in newer dumps.
+	seen := map[string]struct{}{}
+	foundDone := false
+
+	// We start with the newest dump.
+	for i := len(dates) - 1; i >= 0; i-- {
+		date := dates[i]
+
+		var dump *incrementalDump
+		errE := config.Source.failover(ctx, func(ctx context.Context, baseURL string) errors.E {
+			var errE errors.E
+			dump, errE = fetchIncrementalDump(ctx, config.Client, baseURL, date)
+			return errE
+		})
+		if errors.Is(errE, ErrNotFound) && !foundDone {
+			// Newest dumps might still be in progress.
+			continue
+		} else if errE != nil {
+			errors.Details(errE)["date"] = date
+			return errE
+		}
+		foundDone = true
+
+		path := ""
+		if config.Dir != "" {
+			path = filepath.Join(config.Dir, dump.Name)
+		}
+
+		var mu sync.Mutex
+		current := map[string]struct{}{}
+		errE = Process(ctx, &ProcessConfig[Page]{
+			URL:                    dump.URL,
+			Mirrors:                config.Source.MirrorURLs(dump.URL),
+			Path:                   path,
+			Client:                 config.Client,
+			DecompressionThreads:   config.DecompressionThreads,
+			DecodingThreads:        config.DecodingThreads,
+			ItemsProcessingThreads: config.ItemsProcessingThreads,
+			Process: func(ctx context.Context, page Page) errors.E {
+				change, ok, errE := decodeEntityChange(page)
+				if errE != nil {
+					errors.Details(errE)["title"] = page.Title
+					return errE
+				}
+				if !ok {
+					return nil
+				}
+				// seen is modified only between dumps so we can read it without the lock.
+				if _, ok := seen[change.ID]; ok {
+					return nil
+				}
+				// The entity has been deleted after this revision.
+				if t, ok := deleted[change.ID]; ok && !change.Timestamp.After(t) {
+					return nil
+				}
+				mu.Lock()
+				current[change.ID] = struct{}{}
+				mu.Unlock()
+				return processChange(ctx, change)
+			},
+			Progress:       config.Progress,
+			FileType:       XMLDump,
+			Compression:    BZIP2,
+			Checksum:       dump.Checksum,
+			Sources:        nil,
+			SourcesThreads: 0,
+		})
+		if errE != nil {
+			errors.Details(errE)["date"] = date
+			return errE
+		}
+		for id := range current {
+			seen[id] = struct{}{}
+		}
+	}
+
+	if !foundDone {
+		return errors.WithDetails(ErrNotFound, "since", config.Since)
+	}
+
+	for id, t := range deleted {
+		// The entity has been restored and changed after its deletion.
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		errE := processChange(ctx, EntityChange{
+			ID:        id,
+			Entity:    nil,
+			Redirect:  "",
+			Revision:  0,
+			Timestamp: t,
+		})
+		if errE != nil {
+			return errE
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func newIncrementalServer(t *testing.T, status map[string]string) *httptest.Server {
+	t.Helper()
+
+	files := map[string][]byte{
+		"/other/incr/wikidatawiki/": []byte(`<html><body><a href="../">../</a><a href="20231231/">20231231/</a>` +
+			`<a href="20240101/">20240101/</a><a href="20240102/">20240102/</a><a href="20240103/">20240103/</a></body></html>`),
+	}
+	for date, s := range status {
+		files["/other/incr/wikidatawiki/"+date+"/status.txt"] = []byte(s)
+	}
+	for _, date := range []string{"20240101", "20240102"} {
+		name := "wikidatawiki-" + date + "-pages-meta-hist-incr.xml.bz2"
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		require.NoError(t, err)
+		files["/other/incr/wikidatawiki/"+date+"/"+name] = data
+		files["/other/incr/wikidatawiki/"+date+"/wikidatawiki-"+date+"-md5sums.txt"] = []byte(checksumOf(data).MD5 + "  " + name + "\n")
+	}
+
+	// Deletion log, returned two events at a time.
+	events := []map[string]any{
+		{"ns": 0, "title": "Q1", "type": "delete", "action": "delete", "timestamp": "2024-01-01T12:00:00Z"},
+		{"ns": 0, "title": "Q1", "type": "delete", "action": "restore", "timestamp": "2024-01-01T13:00:00Z"},
+		{"ns": 120, "title": "Property:P2", "type": "delete", "action": "delete", "timestamp": "2024-01-01T14:00:00Z"},
+		{"ns": 120, "title": "Property:P2", "type": "delete", "action": "restore", "timestamp": "2024-01-01T14:30:00Z"},
+		{"ns": 146, "title": "Lexeme:L1", "type": "delete", "action": "delete", "timestamp": "2024-01-01T15:00:00Z"},
+		{"ns": 0, "title": "Q5", "type": "delete", "action": "delete", "timestamp": "2024-01-02T12:00:00Z"},
+		{"ns": 0, "title": "Q4", "type": "delete", "action": "delete", "timestamp": "2024-01-03T12:00:00Z"},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/w/api.php" {
+			query := req.URL.Query()
+			assert.Equal(t, "logevents", query.Get("list"))
+			assert.Equal(t, "delete", query.Get("letype"))
+			assert.Equal(t, "newer", query.Get("ledir"))
+			start, err := time.Parse(time.RFC3339, query.Get("lestart"))
+			require.NoError(t, err)
+			i, _ := strconv.Atoi(query.Get("lecontinue"))
+			response := map[string]any{"batchcomplete": true}
+			result := []map[string]any{}
+			for ; i < len(events) && len(result) < 2; i++ {
+				timestamp, err := time.Parse(time.RFC3339, events[i]["timestamp"].(string)) //nolint:forcetypeassert
+				require.NoError(t, err)
+				if !timestamp.Before(start) {
+					result = append(result, events[i])
+				}
+			}
+			if i < len(events) {
+				response["continue"] = map[string]string{"lecontinue": strconv.Itoa(i), "continue": "-||"}
+			}
+			response["query"] = map[string]any{"logevents": result}
+			w.Header().Set("Content-Type", "application/json")
+			assert.NoError(t, json.NewEncoder(w).Encode(response))
+			return
+		}
+		data, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func processIncremental(t *testing.T, config *mediawiki.IncrementalDumpConfig) (map[string]mediawiki.EntityChange, errors.E) {
+	t.Helper()
+
+	var mu sync.Mutex
+	changes := map[string]mediawiki.EntityChange{}
+	errE := mediawiki.ProcessWikidataIncrementalDump(context.Background(), config, func(_ context.Context, change mediawiki.EntityChange) errors.E {
+		mu.Lock()
+		defer mu.Unlock()
+		_, ok := changes[change.ID]
+		assert.False(t, ok, change.ID)
+		changes[change.ID] = change
+		return nil
+	})
+	return changes, errE
+}
+
+func TestProcessWikidataIncrementalDump(t *testing.T) {
+	t.Parallel()
+
+	ts := newIncrementalServer(t, map[string]string{
+		"20231231": "done",
+		"20240101": "done",
+		"20240102": "done:all",
+		"20240103": "in-progress",
+	})
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	dir := t.TempDir()
+
+	changes, errE := processIncremental(t, &mediawiki.IncrementalDumpConfig{
+		Client: client,
+		Source: &mediawiki.DumpSource{BaseURL: ts.URL},
+		API:    ts.URL + "/w/api.php",
+		Since:  "20240101",
+		Dir:    dir,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, changes, 6)
+	assert.FileExists(t, filepath.Join(dir, "wikidatawiki-20240101-pages-meta-hist-incr.xml.bz2"))
+	assert.FileExists(t, filepath.Join(dir, "wikidatawiki-20240102-pages-meta-hist-incr.xml.bz2"))
+
+	q1 := changes["Q1"]
+	require.NotNil(t, q1.Entity)
+	assert.False(t, q1.Deleted())
+	assert.Equal(t, int64(201), q1.Revision)
+	assert.Equal(t, "New", q1.Entity.Labels["en"].Value)
+	assert.Equal(t, "Q1", q1.Entity.Title)
+	assert.Equal(t, int64(1), q1.Entity.PageID)
+	assert.Equal(t, int64(201), q1.Entity.LastRevID)
+	assert.Equal(t, time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC), q1.Entity.Modified)
+	assert.Equal(t, mediawiki.StringValue("example"), q1.Entity.Claims["P1"][0].MainSnak.DataValue.Value)
+
+	assert.Equal(t, int64(103), changes["Q2"].Revision)
+	assert.Equal(t, mediawiki.Property, changes["P1"].Entity.Type)
+	assert.Equal(t, "Property:P1", changes["P1"].Entity.Title)
+
+	// Deleted after its latest revision.
+	q4 := changes["Q4"]
+	assert.True(t, q4.Deleted())
+	assert.Empty(t, q4.Redirect)
+	assert.Equal(t, int64(0), q4.Revision)
+	assert.Equal(t, time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC), q4.Timestamp)
+
+	// Deleted and not in dumps.
+	assert.True(t, changes["Q5"].Deleted())
+	assert.Equal(t, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), changes["Q5"].Timestamp)
+
+	// Deleted and then restored.
+	_, ok := changes["P2"]
+	assert.False(t, ok)
+
+	q3 := changes["Q3"]
+	assert.True(t, q3.Deleted())
+	assert.Equal(t, "Q2", q3.Redirect)
+	assert.Equal(t, int64(105), q3.Revision)
+
+	changes, errE = processIncremental(t, &mediawiki.IncrementalDumpConfig{
+		Client: client,
+		Source: &mediawiki.DumpSource{BaseURL: ts.URL},
+		API:    ts.URL + "/w/api.php",
+		Since:  "20240102",
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, "New", changes["Q1"].Entity.Labels["en"].Value)
+	assert.True(t, changes["Q4"].Deleted())
+	assert.True(t, changes["Q5"].Deleted())
+
+	changes, errE = processIncremental(t, &mediawiki.IncrementalDumpConfig{
+		Client:        client,
+		Source:        &mediawiki.DumpSource{BaseURL: ts.URL},
+		Since:         "20240101",
+		SkipDeletions: true,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, changes, 5)
+	assert.Equal(t, "Fourth", changes["Q4"].Entity.Labels["en"].Value)
+}
+
+func TestProcessWikidataIncrementalDumpMissing(t *testing.T) {
+	t.Parallel()
+
+	// The older dump is not done, so changes from it would be missing.
+	ts := newIncrementalServer(t, map[string]string{
+		"20240101": "in-progress",
+		"20240102": "done",
+	})
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	_, errE := processIncremental(t, &mediawiki.IncrementalDumpConfig{
+		Client: client,
+		Source: &mediawiki.DumpSource{BaseURL: ts.URL},
+		API:    ts.URL + "/w/api.php",
+		Since:  "20240101",
+	})
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+	assert.Equal(t, "20240101", errors.AllDetails(errE)["date"])
+
+	_, errE = processIncremental(t, &mediawiki.IncrementalDumpConfig{
+		Client: client,
+		Source: &mediawiki.DumpSource{BaseURL: ts.URL},
+		API:    ts.URL + "/w/api.php",
+		Since:  "20240103",
+	})
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+}
@@ -0,0 +1,307 @@
+package mediawiki
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"os"
+	"slices"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// LabelResolver resolves entity IDs to their labels.
+type LabelResolver interface {
+	// Label returns the label of the entity in the language.
+	// It returns false if there is no such label.
+	Label(id, language string) (string, bool)
+}
+
+type labelLocation struct {
+	Offset int64
+	Length uint32
+}
+
+// LabelStore is a compact LabelResolver, e.g., for use in two-pass processing.
+//
+// Populate it with Add, e.g., directly from a ProcessWikidataDump
+// callback. If Languages is set, only labels in those languages are stored.
+// By default labels are kept in memory. If Path is set, labels are stored
+// in a file at Path and only their locations are kept in memory. Call Close
+// when done with a disk-backed store. It is safe to use concurrently.
+type LabelStore struct {
+	Languages []string
+	Path      string
+
+	mu        sync.RWMutex
+	labels    map[string]string
+	locations map[string]labelLocation
+	file      *os.File
+	writer    *bufio.Writer
+	size      int64
+	dirty     bool
+}
+
+// encodeLabels encodes labels as a sequence of length-prefixed language and label pairs.
+func encodeLabels(labels map[string]string, languages []string) []byte {
+	data := []byte{}
+	for _, language := range languages {
+		label, ok := labels[language]
+		if !ok {
+			continue
+		}
+		data = binary.AppendUvarint(data, uint64(len(language)))
+		data = append(data, language...)
+		data = binary.AppendUvarint(data, uint64(len(label)))
+		data = append(data, label...)
+	}
+	return data
+}
+
+// decodeLabel finds the label in the language in encoded labels.
+func decodeLabel(data []byte, language string) (string, bool) {
+	for len(data) > 0 {
+		var fields [2]string
+		for i := range fields {
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return "", false
+			}
+			fields[i] = string(data[n : n+int(l)]) //nolint:gosec
+			data = data[n+int(l):]                 //nolint:gosec
+		}
+		if fields[0] == language {
+			return fields[1], true
+		}
+	}
+	return "", false
+}
+
+// Add stores labels of the entity.
+//
+// Its signature matches the callback of ProcessWikidataDump so it can be passed to it directly.
+func (s *LabelStore) Add(_ context.Context, entity Entity) errors.E {
+	labels := make(map[string]string, len(entity.Labels))
+	for language, label := range entity.Labels {
+		labels[language] = label.Value
+	}
+	languages := s.Languages
+	if len(languages) == 0 {
+		languages = make([]string, 0, len(labels))
+		for language := range labels {
+			languages = append(languages, language)
+		}
+		slices.Sort(languages)
+	}
+	data := encodeLabels(labels, languages)
+	if len(data) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Path == "" {
+		if s.labels == nil {
+			s.labels = map[string]string{}
+		}
+		s.labels[entity.ID] = string(data)
+		return nil
+	}
+
+	if s.file == nil {
+		file, err := os.Create(s.Path)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["path"] = s.Path
+			return errE
+		}
+		s.file = file
+		s.writer = bufio.NewWriter(file)
+		s.locations = map[string]labelLocation{}
+	}
+	_, err := s.writer.Write(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.locations[entity.ID] = labelLocation{Offset: s.size, Length: uint32(len(data))} //nolint:gosec
+	s.size += int64(len(data))
+	s.dirty = true
+	return nil
+}
+
+// flush makes sure all written labels can be read and returns with the read lock held.
+func (s *LabelStore) flush() errors.E {
+	for {
+		s.mu.RLock()
+		// Labels might be added between us releasing the write lock
+		// and acquiring the read lock, so we check again.
+		if !s.dirty {
+			return nil
+		}
+		s.mu.RUnlock()
+
+		s.mu.Lock()
+		if s.dirty {
+			err := s.writer.Flush()
+			if err != nil {
+				s.mu.Unlock()
+				s.mu.RLock()
+				return errors.WithStack(err)
+			}
+			s.dirty = false
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Label implements LabelResolver interface.
+//
+// For a disk-backed store, errors reading the file are reported as missing labels.
+func (s *LabelStore) Label(id, language string) (string, bool) {
+	errE := s.flush()
+	defer s.mu.RUnlock()
+	if errE != nil {
+		return "", false
+	}
+
+	if s.Path == "" {
+		return decodeLabel([]byte(s.labels[id]), language)
+	}
+	location, ok := s.locations[id]
+	if !ok {
+		return "", false
+	}
+	data := make([]byte, location.Length)
+	_, err := s.file.ReadAt(data, location.Offset)
+	if err != nil {
+		return "", false
+	}
+	return decodeLabel(data, language)
+}
+
+// Close closes the file of a disk-backed store. The file is not removed.
+func (s *LabelStore) Close() errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	err2 := s.file.Close()
+	s.file = nil
+	s.writer = nil
+	s.locations = nil
+	s.size = 0
+	s.dirty = false
+	return errors.Join(err, err2)
+}
+
+// EntityLabels are labels of entities referenced from an entity,
+// keyed by entity ID and then language. It implements LabelResolver.
+type EntityLabels map[string]map[string]string
+
+// Label implements LabelResolver interface.
+func (l EntityLabels) Label(id, language string) (string, bool) {
+	label, ok := l[id][language]
+	return label, ok
+}
+
+func appendSnakIDs(ids []string, snak Snak) []string {
+	ids = append(ids, snak.Property)
+	if snak.DataValue != nil {
+		if value, ok := snak.DataValue.Value.(WikiBaseEntityIDValue); ok {
+			ids = append(ids, value.ID)
+		}
+	}
+	return ids
+}
+
+// ReferencedEntityIDs returns IDs of all properties and entity values used
+// in the entity's statements (including qualifiers and references), sorted by ID.
+func ReferencedEntityIDs(entity Entity) []string {
+	ids := []string{}
+	for _, statements := range entity.Claims {
+		for _, statement := range statements {
+			ids = appendSnakIDs(ids, statement.MainSnak)
+			for _, snaks := range statement.Qualifiers {
+				for _, snak := range snaks {
+					ids = appendSnakIDs(ids, snak)
+				}
+			}
+			for _, reference := range statement.References {
+				for _, snaks := range reference.Snaks {
+					for _, snak := range snaks {
+						ids = appendSnakIDs(ids, snak)
+					}
+				}
+			}
+		}
+	}
+	slices.SortFunc(ids, CompareEntityIDs)
+	return slices.Compact(ids)
+}
+
+// JoinLabelsConfig is a configuration for JoinLabels.
+//
+// The dump is read twice, so Dump.Path should be set to cache
+// the dump locally. Process reads a dump and is by default
+// ProcessWikidataDump. Languages are languages of labels passed to
+// the callback and default to Store.Languages. Store is by default an
+// in-memory LabelStore which stores only labels in Languages.
+type JoinLabelsConfig struct {
+	Dump      ProcessDumpConfig
+	Process   func(context.Context, *ProcessDumpConfig, func(context.Context, Entity) errors.E) errors.E
+	Store     *LabelStore
+	Languages []string
+}
+
+// JoinLabels reads the dump twice: in the first pass it stores labels of all
+// entities, in the second pass it calls processEntity for every entity together
+// with labels of all entities it references (see ReferencedEntityIDs) in
+// configured languages.
+func JoinLabels(
+	ctx context.Context, config *JoinLabelsConfig, processEntity func(context.Context, Entity, EntityLabels) errors.E,
+) errors.E {
+	process := config.Process
+	if process == nil {
+		process = ProcessWikidataDump
+	}
+	store := config.Store
+	if store == nil {
+		store = &LabelStore{Languages: config.Languages}
+	}
+	languages := config.Languages
+	if len(languages) == 0 {
+		languages = store.Languages
+	}
+	if len(languages) == 0 {
+		return errors.New("languages not set")
+	}
+
+	dump := config.Dump
+	errE := process(ctx, &dump, store.Add)
+	if errE != nil {
+		return errE
+	}
+
+	dump = config.Dump
+	return process(ctx, &dump, func(ctx context.Context, entity Entity) errors.E {
+		labels := EntityLabels{}
+		for _, id := range ReferencedEntityIDs(entity) {
+			for _, language := range languages {
+				label, ok := store.Label(id, language)
+				if !ok {
+					continue
+				}
+				if labels[id] == nil {
+					labels[id] = map[string]string{}
+				}
+				labels[id][language] = label
+			}
+		}
+		return processEntity(ctx, entity, labels)
+	})
+}
@@ -0,0 +1,146 @@
+package mediawiki_test
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/go/mediawiki/formatter"
+)
+
+var labelsTestEntities = []string{ //nolint:gochecknoglobals
+	`{"id": "P31", "type": "property", "datatype": "wikibase-item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z",
+		"labels": {"en": {"language": "en", "value": "instance of"}, "de": {"language": "de", "value": "ist ein(e)"}}}`,
+	`{"id": "Q5", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z",
+		"labels": {"en": {"language": "en", "value": "human"}, "sl": {"language": "sl", "value": "človek"}}}`,
+	`{"id": "Q42", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z",
+		"labels": {"mul": {"language": "mul", "value": "Douglas Adams"}},
+		"claims": {"P31": [{"id": "Q42$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P31", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q5"}}},
+			"references": [{"snaks": {"P143": [{"snaktype": "value", "property": "P143", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q328"}}}]}}]}]}}`,
+}
+
+func TestLabelStore(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range []string{"", filepath.Join(t.TempDir(), "labels")} {
+		t.Run(path, func(t *testing.T) {
+			t.Parallel()
+
+			store := &mediawiki.LabelStore{Languages: []string{"en", "mul"}, Path: path}
+			t.Cleanup(func() {
+				errE := store.Close()
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			})
+			for _, data := range labelsTestEntities {
+				errE := store.Add(context.Background(), parseEntity(t, data))
+				require.NoError(t, errE, "% -+#.1v", errE)
+			}
+
+			label, ok := store.Label("Q5", "en")
+			assert.True(t, ok)
+			assert.Equal(t, "human", label)
+			_, ok = store.Label("Q5", "sl")
+			assert.False(t, ok)
+			_, ok = store.Label("Q1", "en")
+			assert.False(t, ok)
+
+			// Adding after reading works.
+			errE := store.Add(context.Background(), parseEntity(t, `{"id": "Q1", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z",
+				"labels": {"en": {"language": "en", "value": "Universe"}}}`))
+			require.NoError(t, errE, "% -+#.1v", errE)
+			label, ok = store.Label("Q1", "en")
+			assert.True(t, ok)
+			assert.Equal(t, "Universe", label)
+
+			f := formatter.Formatter{Language: "en", Labels: store}
+			assert.Equal(t, "Douglas Adams", f.Label("Q42"))
+		})
+	}
+}
+
+func TestLabelStoreConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := &mediawiki.LabelStore{Path: filepath.Join(t.TempDir(), "labels")}
+	t.Cleanup(func() {
+		errE := store.Close()
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	})
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id := "Q" + strconv.Itoa(i+1)
+			errE := store.Add(context.Background(), mediawiki.Entity{ //nolint:exhaustruct
+				ID:     id,
+				Labels: map[string]mediawiki.LanguageValue{"en": {Language: "en", Value: id}},
+			})
+			if !assert.NoError(t, errE, "% -+#.1v", errE) {
+				return
+			}
+			// Labels added by other goroutines while this one is
+			// flushing must not be lost.
+			label, ok := store.Label(id, "en")
+			assert.True(t, ok, id)
+			assert.Equal(t, id, label)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestJoinLabels(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dump.json")
+	writeNDJSON(t, path, labelsTestEntities...)
+
+	store := &mediawiki.LabelStore{Path: filepath.Join(t.TempDir(), "labels")}
+	t.Cleanup(func() {
+		errE := store.Close()
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	})
+
+	labels := map[string]mediawiki.EntityLabels{}
+	errE := mediawiki.JoinLabels(context.Background(), &mediawiki.JoinLabelsConfig{
+		Dump: mediawiki.ProcessDumpConfig{Path: path},
+		Process: func(ctx context.Context, config *mediawiki.ProcessDumpConfig, processEntity func(context.Context, mediawiki.Entity) errors.E) errors.E {
+			return mediawiki.Process(ctx, &mediawiki.ProcessConfig[mediawiki.Entity]{
+				Path:                   config.Path,
+				Process:                processEntity,
+				ItemsProcessingThreads: 1,
+				FileType:               mediawiki.NDJSON,
+				Compression:            mediawiki.NoCompression,
+			})
+		},
+		Store:     store,
+		Languages: []string{"en", "sl"},
+	}, func(_ context.Context, entity mediawiki.Entity, l mediawiki.EntityLabels) errors.E {
+		labels[entity.ID] = l
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, map[string]mediawiki.EntityLabels{
+		"P31": {},
+		"Q5":  {},
+		"Q42": {
+			"P31": {"en": "instance of"},
+			"Q5":  {"en": "human", "sl": "človek"},
+		},
+	}, labels)
+
+	assert.Equal(t, []string{"P31", "P143", "Q5", "Q328"}, mediawiki.ReferencedEntityIDs(parseEntity(t, labelsTestEntities[2])))
+
+	errE = mediawiki.JoinLabels(context.Background(), &mediawiki.JoinLabelsConfig{}, nil)
+	assert.EqualError(t, errE, "languages not set")
+}
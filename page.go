@@ -0,0 +1,131 @@
+package mediawiki
+
+import (
+	"encoding/xml"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Namespace IDs of some standard MediaWiki namespaces.
+const (
+	MainNamespace     = 0
+	FileNamespace     = 6
+	TemplateNamespace = 10
+	CategoryNamespace = 14
+	// DataNamespace is the namespace of tabular and map data on Wikimedia Commons.
+	DataNamespace = 486
+)
+
+type Contributor struct {
+	Username string `json:"username,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+type Revision struct {
+	ID          int64       `json:"id"`
+	ParentID    int64       `json:"parent_id,omitempty"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Contributor Contributor `json:"contributor"`
+	Minor       bool        `json:"minor,omitempty"`
+	Comment     string      `json:"comment,omitempty"`
+	Origin      int64       `json:"origin,omitempty"`
+	Model       string      `json:"model"`
+	Format      string      `json:"format"`
+	Text        string      `json:"text,omitempty"`
+	TextDeleted bool        `json:"text_deleted,omitempty"`
+	SHA1        string      `json:"sha1,omitempty"`
+}
+
+// Page is a MediaWiki XML dump page.
+//
+// Dumps with only current revisions have exactly one revision per page,
+// history dumps have all revisions in chronological order.
+type Page struct {
+	Title     string     `json:"title"`
+	Namespace int        `json:"ns"`
+	ID        int64      `json:"id"`
+	Redirect  string     `json:"redirect,omitempty"`
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+type xmlContributor struct {
+	Username string `xml:"username"`
+	ID       int64  `xml:"id"`
+	IP       string `xml:"ip"`
+	Deleted  string `xml:"deleted,attr"`
+}
+
+type xmlText struct {
+	Text    string `xml:",chardata"`
+	Deleted string `xml:"deleted,attr"`
+}
+
+type xmlRevision struct {
+	ID          int64          `xml:"id"`
+	ParentID    int64          `xml:"parentid"`
+	Timestamp   time.Time      `xml:"timestamp"`
+	Contributor xmlContributor `xml:"contributor"`
+	Minor       *struct{}      `xml:"minor"`
+	Comment     string         `xml:"comment"`
+	Origin      int64          `xml:"origin"`
+	Model       string         `xml:"model"`
+	Format      string         `xml:"format"`
+	Text        xmlText        `xml:"text"`
+	SHA1        string         `xml:"sha1"`
+}
+
+type xmlPage struct {
+	XMLName  xml.Name `xml:"page"`
+	Title    string   `xml:"title"`
+	NS       int      `xml:"ns"`
+	ID       int64    `xml:"id"`
+	Redirect *struct {
+		Title string `xml:"title,attr"`
+	} `xml:"redirect"`
+	Revisions []xmlRevision `xml:"revision"`
+}
+
+// decodePage decodes one <page> element of a MediaWiki XML dump.
+func decodePage(data []byte) (Page, errors.E) {
+	var p xmlPage
+	err := xml.Unmarshal(data, &p)
+	if err != nil {
+		return Page{}, errors.WithMessage(err, "xml unmarshal")
+	}
+	page := Page{
+		Title:     norm.NFC.String(p.Title),
+		Namespace: p.NS,
+		ID:        p.ID,
+		Redirect:  "",
+		Revisions: nil,
+	}
+	if p.Redirect != nil {
+		page.Redirect = norm.NFC.String(p.Redirect.Title)
+	}
+	for _, r := range p.Revisions {
+		page.Revisions = append(page.Revisions, Revision{
+			ID:        r.ID,
+			ParentID:  r.ParentID,
+			Timestamp: r.Timestamp,
+			Contributor: Contributor{
+				Username: norm.NFC.String(r.Contributor.Username),
+				ID:       r.Contributor.ID,
+				IP:       r.Contributor.IP,
+				Deleted:  r.Contributor.Deleted != "",
+			},
+			Minor:       r.Minor != nil,
+			Comment:     norm.NFC.String(r.Comment),
+			Origin:      r.Origin,
+			Model:       r.Model,
+			Format:      r.Format,
+			Text:        r.Text.Text,
+			TextDeleted: r.Text.Deleted != "",
+			SHA1:        r.SHA1,
+		})
+	}
+	return page, nil
+}
@@ -0,0 +1,577 @@
+package mediawiki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	parquetMagic               = "PAR1"
+	defaultParquetRowGroupSize = 10000
+	parquetCreatedBy           = "gitlab.com/tozd/go/mediawiki"
+)
+
+// Thrift compact protocol types.
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// Parquet format enumerations.
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+
+	parquetRequired int32 = 0
+	parquetOptional int32 = 1
+	parquetRepeated int32 = 2
+
+	parquetUTF8            int32 = 0
+	parquetTimestampMillis int32 = 9
+
+	parquetPlain int32 = 0
+	parquetRLE   int32 = 3
+
+	parquetDataPage int32 = 0
+)
+
+// thriftEncoder encodes Parquet metadata using Thrift compact protocol.
+type thriftEncoder struct {
+	buf   []byte
+	last  int16
+	stack []int16
+}
+
+func (e *thriftEncoder) field(id int16, typ byte) {
+	delta := id - e.last
+	if delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ) //nolint:gosec
+	} else {
+		e.buf = append(e.buf, typ)
+		e.buf = binary.AppendVarint(e.buf, int64(id))
+	}
+	e.last = id
+}
+
+func (e *thriftEncoder) i32(id int16, v int32) {
+	e.field(id, thriftI32)
+	e.buf = binary.AppendVarint(e.buf, int64(v))
+}
+
+func (e *thriftEncoder) i64(id int16, v int64) {
+	e.field(id, thriftI64)
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *thriftEncoder) string(id int16, s string) {
+	e.field(id, thriftBinary)
+	e.appendString(s)
+}
+
+func (e *thriftEncoder) appendString(s string) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *thriftEncoder) list(id int16, elemType byte, n int) {
+	e.field(id, thriftList)
+	if n < 15 { //nolint:mnd
+		e.buf = append(e.buf, byte(n)<<4|elemType) //nolint:gosec
+	} else {
+		e.buf = append(e.buf, 0xF0|elemType) //nolint:mnd
+		e.buf = binary.AppendUvarint(e.buf, uint64(n))
+	}
+}
+
+// beginStruct begins a struct field. Pass id 0 for a list element.
+func (e *thriftEncoder) beginStruct(id int16) {
+	if id != 0 {
+		e.field(id, thriftStruct)
+	}
+	e.stack = append(e.stack, e.last)
+	e.last = 0
+}
+
+func (e *thriftEncoder) endStruct() {
+	e.buf = append(e.buf, 0)
+	e.last = e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+}
+
+// ParquetType is a type of a Parquet column.
+type ParquetType int
+
+const (
+	// ParquetString is a UTF-8 string column with string values.
+	ParquetString ParquetType = iota
+	// ParquetInt64 is an integer column with int64 values.
+	ParquetInt64
+	// ParquetDouble is a floating point column with float64 values.
+	ParquetDouble
+	// ParquetBoolean is a boolean column with bool values.
+	ParquetBoolean
+	// ParquetTimestamp is a timestamp column with time.Time values, stored in milliseconds.
+	ParquetTimestamp
+)
+
+// ParquetCompression is a compression of Parquet pages.
+type ParquetCompression int
+
+const (
+	ParquetZSTD ParquetCompression = iota
+	ParquetGZIP
+	ParquetUncompressed
+)
+
+// ParquetColumn describes a column of a Parquet file.
+//
+// An optional column accepts nil values. A repeated column is a list
+// and accepts a slice of values (or nil for an empty list).
+type ParquetColumn struct {
+	Name     string
+	Type     ParquetType
+	Optional bool
+	Repeated bool
+}
+
+func (c ParquetColumn) physicalType() int32 {
+	switch c.Type {
+	case ParquetString:
+		return parquetByteArray
+	case ParquetInt64, ParquetTimestamp:
+		return parquetInt64
+	case ParquetDouble:
+		return parquetDouble
+	case ParquetBoolean:
+		return parquetBoolean
+	}
+	panic(errors.Errorf("unknown parquet type: %d", c.Type))
+}
+
+func (c ParquetColumn) maxDefinitionLevel() int {
+	if c.Optional || c.Repeated {
+		return 1
+	}
+	return 0
+}
+
+func (c ParquetColumn) maxRepetitionLevel() int {
+	if c.Repeated {
+		return 1
+	}
+	return 0
+}
+
+type parquetColumnChunk struct {
+	Offset           int64
+	Values           int64
+	UncompressedSize int64
+	CompressedSize   int64
+}
+
+type parquetRowGroup struct {
+	Rows    int64
+	Size    int64
+	Columns []parquetColumnChunk
+}
+
+// ParquetWriter writes rows into a Parquet file.
+//
+// Rows are buffered and written in row groups of RowGroupSize rows (by default 10,000).
+// Each row is a slice of values, one value per column in Columns. Write is safe
+// to call concurrently and row groups are encoded and compressed in parallel
+// by goroutines calling Write, e.g., ItemsProcessingThreads workers of Process.
+// Order of row groups in the file is not defined. Close has to be called
+// to write the file footer; it does not close Writer.
+type ParquetWriter struct {
+	Writer       io.Writer
+	Columns      []ParquetColumn
+	RowGroupSize int
+	Compression  ParquetCompression
+
+	mu        sync.Mutex
+	rows      [][]any
+	writeMu   sync.Mutex
+	started   bool
+	offset    int64
+	rowGroups []parquetRowGroup
+}
+
+// Write adds a row.
+func (w *ParquetWriter) Write(row []any) errors.E {
+	if len(row) != len(w.Columns) {
+		errE := errors.New("invalid number of values")
+		errors.Details(errE)["expected"] = len(w.Columns)
+		errors.Details(errE)["got"] = len(row)
+		return errE
+	}
+	rowGroupSize := w.RowGroupSize
+	if rowGroupSize <= 0 {
+		rowGroupSize = defaultParquetRowGroupSize
+	}
+
+	w.mu.Lock()
+	w.rows = append(w.rows, row)
+	var rows [][]any
+	if len(w.rows) >= rowGroupSize {
+		rows = w.rows
+		w.rows = nil
+	}
+	w.mu.Unlock()
+
+	if rows == nil {
+		return nil
+	}
+	return w.writeRowGroup(rows)
+}
+
+func (w *ParquetWriter) writeRowGroup(rows [][]any) errors.E {
+	var data bytes.Buffer
+	rowGroup := parquetRowGroup{Rows: int64(len(rows))}
+	for i, column := range w.Columns {
+		chunk, errE := w.encodeColumn(&data, column, i, rows)
+		if errE != nil {
+			errors.Details(errE)["column"] = column.Name
+			return errE
+		}
+		rowGroup.Columns = append(rowGroup.Columns, chunk)
+		rowGroup.Size += chunk.UncompressedSize
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	errE := w.start()
+	if errE != nil {
+		return errE
+	}
+	for i := range rowGroup.Columns {
+		rowGroup.Columns[i].Offset += w.offset
+	}
+	n, err := w.Writer.Write(data.Bytes())
+	w.offset += int64(n)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w.rowGroups = append(w.rowGroups, rowGroup)
+	return nil
+}
+
+// start writes the file header. Caller has to hold writeMu.
+func (w *ParquetWriter) start() errors.E {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	n, err := io.WriteString(w.Writer, parquetMagic)
+	w.offset += int64(n)
+	return errors.WithStack(err)
+}
+
+// appendLevels appends levels encoded with RLE/bit-packing hybrid encoding,
+// using only RLE runs, prefixed with the length.
+func appendLevels(data []byte, levels []int, maxLevel int) []byte {
+	width := (bits.Len(uint(maxLevel)) + 7) / 8 //nolint:mnd,gosec
+	encoded := []byte{}
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		encoded = binary.AppendUvarint(encoded, uint64(j-i)<<1) //nolint:gosec
+		for k := range width {
+			encoded = append(encoded, byte(levels[i]>>(8*k))) //nolint:gosec
+		}
+		i = j
+	}
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(encoded))) //nolint:gosec
+	return append(data, encoded...)
+}
+
+func appendParquetValue(data []byte, column ParquetColumn, value any, booleans *[]bool) ([]byte, errors.E) {
+	switch column.Type {
+	case ParquetString:
+		if v, ok := value.(string); ok {
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(v))) //nolint:gosec
+			return append(data, v...), nil
+		}
+	case ParquetInt64:
+		switch v := value.(type) {
+		case int64:
+			return binary.LittleEndian.AppendUint64(data, uint64(v)), nil //nolint:gosec
+		case int:
+			return binary.LittleEndian.AppendUint64(data, uint64(v)), nil //nolint:gosec
+		}
+	case ParquetTimestamp:
+		if v, ok := value.(time.Time); ok {
+			return binary.LittleEndian.AppendUint64(data, uint64(v.UnixMilli())), nil //nolint:gosec
+		}
+	case ParquetDouble:
+		if v, ok := value.(float64); ok {
+			return binary.LittleEndian.AppendUint64(data, math.Float64bits(v)), nil
+		}
+	case ParquetBoolean:
+		if v, ok := value.(bool); ok {
+			*booleans = append(*booleans, v)
+			return data, nil
+		}
+	}
+	errE := errors.WithMessage(ErrUnexpectedType, "parquet value")
+	errors.Details(errE)["type"] = fmt.Sprintf("%T", value)
+	return nil, errE
+}
+
+// repeatedValues converts a repeated column value to a slice of values.
+func repeatedValues(value any) ([]any, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, true
+	case []any:
+		return v, true
+	case []string:
+		values := make([]any, len(v))
+		for i, s := range v {
+			values[i] = s
+		}
+		return values, true
+	case []int64:
+		values := make([]any, len(v))
+		for i, n := range v {
+			values[i] = n
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func (w *ParquetWriter) encodeColumn(out *bytes.Buffer, column ParquetColumn, index int, rows [][]any) (parquetColumnChunk, errors.E) {
+	repetitionLevels := []int{}
+	definitionLevels := []int{}
+	values := []byte{}
+	booleans := []bool{}
+	var errE errors.E
+	for _, row := range rows {
+		value := row[index]
+		switch {
+		case column.Repeated:
+			list, ok := repeatedValues(value)
+			if !ok {
+				errE := errors.WithMessage(ErrUnexpectedType, "parquet repeated value")
+				errors.Details(errE)["type"] = fmt.Sprintf("%T", value)
+				return parquetColumnChunk{}, errE
+			}
+			if len(list) == 0 {
+				repetitionLevels = append(repetitionLevels, 0)
+				definitionLevels = append(definitionLevels, 0)
+			}
+			for i, v := range list {
+				repetitionLevels = append(repetitionLevels, min(i, 1))
+				definitionLevels = append(definitionLevels, 1)
+				values, errE = appendParquetValue(values, column, v, &booleans)
+				if errE != nil {
+					return parquetColumnChunk{}, errE
+				}
+			}
+		case column.Optional && value == nil:
+			definitionLevels = append(definitionLevels, 0)
+		default:
+			if column.Optional {
+				definitionLevels = append(definitionLevels, 1)
+			}
+			values, errE = appendParquetValue(values, column, value, &booleans)
+			if errE != nil {
+				return parquetColumnChunk{}, errE
+			}
+		}
+	}
+	for i := 0; i < len(booleans); i += 8 {
+		var b byte
+		for j := i; j < len(booleans) && j < i+8; j++ {
+			if booleans[j] {
+				b |= 1 << (j - i)
+			}
+		}
+		values = append(values, b)
+	}
+
+	page := []byte{}
+	if column.maxRepetitionLevel() > 0 {
+		page = appendLevels(page, repetitionLevels, column.maxRepetitionLevel())
+	}
+	if column.maxDefinitionLevel() > 0 {
+		page = appendLevels(page, definitionLevels, column.maxDefinitionLevel())
+	}
+	page = append(page, values...)
+	compressed, errE := w.compress(page)
+	if errE != nil {
+		return parquetColumnChunk{}, errE
+	}
+
+	numValues := len(rows)
+	if column.maxDefinitionLevel() > 0 {
+		numValues = len(definitionLevels)
+	}
+	header := &thriftEncoder{}
+	header.i32(1, parquetDataPage)
+	header.i32(2, int32(len(page)))       //nolint:gosec
+	header.i32(3, int32(len(compressed))) //nolint:gosec
+	header.beginStruct(5)
+	header.i32(1, int32(numValues)) //nolint:gosec
+	header.i32(2, parquetPlain)
+	header.i32(3, parquetRLE)
+	header.i32(4, parquetRLE)
+	header.endStruct()
+	header.buf = append(header.buf, 0)
+
+	chunk := parquetColumnChunk{
+		Offset:           int64(out.Len()),
+		Values:           int64(numValues),
+		UncompressedSize: int64(len(header.buf) + len(page)),
+		CompressedSize:   int64(len(header.buf) + len(compressed)),
+	}
+	out.Write(header.buf)
+	out.Write(compressed)
+	return chunk, nil
+}
+
+func (w *ParquetWriter) codec() int32 {
+	switch w.Compression {
+	case ParquetZSTD:
+		return 6 //nolint:mnd
+	case ParquetGZIP:
+		return 2 //nolint:mnd
+	case ParquetUncompressed:
+		return 0
+	}
+	panic(errors.Errorf("unknown parquet compression: %d", w.Compression))
+}
+
+func (w *ParquetWriter) compress(data []byte) ([]byte, errors.E) {
+	switch w.Compression {
+	case ParquetZSTD:
+		return storeEncoder().EncodeAll(data, nil), nil
+	case ParquetGZIP:
+		var buf bytes.Buffer
+		gzipWriter := gzip.NewWriter(&buf)
+		_, err := gzipWriter.Write(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = gzipWriter.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return buf.Bytes(), nil
+	case ParquetUncompressed:
+		return data, nil
+	}
+	errE := errors.New("unknown parquet compression")
+	errors.Details(errE)["compression"] = w.Compression
+	return nil, errE
+}
+
+// Close writes remaining rows and the file footer.
+func (w *ParquetWriter) Close() errors.E {
+	w.mu.Lock()
+	rows := w.rows
+	w.rows = nil
+	w.mu.Unlock()
+
+	if len(rows) > 0 {
+		errE := w.writeRowGroup(rows)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	errE := w.start()
+	if errE != nil {
+		return errE
+	}
+
+	codec := w.codec()
+	var numRows int64
+	e := &thriftEncoder{}
+	e.i32(1, 1)
+	e.list(2, thriftStruct, len(w.Columns)+1)
+	e.beginStruct(0)
+	e.string(4, "schema")
+	e.i32(5, int32(len(w.Columns))) //nolint:gosec
+	e.endStruct()
+	for _, column := range w.Columns {
+		e.beginStruct(0)
+		e.i32(1, column.physicalType())
+		switch {
+		case column.Repeated:
+			e.i32(3, parquetRepeated)
+		case column.Optional:
+			e.i32(3, parquetOptional)
+		default:
+			e.i32(3, parquetRequired)
+		}
+		e.string(4, column.Name)
+		switch column.Type { //nolint:exhaustive
+		case ParquetString:
+			e.i32(6, parquetUTF8)
+		case ParquetTimestamp:
+			e.i32(6, parquetTimestampMillis)
+		}
+		e.endStruct()
+	}
+	for _, rowGroup := range w.rowGroups {
+		numRows += rowGroup.Rows
+	}
+	e.i64(3, numRows)
+	e.list(4, thriftStruct, len(w.rowGroups))
+	for _, rowGroup := range w.rowGroups {
+		e.beginStruct(0)
+		e.list(1, thriftStruct, len(rowGroup.Columns))
+		for i, chunk := range rowGroup.Columns {
+			column := w.Columns[i]
+			e.beginStruct(0)
+			e.i64(2, chunk.Offset)
+			e.beginStruct(3)
+			e.i32(1, column.physicalType())
+			e.list(2, thriftI32, 2) //nolint:mnd
+			e.buf = binary.AppendVarint(e.buf, int64(parquetPlain))
+			e.buf = binary.AppendVarint(e.buf, int64(parquetRLE))
+			e.list(3, thriftBinary, 1)
+			e.appendString(column.Name)
+			e.i32(4, codec)
+			e.i64(5, chunk.Values)
+			e.i64(6, chunk.UncompressedSize)
+			e.i64(7, chunk.CompressedSize)
+			e.i64(9, chunk.Offset)
+			e.endStruct()
+			e.endStruct()
+		}
+		e.i64(2, rowGroup.Size)
+		e.i64(3, rowGroup.Rows)
+		e.endStruct()
+	}
+	e.string(6, parquetCreatedBy)
+	e.buf = append(e.buf, 0)
+
+	footer := binary.LittleEndian.AppendUint32(e.buf, uint32(len(e.buf))) //nolint:gosec
+	footer = append(footer, parquetMagic...)
+	n, err := w.Writer.Write(footer)
+	w.offset += int64(n)
+	return errors.WithStack(err)
+}
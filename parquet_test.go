@@ -0,0 +1,543 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// thriftReader decodes Thrift compact protocol into maps from field IDs to values.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int64(r.byte())
+	case 4, 5, 6:
+		return r.varint()
+	case 7:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v
+	case 8:
+		l := int(r.uvarint()) //nolint:gosec
+		s := string(r.data[r.pos : r.pos+l])
+		r.pos += l
+		return s
+	case 9:
+		h := r.byte()
+		size := int(h >> 4)
+		if size == 15 { //nolint:mnd
+			size = int(r.uvarint()) //nolint:gosec
+		}
+		list := []any{}
+		for range size {
+			list = append(list, r.value(h&0x0F)) //nolint:mnd
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]any {
+	m := map[int16]any{}
+	var last int16
+	for {
+		b := r.byte()
+		if b == 0 {
+			return m
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.varint()) //nolint:gosec
+		}
+		last = id
+		m[id] = r.value(b & 0x0F) //nolint:mnd
+	}
+}
+
+func readLevels(t *testing.T, data []byte, count int) ([]int64, []byte) {
+	t.Helper()
+
+	l := binary.LittleEndian.Uint32(data)
+	encoded := data[4 : 4+l]
+	levels := []int64{}
+	for len(encoded) > 0 {
+		header, n := binary.Uvarint(encoded)
+		encoded = encoded[n:]
+		require.Zero(t, header&1, "bit-packed runs are not used")
+		for range header >> 1 {
+			levels = append(levels, int64(encoded[0]))
+		}
+		encoded = encoded[1:]
+	}
+	require.Len(t, levels, count)
+	return levels, data[4+l:]
+}
+
+// readParquet reads a Parquet file written by ParquetWriter and returns column names
+// and all rows.
+func readParquet(t *testing.T, path string) ([]string, [][]any) { //nolint:maintidx
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	metadata := (&thriftReader{data: data[len(data)-8-footerLength : len(data)-8]}).structure()
+
+	schema := metadata[2].([]any) //nolint:forcetypeassert,errcheck
+	require.Equal(t, int64(len(schema)-1), schema[0].(map[int16]any)[5])
+	names := []string{}
+	for _, element := range schema[1:] {
+		names = append(names, element.(map[int16]any)[4].(string)) //nolint:forcetypeassert,errcheck
+	}
+
+	rows := [][]any{}
+	for _, rg := range metadata[4].([]any) { //nolint:forcetypeassert,errcheck
+		rowGroup := rg.(map[int16]any)      //nolint:forcetypeassert,errcheck
+		numRows := int(rowGroup[3].(int64)) //nolint:forcetypeassert,errcheck
+		groupRows := make([][]any, numRows)
+		for i := range groupRows {
+			groupRows[i] = make([]any, len(names))
+		}
+		for c, ch := range rowGroup[1].([]any) { //nolint:forcetypeassert,errcheck
+			element := schema[c+1].(map[int16]any)        //nolint:forcetypeassert,errcheck
+			meta := ch.(map[int16]any)[3].(map[int16]any) //nolint:forcetypeassert,errcheck
+			require.Equal(t, []any{names[c]}, meta[3])
+			reader := &thriftReader{data: data, pos: int(meta[9].(int64))} //nolint:forcetypeassert,errcheck
+			header := reader.structure()
+			page := data[reader.pos : reader.pos+int(header[3].(int64))] //nolint:forcetypeassert,errcheck
+			switch meta[4] {
+			case int64(2):
+				r, err := gzip.NewReader(bytes.NewReader(page))
+				require.NoError(t, err)
+				page, err = io.ReadAll(r)
+				require.NoError(t, err)
+			case int64(6):
+				decoder, err := zstd.NewReader(nil)
+				require.NoError(t, err)
+				page, err = decoder.DecodeAll(page, nil)
+				require.NoError(t, err)
+			}
+			require.Len(t, page, int(header[2].(int64)))           //nolint:forcetypeassert,errcheck
+			numValues := int(header[5].(map[int16]any)[1].(int64)) //nolint:forcetypeassert,errcheck
+
+			repetition := element[3].(int64) //nolint:forcetypeassert,errcheck
+			var repetitionLevels, definitionLevels []int64
+			if repetition == 2 {
+				repetitionLevels, page = readLevels(t, page, numValues)
+			}
+			if repetition != 0 {
+				definitionLevels, page = readLevels(t, page, numValues)
+			}
+			present := numValues
+			for _, level := range definitionLevels {
+				if level == 0 {
+					present--
+				}
+			}
+			values := []any{}
+			for i := range present {
+				switch element[1] {
+				case int64(0):
+					values = append(values, page[i/8]>>(i%8)&1 == 1)
+				case int64(2):
+					v := int64(binary.LittleEndian.Uint64(page)) //nolint:gosec
+					page = page[8:]
+					if element[6] == int64(9) {
+						values = append(values, time.UnixMilli(v).UTC())
+					} else {
+						values = append(values, v)
+					}
+				case int64(5):
+					values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				case int64(6):
+					l := binary.LittleEndian.Uint32(page)
+					values = append(values, string(page[4:4+l]))
+					page = page[4+l:]
+				}
+			}
+
+			row := -1
+			for i := range numValues {
+				if repetitionLevels == nil || repetitionLevels[i] == 0 {
+					row++
+					if repetition == 2 {
+						groupRows[row][c] = []any{}
+					}
+				}
+				if definitionLevels != nil && definitionLevels[i] == 0 {
+					continue
+				}
+				value := values[0]
+				values = values[1:]
+				if repetition == 2 {
+					groupRows[row][c] = append(groupRows[row][c].([]any), value) //nolint:forcetypeassert,errcheck
+				} else {
+					groupRows[row][c] = value
+				}
+			}
+			require.Equal(t, numRows-1, row)
+			require.Empty(t, values)
+		}
+		rows = append(rows, groupRows...)
+	}
+	require.Len(t, rows, int(metadata[3].(int64))) //nolint:forcetypeassert,errcheck
+
+	// Files must be readable by other Parquet implementations, too.
+	otherNames, otherRows := readParquetWithParquetGo(t, path)
+	require.Equal(t, names, otherNames)
+	require.Equal(t, rows, otherRows)
+
+	return names, rows
+}
+
+// readParquetWithParquetGo reads the file using an independent Parquet implementation.
+func readParquetWithParquetGo(t *testing.T, path string) ([]string, [][]any) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close() //nolint:errcheck
+	info, err := file.Stat()
+	require.NoError(t, err)
+	parquetFile, err := parquet.OpenFile(file, info.Size())
+	require.NoError(t, err)
+
+	fields := parquetFile.Schema().Fields()
+	names := []string{}
+	for _, field := range fields {
+		names = append(names, field.Name())
+	}
+
+	reader := parquet.NewReader(parquetFile)
+	defer reader.Close() //nolint:errcheck
+
+	rows := [][]any{}
+	buffer := make([]parquet.Row, 10) //nolint:mnd
+	for {
+		n, err := reader.ReadRows(buffer)
+		for _, row := range buffer[:n] {
+			values := make([]any, len(fields))
+			row.Range(func(i int, columnValues []parquet.Value) bool {
+				field := fields[i]
+				convert := func(v parquet.Value) any {
+					switch {
+					case v.IsNull():
+						return nil
+					case field.Type().LogicalType() != nil && field.Type().LogicalType().Timestamp != nil:
+						return time.UnixMilli(v.Int64()).UTC()
+					}
+					switch v.Kind() { //nolint:exhaustive
+					case parquet.Boolean:
+						return v.Boolean()
+					case parquet.Int64:
+						return v.Int64()
+					case parquet.Double:
+						return v.Double()
+					case parquet.ByteArray:
+						return string(v.ByteArray())
+					}
+					assert.Fail(t, "unexpected kind", v.Kind().String())
+					return nil
+				}
+				if field.Repeated() {
+					list := []any{}
+					for _, v := range columnValues {
+						if !v.IsNull() {
+							list = append(list, convert(v))
+						}
+					}
+					values[i] = list
+				} else {
+					require.Len(t, columnValues, 1)
+					values[i] = convert(columnValues[0])
+				}
+				return true
+			})
+			rows = append(rows, values)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+	}
+	return names, rows
+}
+
+func sortParquetRows(rows [][]any) {
+	slices.SortFunc(rows, func(a, b []any) int {
+		return bytes.Compare(fmt.Append(nil, a...), fmt.Append(nil, b...))
+	})
+}
+
+func TestParquetWriter(t *testing.T) {
+	t.Parallel()
+
+	columns := []mediawiki.ParquetColumn{
+		{Name: "id", Type: mediawiki.ParquetInt64},
+		{Name: "name", Type: mediawiki.ParquetString},
+		{Name: "score", Type: mediawiki.ParquetDouble, Optional: true},
+		{Name: "flag", Type: mediawiki.ParquetBoolean},
+		{Name: "time", Type: mediawiki.ParquetTimestamp},
+		{Name: "tags", Type: mediawiki.ParquetString, Repeated: true},
+	}
+	expected := [][]any{}
+	for i := range 10 {
+		var score any
+		if i%3 != 0 {
+			score = float64(i) / 2
+		}
+		tags := []any{}
+		for j := range i % 4 {
+			tags = append(tags, fmt.Sprintf("tag%d", j))
+		}
+		expected = append(expected, []any{
+			int64(i), fmt.Sprintf("name %d", i), score, i%2 == 0,
+			time.Date(2020, 1, 1, 0, 0, i, 0, time.UTC), tags,
+		})
+	}
+
+	for _, compression := range []mediawiki.ParquetCompression{mediawiki.ParquetZSTD, mediawiki.ParquetGZIP, mediawiki.ParquetUncompressed} {
+		t.Run(fmt.Sprint(compression), func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "test.parquet")
+			file, err := os.Create(path)
+			require.NoError(t, err)
+			writer := &mediawiki.ParquetWriter{
+				Writer:       file,
+				Columns:      columns,
+				RowGroupSize: 3,
+				Compression:  compression,
+			}
+			var wg sync.WaitGroup
+			for _, row := range expected {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					values := slices.Clone(row)
+					tags := []string{}
+					for _, tag := range values[5].([]any) { //nolint:forcetypeassert,errcheck
+						tags = append(tags, tag.(string)) //nolint:forcetypeassert,errcheck
+					}
+					values[5] = tags
+					errE := writer.Write(values)
+					assert.NoError(t, errE, "% -+#.1v", errE)
+				}()
+			}
+			wg.Wait()
+			errE := writer.Close()
+			require.NoError(t, errE, "% -+#.1v", errE)
+			require.NoError(t, file.Close())
+
+			names, rows := readParquet(t, path)
+			assert.Equal(t, []string{"id", "name", "score", "flag", "time", "tags"}, names)
+			sortParquetRows(rows)
+			assert.Equal(t, expected, rows)
+		})
+	}
+
+	writer := &mediawiki.ParquetWriter{Writer: io.Discard, Columns: columns}
+	errE := writer.Write([]any{int64(1)})
+	assert.EqualError(t, errE, "invalid number of values")
+	errE = writer.Write([]any{"1", "name", nil, true, time.Now(), nil})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = writer.Close()
+	assert.EqualError(t, errE, "parquet value: unexpected type")
+}
+
+func TestEntitiesParquet(t *testing.T) {
+	t.Parallel()
+
+	dumpPath := filepath.Join(t.TempDir(), "dump.json")
+	writeNDJSON(t, dumpPath, append(slices.Clone(labelsTestEntities),
+		`{"id": "Q64", "type": "item", "pageid": 123, "ns": 0, "title": "Q64", "lastrevid": 2, "modified": "2020-01-02T00:00:00Z",
+			"labels": {"en": {"language": "en", "value": "Berlin"}},
+			"descriptions": {"en": {"language": "en", "value": "capital of Germany"}},
+			"aliases": {"en": [{"language": "en", "value": "Berlin, Germany"}, {"language": "en", "value": "DE-BE"}]},
+			"sitelinks": {"enwiki": {"site": "enwiki", "title": "Berlin", "badges": ["Q17437796"]}},
+			"claims": {"P1082": [{"id": "Q64$1", "type": "statement", "rank": "preferred",
+				"mainsnak": {"snaktype": "value", "property": "P1082", "datatype": "quantity",
+					"datavalue": {"type": "quantity", "value": {"amount": "+3677472", "unit": "1"}}},
+				"qualifiers": {"P585": [{"snaktype": "value", "property": "P585", "datatype": "time",
+					"datavalue": {"type": "time", "value": {"time": "+2021-12-31T00:00:00Z", "timezone": 0, "before": 0, "after": 0, "precision": 11, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}]}}],
+				"P625": [{"id": "Q64$2", "type": "statement", "rank": "normal",
+				"mainsnak": {"snaktype": "value", "property": "P625", "datatype": "globe-coordinate",
+					"datavalue": {"type": "globecoordinate", "value": {"latitude": 52.52, "longitude": 13.405, "precision": 0.001, "globe": "http://www.wikidata.org/entity/Q2"}}}}],
+				"P1448": [{"id": "Q64$3", "type": "statement", "rank": "normal",
+				"mainsnak": {"snaktype": "novalue", "property": "P1448", "datatype": "monolingualtext"}}]}}`,
+	)...)
+
+	path := filepath.Join(t.TempDir(), "entities")
+	exporter := &mediawiki.EntitiesParquet{Path: path, RowGroupSize: 2}
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Entity]{
+		Path:                   dumpPath,
+		Process:                exporter.Add,
+		ItemsProcessingThreads: 4,
+		FileType:               mediawiki.NDJSON,
+		Compression:            mediawiki.NoCompression,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = exporter.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	names, rows := readParquet(t, filepath.Join(path, "entities.parquet"))
+	assert.Equal(t, []string{"id", "type", "datatype", "page_id", "namespace", "title", "modified", "last_revision_id"}, names)
+	sortParquetRows(rows)
+	assert.Equal(t, [][]any{
+		{"P31", "property", "wikibase-item", int64(0), int64(0), "", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), int64(1)},
+		{"Q42", "item", nil, int64(0), int64(0), "", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), int64(1)},
+		{"Q5", "item", nil, int64(0), int64(0), "", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), int64(1)},
+		{"Q64", "item", nil, int64(123), int64(0), "Q64", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), int64(2)},
+	}, rows)
+
+	_, rows = readParquet(t, filepath.Join(path, "labels.parquet"))
+	assert.Len(t, rows, 6)
+	_, rows = readParquet(t, filepath.Join(path, "descriptions.parquet"))
+	assert.Equal(t, [][]any{{"Q64", "en", "capital of Germany"}}, rows)
+	_, rows = readParquet(t, filepath.Join(path, "aliases.parquet"))
+	assert.Equal(t, [][]any{{"Q64", "en", "Berlin, Germany"}, {"Q64", "en", "DE-BE"}}, rows)
+	_, rows = readParquet(t, filepath.Join(path, "sitelinks.parquet"))
+	assert.Equal(t, [][]any{{"Q64", "enwiki", "Berlin", nil, []any{"Q17437796"}}}, rows)
+
+	names, rows = readParquet(t, filepath.Join(path, "statements.parquet"))
+	assert.Len(t, names, 24)
+	sortParquetRows(rows)
+	assert.Equal(t, [][]any{
+		{
+			"Q42", "Q42$1", "P31", "normal", "value", nil, nil, "Q5", nil, nil, nil, nil, nil, nil, nil,
+			nil, nil, nil, nil, nil, nil, nil, int64(0), int64(1),
+		},
+		{
+			"Q64", "Q64$1", "P1082", "preferred", "value", "quantity", nil, nil, nil, nil, nil, nil, nil, nil, nil,
+			"3677472", nil, nil, "1", nil, nil, nil, int64(1), int64(0),
+		},
+		{
+			"Q64", "Q64$2", "P625", "normal", "value", "globe-coordinate", nil, nil, nil, nil, nil, 52.52, 13.405, 0.001,
+			"http://www.wikidata.org/entity/Q2", nil, nil, nil, nil, nil, nil, nil, int64(0), int64(0),
+		},
+		{
+			"Q64", "Q64$3", "P1448", "normal", "novalue", "monolingualtext", nil, nil, nil, nil, nil, nil, nil, nil, nil,
+			nil, nil, nil, nil, nil, nil, nil, int64(0), int64(0),
+		},
+	}, rows)
+
+	// Close without entities creates empty files.
+	empty := &mediawiki.EntitiesParquet{Path: filepath.Join(t.TempDir(), "empty")}
+	errE = empty.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	_, rows = readParquet(t, filepath.Join(empty.Path, "statements.parquet"))
+	assert.Empty(t, rows)
+}
+
+func TestArticlesParquet(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "articles.parquet")
+	exporter := &mediawiki.ArticlesParquet{Path: path}
+	errE := exporter.Add(context.Background(), mediawiki.Article{
+		Name:         "Ljubljana",
+		Identifier:   1,
+		URL:          "https://en.wikipedia.org/wiki/Ljubljana",
+		DateCreated:  time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		DateModified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:      mediawiki.Version{Identifier: 42},
+		InLanguage:   mediawiki.InLanguage{Identifier: "en"},
+		IsPartOf:     mediawiki.IsPartOf{Identifier: "enwiki"},
+		MainEntity:   &mediawiki.EntityRef{Identifier: "Q437"},
+		Categories:   []mediawiki.Category{{Name: "Category:Capitals in Europe"}, {Name: "Category:Ljubljana"}},
+		Templates:    []mediawiki.Template{{Name: "Template:Infobox settlement"}},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = exporter.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	names, rows := readParquet(t, path)
+	assert.Len(t, names, 14)
+	assert.Equal(t, [][]any{{
+		"Ljubljana", int64(1), "https://en.wikipedia.org/wiki/Ljubljana", int64(0), "en", "enwiki",
+		time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), int64(42), "Q437", nil,
+		[]any{"Category:Capitals in Europe", "Category:Ljubljana"}, []any{"Template:Infobox settlement"}, []any{},
+	}}, rows)
+}
+
+func TestSQLTableParquet(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "image.parquet")
+	exporter := &mediawiki.SQLTableParquet{Path: path}
+	errE := exporter.Add(context.Background(), map[string]interface{}{"img_name": "Foo.jpg", "img_size": float64(1234), "img_metadata": nil})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = exporter.Add(context.Background(), map[string]interface{}{"img_name": "Bar.png", "img_size": nil, "img_metadata": "a:0:{}"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = exporter.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	names, rows := readParquet(t, path)
+	assert.Equal(t, []string{"img_metadata", "img_name", "img_size"}, names)
+	assert.Equal(t, [][]any{{nil, "Foo.jpg", float64(1234)}, {"a:0:{}", "Bar.png", nil}}, rows)
+
+	path = filepath.Join(t.TempDir(), "page.parquet")
+	exporter = &mediawiki.SQLTableParquet{Path: path, Columns: []mediawiki.ParquetColumn{
+		{Name: "page_id", Type: mediawiki.ParquetInt64},
+		{Name: "page_touched", Type: mediawiki.ParquetTimestamp},
+	}}
+	errE = exporter.Add(context.Background(), map[string]interface{}{"page_id": float64(1), "page_touched": "20240102030405"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = exporter.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	_, rows = readParquet(t, path)
+	assert.Equal(t, [][]any{{int64(1), time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}}, rows)
+
+	errE = (&mediawiki.SQLTableParquet{Path: filepath.Join(t.TempDir(), "empty.parquet")}).Close()
+	assert.EqualError(t, errE, "columns not set")
+}
@@ -0,0 +1,608 @@
+package mediawiki
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// Parquet schemas used by EntitiesParquet, one per file in its directory.
+//
+//nolint:gochecknoglobals
+var (
+	// EntityParquetColumns are columns of entities.parquet, one row per entity.
+	EntityParquetColumns = []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "type", Type: ParquetString},
+		{Name: "datatype", Type: ParquetString, Optional: true},
+		{Name: "page_id", Type: ParquetInt64},
+		{Name: "namespace", Type: ParquetInt64},
+		{Name: "title", Type: ParquetString},
+		{Name: "modified", Type: ParquetTimestamp},
+		{Name: "last_revision_id", Type: ParquetInt64},
+	}
+
+	// LabelParquetColumns are columns of labels.parquet and descriptions.parquet,
+	// one row per entity and language.
+	LabelParquetColumns = []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "language", Type: ParquetString},
+		{Name: "value", Type: ParquetString},
+	}
+
+	// AliasParquetColumns are columns of aliases.parquet, one row per alias.
+	AliasParquetColumns = []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "language", Type: ParquetString},
+		{Name: "value", Type: ParquetString},
+	}
+
+	// SiteLinkParquetColumns are columns of sitelinks.parquet, one row per site link.
+	SiteLinkParquetColumns = []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "site", Type: ParquetString},
+		{Name: "title", Type: ParquetString},
+		{Name: "url", Type: ParquetString, Optional: true},
+		{Name: "badges", Type: ParquetString, Repeated: true},
+	}
+
+	// StatementParquetColumns are columns of statements.parquet, one row per statement.
+	//
+	// The main snak value is stored in value columns matching its type: value_string
+	// for string-like values (strings, external IDs, URLs, Commons media, etc.),
+	// value_entity_id for entity values, value_time, value_time_precision and
+	// value_calendar for time values (time is formatted as in Wikibase JSON),
+	// value_latitude, value_longitude, value_coordinate_precision and value_globe
+	// for globe coordinates, value_amount, value_upper_bound, value_lower_bound
+	// and value_unit for quantities (amounts are decimal strings), value_text and
+	// value_language for monolingual text, and value_error for values which failed
+	// to parse. Other value columns are null. Qualifiers and references are counted.
+	StatementParquetColumns = []ParquetColumn{
+		{Name: "entity_id", Type: ParquetString},
+		{Name: "statement_id", Type: ParquetString},
+		{Name: "property", Type: ParquetString},
+		{Name: "rank", Type: ParquetString},
+		{Name: "snak_type", Type: ParquetString},
+		{Name: "datatype", Type: ParquetString, Optional: true},
+		{Name: "value_string", Type: ParquetString, Optional: true},
+		{Name: "value_entity_id", Type: ParquetString, Optional: true},
+		{Name: "value_time", Type: ParquetString, Optional: true},
+		{Name: "value_time_precision", Type: ParquetInt64, Optional: true},
+		{Name: "value_calendar", Type: ParquetString, Optional: true},
+		{Name: "value_latitude", Type: ParquetDouble, Optional: true},
+		{Name: "value_longitude", Type: ParquetDouble, Optional: true},
+		{Name: "value_coordinate_precision", Type: ParquetDouble, Optional: true},
+		{Name: "value_globe", Type: ParquetString, Optional: true},
+		{Name: "value_amount", Type: ParquetString, Optional: true},
+		{Name: "value_upper_bound", Type: ParquetString, Optional: true},
+		{Name: "value_lower_bound", Type: ParquetString, Optional: true},
+		{Name: "value_unit", Type: ParquetString, Optional: true},
+		{Name: "value_text", Type: ParquetString, Optional: true},
+		{Name: "value_language", Type: ParquetString, Optional: true},
+		{Name: "value_error", Type: ParquetString, Optional: true},
+		{Name: "qualifiers", Type: ParquetInt64},
+		{Name: "references", Type: ParquetInt64},
+	}
+
+	// ArticleParquetColumns are columns used by ArticlesParquet, one row per article.
+	// Categories, templates, and redirects are lists of their names.
+	// Article body is not exported.
+	ArticleParquetColumns = []ParquetColumn{
+		{Name: "name", Type: ParquetString},
+		{Name: "identifier", Type: ParquetInt64},
+		{Name: "url", Type: ParquetString},
+		{Name: "namespace", Type: ParquetInt64},
+		{Name: "in_language", Type: ParquetString},
+		{Name: "is_part_of", Type: ParquetString},
+		{Name: "date_created", Type: ParquetTimestamp},
+		{Name: "date_modified", Type: ParquetTimestamp},
+		{Name: "version_identifier", Type: ParquetInt64},
+		{Name: "main_entity", Type: ParquetString, Optional: true},
+		{Name: "abstract", Type: ParquetString, Optional: true},
+		{Name: "categories", Type: ParquetString, Repeated: true},
+		{Name: "templates", Type: ParquetString, Repeated: true},
+		{Name: "redirects", Type: ParquetString, Repeated: true},
+	}
+)
+
+// parquetFile is a ParquetWriter writing into a file.
+type parquetFile struct {
+	file   *os.File
+	buffer *bufio.Writer
+	writer *ParquetWriter
+}
+
+func createParquetFile(path string, columns []ParquetColumn, rowGroupSize int, compression ParquetCompression) (*parquetFile, errors.E) {
+	file, err := os.Create(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	buffer := bufio.NewWriter(file)
+	return &parquetFile{
+		file:   file,
+		buffer: buffer,
+		writer: &ParquetWriter{
+			Writer:       buffer,
+			Columns:      columns,
+			RowGroupSize: rowGroupSize,
+			Compression:  compression,
+		},
+	}, nil
+}
+
+func (f *parquetFile) close() errors.E {
+	errE := f.writer.Close()
+	if errE != nil {
+		f.file.Close() //nolint:errcheck,gosec
+		return errE
+	}
+	err := f.buffer.Flush()
+	err2 := f.file.Close()
+	return errors.Join(err, err2)
+}
+
+// enumString returns JSON string representation of an enumeration value.
+func enumString(v json.Marshaler) string {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(string(data), `"`)
+}
+
+func optionalString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// EntitiesParquet exports entities into Parquet files in the directory at Path:
+// entities.parquet, labels.parquet, descriptions.parquet, aliases.parquet,
+// sitelinks.parquet, and statements.parquet. See EntityParquetColumns and
+// other schemas for their columns.
+//
+// Add's signature matches the callback of ProcessWikidataDump and
+// ProcessCommonsEntitiesDump so it can be passed to them directly. Call Close when done.
+// It is safe to use concurrently.
+type EntitiesParquet struct {
+	Path         string
+	RowGroupSize int
+	Compression  ParquetCompression
+
+	mu    sync.Mutex
+	files map[string]*parquetFile
+}
+
+func (e *EntitiesParquet) init() errors.E {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.files != nil {
+		return nil
+	}
+	err := os.MkdirAll(e.Path, 0o755) //nolint:mnd,gosec
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = e.Path
+		return errE
+	}
+	files := map[string]*parquetFile{}
+	for name, columns := range map[string][]ParquetColumn{
+		"entities":     EntityParquetColumns,
+		"labels":       LabelParquetColumns,
+		"descriptions": LabelParquetColumns,
+		"aliases":      AliasParquetColumns,
+		"sitelinks":    SiteLinkParquetColumns,
+		"statements":   StatementParquetColumns,
+	} {
+		file, errE := createParquetFile(filepath.Join(e.Path, name+".parquet"), columns, e.RowGroupSize, e.Compression)
+		if errE != nil {
+			for _, f := range files {
+				f.file.Close() //nolint:errcheck,gosec
+			}
+			return errE
+		}
+		files[name] = file
+	}
+	e.files = files
+	return nil
+}
+
+// Add writes the entity.
+func (e *EntitiesParquet) Add(_ context.Context, entity Entity) errors.E {
+	errE := e.init()
+	if errE != nil {
+		return errE
+	}
+
+	var dataType any
+	if entity.DataType != nil {
+		dataType = enumString(*entity.DataType)
+	}
+	errE = e.files["entities"].writer.Write([]any{
+		entity.ID, enumString(entity.Type), dataType, entity.PageID, int64(entity.Namespace),
+		entity.Title, entity.Modified, entity.LastRevID,
+	})
+	if errE != nil {
+		return errE
+	}
+	for _, language := range slices.Sorted(maps.Keys(entity.Labels)) {
+		errE := e.files["labels"].writer.Write([]any{entity.ID, language, entity.Labels[language].Value})
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, language := range slices.Sorted(maps.Keys(entity.Descriptions)) {
+		errE := e.files["descriptions"].writer.Write([]any{entity.ID, language, entity.Descriptions[language].Value})
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, language := range slices.Sorted(maps.Keys(entity.Aliases)) {
+		for _, alias := range entity.Aliases[language] {
+			errE := e.files["aliases"].writer.Write([]any{entity.ID, language, alias.Value})
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	for _, site := range slices.Sorted(maps.Keys(entity.SiteLinks)) {
+		siteLink := entity.SiteLinks[site]
+		errE := e.files["sitelinks"].writer.Write([]any{entity.ID, site, siteLink.Title, optionalString(siteLink.URL), siteLink.Badges})
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, property := range slices.SortedFunc(maps.Keys(entity.Claims), CompareEntityIDs) {
+		for _, statement := range entity.Claims[property] {
+			errE := e.files["statements"].writer.Write(statementParquetRow(entity.ID, statement))
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	return nil
+}
+
+func statementParquetRow(id string, statement Statement) []any {
+	row := make([]any, len(StatementParquetColumns))
+	row[0] = id
+	row[1] = statement.ID
+	row[2] = statement.MainSnak.Property
+	row[3] = enumString(statement.Rank)
+	row[4] = enumString(statement.MainSnak.SnakType)
+	row[5] = snakDataType(statement.MainSnak)
+	copy(row[6:22], snakValues(statement.MainSnak))
+	qualifiers := 0
+	for _, snaks := range statement.Qualifiers {
+		qualifiers += len(snaks)
+	}
+	row[22] = int64(qualifiers)
+	row[23] = int64(len(statement.References))
+	return row
+}
+
+// snakDataType returns the data type of the snak or nil if it is not set.
+func snakDataType(snak Snak) any {
+	if snak.DataType == nil {
+		return nil
+	}
+	return enumString(*snak.DataType)
+}
+
+// snakValues flattens the snak value into 16 values matching value columns
+// of StatementParquetColumns, from value_string to value_error. Values not
+// applicable to the snak's value type are nil.
+func snakValues(snak Snak) []any {
+	values := make([]any, 16) //nolint:mnd
+	if snak.DataValue == nil {
+		return values
+	}
+	switch value := snak.DataValue.Value.(type) {
+	case StringValue:
+		values[0] = string(value)
+	case WikiBaseEntityIDValue:
+		values[1] = value.ID
+	case TimeValue:
+		values[2] = formatTime(value.Time, value.Precision)
+		values[3] = int64(value.Precision)
+		values[4] = enumString(value.Calendar)
+	case GlobeCoordinateValue:
+		values[5] = value.Latitude
+		values[6] = value.Longitude
+		values[7] = value.Precision
+		values[8] = value.Globe
+	case QuantityValue:
+		values[9] = value.Amount.String()
+		if value.UpperBound != nil {
+			values[10] = value.UpperBound.String()
+		}
+		if value.LowerBound != nil {
+			values[11] = value.LowerBound.String()
+		}
+		values[12] = value.Unit
+	case MonolingualTextValue:
+		values[13] = value.Text
+		values[14] = value.Language
+	case ErrorValue:
+		values[15] = string(value)
+	}
+	return values
+}
+
+// Close writes remaining rows and footers and closes all files.
+// If no entity was added, empty files are created.
+func (e *EntitiesParquet) Close() errors.E {
+	errE := e.init()
+	if errE != nil {
+		return errE
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	errs := []error{}
+	for _, name := range slices.Sorted(maps.Keys(e.files)) {
+		errs = append(errs, e.files[name].close())
+	}
+	e.files = nil
+	return errors.Join(errs...)
+}
+
+// ArticlesParquet exports articles into a Parquet file at Path.
+// See ArticleParquetColumns for its columns.
+//
+// Add's signature matches the callback of ProcessWikipediaDump so it
+// can be passed to it directly. Call Close when done.
+// It is safe to use concurrently.
+type ArticlesParquet struct {
+	Path         string
+	RowGroupSize int
+	Compression  ParquetCompression
+
+	mu   sync.Mutex
+	file *parquetFile
+}
+
+func (a *ArticlesParquet) init() errors.E {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		return nil
+	}
+	file, errE := createParquetFile(a.Path, ArticleParquetColumns, a.RowGroupSize, a.Compression)
+	if errE != nil {
+		return errE
+	}
+	a.file = file
+	return nil
+}
+
+// Add writes the article.
+func (a *ArticlesParquet) Add(_ context.Context, article Article) errors.E {
+	errE := a.init()
+	if errE != nil {
+		return errE
+	}
+
+	var mainEntity any
+	if article.MainEntity != nil {
+		mainEntity = article.MainEntity.Identifier
+	}
+	categories := make([]string, 0, len(article.Categories))
+	for _, category := range article.Categories {
+		categories = append(categories, category.Name)
+	}
+	templates := make([]string, 0, len(article.Templates))
+	for _, template := range article.Templates {
+		templates = append(templates, template.Name)
+	}
+	redirects := make([]string, 0, len(article.Redirects))
+	for _, redirect := range article.Redirects {
+		redirects = append(redirects, redirect.Name)
+	}
+	return a.file.writer.Write([]any{
+		article.Name, article.Identifier, article.URL, article.Namespace.Identifier,
+		article.InLanguage.Identifier, article.IsPartOf.Identifier, article.DateCreated,
+		article.DateModified, article.Version.Identifier, mainEntity,
+		optionalString(article.Abstract), categories, templates, redirects,
+	})
+}
+
+// Close writes remaining rows and the footer and closes the file.
+func (a *ArticlesParquet) Close() errors.E {
+	errE := a.init()
+	if errE != nil {
+		return errE
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	errE = a.file.close()
+	a.file = nil
+	return errE
+}
+
+// SQLTableParquet exports rows of a SQL table dump into a Parquet file at Path.
+//
+// Rows are maps from column names to values, as decoded by Process with SQLDump
+// file type into map[string]interface{}. Columns lists columns to export. If
+// Columns is empty, columns are inferred from the first row: all columns of
+// the row, sorted by name, with strings as ParquetString and numbers as
+// ParquetDouble. All inferred columns are optional. Values are converted to
+// column types where possible (e.g., integral float64 to ParquetInt64).
+//
+// Add can be used as Process callback. Call Close when done.
+// It is safe to use concurrently.
+type SQLTableParquet struct {
+	Path         string
+	Columns      []ParquetColumn
+	RowGroupSize int
+	Compression  ParquetCompression
+
+	mu   sync.Mutex
+	file *parquetFile
+}
+
+func inferSQLColumns(row map[string]interface{}) []ParquetColumn {
+	columns := []ParquetColumn{}
+	for _, name := range slices.Sorted(maps.Keys(row)) {
+		column := ParquetColumn{Name: name, Type: ParquetString, Optional: true}
+		switch row[name].(type) {
+		case float64, json.Number:
+			column.Type = ParquetDouble
+		case int64, int, uint64:
+			column.Type = ParquetInt64
+		case bool:
+			column.Type = ParquetBoolean
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+func (s *SQLTableParquet) init(row map[string]interface{}) errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil {
+		return nil
+	}
+	if len(s.Columns) == 0 {
+		if row == nil {
+			return errors.New("columns not set")
+		}
+		s.Columns = inferSQLColumns(row)
+	}
+	file, errE := createParquetFile(s.Path, s.Columns, s.RowGroupSize, s.Compression)
+	if errE != nil {
+		return errE
+	}
+	s.file = file
+	return nil
+}
+
+func sqlParquetValue(column ParquetColumn, value interface{}) (any, errors.E) { //nolint:gocyclo,cyclop
+	if value == nil {
+		return nil, nil
+	}
+	if number, ok := value.(json.Number); ok {
+		value = number.String()
+		if column.Type == ParquetInt64 {
+			v, err := number.Int64()
+			if err == nil {
+				return v, nil
+			}
+		} else if column.Type == ParquetDouble {
+			v, err := number.Float64()
+			if err == nil {
+				return v, nil
+			}
+		}
+	}
+	switch column.Type {
+	case ParquetString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		case float64, int64, int, uint64, bool:
+			return fmt.Sprint(v), nil
+		}
+	case ParquetInt64:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case int:
+			return int64(v), nil
+		case uint64:
+			return int64(v), nil //nolint:gosec
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		}
+	case ParquetDouble:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case int:
+			return float64(v), nil
+		}
+	case ParquetBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			return v != 0, nil
+		case int64:
+			return v != 0, nil
+		}
+	case ParquetTimestamp:
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			// MediaWiki timestamps are in YYYYMMDDHHMMSS format.
+			t, err := time.Parse("20060102150405", v)
+			if err == nil {
+				return t, nil
+			}
+		}
+	}
+	errE := errors.WithMessage(ErrUnexpectedType, "sql value")
+	errors.Details(errE)["type"] = fmt.Sprintf("%T", value)
+	return nil, errE
+}
+
+// Add writes the row.
+func (s *SQLTableParquet) Add(_ context.Context, row map[string]interface{}) errors.E {
+	errE := s.init(row)
+	if errE != nil {
+		return errE
+	}
+
+	values := make([]any, len(s.Columns))
+	for i, column := range s.Columns {
+		value, errE := sqlParquetValue(column, row[column.Name])
+		if errE != nil {
+			errors.Details(errE)["column"] = column.Name
+			return errE
+		}
+		values[i] = value
+	}
+	return s.file.writer.Write(values)
+}
+
+// Close writes remaining rows and the footer and closes the file.
+func (s *SQLTableParquet) Close() errors.E {
+	errE := s.init(nil)
+	if errE != nil {
+		return errE
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errE = s.file.close()
+	s.file = nil
+	return errE
+}
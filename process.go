@@ -13,11 +13,13 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
 	"github.com/cosnicolaou/pbzip2"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/klauspost/compress/zstd"
 	gzip "github.com/klauspost/pgzip"
 	"github.com/pingcap/tidb/pkg/parser"
 	"github.com/pingcap/tidb/pkg/parser/ast"
@@ -28,9 +30,32 @@ import (
 )
 
 const (
-	progressPrintRate = 30 * time.Second
+	progressPrintRate     = 30 * time.Second
+	defaultSourcesThreads = 2
 )
 
+// sharedCountingReader is an io.Reader proxy which adds the number
+// of bytes it read and passed on to count.
+type sharedCountingReader struct {
+	Reader io.Reader
+	count  *atomic.Int64
+}
+
+func (c *sharedCountingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.count.Add(int64(n))
+	return n, err //nolint:wrapcheck
+}
+
+// int64Counter implements counter interface for x.NewTicker.
+type int64Counter struct {
+	value *atomic.Int64
+}
+
+func (c int64Counter) Count() int64 {
+	return c.value.Load()
+}
+
 type iterator interface {
 	More() bool
 	Next(b *[]byte) errors.E
@@ -96,12 +121,61 @@ func newStatementIterator(r io.Reader) *statementIterator {
 	}
 }
 
+// pageIterator iterates over <page> elements in MediaWiki XML dumps.
+//
+// It depends on the layout of XML dumps where opening and
+// closing page tags are each on its own line.
+type pageIterator struct {
+	reader *bufio.Reader
+	buffer *bytes.Buffer
+}
+
+func (i *pageIterator) More() bool {
+	_, err := i.reader.Peek(1)
+	return !errors.Is(err, io.EOF)
+}
+
+func (i *pageIterator) Next(b *[]byte) errors.E {
+	for {
+		line, err := i.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return errors.WithMessage(err, "read bytes")
+		}
+		trimmed := bytes.TrimSpace(line)
+		if i.buffer.Len() > 0 || bytes.Equal(trimmed, []byte("<page>")) {
+			i.buffer.Write(line)
+			if bytes.Equal(trimmed, []byte("</page>")) {
+				*b = i.buffer.Bytes()
+				i.buffer = new(bytes.Buffer)
+				return nil
+			}
+		}
+		if err != nil {
+			if i.buffer.Len() > 0 {
+				return errors.New("incomplete page")
+			}
+			// Only siteinfo and closing mediawiki tag were remaining.
+			return errors.WithStack(io.EOF)
+		}
+	}
+}
+
+func newPageIterator(r io.Reader) *pageIterator {
+	return &pageIterator{
+		reader: bufio.NewReader(r),
+		buffer: new(bytes.Buffer),
+	}
+}
+
 type FileType int
 
 const (
 	JSONArray FileType = iota
 	NDJSON
 	SQLDump
+	// XMLDump is a MediaWiki XML dump. Each page is decoded into Page
+	// and then converted to JSON to be decoded into the target type.
+	XMLDump
 )
 
 type Compression int
@@ -113,8 +187,20 @@ const (
 	BZIP2Tar
 	GZIP
 	GZIPTar
+	ZSTD
+	ZSTDTar
 )
 
+// Source is one file (part) of a dump split into multiple files.
+//
+// URL or Path are required. See ProcessConfig for details.
+type Source struct {
+	URL      string
+	Path     string
+	Checksum Checksum
+	Mirrors  []string
+}
+
 // ProcessConfig is a configuration for low-level Process function.
 //
 // URL or Path, Process, FileType, and Compression are required.
@@ -126,6 +212,36 @@ const (
 // while downloading and processing the file at URL. If the file at Path already
 // exists, then Process just uses it as-is and does not download anything from URL.
 //
+// If Checksum is set, an already existing file at Path is verified before it is
+// processed, and a downloaded file is hashed while it is being processed. Process
+// returns ErrChecksumMismatch if the file does not match it. If processing a downloaded
+// file fails (e.g., because of a decompression error), the rest of the file is read
+// and hashed and the error is wrapped with ErrChecksumMismatch if the file does not match.
+// In all those cases the file at Path is removed (if URL is provided) so that the next
+// call downloads it again.
+//
+// Mirrors are URLs of the same file which are tried in order when the download from URL
+// (or the previous mirror) cannot be started, e.g., because the file is missing there.
+// A download which is slow or stalls once it has started is not switched to a mirror.
+// See DumpSource.MirrorURLs.
+//
+// Large dumps are split into multiple files (parts). Instead of URL, Path, Checksum, and Mirrors,
+// Sources can be provided to process all parts as one dump. Each source is downloaded
+// and/or cached as described above for URL, Path, Checksum, and Mirrors. Up to SourcesThreads
+// sources (default 2, to be polite to dump servers) are downloaded and decompressed
+// concurrently, and items from all of them are processed together in no particular order.
+// Sources are started in the order of Sources, so with SourcesThreads set to 1 (and
+// DecodingThreads and ItemsProcessingThreads set to 1, too), items are processed in order.
+// Progress is reported for all sources combined. Errors include "source" detail
+// with the index of the source in Sources.
+//
+// If Index is set, positions of all items are recorded into a dump index stored in
+// the Index directory while the file is processed, without a separate pass over
+// the file (see DumpIndex). The index is stored only if the whole file is processed
+// successfully. Index requires Path (the index is used with the file saved there)
+// and cannot be used together with Sources. Only JSONArray and NDJSON file types
+// with BZIP2 and NoCompression compressions are supported.
+//
 // Client should set User-Agent header with contact information, e.g.:
 //
 //	client := retryablehttp.NewClient()
@@ -143,45 +259,106 @@ type ProcessConfig[T any] struct {
 	Progress               func(context.Context, x.Progress)
 	FileType               FileType
 	Compression            Compression
+	Checksum               Checksum
+	Mirrors                []string
+	Sources                []Source
+	SourcesThreads         int
+	Index                  string
 }
 
+// download starts downloading the source from its URL or,
+// if that fails, from its mirrors in order. If all fail,
+// the error for the URL is returned.
+func download(ctx context.Context, client *retryablehttp.Client, source Source) (*x.RetryableResponse, errors.E) {
+	var firstErrE errors.E
+	for _, u := range append([]string{source.URL}, source.Mirrors...) {
+		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			errE := errors.WithMessage(err, "new request")
+			errors.Details(errE)["url"] = u
+			return nil, errE
+		}
+		downloadReader, errE := x.NewRetryableResponse(client, req)
+		if errE == nil {
+			return downloadReader, nil
+		}
+		errors.Details(errE)["url"] = u
+		if firstErrE == nil {
+			firstErrE = errE
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErrE
+}
+
+// getFileRows reads rows from the source and sends them to output. It sends
+// at most one error to errs. Read bytes are added to count and the size of
+// the source is added to size, once known. If indexer is set, positions
+// of rows are sent to it.
 func getFileRows[T any]( //nolint:maintidx
-	ctx context.Context, config *ProcessConfig[T], wg *sync.WaitGroup,
-	output chan<- []byte, errs chan<- errors.E,
+	ctx context.Context, config *ProcessConfig[T], source Source,
+	count, size *atomic.Int64, output chan<- []byte, errs chan<- errors.E, indexer *dumpIndexer,
 ) {
-	defer wg.Done()
+	// corrupted is set when the file does not match the checksum.
+	corrupted := false
+	defer func() {
+		if corrupted && source.Path != "" && source.URL != "" {
+			// Remove the corrupted file so that it is downloaded again next time.
+			_ = os.Remove(source.Path)
+		}
+	}()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var compressedReader io.Reader
 	var compressedSize int64
+	// cached is set when the file at Path already exists.
+	cached := false
 
-	if config.Path != "" {
+	if source.Path != "" {
 		// If we file is already available, we use it.
-		compressedFile, err := os.Open(config.Path)
+		compressedFile, err := os.Open(source.Path)
 		if err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
 				errE := errors.WithMessage(err, "open")
-				errors.Details(errE)["path"] = config.Path
+				errors.Details(errE)["path"] = source.Path
 				errs <- errE
 				return
 			}
 			// File does not exists. Continue.
 		} else {
 			defer compressedFile.Close() //nolint:errcheck
+			if !source.Checksum.IsZero() {
+				// We verify the cached file before processing it so that a corrupted
+				// file is detected (and removed) before any of its items are processed.
+				errE := VerifyDumpFile(source.Path, source.Checksum)
+				if errE != nil {
+					if errors.Is(errE, ErrChecksumMismatch) {
+						corrupted = true
+						if source.URL != "" {
+							errors.Details(errE)["url"] = source.URL
+						}
+					}
+					errs <- errE
+					return
+				}
+			}
+			cached = true
 			compressedReader = compressedFile
 			compressedSize, err = compressedFile.Seek(0, io.SeekEnd)
 			if err != nil {
 				errE := errors.WithMessage(err, "seek end")
-				errors.Details(errE)["path"] = config.Path
+				errors.Details(errE)["path"] = source.Path
 				errs <- errE
 				return
 			}
 			_, err = compressedFile.Seek(0, io.SeekStart)
 			if err != nil {
 				errE := errors.WithMessage(err, "seek start")
-				errors.Details(errE)["path"] = config.Path
+				errors.Details(errE)["path"] = source.Path
 				errs <- errE
 				return
 			}
@@ -190,34 +367,26 @@ func getFileRows[T any]( //nolint:maintidx
 
 	if compressedReader == nil {
 		// File does not already exist. We download the file and optionally save it.
-		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, config.URL, nil)
-		if err != nil {
-			errE := errors.WithMessage(err, "new request")
-			errors.Details(errE)["url"] = config.URL
-			errs <- errE
-			return
-		}
-		downloadReader, errE := x.NewRetryableResponse(config.Client, req)
+		downloadReader, errE := download(ctx, config.Client, source)
 		if errE != nil {
-			errors.Details(errE)["url"] = config.URL
 			errs <- errE
 			return
 		}
 		defer downloadReader.Close() //nolint:errcheck
 		compressedSize = downloadReader.Size()
-		if config.Path != "" {
-			compressedFile, err := os.Create(config.Path)
+		if source.Path != "" {
+			compressedFile, err := os.Create(source.Path)
 			if err != nil {
 				errE := errors.WithMessage(err, "create")
-				errors.Details(errE)["path"] = config.Path
+				errors.Details(errE)["path"] = source.Path
 				errs <- errE
 				return
 			}
 			defer func() {
-				info, err := os.Stat(config.Path)
+				info, err := os.Stat(source.Path)
 				if err != nil || downloadReader.Size() != info.Size() {
 					// Incomplete file. Delete.
-					_ = os.Remove(config.Path)
+					_ = os.Remove(source.Path)
 				}
 			}()
 			defer compressedFile.Close() //nolint:errcheck
@@ -227,34 +396,108 @@ func getFileRows[T any]( //nolint:maintidx
 		}
 	}
 
-	countingReader := &x.CountingReader{Reader: compressedReader}
-	ticker := x.NewTicker(ctx, countingReader, x.NewCounter(compressedSize), progressPrintRate)
-	defer ticker.Stop()
-	go func() {
-		for progress := range ticker.C {
-			if config.Progress != nil {
-				config.Progress(ctx, progress)
+	var hasher *checksumHasher
+	if !source.Checksum.IsZero() && !cached {
+		// Cached file has already been verified above, so we hash only while downloading.
+		hasher = newChecksumHasher(source.Checksum)
+		compressedReader = io.TeeReader(compressedReader, hasher)
+	}
+	// Decompressors read from compressedReader in their own goroutines,
+	// so we have to synchronize with reading the rest of the file in drain.
+	compressedReader = &lockedReader{Reader: compressedReader, mu: sync.Mutex{}}
+
+	// Context for decompressors which is canceled to stop them before
+	// the rest of the file is read in drain.
+	decompressCtx, decompressCancel := context.WithCancel(ctx)
+	defer decompressCancel()
+
+	// drain makes sure the whole file is read (and written out to
+	// compressedFile, if saving) and verifies its checksum.
+	drain := func() errors.E {
+		decompressCancel()
+		_, err := io.Copy(io.Discard, compressedReader)
+		if hasher == nil {
+			return nil
+		}
+		if err != nil {
+			return errors.WithMessage(err, "read")
+		}
+		errE := hasher.verify()
+		if errE != nil {
+			corrupted = true
+			if source.Path != "" {
+				errors.Details(errE)["path"] = source.Path
+			}
+			if source.URL != "" {
+				errors.Details(errE)["url"] = source.URL
 			}
+			return errE
 		}
-	}()
+		return nil
+	}
+
+	finish := func() {
+		errE := drain()
+		if errE != nil {
+			errs <- errE
+		}
+	}
+
+	// fail sends errE to errs. If the file is being hashed, the rest of it is read
+	// first and if the file does not match the checksum, errE is wrapped with
+	// ErrChecksumMismatch because errE was most likely caused by the corrupted file.
+	fail := func(errE errors.E) {
+		if hasher == nil || ctx.Err() != nil {
+			errs <- errE
+			return
+		}
+		checksumErr := drain()
+		if errors.Is(checksumErr, ErrChecksumMismatch) {
+			errE = errors.WrapWith(errE, checksumErr)
+			if source.Path != "" {
+				errors.Details(errE)["path"] = source.Path
+			}
+			if source.URL != "" {
+				errors.Details(errE)["url"] = source.URL
+			}
+		}
+		errs <- errE
+	}
+
+	size.Add(compressedSize)
+	countingReader := &sharedCountingReader{Reader: compressedReader, count: count}
 
 	var decompressedReader io.Reader
 	switch config.Compression {
 	case BZIP2, BZIP2Tar:
-		decompressedReader = pbzip2.NewReader(
-			ctx, countingReader,
-			pbzip2.DecompressionOptions(
-				pbzip2.BZConcurrency(config.DecompressionThreads),
-			),
-		)
+		if indexer != nil {
+			// We decompress blocks ourselves to record their positions.
+			decompressedReader = indexer.decompress(decompressCtx, countingReader, config.DecompressionThreads)
+			defer indexer.stopDecompress()
+		} else {
+			decompressedReader = pbzip2.NewReader(
+				decompressCtx, countingReader,
+				pbzip2.DecompressionOptions(
+					pbzip2.BZConcurrency(config.DecompressionThreads),
+				),
+			)
+		}
 	case GZIP, GZIPTar:
 		gzipReader, err := gzip.NewReader(countingReader)
 		if err != nil {
-			errs <- errors.WithMessage(err, "new gzip reader")
+			fail(errors.WithMessage(err, "new gzip reader"))
 			return
 		}
 		defer gzipReader.Close() //nolint:errcheck
 		decompressedReader = gzipReader
+	case ZSTD, ZSTDTar:
+		zstdReader, err := zstd.NewReader(countingReader, zstd.WithDecoderConcurrency(config.DecompressionThreads))
+		if err != nil {
+			fail(errors.WithMessage(err, "new zstd reader"))
+			return
+		}
+		defer zstdReader.Close()
+		decompressedReader = zstdReader
 	case NoCompression, Tar:
 		decompressedReader = countingReader
 	default:
@@ -263,21 +506,20 @@ func getFileRows[T any]( //nolint:maintidx
 		panic(errE)
 	}
 
-	if config.Compression == Tar || config.Compression == GZIPTar || config.Compression == BZIP2Tar {
+	if config.Compression == Tar || config.Compression == GZIPTar || config.Compression == BZIP2Tar || config.Compression == ZSTDTar {
 		decompressedReader = tar.NewReader(decompressedReader)
 	}
 
 	for {
-		if config.Compression == Tar || config.Compression == GZIPTar || config.Compression == BZIP2Tar {
+		if config.Compression == Tar || config.Compression == GZIPTar || config.Compression == BZIP2Tar || config.Compression == ZSTDTar {
 			// Go to the first or next file in gzip/tar.
 			_, err := decompressedReader.(*tar.Reader).Next() //nolint:forcetypeassert,errcheck
 			if err != nil {
 				// When there are no more files in gzip/tar, Next returns io.EOF.
 				if errors.Is(err, io.EOF) {
-					// Make sure the whole file is written out to compressedFile.
-					_, _ = io.Copy(io.Discard, compressedReader)
+					finish()
 				} else {
-					errs <- errors.WithMessage(err, "tar reader next")
+					fail(errors.WithMessage(err, "tar reader next"))
 				}
 				return
 			}
@@ -289,13 +531,15 @@ func getFileRows[T any]( //nolint:maintidx
 			iter = newJSONIterator(decompressedReader)
 		case SQLDump:
 			iter = newStatementIterator(decompressedReader)
+		case XMLDump:
+			iter = newPageIterator(decompressedReader)
 		}
 
 		if config.FileType == JSONArray {
 			// Read open bracket.
 			_, err := (*json.Decoder)(iter.(*jsonIterator)).Token() //nolint:forcetypeassert,errcheck
 			if err != nil {
-				errs <- errors.WithMessage(err, "json decoder token")
+				fail(errors.WithMessage(err, "json decoder token"))
 				return
 			}
 		}
@@ -309,9 +553,17 @@ func getFileRows[T any]( //nolint:maintidx
 				if errors.Is(err, io.EOF) {
 					break
 				}
-				errs <- err
+				fail(err)
 				return
 			}
+			if indexer != nil {
+				offset := (*json.Decoder)(iter.(*jsonIterator)).InputOffset() - int64(len(row)) //nolint:forcetypeassert
+				errE := indexer.add(ctx, row, offset)
+				if errE != nil {
+					errs <- errE
+					return
+				}
+			}
 			select {
 			case <-ctx.Done():
 				errs <- errors.WithStack(ctx.Err())
@@ -324,25 +576,36 @@ func getFileRows[T any]( //nolint:maintidx
 			// Read closing bracket.
 			_, err := (*json.Decoder)(iter.(*jsonIterator)).Token() //nolint:forcetypeassert,errcheck
 			if err != nil {
-				errs <- errors.WithMessage(err, "json decoder token")
+				fail(errors.WithMessage(err, "json decoder token"))
 				return
 			}
 
 			_, err = (*json.Decoder)(iter.(*jsonIterator)).Token() //nolint:forcetypeassert,errcheck
 			if !errors.Is(err, io.EOF) {
-				errs <- errors.New("invalid data after top-level value")
+				fail(errors.New("invalid data after top-level value"))
 				return
 			}
 		}
 
-		if config.Compression != Tar && config.Compression != GZIPTar && config.Compression != BZIP2Tar {
+		if config.Compression != Tar && config.Compression != GZIPTar && config.Compression != BZIP2Tar && config.Compression != ZSTDTar {
 			// Only tar can have multiple files.
 			break
 		}
 	}
 
-	// Make sure the whole file is written out to compressedFile.
-	_, _ = io.Copy(io.Discard, compressedReader)
+	finish()
+}
+
+// lockedReader is an io.Reader proxy which allows only one Read at a time.
+type lockedReader struct {
+	Reader io.Reader
+	mu     sync.Mutex
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Reader.Read(p) //nolint:wrapcheck
 }
 
 // Similar to strings.ToValidUTF8, but makes sure that the number
@@ -493,6 +756,21 @@ func decodeRows[T any](
 					errs <- errE
 					return
 				}
+			} else if config.FileType == XMLDump {
+				page, errE := decodePage(row)
+				if errE != nil {
+					errE = errors.Prefix(errE, ErrXMLDecode)
+					errors.Details(errE)["row"] = string(row)
+					errs <- errE
+					return
+				}
+				// We marshal to JSON to decode to a struct if provided.
+				d, errE := x.MarshalWithoutEscapeHTML(page)
+				if errE != nil {
+					errs <- errE
+					return
+				}
+				decodeJSON(ctx, d, output, errs)
 			} else {
 				decodeJSON(ctx, row, output, errs)
 			}
@@ -528,8 +806,8 @@ func processItems[T any](
 }
 
 // Process is a low-level function which decompresses a file (supports Compression compressions),
-// extacts JSONs or SQL statements from it (stored in FileType types), decodes JSONs or SQL statements, and
-// calls Process callback on each decoded JSON or SQL statement. All that in parallel fashion, controlled by
+// extacts JSONs, SQL statements, or XML pages from it (stored in FileType types), decodes them, and
+// calls Process callback on each decoded JSON, SQL statement, or XML page. All that in parallel fashion, controlled by
 // DecompressionThreads, DecodingThreads, and ItemsProcessingThreads. File is downloaded from a HTTP URL and is
 // processed already during download. Downloaded file is optionally saved (to a file at Path) and followup
 // calls to Process can use a saved file (if same Path is provided).
@@ -543,6 +821,25 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 	if config.ItemsProcessingThreads == 0 {
 		config.ItemsProcessingThreads = runtime.GOMAXPROCS(0)
 	}
+	if config.SourcesThreads == 0 {
+		config.SourcesThreads = defaultSourcesThreads
+	}
+
+	sources := config.Sources
+	if len(sources) == 0 {
+		sources = []Source{{URL: config.URL, Path: config.Path, Checksum: config.Checksum, Mirrors: config.Mirrors}}
+	} else if config.URL != "" || config.Path != "" || !config.Checksum.IsZero() || len(config.Mirrors) > 0 {
+		return errors.New("URL, Path, Checksum, and Mirrors cannot be used together with Sources")
+	}
+
+	var indexer *dumpIndexer
+	if config.Index != "" {
+		var errE errors.E
+		indexer, errE = newDumpIndexer(config)
+		if errE != nil {
+			return errE
+		}
+	}
 
 	// We call cancel on any error from goroutines. The expectation is that all
 	// goroutines return soon afterwards.
@@ -555,22 +852,65 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 	// mainWgChan is closed when mainWg is done.
 	mainWgChan := make(chan struct{})
 
-	errs := make(chan errors.E, 1+config.DecodingThreads+config.ItemsProcessingThreads)
+	errs := make(chan errors.E, len(sources)+2*config.DecodingThreads+config.ItemsProcessingThreads)
 	defer close(errs)
 
 	rows := make(chan []byte, config.DecodingThreads)
 	items := make(chan T, config.ItemsProcessingThreads)
 
+	// Progress is reported for all sources combined.
+	var count, size atomic.Int64
+	ticker := x.NewTicker(ctx, int64Counter{&count}, int64Counter{&size}, progressPrintRate)
+	defer ticker.Stop()
+	go func() {
+		for progress := range ticker.C {
+			if config.Progress != nil {
+				config.Progress(ctx, progress)
+			}
+		}
+	}()
+
 	var getFileRowsWg sync.WaitGroup
 	mainWg.Add(1)
-	getFileRowsWg.Add(1)
-	go getFileRows(ctx, config, &getFileRowsWg, rows, errs)
+	// semaphore limits the number of sources processed concurrently.
+	semaphore := make(chan struct{}, config.SourcesThreads)
 	go func() {
-		getFileRowsWg.Wait()
-		mainWg.Done()
-		// All goroutines using rows channel as output are done,
-		// we can close the channel.
-		close(rows)
+		defer func() {
+			getFileRowsWg.Wait()
+			mainWg.Done()
+			// All goroutines using rows channel as output are done,
+			// we can close the channel.
+			close(rows)
+			if indexer != nil {
+				close(indexer.rows)
+			}
+		}()
+
+		// Sources are started in order, so with one source thread
+		// they are also processed in order.
+		for i, source := range sources {
+			select {
+			case <-ctx.Done():
+				return
+			case semaphore <- struct{}{}:
+			}
+			getFileRowsWg.Add(1)
+			go func() {
+				defer getFileRowsWg.Done()
+				defer func() { <-semaphore }()
+
+				sourceErrs := make(chan errors.E, 1)
+				getFileRows(ctx, config, source, &count, &size, rows, sourceErrs, indexer)
+				select {
+				case errE := <-sourceErrs:
+					if len(config.Sources) > 0 {
+						errors.Details(errE)["source"] = i
+					}
+					errs <- errE
+				default:
+				}
+			}()
+		}
 	}()
 
 	var decodeRowsWg sync.WaitGroup
@@ -588,6 +928,19 @@ func Process[T any](ctx context.Context, config *ProcessConfig[T]) errors.E {
 		close(items)
 	}()
 
+	if indexer != nil {
+		var recordWg sync.WaitGroup
+		mainWg.Add(1)
+		for range config.DecodingThreads {
+			recordWg.Add(1)
+			go indexer.record(ctx, &recordWg, errs)
+		}
+		go func() {
+			recordWg.Wait()
+			mainWg.Done()
+		}()
+	}
+
 	var processItemWg sync.WaitGroup
 	mainWg.Add(1)
 	for range config.ItemsProcessingThreads {
@@ -624,6 +977,17 @@ WAIT:
 		}
 	}
 
+	if indexer != nil {
+		if len(allErrors) > 0 {
+			indexer.abort()
+		} else {
+			errE := indexer.finish(ctx)
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+
 	if len(allErrors) > 0 {
 		// If there is any non-context-canceled error, return them.
 		nonCanceledErrors := []error{}
@@ -1,15 +1,24 @@
 package mediawiki_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
 
 	"gitlab.com/tozd/go/mediawiki"
 )
@@ -117,3 +126,87 @@ func TestSQLDump(t *testing.T) {
 	require.NoError(t, err, "% -+#.1v", err)
 	assert.Equal(t, int64(9057), itemCounter)
 }
+
+func TestProcessSources(t *testing.T) {
+	t.Parallel()
+
+	expected := readDump(t, filepath.Join("testdata", "wikidata-testdata-index.json.bz2"), mediawiki.JSONArray, mediawiki.BZIP2)
+
+	// We split the dump into parts.
+	dir := t.TempDir()
+	parts := make([][]byte, 3)
+	for i := range parts {
+		var buffer bytes.Buffer
+		writer := &mediawiki.DumpWriter[json.RawMessage]{Writer: &buffer, FileType: mediawiki.NDJSON, Compression: mediawiki.GZIP}
+		for j := i; j < len(expected); j += len(parts) {
+			errE := writer.Write(context.Background(), json.RawMessage(expected[j]))
+			require.NoError(t, errE, "% -+#.1v", errE)
+		}
+		errE := writer.Close()
+		require.NoError(t, errE, "% -+#.1v", errE)
+		parts[i] = buffer.Bytes()
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "part0.ndjson.gz"), parts[0], 0o600))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i, part := range parts {
+			if req.URL.Path == fmt.Sprintf("/part%d.ndjson.gz", i) {
+				http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(part))
+				return
+			}
+		}
+		http.NotFound(w, req)
+	}))
+	t.Cleanup(ts.Close)
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+
+	sources := []mediawiki.Source{
+		// Local file.
+		{Path: filepath.Join(dir, "part0.ndjson.gz"), Checksum: checksumOf(parts[0])},
+		// Downloaded and cached.
+		{URL: ts.URL + "/part1.ndjson.gz", Path: filepath.Join(dir, "part1.ndjson.gz"), Checksum: checksumOf(parts[1])},
+		// Just downloaded.
+		{URL: ts.URL + "/part2.ndjson.gz"},
+	}
+
+	var mu sync.Mutex
+	rows := []string{}
+	errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[json.RawMessage]{
+		Client: client,
+		Process: func(_ context.Context, row json.RawMessage) errors.E {
+			mu.Lock()
+			defer mu.Unlock()
+			rows = append(rows, string(row))
+			return nil
+		},
+		FileType:       mediawiki.NDJSON,
+		Compression:    mediawiki.GZIP,
+		Sources:        sources,
+		SourcesThreads: 3,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ElementsMatch(t, expected, rows)
+	assert.FileExists(t, filepath.Join(dir, "part1.ndjson.gz"))
+	assert.NoFileExists(t, filepath.Join(dir, "part2.ndjson.gz"))
+
+	// Errors contain the index of the source.
+	sources = append(sources, mediawiki.Source{URL: ts.URL + "/part3.ndjson.gz"})
+	errE = mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[json.RawMessage]{
+		Client: client,
+		Process: func(_ context.Context, _ json.RawMessage) errors.E {
+			return nil
+		},
+		FileType:    mediawiki.NDJSON,
+		Compression: mediawiki.GZIP,
+		Sources:     sources,
+	})
+	require.ErrorIs(t, errE, x.ErrResponseBadStatus)
+	assert.Equal(t, 3, errors.AllDetails(errE)["source"])
+
+	errE = mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[json.RawMessage]{
+		URL:     ts.URL + "/part0.ndjson.gz",
+		Sources: sources,
+	})
+	assert.EqualError(t, errE, "URL, Path, Checksum, and Mirrors cannot be used together with Sources")
+}
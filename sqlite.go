@@ -0,0 +1,713 @@
+package mediawiki
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const defaultSQLiteBatchSize = 1000
+
+// Value columns of snaks, matching values returned by snakValues.
+const sqliteSnakColumns = `snak_type TEXT NOT NULL,
+	datatype TEXT,
+	value_string TEXT,
+	value_entity_id TEXT,
+	value_time TEXT,
+	value_time_precision INTEGER,
+	value_calendar TEXT,
+	value_latitude REAL,
+	value_longitude REAL,
+	value_coordinate_precision REAL,
+	value_globe TEXT,
+	value_amount TEXT,
+	value_upper_bound TEXT,
+	value_lower_bound TEXT,
+	value_unit TEXT,
+	value_text TEXT,
+	value_language TEXT,
+	value_error TEXT`
+
+const sqliteSnakColumnNames = `snak_type, datatype, value_string, value_entity_id, value_time,
+	value_time_precision, value_calendar, value_latitude, value_longitude,
+	value_coordinate_precision, value_globe, value_amount, value_upper_bound,
+	value_lower_bound, value_unit, value_text, value_language, value_error`
+
+// SQLite schemas and indexes used by EntitiesSQLite and ArticlesSQLite.
+//
+//nolint:gochecknoglobals
+var (
+	// EntitySQLiteSchema creates tables used by EntitiesSQLite.
+	//
+	// Times are stored as RFC 3339 strings. Snak values of statements, qualifiers,
+	// and references are stored in value columns matching their type, as described
+	// for StatementParquetColumns. Positions are 0-based and preserve the order
+	// of aliases, qualifiers, references, and reference snaks. Site link badges are
+	// stored as a JSON array.
+	EntitySQLiteSchema = []string{
+		`CREATE TABLE IF NOT EXISTS entity (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	datatype TEXT,
+	page_id INTEGER NOT NULL,
+	namespace INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	modified TEXT,
+	last_revision_id INTEGER NOT NULL
+)`,
+		`CREATE TABLE IF NOT EXISTS label (
+	entity_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	value TEXT NOT NULL
+)`,
+		`CREATE TABLE IF NOT EXISTS description (
+	entity_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	value TEXT NOT NULL
+)`,
+		`CREATE TABLE IF NOT EXISTS alias (
+	entity_id TEXT NOT NULL,
+	language TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	value TEXT NOT NULL
+)`,
+		`CREATE TABLE IF NOT EXISTS sitelink (
+	entity_id TEXT NOT NULL,
+	site TEXT NOT NULL,
+	title TEXT NOT NULL,
+	url TEXT,
+	badges TEXT NOT NULL
+)`,
+		`CREATE TABLE IF NOT EXISTS statement (
+	id TEXT NOT NULL,
+	entity_id TEXT NOT NULL,
+	property TEXT NOT NULL,
+	rank TEXT NOT NULL,
+	` + sqliteSnakColumns + `
+)`,
+		`CREATE TABLE IF NOT EXISTS qualifier (
+	statement_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	hash TEXT,
+	property TEXT NOT NULL,
+	` + sqliteSnakColumns + `
+)`,
+		`CREATE TABLE IF NOT EXISTS reference_snak (
+	statement_id TEXT NOT NULL,
+	reference INTEGER NOT NULL,
+	reference_hash TEXT,
+	position INTEGER NOT NULL,
+	property TEXT NOT NULL,
+	` + sqliteSnakColumns + `
+)`,
+	}
+
+	// EntitySQLiteIndexes are created by EntitiesSQLite after all entities are loaded.
+	EntitySQLiteIndexes = []string{
+		`CREATE INDEX IF NOT EXISTS label_entity_id ON label (entity_id, language)`,
+		`CREATE INDEX IF NOT EXISTS label_value ON label (value)`,
+		`CREATE INDEX IF NOT EXISTS description_entity_id ON description (entity_id, language)`,
+		`CREATE INDEX IF NOT EXISTS alias_entity_id ON alias (entity_id, language)`,
+		`CREATE INDEX IF NOT EXISTS alias_value ON alias (value)`,
+		`CREATE INDEX IF NOT EXISTS sitelink_entity_id ON sitelink (entity_id)`,
+		`CREATE INDEX IF NOT EXISTS sitelink_site_title ON sitelink (site, title)`,
+		`CREATE INDEX IF NOT EXISTS statement_id ON statement (id)`,
+		`CREATE INDEX IF NOT EXISTS statement_entity_id ON statement (entity_id, property)`,
+		`CREATE INDEX IF NOT EXISTS statement_property_value_entity_id ON statement (property, value_entity_id)`,
+		`CREATE INDEX IF NOT EXISTS qualifier_statement_id ON qualifier (statement_id)`,
+		`CREATE INDEX IF NOT EXISTS reference_snak_statement_id ON reference_snak (statement_id)`,
+	}
+
+	// ArticleSQLiteSchema creates tables used by ArticlesSQLite.
+	//
+	// Articles are identified by the wiki (is_part_of) and page ID (identifier).
+	// Times are stored as RFC 3339 strings. Article body is not loaded.
+	ArticleSQLiteSchema = []string{
+		`CREATE TABLE IF NOT EXISTS article (
+	is_part_of TEXT NOT NULL,
+	identifier INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	url TEXT NOT NULL,
+	namespace INTEGER NOT NULL,
+	in_language TEXT NOT NULL,
+	date_created TEXT,
+	date_modified TEXT,
+	version_identifier INTEGER NOT NULL,
+	main_entity TEXT,
+	abstract TEXT,
+	PRIMARY KEY (is_part_of, identifier)
+)`,
+		`CREATE TABLE IF NOT EXISTS article_category (
+	is_part_of TEXT NOT NULL,
+	identifier INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	url TEXT NOT NULL
+)`,
+	}
+
+	// ArticleSQLiteIndexes are created by ArticlesSQLite after all articles are loaded.
+	ArticleSQLiteIndexes = []string{
+		`CREATE INDEX IF NOT EXISTS article_name ON article (is_part_of, name)`,
+		`CREATE INDEX IF NOT EXISTS article_main_entity ON article (main_entity)`,
+		`CREATE INDEX IF NOT EXISTS article_category_identifier ON article_category (is_part_of, identifier)`,
+		`CREATE INDEX IF NOT EXISTS article_category_name ON article_category (name)`,
+	}
+)
+
+// sqliteBatcher collects items and writes them in batches, each batch in its own transaction.
+type sqliteBatcher[T any] struct {
+	mu          sync.Mutex
+	initialized bool
+	batch       []T
+
+	// writeMu serializes transactions because SQLite supports only one writer at a time.
+	writeMu sync.Mutex
+}
+
+// init executes statements returned by schema, only once. Schema is called
+// with b.mu held, so it can set state which is then read under b.mu, too.
+func (b *sqliteBatcher[T]) init(ctx context.Context, db *sql.DB, schema func() ([]string, errors.E)) errors.E {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.initialized {
+		return nil
+	}
+	statements, errE := schema()
+	if errE != nil {
+		return errE
+	}
+	errE = execSQLite(ctx, db, statements)
+	if errE != nil {
+		return errE
+	}
+	b.initialized = true
+	return nil
+}
+
+func (b *sqliteBatcher[T]) add(
+	ctx context.Context, db *sql.DB, batchSize int, item T, write func(context.Context, *sql.Tx, []T) errors.E,
+) errors.E {
+	if batchSize == 0 {
+		batchSize = defaultSQLiteBatchSize
+	}
+
+	b.mu.Lock()
+	b.batch = append(b.batch, item)
+	var batch []T
+	if len(b.batch) >= batchSize {
+		batch = b.batch
+		b.batch = nil
+	}
+	b.mu.Unlock()
+
+	if batch == nil {
+		return nil
+	}
+	return b.write(ctx, db, batch, write)
+}
+
+func (b *sqliteBatcher[T]) write(ctx context.Context, db *sql.DB, batch []T, write func(context.Context, *sql.Tx, []T) errors.E) errors.E {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	errE := write(ctx, tx, batch)
+	if errE != nil {
+		tx.Rollback() //nolint:errcheck,gosec
+		return errE
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+func (b *sqliteBatcher[T]) close(
+	ctx context.Context, db *sql.DB, write func(context.Context, *sql.Tx, []T) errors.E, indexes []string,
+) errors.E {
+	b.mu.Lock()
+	batch := b.batch
+	b.batch = nil
+	b.mu.Unlock()
+
+	if len(batch) > 0 {
+		errE := b.write(ctx, db, batch, write)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	return execSQLite(ctx, db, indexes)
+}
+
+func staticSQLiteSchema(statements []string) func() ([]string, errors.E) {
+	return func() ([]string, errors.E) {
+		return statements, nil
+	}
+}
+
+func execSQLite(ctx context.Context, db *sql.DB, statements []string) errors.E {
+	for _, statement := range statements {
+		_, err := db.ExecContext(ctx, statement)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["statement"] = statement
+			return errE
+		}
+	}
+	return nil
+}
+
+// sqliteStatements prepares insert statements within a transaction.
+type sqliteStatements struct {
+	ctx        context.Context //nolint:containedctx
+	tx         *sql.Tx
+	statements map[string]*sql.Stmt
+}
+
+func newSQLiteStatements(ctx context.Context, tx *sql.Tx) *sqliteStatements {
+	return &sqliteStatements{
+		ctx:        ctx,
+		tx:         tx,
+		statements: map[string]*sql.Stmt{},
+	}
+}
+
+// exec executes the query with args, preparing the query on first use.
+func (s *sqliteStatements) exec(query string, args ...any) (sql.Result, errors.E) {
+	stmt, ok := s.statements[query]
+	if !ok {
+		var err error
+		stmt, err = s.tx.PrepareContext(s.ctx, query)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["statement"] = query
+			return nil, errE
+		}
+		s.statements[query] = stmt
+	}
+	result, err := stmt.ExecContext(s.ctx, args...)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["statement"] = query
+		return nil, errE
+	}
+	return result, nil
+}
+
+// insert executes the query with args and returns true if a row was inserted.
+func (s *sqliteStatements) insert(query string, args ...any) (bool, errors.E) {
+	result, errE := s.exec(query, args...)
+	if errE != nil {
+		return false, errE
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return n > 0, nil
+}
+
+func (s *sqliteStatements) close() {
+	for _, stmt := range s.statements {
+		stmt.Close() //nolint:errcheck,gosec
+	}
+}
+
+func sqlitePlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func sqliteTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func quoteSQLiteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+//nolint:gochecknoglobals
+var (
+	sqliteInsertEntity = `INSERT OR IGNORE INTO entity (id, type, datatype, page_id, namespace, title, modified, last_revision_id)
+	VALUES (` + sqlitePlaceholders(8) + `)`
+
+	sqliteInsertLabel       = `INSERT INTO label (entity_id, language, value) VALUES (?, ?, ?)`
+	sqliteInsertDescription = `INSERT INTO description (entity_id, language, value) VALUES (?, ?, ?)`
+	sqliteInsertAlias       = `INSERT INTO alias (entity_id, language, position, value) VALUES (?, ?, ?, ?)`
+	sqliteInsertSiteLink    = `INSERT INTO sitelink (entity_id, site, title, url, badges) VALUES (?, ?, ?, ?, ?)`
+
+	sqliteInsertStatement = `INSERT INTO statement (id, entity_id, property, rank, ` + sqliteSnakColumnNames + `)
+	VALUES (` + sqlitePlaceholders(4+18) + `)`
+
+	sqliteInsertQualifier = `INSERT INTO qualifier (statement_id, position, hash, property, ` + sqliteSnakColumnNames + `)
+	VALUES (` + sqlitePlaceholders(4+18) + `)`
+
+	sqliteInsertReferenceSnak = `INSERT INTO reference_snak (statement_id, reference, reference_hash, position, property, ` + sqliteSnakColumnNames + `)
+	VALUES (` + sqlitePlaceholders(5+18) + `)`
+
+	sqliteInsertArticle = `INSERT OR IGNORE INTO article (is_part_of, identifier, name, url, namespace, in_language,
+	date_created, date_modified, version_identifier, main_entity, abstract)
+	VALUES (` + sqlitePlaceholders(11) + `)`
+
+	sqliteInsertArticleCategory = `INSERT INTO article_category (is_part_of, identifier, position, name, url) VALUES (?, ?, ?, ?, ?)`
+)
+
+// snakSQLiteArgs returns arguments for snak columns, from snak_type to value_error.
+func snakSQLiteArgs(snak Snak) []any {
+	return append([]any{enumString(snak.SnakType), snakDataType(snak)}, snakValues(snak)...)
+}
+
+// EntitiesSQLite loads entities into a SQLite database using a normalized schema,
+// see EntitySQLiteSchema. Tables are created if they do not yet exist.
+//
+// DB is an open SQLite database, using any database/sql SQLite driver.
+// Entities are inserted in batches of BatchSize (default 1000), each batch in its
+// own transaction. Indexes (see EntitySQLiteIndexes) are created in Close, after
+// all entities have been loaded.
+//
+// Loading is resumable: entities already in the database are skipped, so
+// an interrupted load can be restarted with the same dump and the same database.
+// This also means that loading a newer dump into the same database does not
+// update existing entities.
+//
+// Add's signature matches the callback of ProcessWikidataDump and
+// ProcessCommonsEntitiesDump so it can be passed to them directly. Call Close when done.
+// It is safe to use concurrently.
+type EntitiesSQLite struct {
+	DB        *sql.DB
+	BatchSize int
+
+	batcher sqliteBatcher[Entity]
+}
+
+// Add loads the entity. It might be inserted only once its batch is full.
+func (e *EntitiesSQLite) Add(ctx context.Context, entity Entity) errors.E {
+	errE := e.batcher.init(ctx, e.DB, staticSQLiteSchema(EntitySQLiteSchema))
+	if errE != nil {
+		return errE
+	}
+	return e.batcher.add(ctx, e.DB, e.BatchSize, entity, writeEntitiesSQLite)
+}
+
+// Close inserts remaining entities and creates indexes.
+// It does not close DB.
+func (e *EntitiesSQLite) Close(ctx context.Context) errors.E {
+	errE := e.batcher.init(ctx, e.DB, staticSQLiteSchema(EntitySQLiteSchema))
+	if errE != nil {
+		return errE
+	}
+	return e.batcher.close(ctx, e.DB, writeEntitiesSQLite, EntitySQLiteIndexes)
+}
+
+func writeEntitiesSQLite(ctx context.Context, tx *sql.Tx, entities []Entity) errors.E {
+	s := newSQLiteStatements(ctx, tx)
+	defer s.close()
+
+	for _, entity := range entities {
+		errE := writeEntitySQLite(s, entity)
+		if errE != nil {
+			errors.Details(errE)["entity"] = entity.ID
+			return errE
+		}
+	}
+	return nil
+}
+
+func writeEntitySQLite(s *sqliteStatements, entity Entity) errors.E { //nolint:gocognit
+	var dataType any
+	if entity.DataType != nil {
+		dataType = enumString(*entity.DataType)
+	}
+	inserted, errE := s.insert(sqliteInsertEntity,
+		entity.ID, enumString(entity.Type), dataType, entity.PageID, entity.Namespace,
+		entity.Title, sqliteTime(entity.Modified), entity.LastRevID,
+	)
+	if errE != nil {
+		return errE
+	}
+	if !inserted {
+		// The entity has already been loaded (together with everything
+		// else below, in the same transaction).
+		return nil
+	}
+
+	for _, language := range slices.Sorted(maps.Keys(entity.Labels)) {
+		_, errE := s.exec(sqliteInsertLabel, entity.ID, language, entity.Labels[language].Value)
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, language := range slices.Sorted(maps.Keys(entity.Descriptions)) {
+		_, errE := s.exec(sqliteInsertDescription, entity.ID, language, entity.Descriptions[language].Value)
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, language := range slices.Sorted(maps.Keys(entity.Aliases)) {
+		for i, alias := range entity.Aliases[language] {
+			_, errE := s.exec(sqliteInsertAlias, entity.ID, language, i, alias.Value)
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	for _, site := range slices.Sorted(maps.Keys(entity.SiteLinks)) {
+		siteLink := entity.SiteLinks[site]
+		badges := siteLink.Badges
+		if badges == nil {
+			badges = []string{}
+		}
+		data, err := json.Marshal(badges)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, errE := s.exec(sqliteInsertSiteLink, entity.ID, site, siteLink.Title, optionalString(siteLink.URL), string(data))
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, property := range slices.SortedFunc(maps.Keys(entity.Claims), CompareEntityIDs) {
+		for _, statement := range entity.Claims[property] {
+			_, errE := s.exec(sqliteInsertStatement, append([]any{
+				statement.ID, entity.ID, statement.MainSnak.Property, enumString(statement.Rank),
+			}, snakSQLiteArgs(statement.MainSnak)...)...)
+			if errE != nil {
+				return errE
+			}
+			for i, snak := range flattenSnaks(statement.Qualifiers, statement.QualifiersOrder) {
+				_, errE := s.exec(sqliteInsertQualifier, append([]any{
+					statement.ID, i, optionalString(snak.Hash), snak.Property,
+				}, snakSQLiteArgs(snak)...)...)
+				if errE != nil {
+					return errE
+				}
+			}
+			for i, reference := range statement.References {
+				for j, snak := range flattenSnaks(reference.Snaks, reference.SnaksOrder) {
+					_, errE := s.exec(sqliteInsertReferenceSnak, append([]any{
+						statement.ID, i, optionalString(reference.Hash), j, snak.Property,
+					}, snakSQLiteArgs(snak)...)...)
+					if errE != nil {
+						return errE
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ArticlesSQLite loads articles into a SQLite database, see ArticleSQLiteSchema.
+// Tables are created if they do not yet exist.
+//
+// DB is an open SQLite database, using any database/sql SQLite driver.
+// Articles are inserted in batches of BatchSize (default 1000), each batch in its
+// own transaction. Indexes (see ArticleSQLiteIndexes) are created in Close, after
+// all articles have been loaded.
+//
+// Loading is resumable: articles already in the database are skipped.
+//
+// Add's signature matches the callback of ProcessWikipediaDump so it
+// can be passed to it directly. Call Close when done.
+// It is safe to use concurrently.
+type ArticlesSQLite struct {
+	DB        *sql.DB
+	BatchSize int
+
+	batcher sqliteBatcher[Article]
+}
+
+// Add loads the article. It might be inserted only once its batch is full.
+func (a *ArticlesSQLite) Add(ctx context.Context, article Article) errors.E {
+	errE := a.batcher.init(ctx, a.DB, staticSQLiteSchema(ArticleSQLiteSchema))
+	if errE != nil {
+		return errE
+	}
+	return a.batcher.add(ctx, a.DB, a.BatchSize, article, writeArticlesSQLite)
+}
+
+// Close inserts remaining articles and creates indexes.
+// It does not close DB.
+func (a *ArticlesSQLite) Close(ctx context.Context) errors.E {
+	errE := a.batcher.init(ctx, a.DB, staticSQLiteSchema(ArticleSQLiteSchema))
+	if errE != nil {
+		return errE
+	}
+	return a.batcher.close(ctx, a.DB, writeArticlesSQLite, ArticleSQLiteIndexes)
+}
+
+func writeArticlesSQLite(ctx context.Context, tx *sql.Tx, articles []Article) errors.E {
+	s := newSQLiteStatements(ctx, tx)
+	defer s.close()
+
+	for _, article := range articles {
+		var mainEntity any
+		if article.MainEntity != nil {
+			mainEntity = article.MainEntity.Identifier
+		}
+		inserted, errE := s.insert(sqliteInsertArticle,
+			article.IsPartOf.Identifier, article.Identifier, article.Name, article.URL,
+			article.Namespace.Identifier, article.InLanguage.Identifier, sqliteTime(article.DateCreated),
+			sqliteTime(article.DateModified), article.Version.Identifier, mainEntity,
+			optionalString(article.Abstract),
+		)
+		if errE != nil {
+			errors.Details(errE)["article"] = article.Name
+			return errE
+		}
+		if !inserted {
+			continue
+		}
+		for i, category := range article.Categories {
+			_, errE := s.exec(sqliteInsertArticleCategory, article.IsPartOf.Identifier, article.Identifier, i, category.Name, category.URL)
+			if errE != nil {
+				errors.Details(errE)["article"] = article.Name
+				return errE
+			}
+		}
+	}
+	return nil
+}
+
+// SQLTableSQLite loads rows of a SQL table dump (e.g., page or pagelinks)
+// into a SQLite table named Table. The table is created if it does not yet exist.
+//
+// Rows are maps from column names to values, as decoded by Process with SQLDump
+// file type into map[string]interface{}. Columns lists columns to load. If
+// Columns is empty, all columns of the first row are loaded, sorted by name.
+// Columns with string values in the first row get TEXT affinity, columns with
+// numbers NUMERIC affinity (so integral numbers are stored as integers).
+//
+// If PrimaryKey is set, it is used as the primary key of the table and rows
+// already in the table are skipped, which makes loading resumable. Indexes lists
+// columns of indexes to create in Close, after all rows have been loaded.
+// Rows are inserted in batches of BatchSize (default 1000), each batch in its own transaction.
+//
+// Add can be used as Process callback. Call Close when done.
+// It is safe to use concurrently.
+type SQLTableSQLite struct {
+	DB         *sql.DB
+	Table      string
+	Columns    []string
+	PrimaryKey []string
+	Indexes    [][]string
+	BatchSize  int
+
+	batcher sqliteBatcher[map[string]interface{}]
+	insert  string
+}
+
+// schema is called by the batcher with its lock held.
+func (s *SQLTableSQLite) schema(row map[string]interface{}) ([]string, errors.E) {
+	if len(s.Columns) == 0 {
+		if row == nil {
+			return nil, errors.New("columns not set")
+		}
+		s.Columns = slices.Sorted(maps.Keys(row))
+	}
+
+	definitions := []string{}
+	names := []string{}
+	for _, column := range s.Columns {
+		definition := quoteSQLiteIdentifier(column)
+		switch row[column].(type) {
+		case string, []byte:
+			definition += " TEXT"
+		case float64, json.Number, int64, int, uint64:
+			definition += " NUMERIC"
+		}
+		definitions = append(definitions, definition)
+		names = append(names, quoteSQLiteIdentifier(column))
+	}
+	insert := "INSERT"
+	if len(s.PrimaryKey) > 0 {
+		keys := []string{}
+		for _, column := range s.PrimaryKey {
+			keys = append(keys, quoteSQLiteIdentifier(column))
+		}
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(keys, ", ")))
+		insert = "INSERT OR IGNORE"
+	}
+	table := quoteSQLiteIdentifier(s.Table)
+	s.insert = fmt.Sprintf("%s INTO %s (%s) VALUES (%s)", insert, table, strings.Join(names, ", "), sqlitePlaceholders(len(names)))
+	return []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(definitions, ", "))}, nil
+}
+
+func (s *SQLTableSQLite) init(ctx context.Context, row map[string]interface{}) errors.E {
+	return s.batcher.init(ctx, s.DB, func() ([]string, errors.E) {
+		return s.schema(row)
+	})
+}
+
+func (s *SQLTableSQLite) write(ctx context.Context, tx *sql.Tx, rows []map[string]interface{}) errors.E {
+	// Columns and insert are set by schema under the batcher's lock.
+	s.batcher.mu.Lock()
+	columns := s.Columns
+	insert := s.insert
+	s.batcher.mu.Unlock()
+
+	st := newSQLiteStatements(ctx, tx)
+	defer st.close()
+
+	for _, row := range rows {
+		args := make([]any, len(columns))
+		for i, column := range columns {
+			value := row[column]
+			if number, ok := value.(json.Number); ok {
+				value = number.String()
+			}
+			args[i] = value
+		}
+		_, errE := st.exec(insert, args...)
+		if errE != nil {
+			return errE
+		}
+	}
+	return nil
+}
+
+// Add loads the row. It might be inserted only once its batch is full.
+func (s *SQLTableSQLite) Add(ctx context.Context, row map[string]interface{}) errors.E {
+	errE := s.init(ctx, row)
+	if errE != nil {
+		return errE
+	}
+	return s.batcher.add(ctx, s.DB, s.BatchSize, row, s.write)
+}
+
+// Close inserts remaining rows and creates indexes.
+// It does not close DB.
+func (s *SQLTableSQLite) Close(ctx context.Context) errors.E {
+	errE := s.init(ctx, nil)
+	if errE != nil {
+		return errE
+	}
+	indexes := []string{}
+	for _, index := range s.Indexes {
+		columns := []string{}
+		for _, column := range index {
+			columns = append(columns, quoteSQLiteIdentifier(column))
+		}
+		name := quoteSQLiteIdentifier(s.Table + "_" + strings.Join(index, "_"))
+		indexes = append(indexes, fmt.Sprintf(
+			"CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, quoteSQLiteIdentifier(s.Table), strings.Join(columns, ", "),
+		))
+	}
+	return s.batcher.close(ctx, s.DB, s.write, indexes)
+}
@@ -0,0 +1,491 @@
+package mediawiki_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	_ "modernc.org/sqlite"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// fakeSQLite is a database/sql driver which records executed statements
+// and inserted rows. It does not interpret SQL beyond what is needed for that.
+type fakeSQLite struct {
+	mu         sync.Mutex
+	statements []string
+	rows       map[string][]map[string]driver.Value
+	// Keys lists key columns for tables into which rows are inserted with INSERT OR IGNORE.
+	keys map[string][]string
+	// Fail is called for every insert and can return an error to fail it.
+	fail func(table string, row map[string]driver.Value) error
+}
+
+type fakeSQLiteInsert struct {
+	table string
+	row   map[string]driver.Value
+}
+
+func (f *fakeSQLite) Connect(context.Context) (driver.Conn, error) {
+	return &fakeSQLiteConn{db: f}, nil
+}
+
+func (f *fakeSQLite) Driver() driver.Driver {
+	return nil
+}
+
+func (f *fakeSQLite) key(table string, row map[string]driver.Value) string {
+	key := []any{}
+	for _, column := range f.keys[table] {
+		key = append(key, row[column])
+	}
+	return fmt.Sprint(key...)
+}
+
+// exists returns true if the row with the same key already exists.
+func (f *fakeSQLite) exists(table string, row map[string]driver.Value, pending []fakeSQLiteInsert) bool {
+	key := f.key(table, row)
+	for _, r := range f.rows[table] {
+		if f.key(table, r) == key {
+			return true
+		}
+	}
+	for _, insert := range pending {
+		if insert.table == table && f.key(table, insert.row) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeSQLite) Rows(table string) []map[string]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.rows[table])
+}
+
+type fakeSQLiteConn struct {
+	db      *fakeSQLite
+	pending []fakeSQLiteInsert
+	inTx    bool
+}
+
+func (c *fakeSQLiteConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLiteStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeSQLiteConn) Close() error {
+	return nil
+}
+
+func (c *fakeSQLiteConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeSQLiteConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, insert := range c.pending {
+		c.db.rows[insert.table] = append(c.db.rows[insert.table], insert.row)
+	}
+	c.pending = nil
+	c.inTx = false
+	return nil
+}
+
+func (c *fakeSQLiteConn) Rollback() error {
+	c.pending = nil
+	c.inTx = false
+	return nil
+}
+
+type fakeSQLiteStmt struct {
+	conn  *fakeSQLiteConn
+	query string
+}
+
+func (s *fakeSQLiteStmt) Close() error {
+	return nil
+}
+
+func (s *fakeSQLiteStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeSQLiteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	query := strings.Join(strings.Fields(s.query), " ")
+	if !strings.HasPrefix(query, "INSERT") {
+		db.statements = append(db.statements, query)
+		return driver.RowsAffected(0), nil
+	}
+	if !s.conn.inTx {
+		return nil, errors.New("insert outside of a transaction")
+	}
+
+	// INSERT [OR IGNORE] INTO table (columns) VALUES (...)
+	fields := strings.Fields(query)
+	table := strings.Trim(fields[slices.Index(fields, "INTO")+1], `"`)
+	columns := strings.Split(query[strings.Index(query, "(")+1:strings.Index(query, ") VALUES")], ",")
+	if len(columns) != len(args) {
+		return nil, errors.New("invalid number of arguments")
+	}
+	row := map[string]driver.Value{}
+	for i, column := range columns {
+		row[strings.Trim(strings.TrimSpace(column), `"`)] = args[i]
+	}
+	if db.fail != nil {
+		err := db.fail(table, row)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasPrefix(query, "INSERT OR IGNORE") && db.exists(table, row, s.conn.pending) {
+		return driver.RowsAffected(0), nil
+	}
+	s.conn.pending = append(s.conn.pending, fakeSQLiteInsert{table, row})
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeSQLiteStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func newFakeSQLite(t *testing.T, keys map[string][]string) (*fakeSQLite, *sql.DB) {
+	t.Helper()
+
+	f := &fakeSQLite{rows: map[string][]map[string]driver.Value{}, keys: keys}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() {
+		db.Close() //nolint:errcheck,gosec
+	})
+	return f, db
+}
+
+var sqliteTestEntity = `{"id": "Q64", "type": "item", "pageid": 123, "ns": 0, "title": "Q64", "lastrevid": 2, "modified": "2020-01-02T00:00:00Z",
+	"labels": {"en": {"language": "en", "value": "Berlin"}},
+	"descriptions": {"en": {"language": "en", "value": "capital of Germany"}},
+	"aliases": {"en": [{"language": "en", "value": "Berlin, Germany"}, {"language": "en", "value": "DE-BE"}]},
+	"sitelinks": {"enwiki": {"site": "enwiki", "title": "Berlin", "badges": ["Q17437796"]}, "dewiki": {"site": "dewiki", "title": "Berlin", "badges": []}},
+	"claims": {"P1082": [{"id": "Q64$1", "type": "statement", "rank": "preferred",
+		"mainsnak": {"snaktype": "value", "property": "P1082", "datatype": "quantity",
+			"datavalue": {"type": "quantity", "value": {"amount": "+3677472", "unit": "1"}}},
+		"qualifiers": {
+			"P585": [{"hash": "h1", "snaktype": "value", "property": "P585", "datatype": "time",
+				"datavalue": {"type": "time", "value": {"time": "+2021-12-31T00:00:00Z", "timezone": 0, "before": 0, "after": 0, "precision": 11, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}],
+			"P459": [{"hash": "h2", "snaktype": "somevalue", "property": "P459", "datatype": "wikibase-item"}]},
+		"qualifiers-order": ["P585", "P459"],
+		"references": [
+			{"hash": "r1", "snaks": {"P854": [{"snaktype": "value", "property": "P854", "datatype": "url", "datavalue": {"type": "string", "value": "https://example.com"}}]}},
+			{"hash": "r2", "snaks": {"P143": [{"snaktype": "value", "property": "P143", "datatype": "wikibase-item", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q328"}}}]}}]}]}}`
+
+func TestEntitiesSQLite(t *testing.T) {
+	t.Parallel()
+
+	dumpPath := filepath.Join(t.TempDir(), "dump.json")
+	writeNDJSON(t, dumpPath, append(slices.Clone(labelsTestEntities), sqliteTestEntity)...)
+
+	fake, db := newFakeSQLite(t, map[string][]string{"entity": {"id"}})
+
+	load := func() errors.E {
+		loader := &mediawiki.EntitiesSQLite{DB: db, BatchSize: 2}
+		errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Entity]{
+			Path:                   dumpPath,
+			Process:                loader.Add,
+			ItemsProcessingThreads: 4,
+			FileType:               mediawiki.NDJSON,
+			Compression:            mediawiki.NoCompression,
+		})
+		if errE != nil {
+			return errE
+		}
+		return loader.Close(context.Background())
+	}
+
+	// The first load fails for one entity, so its batch is rolled back.
+	fake.fail = func(table string, row map[string]driver.Value) error {
+		if table == "statement" && row["entity_id"] == "Q64" {
+			return errors.New("test failure")
+		}
+		return nil
+	}
+	errE := load()
+	require.ErrorContains(t, errE, "test failure")
+	for _, row := range fake.Rows("entity") {
+		assert.NotEqual(t, "Q64", row["id"])
+	}
+	for _, row := range fake.Rows("label") {
+		assert.NotEqual(t, "Q64", row["entity_id"])
+	}
+
+	// Resumed load skips already loaded entities.
+	fake.fail = nil
+	errE = load()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	entities := fake.Rows("entity")
+	slices.SortFunc(entities, func(a, b map[string]driver.Value) int {
+		return mediawiki.CompareEntityIDs(a["id"].(string), b["id"].(string)) //nolint:forcetypeassert,errcheck
+	})
+	assert.Equal(t, []map[string]driver.Value{
+		{"id": "P31", "type": "property", "datatype": "wikibase-item", "page_id": int64(0), "namespace": int64(0), "title": "", "modified": "2020-01-01T00:00:00Z", "last_revision_id": int64(1)},
+		{"id": "Q5", "type": "item", "datatype": nil, "page_id": int64(0), "namespace": int64(0), "title": "", "modified": "2020-01-01T00:00:00Z", "last_revision_id": int64(1)},
+		{"id": "Q42", "type": "item", "datatype": nil, "page_id": int64(0), "namespace": int64(0), "title": "", "modified": "2020-01-01T00:00:00Z", "last_revision_id": int64(1)},
+		{"id": "Q64", "type": "item", "datatype": nil, "page_id": int64(123), "namespace": int64(0), "title": "Q64", "modified": "2020-01-02T00:00:00Z", "last_revision_id": int64(2)},
+	}, entities)
+	assert.Len(t, fake.Rows("label"), 6)
+	assert.Equal(t, []map[string]driver.Value{{"entity_id": "Q64", "language": "en", "value": "capital of Germany"}}, fake.Rows("description"))
+	assert.Equal(t, []map[string]driver.Value{
+		{"entity_id": "Q64", "language": "en", "position": int64(0), "value": "Berlin, Germany"},
+		{"entity_id": "Q64", "language": "en", "position": int64(1), "value": "DE-BE"},
+	}, fake.Rows("alias"))
+	assert.Equal(t, []map[string]driver.Value{
+		{"entity_id": "Q64", "site": "dewiki", "title": "Berlin", "url": nil, "badges": "[]"},
+		{"entity_id": "Q64", "site": "enwiki", "title": "Berlin", "url": nil, "badges": `["Q17437796"]`},
+	}, fake.Rows("sitelink"))
+
+	statements := fake.Rows("statement")
+	slices.SortFunc(statements, func(a, b map[string]driver.Value) int {
+		return strings.Compare(a["id"].(string), b["id"].(string)) //nolint:forcetypeassert,errcheck
+	})
+	require.Len(t, statements, 2)
+	assert.Equal(t, "Q42", statements[0]["entity_id"])
+	assert.Equal(t, "Q5", statements[0]["value_entity_id"])
+	assert.Nil(t, statements[0]["datatype"])
+	assert.Equal(t, map[string]driver.Value{
+		"id": "Q64$1", "entity_id": "Q64", "property": "P1082", "rank": "preferred", "snak_type": "value", "datatype": "quantity",
+		"value_string": nil, "value_entity_id": nil, "value_time": nil, "value_time_precision": nil, "value_calendar": nil,
+		"value_latitude": nil, "value_longitude": nil, "value_coordinate_precision": nil, "value_globe": nil,
+		"value_amount": "3677472", "value_upper_bound": nil, "value_lower_bound": nil, "value_unit": "1",
+		"value_text": nil, "value_language": nil, "value_error": nil,
+	}, statements[1])
+
+	qualifiers := fake.Rows("qualifier")
+	require.Len(t, qualifiers, 2)
+	assert.Equal(t, []any{"Q64$1", int64(0), "h1", "P585", "value", "+2021-12-31T00:00:00Z", int64(11), "https://www.wikidata.org/wiki/Q1985727"}, []any{
+		qualifiers[0]["statement_id"], qualifiers[0]["position"], qualifiers[0]["hash"], qualifiers[0]["property"],
+		qualifiers[0]["snak_type"], qualifiers[0]["value_time"], qualifiers[0]["value_time_precision"], qualifiers[0]["value_calendar"],
+	})
+	assert.Equal(t, []any{int64(1), "P459", "somevalue", nil}, []any{
+		qualifiers[1]["position"], qualifiers[1]["property"], qualifiers[1]["snak_type"], qualifiers[1]["value_entity_id"],
+	})
+
+	references := fake.Rows("reference_snak")
+	require.Len(t, references, 3)
+	for _, reference := range references {
+		reference := []any{
+			reference["statement_id"], reference["reference"], reference["reference_hash"], reference["position"],
+			reference["property"], reference["value_string"], reference["value_entity_id"],
+		}
+		assert.Contains(t, [][]any{
+			{"Q42$1", int64(0), nil, int64(0), "P143", nil, "Q328"},
+			{"Q64$1", int64(0), "r1", int64(0), "P854", "https://example.com", nil},
+			{"Q64$1", int64(1), "r2", int64(0), "P143", nil, "Q328"},
+		}, reference)
+	}
+
+	// Tables are created before and indexes after loading, on every load.
+	assert.Equal(t, len(mediawiki.EntitySQLiteSchema)*2+len(mediawiki.EntitySQLiteIndexes), len(fake.statements))
+	assert.True(t, strings.HasPrefix(fake.statements[len(fake.statements)-1], "CREATE INDEX IF NOT EXISTS"))
+}
+
+func TestArticlesSQLite(t *testing.T) {
+	t.Parallel()
+
+	fake, db := newFakeSQLite(t, map[string][]string{"article": {"is_part_of", "identifier"}})
+
+	article := mediawiki.Article{
+		Name:         "Ljubljana",
+		Identifier:   1,
+		URL:          "https://en.wikipedia.org/wiki/Ljubljana",
+		DateModified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:      mediawiki.Version{Identifier: 42},
+		InLanguage:   mediawiki.InLanguage{Identifier: "en"},
+		IsPartOf:     mediawiki.IsPartOf{Identifier: "enwiki"},
+		MainEntity:   &mediawiki.EntityRef{Identifier: "Q437"},
+		Categories: []mediawiki.Category{
+			{Name: "Category:Capitals in Europe", URL: "https://en.wikipedia.org/wiki/Category:Capitals_in_Europe"},
+			{Name: "Category:Ljubljana", URL: "https://en.wikipedia.org/wiki/Category:Ljubljana"},
+		},
+	}
+	// The second load skips the already loaded article.
+	for i := range 2 {
+		loader := &mediawiki.ArticlesSQLite{DB: db}
+		errE := loader.Add(context.Background(), article)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		if i == 0 {
+			// Nothing is inserted before the batch is full.
+			assert.Empty(t, fake.Rows("article"))
+		}
+		errE = loader.Close(context.Background())
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	assert.Equal(t, []map[string]driver.Value{{
+		"is_part_of": "enwiki", "identifier": int64(1), "name": "Ljubljana", "url": "https://en.wikipedia.org/wiki/Ljubljana",
+		"namespace": int64(0), "in_language": "en", "date_created": nil, "date_modified": "2024-01-01T00:00:00Z",
+		"version_identifier": int64(42), "main_entity": "Q437", "abstract": nil,
+	}}, fake.Rows("article"))
+	assert.Equal(t, []map[string]driver.Value{
+		{"is_part_of": "enwiki", "identifier": int64(1), "position": int64(0), "name": "Category:Capitals in Europe", "url": "https://en.wikipedia.org/wiki/Category:Capitals_in_Europe"},
+		{"is_part_of": "enwiki", "identifier": int64(1), "position": int64(1), "name": "Category:Ljubljana", "url": "https://en.wikipedia.org/wiki/Category:Ljubljana"},
+	}, fake.Rows("article_category"))
+}
+
+func TestSQLTableSQLite(t *testing.T) {
+	t.Parallel()
+
+	fake, db := newFakeSQLite(t, map[string][]string{"page": {"page_id"}})
+
+	loader := &mediawiki.SQLTableSQLite{
+		DB:         db,
+		Table:      "page",
+		PrimaryKey: []string{"page_id"},
+		Indexes:    [][]string{{"page_namespace", "page_title"}},
+	}
+	for _, row := range []map[string]interface{}{
+		{"page_id": float64(1), "page_namespace": float64(0), "page_title": "Main_Page"},
+		{"page_id": float64(2), "page_namespace": float64(1), "page_title": "Main_Page"},
+		{"page_id": float64(1), "page_namespace": float64(0), "page_title": "Main_Page"},
+	} {
+		errE := loader.Add(context.Background(), row)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE := loader.Close(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS "page" ("page_id" NUMERIC, "page_namespace" NUMERIC, "page_title" TEXT, PRIMARY KEY ("page_id"))`,
+		`CREATE INDEX IF NOT EXISTS "page_page_namespace_page_title" ON "page" ("page_namespace", "page_title")`,
+	}, fake.statements)
+	assert.Equal(t, []map[string]driver.Value{
+		{"page_id": float64(1), "page_namespace": float64(0), "page_title": "Main_Page"},
+		{"page_id": float64(2), "page_namespace": float64(1), "page_title": "Main_Page"},
+	}, fake.Rows("page"))
+
+	errE = (&mediawiki.SQLTableSQLite{DB: db, Table: "empty"}).Close(context.Background())
+	assert.EqualError(t, errE, "columns not set")
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close() //nolint:errcheck,gosec
+	})
+	// Concurrent transactions wait for each other instead of failing.
+	_, err = db.Exec("PRAGMA busy_timeout = 10000")
+	require.NoError(t, err)
+	return db
+}
+
+func querySQLite(t *testing.T, db *sql.DB, query string) [][]any {
+	t.Helper()
+
+	rows, err := db.Query(query)
+	require.NoError(t, err)
+	defer rows.Close() //nolint:errcheck
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+	result := [][]any{}
+	for rows.Next() {
+		row := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		require.NoError(t, rows.Scan(pointers...))
+		result = append(result, row)
+	}
+	require.NoError(t, rows.Err())
+	return result
+}
+
+func TestEntitiesSQLiteDriver(t *testing.T) {
+	t.Parallel()
+
+	dumpPath := filepath.Join(t.TempDir(), "dump.json")
+	writeNDJSON(t, dumpPath, append(slices.Clone(labelsTestEntities), sqliteTestEntity)...)
+
+	db := openSQLite(t)
+
+	// Loading twice shows that loading is resumable.
+	for range 2 {
+		loader := &mediawiki.EntitiesSQLite{DB: db, BatchSize: 1}
+		errE := mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Entity]{
+			Path:                   dumpPath,
+			Process:                loader.Add,
+			ItemsProcessingThreads: 4,
+			FileType:               mediawiki.NDJSON,
+			Compression:            mediawiki.NoCompression,
+		})
+		require.NoError(t, errE, "% -+#.1v", errE)
+		errE = loader.Close(context.Background())
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	assert.Equal(t, [][]any{{"P31"}, {"Q42"}, {"Q5"}, {"Q64"}}, querySQLite(t, db, `SELECT id FROM entity ORDER BY id`))
+	assert.Equal(t, [][]any{{int64(6)}}, querySQLite(t, db, `SELECT COUNT(*) FROM label`))
+	assert.Equal(t, [][]any{{"Q64", "P1082", "preferred", "3677472", "1"}}, querySQLite(t, db,
+		`SELECT entity_id, property, rank, value_amount, value_unit FROM statement WHERE value_amount IS NOT NULL`,
+	))
+	assert.Equal(t, [][]any{{int64(0), "P585", "+2021-12-31T00:00:00Z", int64(11)}, {int64(1), "P459", nil, nil}}, querySQLite(t, db,
+		`SELECT position, property, value_time, value_time_precision FROM qualifier ORDER BY position`,
+	))
+	// Entities can be found through their labels and statements using indexes.
+	assert.Equal(t, [][]any{{"Q42"}}, querySQLite(t, db,
+		`SELECT s.entity_id FROM statement s JOIN label l ON l.entity_id = s.value_entity_id WHERE s.property = 'P31' AND l.value = 'human'`,
+	))
+	assert.Len(t, querySQLite(t, db, `SELECT name FROM sqlite_master WHERE type = 'index' AND name NOT LIKE 'sqlite_%'`), len(mediawiki.EntitySQLiteIndexes))
+}
+
+func TestSQLTableSQLiteDriver(t *testing.T) {
+	t.Parallel()
+
+	db := openSQLite(t)
+
+	loader := &mediawiki.SQLTableSQLite{
+		DB:         db,
+		Table:      "page",
+		PrimaryKey: []string{"page_id"},
+		Indexes:    [][]string{{"page_namespace", "page_title"}},
+		BatchSize:  3,
+	}
+	// Rows are added concurrently while the schema is being inferred from the first row.
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errE := loader.Add(context.Background(), map[string]interface{}{
+				"page_id": float64(i % 50), "page_namespace": float64(i % 2), "page_title": fmt.Sprintf("Page_%d", i%50),
+			})
+			assert.NoError(t, errE, "% -+#.1v", errE)
+		}()
+	}
+	wg.Wait()
+	errE := loader.Close(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, [][]any{{int64(50), int64(25)}}, querySQLite(t, db, `SELECT COUNT(*), SUM(page_namespace) FROM page`))
+	assert.Equal(t, [][]any{{int64(7), int64(1), "Page_7"}}, querySQLite(t, db, `SELECT * FROM page WHERE page_id = 7`))
+}
@@ -0,0 +1,826 @@
+package mediawiki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	storeManifest           = "MANIFEST"
+	storeSegmentMagic       = "MWSTORE1"
+	storeFooterSize         = 16
+	defaultStoreBlockSize   = 256 << 10
+	defaultStoreMemoryLimit = 256 << 20
+	// storeMergeFactor is the number of segments of similar size which
+	// StoreWriter merges into one while writing.
+	storeMergeFactor = 8
+)
+
+var (
+	storeEncoder = sync.OnceValue(func() *zstd.Encoder { //nolint:gochecknoglobals
+		encoder, _ := zstd.NewWriter(nil)
+		return encoder
+	})
+	storeDecoder = sync.OnceValue(func() *zstd.Decoder { //nolint:gochecknoglobals
+		decoder, _ := zstd.NewReader(nil)
+		return decoder
+	})
+)
+
+// storeRecord is a stored entity. Nil data is a tombstone for a deleted entity.
+type storeRecord struct {
+	ID   string
+	Data []byte
+}
+
+type storeBlock struct {
+	FirstID string
+	Offset  int64
+	Length  int64
+}
+
+// storeSegment is an immutable file with records sorted by ID, stored
+// in compressed blocks, and an index of blocks at the end.
+type storeSegment struct {
+	Name   string
+	path   string
+	file   *os.File
+	blocks []storeBlock
+
+	// refs counts users of a segment in a store: the store itself while
+	// the segment is part of it, and ongoing Range calls.
+	refs atomic.Int64
+	// removed is set when the segment is no longer part of the store
+	// and its file should be removed once it is not used anymore.
+	removed atomic.Bool
+}
+
+// acquire adds a reference to the segment.
+func (s *storeSegment) acquire() {
+	s.refs.Add(1)
+}
+
+// release drops a reference to the segment. When the last reference is dropped,
+// the file is closed and, if the segment has been removed from the store, deleted.
+func (s *storeSegment) release() errors.E {
+	if s.refs.Add(-1) > 0 {
+		return nil
+	}
+	err := s.file.Close()
+	if s.removed.Load() {
+		os.Remove(s.path) //nolint:errcheck,gosec
+	}
+	return errors.WithStack(err)
+}
+
+func openStoreSegment(path string) (*storeSegment, errors.E) {
+	file, err := os.Open(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	segment, errE := readStoreSegment(file)
+	if errE != nil {
+		file.Close() //nolint:errcheck,gosec
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	segment.Name = filepath.Base(path)
+	segment.path = path
+	return segment, nil
+}
+
+func readStoreSegment(file *os.File) (*storeSegment, errors.E) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if info.Size() < storeFooterSize {
+		return nil, errors.WithMessage(ErrInvalidValue, "store segment too short")
+	}
+	footer := make([]byte, storeFooterSize)
+	_, err = file.ReadAt(footer, info.Size()-storeFooterSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if string(footer[8:]) != storeSegmentMagic {
+		return nil, errors.WithMessage(ErrInvalidValue, "store segment magic")
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(footer[:8])) //nolint:gosec
+	if indexOffset < 0 || indexOffset > info.Size()-storeFooterSize {
+		return nil, errors.WithMessage(ErrInvalidValue, "store segment index offset")
+	}
+	index := make([]byte, info.Size()-storeFooterSize-indexOffset)
+	_, err = file.ReadAt(index, indexOffset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := bytes.NewReader(index)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	segment := &storeSegment{file: file}
+	var offset int64
+	for range count {
+		id, errE := readStoreBytes(r)
+		if errE != nil {
+			return nil, errE
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		segment.blocks = append(segment.blocks, storeBlock{FirstID: string(id), Offset: offset, Length: int64(length)}) //nolint:gosec
+		offset += int64(length)                                                                                         //nolint:gosec
+	}
+	if offset != indexOffset {
+		return nil, errors.WithMessage(ErrInvalidValue, "store segment index")
+	}
+	return segment, nil
+}
+
+func readStoreBytes(r *bytes.Reader) ([]byte, errors.E) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if l > uint64(r.Len()) {
+		return nil, errors.WithMessage(ErrInvalidValue, "store record length")
+	}
+	b := make([]byte, l)
+	_, _ = r.Read(b)
+	return b, nil
+}
+
+func (s *storeSegment) readBlock(i int) ([]storeRecord, errors.E) {
+	block := s.blocks[i]
+	compressed := make([]byte, block.Length)
+	_, err := s.file.ReadAt(compressed, block.Offset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := storeDecoder().DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := bytes.NewReader(data)
+	records := []storeRecord{}
+	for r.Len() > 0 {
+		id, errE := readStoreBytes(r)
+		if errE != nil {
+			return nil, errE
+		}
+		// Data length is stored incremented by one so that zero marks a tombstone.
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		record := storeRecord{ID: string(id)}
+		if l > 0 {
+			if l-1 > uint64(r.Len()) {
+				return nil, errors.WithMessage(ErrInvalidValue, "store record length")
+			}
+			record.Data = make([]byte, l-1)
+			_, _ = r.Read(record.Data)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// blockFor returns the index of the block which could contain the ID, or -1.
+func (s *storeSegment) blockFor(id string) int {
+	return sort.Search(len(s.blocks), func(i int) bool {
+		return CompareEntityIDs(s.blocks[i].FirstID, id) > 0
+	}) - 1
+}
+
+func (s *storeSegment) find(id string) (storeRecord, bool, errors.E) {
+	i := s.blockFor(id)
+	if i < 0 {
+		return storeRecord{}, false, nil
+	}
+	records, errE := s.readBlock(i)
+	if errE != nil {
+		return storeRecord{}, false, errE
+	}
+	j, found := slices.BinarySearchFunc(records, id, func(r storeRecord, id string) int {
+		return CompareEntityIDs(r.ID, id)
+	})
+	if !found {
+		return storeRecord{}, false, nil
+	}
+	return records[j], true, nil
+}
+
+// segmentIterator iterates over records of a segment in order.
+type segmentIterator struct {
+	segment *storeSegment
+	block   int
+	records []storeRecord
+}
+
+func (s *storeSegment) iterator(from string) (*segmentIterator, errors.E) {
+	it := &segmentIterator{segment: s, block: 0}
+	if from != "" {
+		it.block = max(s.blockFor(from), 0)
+	}
+	errE := it.load()
+	if errE != nil {
+		return nil, errE
+	}
+	for from != "" {
+		record, ok := it.peek()
+		if !ok || CompareEntityIDs(record.ID, from) >= 0 {
+			break
+		}
+		errE := it.next()
+		if errE != nil {
+			return nil, errE
+		}
+	}
+	return it, nil
+}
+
+func (it *segmentIterator) load() errors.E {
+	for len(it.records) == 0 && it.block < len(it.segment.blocks) {
+		records, errE := it.segment.readBlock(it.block)
+		if errE != nil {
+			return errE
+		}
+		it.records = records
+		it.block++
+	}
+	return nil
+}
+
+func (it *segmentIterator) peek() (storeRecord, bool) {
+	if len(it.records) == 0 {
+		return storeRecord{}, false
+	}
+	return it.records[0], true
+}
+
+func (it *segmentIterator) next() errors.E {
+	it.records = it.records[1:]
+	return it.load()
+}
+
+// mergeSegments calls fn for records from all segments in ID order.
+// When multiple segments have the same ID, the record from the last segment is used.
+func mergeSegments(ctx context.Context, segments []*storeSegment, from, to string, fn func(storeRecord) errors.E) errors.E {
+	iterators := make([]*segmentIterator, 0, len(segments))
+	for _, segment := range segments {
+		it, errE := segment.iterator(from)
+		if errE != nil {
+			return errE
+		}
+		iterators = append(iterators, it)
+	}
+	for {
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		var current *storeRecord
+		for _, it := range iterators {
+			record, ok := it.peek()
+			if !ok {
+				continue
+			}
+			// Later segments win on equal IDs.
+			if current == nil || CompareEntityIDs(record.ID, current.ID) <= 0 {
+				current = &record
+			}
+		}
+		if current == nil || (to != "" && CompareEntityIDs(current.ID, to) >= 0) {
+			return nil
+		}
+		for _, it := range iterators {
+			record, ok := it.peek()
+			if ok && record.ID == current.ID {
+				errE := it.next()
+				if errE != nil {
+					return errE
+				}
+			}
+		}
+		errE := fn(*current)
+		if errE != nil {
+			return errE
+		}
+	}
+}
+
+// segmentWriter writes records, which have to be sorted by ID, to a new segment.
+type segmentWriter struct {
+	path      string
+	file      *os.File
+	writer    *bufio.Writer
+	blockSize int
+	offset    int64
+	block     []byte
+	firstID   string
+	index     []byte
+	blocks    uint64
+}
+
+func newSegmentWriter(path string, blockSize int) (*segmentWriter, errors.E) {
+	file, err := os.Create(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	return &segmentWriter{
+		path:      path,
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		blockSize: blockSize,
+	}, nil
+}
+
+func (w *segmentWriter) write(record storeRecord) errors.E {
+	if len(w.block) == 0 {
+		w.firstID = record.ID
+	}
+	w.block = binary.AppendUvarint(w.block, uint64(len(record.ID)))
+	w.block = append(w.block, record.ID...)
+	if record.Data == nil {
+		w.block = binary.AppendUvarint(w.block, 0)
+	} else {
+		w.block = binary.AppendUvarint(w.block, uint64(len(record.Data))+1)
+		w.block = append(w.block, record.Data...)
+	}
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *segmentWriter) flushBlock() errors.E {
+	if len(w.block) == 0 {
+		return nil
+	}
+	compressed := storeEncoder().EncodeAll(w.block, nil)
+	_, err := w.writer.Write(compressed)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w.index = binary.AppendUvarint(w.index, uint64(len(w.firstID)))
+	w.index = append(w.index, w.firstID...)
+	w.index = binary.AppendUvarint(w.index, uint64(len(compressed)))
+	w.offset += int64(len(compressed))
+	w.blocks++
+	w.block = w.block[:0]
+	return nil
+}
+
+// close finishes the segment and opens it for reading.
+func (w *segmentWriter) close() (*storeSegment, errors.E) {
+	errE := w.flushBlock()
+	if errE != nil {
+		w.file.Close() //nolint:errcheck,gosec
+		return nil, errE
+	}
+	_, _ = w.writer.Write(binary.AppendUvarint(nil, w.blocks))
+	_, _ = w.writer.Write(w.index)
+	footer := binary.LittleEndian.AppendUint64(nil, uint64(w.offset)) //nolint:gosec
+	footer = append(footer, storeSegmentMagic...)
+	_, _ = w.writer.Write(footer)
+	err := w.writer.Flush()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	err2 := w.file.Close()
+	if err != nil || err2 != nil {
+		return nil, errors.Join(err, err2)
+	}
+	return openStoreSegment(w.path)
+}
+
+// Store is an embedded on-disk entity store.
+//
+// Entities are stored in immutable segments, each with entities sorted by ID,
+// compressed in blocks, and with a sparse index of blocks. Only block indices are
+// kept in memory. Entities are added with StoreWriter and newer segments take
+// precedence over older ones, which allows incremental updates. Compact merges
+// all segments into one.
+//
+// It is safe to use concurrently.
+type Store struct {
+	path     string
+	mu       sync.RWMutex
+	segments []*storeSegment
+	next     int
+}
+
+// OpenStore opens the store at the directory path, creating it if necessary.
+func OpenStore(path string) (*Store, errors.E) {
+	err := os.MkdirAll(path, 0o755) //nolint:mnd,gosec
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	s := &Store{path: path}
+	manifest, err := os.ReadFile(filepath.Join(path, storeManifest))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	for _, name := range strings.Fields(string(manifest)) {
+		segment, errE := openStoreSegment(filepath.Join(path, name))
+		if errE != nil {
+			s.Close() //nolint:errcheck,gosec
+			return nil, errE
+		}
+		segment.acquire()
+		s.segments = append(s.segments, segment)
+		var n int
+		_, err := fmt.Sscanf(name, "segment-%d.mws", &n)
+		if err == nil && n >= s.next {
+			s.next = n + 1
+		}
+	}
+	return s, nil
+}
+
+// newSegmentPath returns a path for a new segment. Caller has to hold the write lock.
+func (s *Store) newSegmentPath() string {
+	name := fmt.Sprintf("segment-%06d.mws", s.next)
+	s.next++
+	return filepath.Join(s.path, name)
+}
+
+// writeManifest atomically writes the list of segments. Caller has to hold the write lock.
+func (s *Store) writeManifest(segments []*storeSegment) errors.E {
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteString(segment.Name)
+		b.WriteString("\n")
+	}
+	path := filepath.Join(s.path, storeManifest)
+	err := os.WriteFile(path+".tmp", []byte(b.String()), 0o644) //nolint:mnd,gosec
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(path+".tmp", path))
+}
+
+// Get returns the entity with the ID. It returns ErrNotFound if there is no such entity.
+func (s *Store) Get(_ context.Context, id string) (Entity, errors.E) {
+	data, errE := s.get(id)
+	if errE != nil {
+		return Entity{}, errE
+	}
+	return decodeStoreRecord(storeRecord{ID: id, Data: data})
+}
+
+// get returns stored data for the ID, or ErrNotFound.
+func (s *Store) get(id string) ([]byte, errors.E) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		record, ok, errE := s.segments[i].find(id)
+		if errE != nil {
+			errors.Details(errE)["id"] = id
+			return nil, errE
+		}
+		if !ok {
+			continue
+		}
+		if record.Data == nil {
+			break
+		}
+		return record.Data, nil
+	}
+	return nil, errors.WithDetails(ErrNotFound, "id", id)
+}
+
+func decodeStoreRecord(record storeRecord) (Entity, errors.E) {
+	var entity Entity
+	errE := x.UnmarshalWithoutUnknownFields(record.Data, &entity)
+	if errE != nil {
+		errE = errors.Prefix(errE, ErrJSONDecode)
+		errors.Details(errE)["id"] = record.ID
+		return Entity{}, errE
+	}
+	return entity, nil
+}
+
+// Range calls fn for all entities with IDs from from (inclusive) to to (exclusive),
+// in order of their IDs (see CompareEntityIDs). Empty from or to means unbounded.
+//
+// Range iterates over entities as they were when it was called. fn can use the store,
+// e.g., call Get, and changes made in the meantime are not visible to Range.
+func (s *Store) Range(ctx context.Context, from, to string, fn func(context.Context, Entity) errors.E) errors.E {
+	s.mu.RLock()
+	segments := slices.Clone(s.segments)
+	for _, segment := range segments {
+		segment.acquire()
+	}
+	s.mu.RUnlock()
+
+	defer func() {
+		for _, segment := range segments {
+			segment.release() //nolint:errcheck,gosec
+		}
+	}()
+
+	return mergeSegments(ctx, segments, from, to, func(record storeRecord) errors.E {
+		if record.Data == nil {
+			return nil
+		}
+		entity, errE := decodeStoreRecord(record)
+		if errE != nil {
+			return errE
+		}
+		return fn(ctx, entity)
+	})
+}
+
+// Compact merges all segments into one, dropping deleted and replaced entities.
+//
+// Get is blocked while compacting. Ongoing Range calls are not affected.
+func (s *Store) Compact(ctx context.Context) errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) <= 1 {
+		return nil
+	}
+	w, errE := newSegmentWriter(s.newSegmentPath(), defaultStoreBlockSize)
+	if errE != nil {
+		return errE
+	}
+	errE = mergeSegments(ctx, s.segments, "", "", func(record storeRecord) errors.E {
+		if record.Data == nil {
+			return nil
+		}
+		return w.write(record)
+	})
+	if errE != nil {
+		w.file.Close()    //nolint:errcheck,gosec
+		os.Remove(w.path) //nolint:errcheck,gosec
+		return errE
+	}
+	segment, errE := w.close()
+	if errE != nil {
+		os.Remove(w.path) //nolint:errcheck,gosec
+		return errE
+	}
+	errE = s.writeManifest([]*storeSegment{segment})
+	if errE != nil {
+		segment.file.Close() //nolint:errcheck,gosec
+		os.Remove(w.path)    //nolint:errcheck,gosec
+		return errE
+	}
+	segment.acquire()
+	for _, old := range s.segments {
+		// Ongoing Range calls might still use the segment, so it is
+		// deleted only once they release it.
+		old.removed.Store(true)
+		old.release() //nolint:errcheck,gosec
+	}
+	s.segments = []*storeSegment{segment}
+	return nil
+}
+
+// Close closes the store.
+func (s *Store) Close() errors.E {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := []error{}
+	for _, segment := range s.segments {
+		errs = append(errs, segment.release())
+	}
+	s.segments = nil
+	return errors.Join(errs...)
+}
+
+// StoreWriter adds entities to a Store.
+//
+// Entities are buffered in memory up to MemoryLimit bytes (by default 256 MB),
+// sorted, and written as a temporary segment. Whenever there are 8 temporary
+// segments of similar size, they are merged into one, so the number of temporary
+// segments (and open files) grows only logarithmically with the number of entities.
+// Close merges all temporary segments into one segment which is added to the store,
+// so each writer adds exactly one segment. Changes become visible to readers only
+// after Close. BlockSize is the uncompressed size of blocks (by default 256 KB);
+// smaller blocks make random reads faster but use more memory for block indices.
+//
+// It is safe to use concurrently.
+type StoreWriter struct {
+	MemoryLimit int
+	BlockSize   int
+
+	store   *Store
+	mu      sync.Mutex
+	records []storeRecord
+	size    int
+	// levels[i] are temporary segments which were merged i times,
+	// each level from oldest to newest. Higher levels are older.
+	levels [][]*storeSegment
+}
+
+// NewWriter returns a new writer for the store.
+func (s *Store) NewWriter() *StoreWriter {
+	return &StoreWriter{store: s}
+}
+
+// Put adds or replaces the entity.
+//
+// Its signature matches the callback of ProcessWikidataDump and
+// ProcessCommonsEntitiesDump so it can be passed to them directly.
+func (w *StoreWriter) Put(_ context.Context, entity Entity) errors.E {
+	data, errE := x.MarshalWithoutEscapeHTML(entity)
+	if errE != nil {
+		errors.Details(errE)["id"] = entity.ID
+		return errE
+	}
+	return w.add(storeRecord{ID: entity.ID, Data: data})
+}
+
+// Delete marks the entity with the ID as deleted.
+func (w *StoreWriter) Delete(_ context.Context, id string) errors.E {
+	return w.add(storeRecord{ID: id, Data: nil})
+}
+
+func (w *StoreWriter) add(record storeRecord) errors.E {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.records = append(w.records, record)
+	w.size += len(record.ID) + len(record.Data)
+	memoryLimit := w.MemoryLimit
+	if memoryLimit <= 0 {
+		memoryLimit = defaultStoreMemoryLimit
+	}
+	if w.size >= memoryLimit {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes buffered records as a new segment. Caller has to hold the lock.
+func (w *StoreWriter) flush() errors.E {
+	if len(w.records) == 0 {
+		return nil
+	}
+	// Stable sort so that for the same ID the last record wins.
+	slices.SortStableFunc(w.records, func(a, b storeRecord) int {
+		return CompareEntityIDs(a.ID, b.ID)
+	})
+	blockSize := w.BlockSize
+	if blockSize <= 0 {
+		blockSize = defaultStoreBlockSize
+	}
+
+	w.store.mu.Lock()
+	path := w.store.newSegmentPath()
+	w.store.mu.Unlock()
+
+	sw, errE := newSegmentWriter(path, blockSize)
+	if errE != nil {
+		return errE
+	}
+	for i, record := range w.records {
+		if i+1 < len(w.records) && w.records[i+1].ID == record.ID {
+			continue
+		}
+		errE := sw.write(record)
+		if errE != nil {
+			sw.file.Close() //nolint:errcheck,gosec
+			os.Remove(path) //nolint:errcheck,gosec
+			return errE
+		}
+	}
+	segment, errE := sw.close()
+	if errE != nil {
+		os.Remove(path) //nolint:errcheck,gosec
+		return errE
+	}
+	w.records = nil
+	w.size = 0
+	return w.addSegment(0, segment)
+}
+
+// addSegment adds the temporary segment at the level, merging segments
+// of the level into the next level when there are storeMergeFactor of them.
+// Caller has to hold the lock.
+func (w *StoreWriter) addSegment(level int, segment *storeSegment) errors.E {
+	if level == len(w.levels) {
+		w.levels = append(w.levels, nil)
+	}
+	w.levels[level] = append(w.levels[level], segment)
+	if len(w.levels[level]) < storeMergeFactor {
+		return nil
+	}
+	merged, errE := w.merge(w.levels[level])
+	if errE != nil {
+		return errE
+	}
+	w.levels[level] = nil
+	return w.addSegment(level+1, merged)
+}
+
+// segments returns all temporary segments from oldest to newest. Caller has to hold the lock.
+func (w *StoreWriter) segments() []*storeSegment {
+	segments := []*storeSegment{}
+	for i := len(w.levels) - 1; i >= 0; i-- {
+		segments = append(segments, w.levels[i]...)
+	}
+	return segments
+}
+
+// merge merges segments into a new segment, keeping tombstones because
+// older segments in the store might contain deleted entities, and removes them.
+// Caller has to hold the lock.
+func (w *StoreWriter) merge(segments []*storeSegment) (*storeSegment, errors.E) {
+	blockSize := w.BlockSize
+	if blockSize <= 0 {
+		blockSize = defaultStoreBlockSize
+	}
+
+	w.store.mu.Lock()
+	path := w.store.newSegmentPath()
+	w.store.mu.Unlock()
+
+	sw, errE := newSegmentWriter(path, blockSize)
+	if errE != nil {
+		return nil, errE
+	}
+	errE = mergeSegments(context.Background(), segments, "", "", sw.write)
+	if errE != nil {
+		sw.file.Close() //nolint:errcheck,gosec
+		os.Remove(path) //nolint:errcheck,gosec
+		return nil, errE
+	}
+	segment, errE := sw.close()
+	if errE != nil {
+		os.Remove(path) //nolint:errcheck,gosec
+		return nil, errE
+	}
+	for _, old := range segments {
+		old.file.Close()                                 //nolint:errcheck,gosec
+		os.Remove(filepath.Join(w.store.path, old.Name)) //nolint:errcheck,gosec
+	}
+	return segment, nil
+}
+
+// Close writes remaining entities, merges them into one segment,
+// and makes all written entities visible to readers.
+func (w *StoreWriter) Close() errors.E {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	errE := w.flush()
+	if errE != nil {
+		return errE
+	}
+	segments := w.segments()
+	if len(segments) == 0 {
+		return nil
+	}
+	segment := segments[0]
+	if len(segments) > 1 {
+		segment, errE = w.merge(segments)
+		if errE != nil {
+			return errE
+		}
+	}
+	w.levels = nil
+
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	all := append(slices.Clone(w.store.segments), segment)
+	errE = w.store.writeManifest(all)
+	if errE != nil {
+		return errE
+	}
+	segment.acquire()
+	w.store.segments = all
+	return nil
+}
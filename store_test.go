@@ -0,0 +1,267 @@
+package mediawiki_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func storeTestEntity(id, label string) string {
+	return fmt.Sprintf(`{"id": %q, "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z",
+		"labels": {"en": {"language": "en", "value": %q}}}`, id, label)
+}
+
+func storeRange(t *testing.T, store *mediawiki.Store, from, to string) []string {
+	t.Helper()
+
+	ids := []string{}
+	errE := store.Range(context.Background(), from, to, func(_ context.Context, entity mediawiki.Entity) errors.E {
+		ids = append(ids, entity.ID)
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return ids
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	dumpPath := filepath.Join(t.TempDir(), "dump.json")
+	entities := []string{}
+	for i := 1; i <= 20; i++ {
+		entities = append(entities, storeTestEntity(fmt.Sprintf("Q%d", i), fmt.Sprintf("item %d", i)))
+	}
+	entities = append(entities, storeTestEntity("P31", "instance of"))
+	writeNDJSON(t, dumpPath, entities...)
+
+	storePath := filepath.Join(t.TempDir(), "store")
+	store, errE := mediawiki.OpenStore(storePath)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// Small limits to get multiple blocks and segments.
+	writer := store.NewWriter()
+	writer.MemoryLimit = 2000
+	writer.BlockSize = 500
+	errE = mediawiki.Process(context.Background(), &mediawiki.ProcessConfig[mediawiki.Entity]{
+		Path:                   dumpPath,
+		Process:                writer.Put,
+		ItemsProcessingThreads: 4,
+		FileType:               mediawiki.NDJSON,
+		Compression:            mediawiki.NoCompression,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// Not visible before the writer is closed.
+	_, errE = store.Get(context.Background(), "Q1")
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	entity, errE := store.Get(context.Background(), "Q12")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, parseEntity(t, entities[11]), entity)
+	_, errE = store.Get(context.Background(), "Q21")
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+
+	assert.Equal(t, []string{"Q9", "Q10", "Q11"}, storeRange(t, store, "Q9", "Q12"))
+	assert.Equal(t, []string{"Q19", "Q20"}, storeRange(t, store, "Q19", ""))
+	assert.Len(t, storeRange(t, store, "", ""), 21)
+	assert.Equal(t, []string{"P31"}, storeRange(t, store, "", "Q1"))
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entity, errE := store.Get(context.Background(), fmt.Sprintf("Q%d", i))
+			if assert.NoError(t, errE, "% -+#.1v", errE) {
+				assert.Equal(t, fmt.Sprintf("item %d", i), entity.Labels["en"].Value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Incremental update.
+	writer = store.NewWriter()
+	errE = writer.Put(context.Background(), parseEntity(t, storeTestEntity("Q5", "human")))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = writer.Put(context.Background(), parseEntity(t, storeTestEntity("Q42", "Douglas Adams")))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = writer.Delete(context.Background(), "Q10")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	check := func(store *mediawiki.Store) {
+		t.Helper()
+
+		entity, errE := store.Get(context.Background(), "Q5")
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, "human", entity.Labels["en"].Value)
+		_, errE = store.Get(context.Background(), "Q10")
+		assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+		entity, errE = store.Get(context.Background(), "Q11")
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, "item 11", entity.Labels["en"].Value)
+		assert.Equal(t, []string{"Q9", "Q11", "Q12"}, storeRange(t, store, "Q9", "Q13"))
+		assert.Equal(t, []string{"Q20", "Q42"}, storeRange(t, store, "Q20", ""))
+	}
+	check(store)
+
+	errE = store.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	store, errE = mediawiki.OpenStore(storePath)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	t.Cleanup(func() {
+		errE := store.Close()
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	})
+	check(store)
+
+	errE = store.Compact(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+	check(store)
+	matches, err := filepath.Glob(filepath.Join(storePath, "segment-*"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 1)
+
+	errE = store.Range(context.Background(), "", "", func(_ context.Context, _ mediawiki.Entity) errors.E {
+		return errors.New("stop")
+	})
+	assert.EqualError(t, errE, "stop")
+}
+
+func TestStoreRangeReentrant(t *testing.T) {
+	t.Parallel()
+
+	storePath := filepath.Join(t.TempDir(), "store")
+	store, errE := mediawiki.OpenStore(storePath)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	t.Cleanup(func() {
+		errE := store.Close()
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	})
+
+	// Two segments.
+	for _, id := range []string{"Q1", "Q2"} {
+		writer := store.NewWriter()
+		errE = writer.Put(context.Background(), parseEntity(t, storeTestEntity(id, "item "+id)))
+		require.NoError(t, errE, "% -+#.1v", errE)
+		errE = writer.Close()
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	ids := []string{}
+	errE = store.Range(context.Background(), "", "", func(ctx context.Context, entity mediawiki.Entity) errors.E {
+		ids = append(ids, entity.ID)
+		got, errE := store.Get(ctx, entity.ID)
+		if errE != nil {
+			return errE
+		}
+		assert.Equal(t, entity, got)
+		if entity.ID != "Q1" {
+			return nil
+		}
+		// Writing and compacting while ranging does not block
+		// and does not change entities Range iterates over.
+		writer := store.NewWriter()
+		errE = writer.Put(ctx, parseEntity(t, storeTestEntity("Q3", "item Q3")))
+		if errE != nil {
+			return errE
+		}
+		errE = writer.Close()
+		if errE != nil {
+			return errE
+		}
+		return store.Compact(ctx)
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"Q1", "Q2"}, ids)
+
+	// Segments replaced by compaction are removed once Range is done.
+	matches, err := filepath.Glob(filepath.Join(storePath, "segment-*"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, []string{"Q1", "Q2", "Q3"}, storeRange(t, store, "", ""))
+}
+
+func openFiles(t *testing.T) int {
+	t.Helper()
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open files")
+	}
+	return len(entries)
+}
+
+// Not parallel so that other tests do not change the number of open files.
+func TestStoreWriterManySegments(t *testing.T) { //nolint:paralleltest
+	storePath := filepath.Join(t.TempDir(), "store")
+	store, errE := mediawiki.OpenStore(storePath)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	t.Cleanup(func() {
+		errE := store.Close()
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	})
+
+	before := openFiles(t)
+
+	// Every entity is written as its own segment.
+	writer := store.NewWriter()
+	writer.MemoryLimit = 1
+	for i := 1; i <= 1000; i++ {
+		errE = writer.Put(context.Background(), parseEntity(t, storeTestEntity(fmt.Sprintf("Q%d", i), fmt.Sprintf("item %d", i))))
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE = writer.Delete(context.Background(), "Q500")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// 1001 segments were written, but at most 7 per
+	// level are kept: 1001 is 1751 in base 8, so 1+7+5+1 segments.
+	matches, err := filepath.Glob(filepath.Join(storePath, "segment-*"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 14)
+	assert.Equal(t, 14, openFiles(t)-before)
+
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	matches, err = filepath.Glob(filepath.Join(storePath, "segment-*"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, 1, openFiles(t)-before)
+
+	entity, errE := store.Get(context.Background(), "Q1000")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "item 1000", entity.Labels["en"].Value)
+	_, errE = store.Get(context.Background(), "Q500")
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+	assert.Len(t, storeRange(t, store, "", ""), 999)
+
+	// Tombstones are kept in merged segments so that they hide older segments.
+	writer = store.NewWriter()
+	writer.MemoryLimit = 1
+	for i := 1; i <= 20; i++ {
+		errE = writer.Delete(context.Background(), fmt.Sprintf("Q%d", i))
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE = writer.Close()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	matches, err = filepath.Glob(filepath.Join(storePath, "segment-*"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 2)
+	_, errE = store.Get(context.Background(), "Q7")
+	assert.ErrorIs(t, errE, mediawiki.ErrNotFound)
+	assert.Len(t, storeRange(t, store, "", ""), 979)
+}
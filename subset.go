@@ -0,0 +1,145 @@
+package mediawiki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// idHash returns a stable 64-bit hash of the ID, prefixed with the prefix.
+func idHash(prefix, id string) uint64 {
+	h := sha256.Sum256([]byte(prefix + "\x00" + id))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// InSample returns true if the item with the ID belongs to the sample of
+// approximately rate fraction of all items. Membership depends only on the ID,
+// the seed, and the rate, so samples are reproducible across runs and
+// across dumps. Samples with a larger rate (and the same seed) contain all items
+// from samples with a smaller rate. Different seeds give independent samples.
+//
+// Rate 0 or 1 (or larger) means that all items belong to the sample.
+func InSample(id, seed string, rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	// We use 53 bits, the precision of float64.
+	return float64(idHash("sample:"+seed, id)>>11)/(1<<53) < rate
+}
+
+// Shard returns the shard from 0 to shards-1 to which the item with the ID belongs.
+// It depends only on the ID and the number of shards.
+func Shard(id string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	return int(idHash("shard", id) % uint64(shards)) //nolint:gosec
+}
+
+// SubsetConfig is a configuration for Subset function.
+//
+// URL, Path, Client, DecompressionThreads, DecodingThreads, ItemsProcessingThreads,
+// Progress, FileType, Compression, Checksum, Mirrors, Sources, and SourcesThreads configure
+// reading the input dump as in ProcessConfig.
+//
+// ID returns the ID of an item, e.g., Entity.ID. It is required when
+// sampling or sharding.
+//
+// SampleRate is the approximate fraction of items to keep, selected
+// deterministically by hashing their IDs and Seed, see InSample.
+// Zero keeps all items.
+//
+// Filter is an optional predicate on the item. Only items for which it returns
+// true are kept.
+//
+// Kept items are written to Outputs. If there is more than one output, items are
+// sharded between them by hashing their IDs, see Shard. Outputs are not closed.
+type SubsetConfig[T any] struct {
+	URL                    string
+	Path                   string
+	Client                 *retryablehttp.Client
+	DecompressionThreads   int
+	DecodingThreads        int
+	ItemsProcessingThreads int
+	Progress               func(context.Context, x.Progress)
+	FileType               FileType
+	Compression            Compression
+	Checksum               Checksum
+	Mirrors                []string
+	Sources                []Source
+	SourcesThreads         int
+
+	ID         func(T) string
+	SampleRate float64
+	Seed       string
+	Filter     func(context.Context, T) (bool, errors.E)
+	Outputs    []*DumpWriter[json.RawMessage]
+}
+
+// Subset derives a sub-dump from a dump by sampling, filtering, and sharding
+// its items, as configured by config.
+//
+// Items are decoded into T for ID and Filter, but their original JSON is written
+// to outputs, so nothing is lost through re-encoding (only insignificant whitespace
+// is removed).
+func Subset[T any](ctx context.Context, config *SubsetConfig[T]) errors.E {
+	if len(config.Outputs) == 0 {
+		return errors.New("no outputs")
+	}
+	if config.ID == nil && (len(config.Outputs) > 1 || (config.SampleRate > 0 && config.SampleRate < 1)) {
+		return errors.New("ID is required for sampling and sharding")
+	}
+
+	return Process(ctx, &ProcessConfig[json.RawMessage]{
+		URL:                    config.URL,
+		Path:                   config.Path,
+		Client:                 config.Client,
+		DecompressionThreads:   config.DecompressionThreads,
+		DecodingThreads:        config.DecodingThreads,
+		ItemsProcessingThreads: config.ItemsProcessingThreads,
+		Process: func(ctx context.Context, raw json.RawMessage) errors.E {
+			if config.ID == nil && config.Filter == nil {
+				return config.Outputs[0].Write(ctx, raw)
+			}
+
+			var item T
+			errE := x.UnmarshalWithoutUnknownFields(raw, &item)
+			if errE != nil {
+				return errors.Prefix(errE, ErrJSONDecode)
+			}
+
+			shard := 0
+			if config.ID != nil {
+				id := config.ID(item)
+				if !InSample(id, config.Seed, config.SampleRate) {
+					return nil
+				}
+				shard = Shard(id, len(config.Outputs))
+			}
+
+			if config.Filter != nil {
+				keep, errE := config.Filter(ctx, item)
+				if errE != nil {
+					return errE
+				}
+				if !keep {
+					return nil
+				}
+			}
+
+			return config.Outputs[shard].Write(ctx, raw)
+		},
+		Progress:       config.Progress,
+		FileType:       config.FileType,
+		Compression:    config.Compression,
+		Checksum:       config.Checksum,
+		Mirrors:        config.Mirrors,
+		Sources:        config.Sources,
+		SourcesThreads: config.SourcesThreads,
+	})
+}
@@ -0,0 +1,136 @@
+package mediawiki_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestInSampleShard(t *testing.T) {
+	t.Parallel()
+
+	// Values must not change between versions to keep samples reproducible.
+	assert.Equal(t, 3, mediawiki.Shard("Q42", 4))
+	assert.Equal(t, 0, mediawiki.Shard("Q42", 1))
+	assert.True(t, mediawiki.InSample("Q42", "", 0))
+	assert.True(t, mediawiki.InSample("Q42", "", 1))
+
+	inSample := 0
+	shards := make([]int, 4)
+	for i := range 10000 {
+		id := fmt.Sprintf("Q%d", i)
+		if mediawiki.InSample(id, "", 0.1) {
+			inSample++
+			// Larger samples contain smaller samples.
+			assert.True(t, mediawiki.InSample(id, "", 0.2))
+		}
+		shards[mediawiki.Shard(id, len(shards))]++
+	}
+	assert.InDelta(t, 1000, inSample, 100)
+	for _, count := range shards {
+		assert.InDelta(t, 2500, count, 150)
+	}
+}
+
+func subsetDump(t *testing.T, config *mediawiki.SubsetConfig[mediawiki.Entity], outputs int) [][]string {
+	t.Helper()
+
+	buffers := make([]*bytes.Buffer, outputs)
+	for i := range outputs {
+		buffers[i] = new(bytes.Buffer)
+		config.Outputs = append(config.Outputs, &mediawiki.DumpWriter[json.RawMessage]{Writer: buffers[i], FileType: mediawiki.NDJSON})
+	}
+	config.Path = filepath.Join("testdata", "wikidata-testdata-index.json.bz2")
+	config.FileType = mediawiki.JSONArray
+	config.Compression = mediawiki.BZIP2
+	config.ItemsProcessingThreads = 4
+	errE := mediawiki.Subset(context.Background(), config)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	results := [][]string{}
+	for i, output := range config.Outputs {
+		errE := output.Close()
+		require.NoError(t, errE, "% -+#.1v", errE)
+		rows := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(buffers[i].String()), "\n") {
+			if line != "" {
+				rows = append(rows, line)
+			}
+		}
+		results = append(results, rows)
+	}
+	return results
+}
+
+func entityID(entity mediawiki.Entity) string {
+	return entity.ID
+}
+
+func TestSubset(t *testing.T) {
+	t.Parallel()
+
+	all := readDump(t, filepath.Join("testdata", "wikidata-testdata-index.json.bz2"), mediawiki.JSONArray, mediawiki.BZIP2)
+	ids := map[string]string{}
+	for _, row := range all {
+		var entity struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(row), &entity))
+		ids[row] = entity.ID
+	}
+
+	sample := subsetDump(t, &mediawiki.SubsetConfig[mediawiki.Entity]{ID: entityID, SampleRate: 0.1, Seed: "test"}, 1)[0]
+	assert.InDelta(t, 255, len(sample), 60)
+	for _, row := range sample {
+		// Original JSON is preserved.
+		assert.Contains(t, all, row)
+		assert.True(t, mediawiki.InSample(ids[row], "test", 0.1))
+	}
+	again := subsetDump(t, &mediawiki.SubsetConfig[mediawiki.Entity]{ID: entityID, SampleRate: 0.1, Seed: "test"}, 1)[0]
+	assert.ElementsMatch(t, sample, again)
+	other := subsetDump(t, &mediawiki.SubsetConfig[mediawiki.Entity]{ID: entityID, SampleRate: 0.1, Seed: "other"}, 1)[0]
+	assert.NotElementsMatch(t, sample, other)
+
+	properties := subsetDump(t, &mediawiki.SubsetConfig[mediawiki.Entity]{
+		Filter: func(_ context.Context, entity mediawiki.Entity) (bool, errors.E) {
+			return entity.Type == mediawiki.Property, nil
+		},
+	}, 1)[0]
+	expected := []string{}
+	for _, row := range all {
+		if strings.HasPrefix(ids[row], "P") {
+			expected = append(expected, row)
+		}
+	}
+	assert.NotEmpty(t, expected)
+	assert.ElementsMatch(t, expected, properties)
+
+	shards := subsetDump(t, &mediawiki.SubsetConfig[mediawiki.Entity]{ID: entityID}, 3)
+	union := []string{}
+	for i, shard := range shards {
+		assert.NotEmpty(t, shard)
+		for _, row := range shard {
+			assert.Equal(t, i, mediawiki.Shard(ids[row], 3))
+		}
+		union = append(union, shard...)
+	}
+	assert.ElementsMatch(t, all, union)
+
+	errE := mediawiki.Subset(context.Background(), &mediawiki.SubsetConfig[mediawiki.Entity]{})
+	assert.EqualError(t, errE, "no outputs")
+	errE = mediawiki.Subset(context.Background(), &mediawiki.SubsetConfig[mediawiki.Entity]{
+		SampleRate: 0.5,
+		Outputs:    []*mediawiki.DumpWriter[json.RawMessage]{{}},
+	})
+	assert.EqualError(t, errE, "ID is required for sampling and sharding")
+}
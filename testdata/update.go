@@ -21,7 +21,7 @@ const (
 )
 
 func CommonsEntities(ctx context.Context, client *retryablehttp.Client) errors.E {
-	url, errE := mediawiki.LatestCommonsEntitiesRun(ctx, client)
+	url, errE := mediawiki.LatestCommonsEntitiesRun(ctx, client, nil)
 	if errE != nil {
 		return errE
 	}
@@ -30,7 +30,7 @@ func CommonsEntities(ctx context.Context, client *retryablehttp.Client) errors.E
 }
 
 func WikidataEntities(ctx context.Context, client *retryablehttp.Client) errors.E {
-	url, errE := mediawiki.LatestWikidataEntitiesRun(ctx, client)
+	url, errE := mediawiki.LatestWikidataEntitiesRun(ctx, client, nil)
 	if errE != nil {
 		return errE
 	}
@@ -48,27 +48,29 @@ func entities(ctx context.Context, client *retryablehttp.Client, url, output str
 	}
 	defer file.Close() //nolint:errcheck
 
-	entriesCount := 0
-	_, _ = file.WriteString("[\n")
+	writer := &mediawiki.DumpWriter[json.RawMessage]{ //nolint:exhaustruct
+		Writer:   file,
+		FileType: mediawiki.JSONArray,
+	}
 
+	entriesCount := 0
 	errE := mediawiki.Process(ctx, &mediawiki.ProcessConfig[json.RawMessage]{ //nolint:exhaustruct
 		URL:                    url,
 		Client:                 client,
 		DecompressionThreads:   1,
 		DecodingThreads:        1,
 		ItemsProcessingThreads: 1,
-		Process: func(_ context.Context, j json.RawMessage) errors.E {
-			if entriesCount > maxEntries {
+		Process: func(ctx context.Context, j json.RawMessage) errors.E {
+			if entriesCount >= maxEntries {
 				cancel()
 				return nil
 			}
 			entriesCount++
 
-			_, _ = file.Write(j)
-			if entriesCount < maxEntries {
-				_, _ = file.WriteString(",")
+			errE := writer.Write(ctx, j)
+			if errE != nil {
+				return errE
 			}
-			_, _ = file.WriteString("\n")
 			if entriesCount == maxEntries {
 				cancel()
 			}
@@ -80,13 +82,12 @@ func entities(ctx context.Context, client *retryablehttp.Client, url, output str
 	if errE != nil && !errors.Is(errE, context.Canceled) {
 		return errE
 	}
-	_, _ = file.WriteString("]\n")
 
-	return nil
+	return writer.Close()
 }
 
 func Wikipedia(ctx context.Context, client *retryablehttp.Client) errors.E {
-	url, errE := mediawiki.LatestWikipediaRun(ctx, client, "enwiki", 0)
+	url, errE := mediawiki.LatestWikipediaRun(ctx, client, nil, "enwiki", 0)
 	if errE != nil {
 		return errE
 	}
@@ -100,6 +101,11 @@ func Wikipedia(ctx context.Context, client *retryablehttp.Client) errors.E {
 	}
 	defer file.Close() //nolint:errcheck
 
+	writer := &mediawiki.DumpWriter[json.RawMessage]{ //nolint:exhaustruct
+		Writer:   file,
+		FileType: mediawiki.NDJSON,
+	}
+
 	entriesCount := 0
 	errE = mediawiki.Process(ctx, &mediawiki.ProcessConfig[json.RawMessage]{ //nolint:exhaustruct
 		URL:                    url,
@@ -107,15 +113,17 @@ func Wikipedia(ctx context.Context, client *retryablehttp.Client) errors.E {
 		DecompressionThreads:   1,
 		DecodingThreads:        1,
 		ItemsProcessingThreads: 1,
-		Process: func(_ context.Context, j json.RawMessage) errors.E {
-			if entriesCount > maxEntries {
+		Process: func(ctx context.Context, j json.RawMessage) errors.E {
+			if entriesCount >= maxEntries {
 				cancel()
 				return nil
 			}
 			entriesCount++
 
-			_, _ = file.Write(j)
-			_, _ = file.WriteString("\n")
+			errE := writer.Write(ctx, j)
+			if errE != nil {
+				return errE
+			}
 			if entriesCount == maxEntries {
 				cancel()
 			}
@@ -128,7 +136,7 @@ func Wikipedia(ctx context.Context, client *retryablehttp.Client) errors.E {
 		return errE
 	}
 
-	return nil
+	return writer.Close()
 }
 
 func main() {
@@ -0,0 +1,631 @@
+package mediawiki
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	// InstanceOfProperty is Wikidata's "instance of" property.
+	InstanceOfProperty = "P31"
+	// SubclassOfProperty is Wikidata's "subclass of" property.
+	SubclassOfProperty = "P279"
+	// PropertyConstraintProperty is Wikidata's "property constraint" property.
+	PropertyConstraintProperty = "P2302"
+)
+
+// Wikidata items for property constraint types supported by Validator.
+const (
+	TypeConstraint        = "Q21503250"
+	ValueTypeConstraint   = "Q21510865"
+	FormatConstraint      = "Q21502404"
+	SingleValueConstraint = "Q19474404"
+	RangeConstraint       = "Q21510860"
+)
+
+// Qualifiers of property constraint statements.
+const (
+	classQualifier       = "P2308"
+	relationQualifier    = "P2309"
+	formatQualifier      = "P1793"
+	minimumQuantity      = "P2313"
+	maximumQuantity      = "P2312"
+	minimumDate          = "P2310"
+	maximumDate          = "P2311"
+	separatorQualifier   = "P4155"
+	exceptionQualifier   = "P2303"
+	instanceOfRelation   = "Q21503252"
+	subclassOfRelation   = "Q21514624"
+	instanceOrSubclassOf = "Q30208840"
+)
+
+type ViolationType int
+
+const (
+	// UnknownProperty is used when a property has not been seen in the first pass.
+	UnknownProperty ViolationType = iota
+	// DataTypeMismatch is used when snak's data type does not match property's data type.
+	DataTypeMismatch
+	// InvalidDataValue is used when data value does not match property's data type.
+	InvalidDataValue
+	TypeViolation
+	ValueTypeViolation
+	FormatViolation
+	SingleValueViolation
+	RangeViolation
+)
+
+func (t ViolationType) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	switch t {
+	case UnknownProperty:
+		buffer.WriteString("unknown-property")
+	case DataTypeMismatch:
+		buffer.WriteString("datatype-mismatch")
+	case InvalidDataValue:
+		buffer.WriteString("invalid-datavalue")
+	case TypeViolation:
+		buffer.WriteString("type")
+	case ValueTypeViolation:
+		buffer.WriteString("value-type")
+	case FormatViolation:
+		buffer.WriteString("format")
+	case SingleValueViolation:
+		buffer.WriteString("single-value")
+	case RangeViolation:
+		buffer.WriteString("range")
+	}
+	buffer.WriteString(`"`)
+	return buffer.Bytes(), nil
+}
+
+func (t *ViolationType) UnmarshalJSON(b []byte) error {
+	var s string
+	errE := x.Unmarshal(b, &s)
+	if errE != nil {
+		return errE
+	}
+	switch s {
+	case "unknown-property":
+		*t = UnknownProperty
+	case "datatype-mismatch":
+		*t = DataTypeMismatch
+	case "invalid-datavalue":
+		*t = InvalidDataValue
+	case "type":
+		*t = TypeViolation
+	case "value-type":
+		*t = ValueTypeViolation
+	case "format":
+		*t = FormatViolation
+	case "single-value":
+		*t = SingleValueViolation
+	case "range":
+		*t = RangeViolation
+	default:
+		errE := errors.WithMessage(ErrInvalidValue, "violation type")
+		errors.Details(errE)["value"] = s
+		return errE
+	}
+	return nil
+}
+
+// Violation is a problem found by Validator.
+//
+// Snak is "mainsnak", "qualifier", or "reference" and tells where
+// in the statement the problem was found. For constraint violations,
+// Constraint is the constraint type item ID.
+type Violation struct {
+	Entity     string        `json:"entity"`
+	Statement  string        `json:"statement,omitempty"`
+	Property   string        `json:"property"`
+	Snak       string        `json:"snak,omitempty"`
+	Type       ViolationType `json:"type"`
+	Constraint string        `json:"constraint,omitempty"`
+	Message    string        `json:"message"`
+}
+
+type propertyConstraint struct {
+	Type        string
+	Classes     []string
+	Relation    string
+	Format      *regexp.Regexp
+	MinQuantity *Amount
+	MaxQuantity *Amount
+	MinTime     *time.Time
+	MaxTime     *time.Time
+	MaxNow      bool
+	Separators  []string
+	Exceptions  map[string]bool
+}
+
+type propertyInfo struct {
+	DataType    DataType
+	Constraints []propertyConstraint
+}
+
+// Validator checks entities against data types of their properties
+// and optionally against property constraints.
+//
+// It works in two passes: first all properties have to be added using Add
+// and only then entities can be checked using Validate.
+//
+// Type and value type constraints require knowing the class hierarchy. When
+// Classes is set, Add collects "instance of" and "subclass of" statements of all
+// entities, which requires memory proportional to the size of the dump.
+// Without Classes, type and value type constraints are not checked.
+//
+// Format constraints using regular expressions not supported by Go are ignored.
+type Validator struct {
+	Constraints bool
+	Classes     bool
+
+	mu         sync.RWMutex
+	properties map[string]propertyInfo
+	classes    ClassGraph
+}
+
+// itemValues returns item IDs of values of non-deprecated statements for the property.
+func itemValues(entity Entity, property string) []string {
+	ids := []string{}
+	for _, statement := range entity.Claims[property] {
+		if statement.Rank == Deprecated || statement.MainSnak.DataValue == nil {
+			continue
+		}
+		if value, ok := statement.MainSnak.DataValue.Value.(WikiBaseEntityIDValue); ok {
+			ids = append(ids, value.ID)
+		}
+	}
+	return ids
+}
+
+// snakItemValues returns item IDs of values of snaks.
+func snakItemValues(snaks []Snak) []string {
+	ids := []string{}
+	for _, snak := range snaks {
+		if snak.DataValue == nil {
+			continue
+		}
+		if value, ok := snak.DataValue.Value.(WikiBaseEntityIDValue); ok {
+			ids = append(ids, value.ID)
+		}
+	}
+	return ids
+}
+
+func snakValue[T any](snaks []Snak) (T, bool) { //nolint:ireturn
+	for _, snak := range snaks {
+		if snak.DataValue == nil {
+			continue
+		}
+		if value, ok := snak.DataValue.Value.(T); ok {
+			return value, true
+		}
+	}
+	return *new(T), false
+}
+
+func hasSomeValue(snaks []Snak) bool {
+	for _, snak := range snaks {
+		if snak.SnakType == SomeValue {
+			return true
+		}
+	}
+	return false
+}
+
+func parseConstraint(statement Statement) (propertyConstraint, bool) {
+	if statement.Rank == Deprecated || statement.MainSnak.DataValue == nil {
+		return propertyConstraint{}, false
+	}
+	value, ok := statement.MainSnak.DataValue.Value.(WikiBaseEntityIDValue)
+	if !ok {
+		return propertyConstraint{}, false
+	}
+	constraint := propertyConstraint{
+		Type:       value.ID,
+		Exceptions: map[string]bool{},
+	}
+	for _, id := range snakItemValues(statement.Qualifiers[exceptionQualifier]) {
+		constraint.Exceptions[id] = true
+	}
+	switch constraint.Type {
+	case TypeConstraint, ValueTypeConstraint:
+		constraint.Classes = snakItemValues(statement.Qualifiers[classQualifier])
+		constraint.Relation = instanceOfRelation
+		if relations := snakItemValues(statement.Qualifiers[relationQualifier]); len(relations) > 0 {
+			constraint.Relation = relations[0]
+		}
+	case FormatConstraint:
+		format, ok := snakValue[StringValue](statement.Qualifiers[formatQualifier])
+		if !ok {
+			return propertyConstraint{}, false
+		}
+		// Format has to match the whole value.
+		re, err := regexp.Compile(`^(?:` + string(format) + `)$`)
+		if err != nil {
+			return propertyConstraint{}, false
+		}
+		constraint.Format = re
+	case SingleValueConstraint:
+		constraint.Separators = snakItemValues(statement.Qualifiers[separatorQualifier])
+	case RangeConstraint:
+		if value, ok := snakValue[QuantityValue](statement.Qualifiers[minimumQuantity]); ok {
+			constraint.MinQuantity = &value.Amount
+		}
+		if value, ok := snakValue[QuantityValue](statement.Qualifiers[maximumQuantity]); ok {
+			constraint.MaxQuantity = &value.Amount
+		}
+		if value, ok := snakValue[TimeValue](statement.Qualifiers[minimumDate]); ok {
+			constraint.MinTime = &value.Time
+		}
+		if value, ok := snakValue[TimeValue](statement.Qualifiers[maximumDate]); ok {
+			constraint.MaxTime = &value.Time
+		} else if hasSomeValue(statement.Qualifiers[maximumDate]) {
+			// Unknown maximum date means now.
+			constraint.MaxNow = true
+		}
+	default:
+		return propertyConstraint{}, false
+	}
+	return constraint, true
+}
+
+// Add adds the entity to the validator.
+//
+// For properties, their data type and (when Constraints is set) their constraints
+// are stored. For other entities, nothing is stored unless Classes is set.
+//
+// It is safe to call Add concurrently.
+func (v *Validator) Add(ctx context.Context, entity Entity) errors.E {
+	var info *propertyInfo
+	if entity.Type == Property {
+		if entity.DataType == nil {
+			errE := errors.New("property without data type")
+			errors.Details(errE)["entity"] = entity.ID
+			return errE
+		}
+		info = &propertyInfo{DataType: *entity.DataType}
+		if v.Constraints {
+			for _, statement := range entity.Claims[PropertyConstraintProperty] {
+				constraint, ok := parseConstraint(statement)
+				if ok {
+					info.Constraints = append(info.Constraints, constraint)
+				}
+			}
+		}
+	}
+	if v.Classes {
+		errE := v.classes.Add(ctx, entity)
+		if errE != nil {
+			return errE
+		}
+	}
+	if info == nil {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.properties == nil {
+		v.properties = map[string]propertyInfo{}
+	}
+	v.properties[entity.ID] = *info
+	return nil
+}
+
+// dataValueMatches returns true if the data value is of the kind used by the data type.
+func dataValueMatches(dataType DataType, value interface{}) bool {
+	switch dataType {
+	case ExternalID, String, CommonsMedia, URL, GeoShape, Math, MusicalNotation, TabularData:
+		_, ok := value.(StringValue)
+		return ok
+	case Quantity:
+		_, ok := value.(QuantityValue)
+		return ok
+	case Time:
+		_, ok := value.(TimeValue)
+		return ok
+	case GlobeCoordinate:
+		_, ok := value.(GlobeCoordinateValue)
+		return ok
+	case MonolingualText:
+		_, ok := value.(MonolingualTextValue)
+		return ok
+	case WikiBaseItem, WikiBaseProperty, WikiBaseLexeme, WikiBaseForm, WikiBaseSense, EntitySchema:
+		v, ok := value.(WikiBaseEntityIDValue)
+		if !ok {
+			return false
+		}
+		switch dataType { //nolint:exhaustive
+		case WikiBaseItem:
+			return v.Type == ItemType
+		case WikiBaseProperty:
+			return v.Type == PropertyType
+		case WikiBaseLexeme:
+			return v.Type == LexemeType
+		case WikiBaseForm:
+			return v.Type == FormType
+		case WikiBaseSense:
+			return v.Type == SenseType
+		default:
+			return v.Type == EntitySchemaType
+		}
+	}
+	return false
+}
+
+// hasClass checks if an entity with the given direct classes satisfies the relation to
+// any of the classes.
+func (v *Validator) hasClass(instanceOf, subclassOf []string, relation string, classes []string) bool {
+	var candidates []string
+	switch relation {
+	case subclassOfRelation:
+		candidates = subclassOf
+	case instanceOrSubclassOf:
+		candidates = append(append([]string{}, instanceOf...), subclassOf...)
+	default:
+		candidates = instanceOf
+	}
+	candidates = append(candidates, v.classes.AllSuperclasses(candidates...)...)
+	for _, class := range classes {
+		if slices.Contains(candidates, class) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Validator) checkSnak(entity Entity, statement Statement, location string, snak Snak) []Violation {
+	violation := Violation{
+		Entity:    entity.ID,
+		Statement: statement.ID,
+		Property:  snak.Property,
+		Snak:      location,
+	}
+	info, ok := v.properties[snak.Property]
+	if !ok {
+		violation.Type = UnknownProperty
+		violation.Message = "unknown property"
+		return []Violation{violation}
+	}
+	if snak.DataType != nil && *snak.DataType != info.DataType {
+		violation.Type = DataTypeMismatch
+		violation.Message = fmt.Sprintf("snak data type %s does not match property data type %s", mustMarshal(*snak.DataType), mustMarshal(info.DataType))
+		return []Violation{violation}
+	}
+	if snak.SnakType == Value {
+		if snak.DataValue == nil {
+			violation.Type = InvalidDataValue
+			violation.Message = "missing data value"
+			return []Violation{violation}
+		}
+		if errorValue, ok := snak.DataValue.Value.(ErrorValue); ok {
+			violation.Type = InvalidDataValue
+			violation.Message = string(errorValue)
+			return []Violation{violation}
+		}
+		if !dataValueMatches(info.DataType, snak.DataValue.Value) {
+			violation.Type = InvalidDataValue
+			violation.Message = fmt.Sprintf("data value does not match property data type %s", mustMarshal(info.DataType))
+			return []Violation{violation}
+		}
+	}
+	return nil
+}
+
+// mustMarshal returns JSON string of a value which always marshals.
+func mustMarshal(value interface{}) string {
+	data, errE := x.MarshalWithoutEscapeHTML(value)
+	if errE != nil {
+		panic(errE)
+	}
+	return string(bytes.Trim(data, `"`))
+}
+
+func (v *Validator) checkConstraint(entity Entity, property string, constraint propertyConstraint, statements []Statement) []Violation { //nolint:gocognit,cyclop
+	if constraint.Exceptions[entity.ID] {
+		return nil
+	}
+	violations := []Violation{}
+	add := func(statement Statement, t ViolationType, message string) {
+		violations = append(violations, Violation{
+			Entity:     entity.ID,
+			Statement:  statement.ID,
+			Property:   property,
+			Snak:       "mainsnak",
+			Type:       t,
+			Constraint: constraint.Type,
+			Message:    message,
+		})
+	}
+
+	switch constraint.Type {
+	case TypeConstraint:
+		if !v.Classes || len(statements) == 0 {
+			return nil
+		}
+		if !v.hasClass(itemValues(entity, InstanceOfProperty), itemValues(entity, SubclassOfProperty), constraint.Relation, constraint.Classes) {
+			add(statements[0], TypeViolation, "entity does not have required class")
+		}
+	case ValueTypeConstraint:
+		if !v.Classes {
+			return nil
+		}
+		for _, statement := range statements {
+			if statement.MainSnak.DataValue == nil {
+				continue
+			}
+			value, ok := statement.MainSnak.DataValue.Value.(WikiBaseEntityIDValue)
+			if !ok {
+				continue
+			}
+			instanceOf, subclassOf := v.classes.Classes(value.ID), v.classes.AllSuperclasses(value.ID)
+			if len(instanceOf) == 0 && len(subclassOf) == 0 {
+				// We do not know anything about the value.
+				continue
+			}
+			if !v.hasClass(instanceOf, subclassOf, constraint.Relation, constraint.Classes) {
+				add(statement, ValueTypeViolation, fmt.Sprintf("value %s does not have required class", value.ID))
+			}
+		}
+	case FormatConstraint:
+		for _, statement := range statements {
+			if statement.MainSnak.DataValue == nil {
+				continue
+			}
+			value, ok := statement.MainSnak.DataValue.Value.(StringValue)
+			if ok && !constraint.Format.MatchString(string(value)) {
+				add(statement, FormatViolation, fmt.Sprintf("value %q does not match format", string(value)))
+			}
+		}
+	case SingleValueConstraint:
+		// Statements with different values of separator qualifiers are allowed.
+		groups := map[string][]Statement{}
+		keys := []string{}
+		for _, statement := range statements {
+			key := ""
+			for _, separator := range constraint.Separators {
+				hash, errE := snakMapHash(map[string][]Snak{separator: statement.Qualifiers[separator]}, false)
+				if errE != nil {
+					continue
+				}
+				key += hash
+			}
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], statement)
+		}
+		for _, key := range keys {
+			if len(groups[key]) > 1 {
+				for _, statement := range groups[key] {
+					add(statement, SingleValueViolation, fmt.Sprintf("property has %d values", len(groups[key])))
+				}
+			}
+		}
+	case RangeConstraint:
+		for _, statement := range statements {
+			if statement.MainSnak.DataValue == nil {
+				continue
+			}
+			switch value := statement.MainSnak.DataValue.Value.(type) {
+			case QuantityValue:
+				if constraint.MinQuantity != nil && value.Amount.Cmp(&constraint.MinQuantity.Rat) < 0 {
+					add(statement, RangeViolation, fmt.Sprintf("value %s is less than minimum %s", value.Amount.String(), constraint.MinQuantity.String()))
+				}
+				if constraint.MaxQuantity != nil && value.Amount.Cmp(&constraint.MaxQuantity.Rat) > 0 {
+					add(statement, RangeViolation, fmt.Sprintf("value %s is greater than maximum %s", value.Amount.String(), constraint.MaxQuantity.String()))
+				}
+			case TimeValue:
+				if constraint.MinTime != nil && value.Time.Before(*constraint.MinTime) {
+					add(statement, RangeViolation, fmt.Sprintf("value %s is before minimum %s",
+						formatTime(value.Time, value.Precision), formatTime(*constraint.MinTime, Second)))
+				}
+				maxTime := constraint.MaxTime
+				if constraint.MaxNow {
+					now := time.Now().UTC()
+					maxTime = &now
+				}
+				if maxTime != nil && value.Time.After(*maxTime) {
+					add(statement, RangeViolation, fmt.Sprintf("value %s is after maximum %s",
+						formatTime(value.Time, value.Precision), formatTime(*maxTime, Second)))
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// Validate checks the entity and returns all found violations.
+//
+// Data types are checked for all snaks, constraints only for main snaks
+// of non-deprecated statements.
+//
+// It is safe to call Validate concurrently, but only after all calls to Add finished.
+func (v *Validator) Validate(entity Entity) []Violation {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	violations := []Violation{}
+	for _, property := range slices.SortedFunc(maps.Keys(entity.Claims), CompareEntityIDs) {
+		statements := entity.Claims[property]
+		nonDeprecated := []Statement{}
+		for _, statement := range statements {
+			violations = append(violations, v.checkSnak(entity, statement, "mainsnak", statement.MainSnak)...)
+			for _, qualifier := range OrderedProperties(statement.Qualifiers, statement.QualifiersOrder) {
+				for _, snak := range statement.Qualifiers[qualifier] {
+					violations = append(violations, v.checkSnak(entity, statement, "qualifier", snak)...)
+				}
+			}
+			for _, reference := range statement.References {
+				for _, p := range OrderedProperties(reference.Snaks, reference.SnaksOrder) {
+					for _, snak := range reference.Snaks[p] {
+						violations = append(violations, v.checkSnak(entity, statement, "reference", snak)...)
+					}
+				}
+			}
+			if statement.Rank != Deprecated {
+				nonDeprecated = append(nonDeprecated, statement)
+			}
+		}
+		for _, constraint := range v.properties[property].Constraints {
+			violations = append(violations, v.checkConstraint(entity, property, constraint, nonDeprecated)...)
+		}
+	}
+	return violations
+}
+
+// ValidateDumpConfig is a configuration for ValidateDump.
+//
+// The dump is read twice, so Dump.Path should be set to cache
+// the dump locally. Process reads a dump and is by default
+// ProcessWikidataDump. Validator is by default an empty Validator
+// which checks only data types.
+type ValidateDumpConfig struct {
+	Dump      ProcessDumpConfig
+	Process   func(context.Context, *ProcessDumpConfig, func(context.Context, Entity) errors.E) errors.E
+	Validator *Validator
+}
+
+// ValidateDump validates all entities in the dump.
+//
+// In the first pass it adds all entities to the validator and in the
+// second pass it calls processViolations for every entity with violations.
+func ValidateDump(
+	ctx context.Context, config *ValidateDumpConfig, processViolations func(context.Context, Entity, []Violation) errors.E,
+) errors.E {
+	process := config.Process
+	if process == nil {
+		process = ProcessWikidataDump
+	}
+	validator := config.Validator
+	if validator == nil {
+		validator = &Validator{}
+	}
+
+	dump := config.Dump
+	errE := process(ctx, &dump, validator.Add)
+	if errE != nil {
+		return errE
+	}
+
+	dump = config.Dump
+	return process(ctx, &dump, func(ctx context.Context, entity Entity) errors.E {
+		violations := validator.Validate(entity)
+		if len(violations) == 0 {
+			return nil
+		}
+		return processViolations(ctx, entity, violations)
+	})
+}
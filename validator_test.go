@@ -0,0 +1,170 @@
+package mediawiki_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+var validatorTestEntities = []string{ //nolint:gochecknoglobals
+	// Property "population" with single-value (separated by point in time) and range constraints.
+	`{"id": "P1082", "type": "property", "datatype": "quantity", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {"P2302": [
+		{"id": "P1082$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P2302", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q19474404"}}},
+			"qualifiers": {"P4155": [{"snaktype": "value", "property": "P4155", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "property", "id": "P585"}}}]}},
+		{"id": "P1082$2", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P2302", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q21510860"}}},
+			"qualifiers": {"P2313": [{"snaktype": "value", "property": "P2313", "datavalue": {"type": "quantity", "value": {"amount": "+0", "unit": "1"}}}]}},
+		{"id": "P1082$3", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P2302", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q21503250"}}},
+			"qualifiers": {
+				"P2308": [{"snaktype": "value", "property": "P2308", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q486972"}}}],
+				"P2309": [{"snaktype": "value", "property": "P2309", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q21503252"}}}]
+			}}
+	]}}`,
+	// Property "point in time".
+	`{"id": "P585", "type": "property", "datatype": "time", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z"}`,
+	// Property "ISO 3166-1 alpha-2 code" with format constraint.
+	`{"id": "P297", "type": "property", "datatype": "external-id", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {"P2302": [
+		{"id": "P297$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P2302", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q21502404"}}},
+			"qualifiers": {"P1793": [{"snaktype": "value", "property": "P1793", "datavalue": {"type": "string", "value": "[A-Z]{2}"}}]}}
+	]}}`,
+	// Property "capital" with value type constraint.
+	`{"id": "P36", "type": "property", "datatype": "wikibase-item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {"P2302": [
+		{"id": "P36$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P2302", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q21510865"}}},
+			"qualifiers": {
+				"P2308": [{"snaktype": "value", "property": "P2308", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q486972"}}}],
+				"P2309": [{"snaktype": "value", "property": "P2309", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q30208840"}}}],
+				"P2303": [{"snaktype": "value", "property": "P2303", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q3"}}}]
+			}}
+	]}}`,
+	`{"id": "P31", "type": "property", "datatype": "wikibase-item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z"}`,
+	`{"id": "P279", "type": "property", "datatype": "wikibase-item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z"}`,
+	// Class hierarchy: city (Q515) is a subclass of human settlement (Q486972).
+	`{"id": "Q515", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {"P279": [
+		{"id": "Q515$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P279", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q486972"}}}}
+	]}}`,
+	// Ljubljana is a city.
+	`{"id": "Q437", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {"P31": [
+		{"id": "Q437$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P31", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q515"}}}}
+	]}}`,
+	// Human (Q5) is not a settlement.
+	`{"id": "Q42", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {"P31": [
+		{"id": "Q42$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P31", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q5"}}}}
+	]}}`,
+}
+
+const validatorTestEntity = `{"id": "Q215", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {
+	"P31": [{"id": "Q215$0", "type": "statement", "rank": "normal",
+		"mainsnak": {"snaktype": "value", "property": "P31", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q6256"}}}}],
+	"P297": [
+		{"id": "Q215$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P297", "datatype": "external-id", "datavalue": {"type": "string", "value": "SI"}}},
+		{"id": "Q215$2", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P297", "datavalue": {"type": "string", "value": "si"}}},
+		{"id": "Q215$3", "type": "statement", "rank": "deprecated",
+			"mainsnak": {"snaktype": "value", "property": "P297", "datavalue": {"type": "string", "value": "Slo"}}}
+	],
+	"P36": [
+		{"id": "Q215$4", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P36", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q437"}}}},
+		{"id": "Q215$5", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P36", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q42"}}}}
+	],
+	"P1082": [
+		{"id": "Q215$6", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P1082", "datavalue": {"type": "quantity", "value": {"amount": "+2100000", "unit": "1"}}},
+			"qualifiers": {"P585": [{"snaktype": "value", "property": "P585", "datavalue": {"type": "time", "value": {"time": "+2020-00-00T00:00:00Z", "precision": 9, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}]}},
+		{"id": "Q215$7", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P1082", "datavalue": {"type": "quantity", "value": {"amount": "-5", "unit": "1"}}},
+			"qualifiers": {"P585": [{"snaktype": "value", "property": "P585", "datavalue": {"type": "time", "value": {"time": "+2020-00-00T00:00:00Z", "precision": 9, "calendarmodel": "http://www.wikidata.org/entity/Q1985727"}}}]},
+			"references": [{"snaks": {"P854": [{"snaktype": "value", "property": "P854", "datavalue": {"type": "string", "value": "https://example.com"}}]}}]},
+		{"id": "Q215$8", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P1082", "datavalue": {"type": "string", "value": "many"}},
+			"qualifiers": {"P585": [{"snaktype": "value", "property": "P585", "datatype": "string", "datavalue": {"type": "string", "value": "2021"}}]}}
+	]
+}}`
+
+func TestValidator(t *testing.T) {
+	t.Parallel()
+
+	validator := &mediawiki.Validator{Constraints: true, Classes: true}
+	for _, data := range validatorTestEntities {
+		errE := validator.Add(context.Background(), parseEntity(t, data))
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	violations := validator.Validate(parseEntity(t, validatorTestEntity))
+	assert.Equal(t, []mediawiki.Violation{
+		{Entity: "Q215", Statement: "Q215$5", Property: "P36", Snak: "mainsnak", Type: mediawiki.ValueTypeViolation, Constraint: mediawiki.ValueTypeConstraint, Message: "value Q42 does not have required class"},
+		{Entity: "Q215", Statement: "Q215$2", Property: "P297", Snak: "mainsnak", Type: mediawiki.FormatViolation, Constraint: mediawiki.FormatConstraint, Message: `value "si" does not match format`},
+		{Entity: "Q215", Statement: "Q215$7", Property: "P854", Snak: "reference", Type: mediawiki.UnknownProperty, Message: "unknown property"},
+		{Entity: "Q215", Statement: "Q215$8", Property: "P1082", Snak: "mainsnak", Type: mediawiki.InvalidDataValue, Message: "data value does not match property data type quantity"},
+		{Entity: "Q215", Statement: "Q215$8", Property: "P585", Snak: "qualifier", Type: mediawiki.DataTypeMismatch, Message: "snak data type string does not match property data type time"},
+		{Entity: "Q215", Statement: "Q215$6", Property: "P1082", Snak: "mainsnak", Type: mediawiki.SingleValueViolation, Constraint: mediawiki.SingleValueConstraint, Message: "property has 2 values"},
+		{Entity: "Q215", Statement: "Q215$7", Property: "P1082", Snak: "mainsnak", Type: mediawiki.SingleValueViolation, Constraint: mediawiki.SingleValueConstraint, Message: "property has 2 values"},
+		{Entity: "Q215", Statement: "Q215$7", Property: "P1082", Snak: "mainsnak", Type: mediawiki.RangeViolation, Constraint: mediawiki.RangeConstraint, Message: "value -5 is less than minimum 0"},
+		{Entity: "Q215", Statement: "Q215$6", Property: "P1082", Snak: "mainsnak", Type: mediawiki.TypeViolation, Constraint: mediawiki.TypeConstraint, Message: "entity does not have required class"},
+	}, violations)
+
+	// Ljubljana is a human settlement, so there are no violations. Q3 is an exception.
+	violations = validator.Validate(parseEntity(t, `{"id": "Q3", "type": "item", "lastrevid": 1, "modified": "2020-01-01T00:00:00Z", "claims": {
+		"P31": [{"id": "Q3$0", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P31", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q515"}}}}],
+		"P1082": [{"id": "Q3$1", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P1082", "datavalue": {"type": "quantity", "value": {"amount": "+300000", "unit": "1"}}}}],
+		"P36": [{"id": "Q3$2", "type": "statement", "rank": "normal",
+			"mainsnak": {"snaktype": "value", "property": "P36", "datavalue": {"type": "wikibase-entityid", "value": {"entity-type": "item", "id": "Q42"}}}}]
+	}}`))
+	assert.Empty(t, violations)
+
+	// Without constraints, only data types are checked.
+	validator = &mediawiki.Validator{}
+	for _, data := range validatorTestEntities {
+		errE := validator.Add(context.Background(), parseEntity(t, data))
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	violations = validator.Validate(parseEntity(t, validatorTestEntity))
+	assert.Len(t, violations, 3)
+}
+
+func TestValidateDump(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dump.json")
+	writeNDJSON(t, path, append(append([]string{}, validatorTestEntities...), validatorTestEntity)...)
+
+	violations := map[string][]mediawiki.Violation{}
+	errE := mediawiki.ValidateDump(context.Background(), &mediawiki.ValidateDumpConfig{
+		Dump: mediawiki.ProcessDumpConfig{Path: path},
+		Process: func(ctx context.Context, config *mediawiki.ProcessDumpConfig, processEntity func(context.Context, mediawiki.Entity) errors.E) errors.E {
+			return mediawiki.Process(ctx, &mediawiki.ProcessConfig[mediawiki.Entity]{
+				Path:        config.Path,
+				Process:     processEntity,
+				FileType:    mediawiki.NDJSON,
+				Compression: mediawiki.NoCompression,
+			})
+		},
+		Validator: &mediawiki.Validator{Constraints: true},
+	}, func(_ context.Context, entity mediawiki.Entity, v []mediawiki.Violation) errors.E {
+		violations[entity.ID] = v
+		return nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// Properties used for constraints are not in the dump.
+	require.Len(t, violations, 4)
+	assert.Equal(t, mediawiki.UnknownProperty, violations["P297"][0].Type)
+	// Type and value type constraints are not checked without classes.
+	assert.Len(t, violations["Q215"], 7)
+}
@@ -8,12 +8,14 @@ import (
 )
 
 // LatestWikidataEntitiesRun returns URL of the latest run of Wikidata entities JSON dump.
-func LatestWikidataEntitiesRun(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+// Source can be nil to use https://dumps.wikimedia.org/.
+func LatestWikidataEntitiesRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource) (string, errors.E) {
 	return latestRun(
 		ctx,
 		client,
-		"https://dumps.wikimedia.org/wikidatawiki/entities/",
-		"https://dumps.wikimedia.org/wikidatawiki/entities/%s/wikidata-%s-all.json.bz2",
+		source,
+		"wikidatawiki/entities/",
+		"wikidatawiki/entities/%s/wikidata-%s-all.json.bz2",
 	)
 }
 
@@ -34,5 +36,10 @@ func ProcessWikidataDump(
 		Progress:               config.Progress,
 		FileType:               JSONArray,
 		Compression:            BZIP2,
+		Checksum:               config.Checksum,
+		Mirrors:                config.Mirrors,
+		Sources:                config.Sources,
+		SourcesThreads:         config.SourcesThreads,
+		Index:                  config.Index,
 	})
 }
@@ -32,7 +32,7 @@ func TestProcessWikidataDumpLatest(t *testing.T) {
 		req.Header.Set("User-Agent", testUserAgent)
 	}
 
-	url, errE := mediawiki.LatestWikidataEntitiesRun(context.Background(), client)
+	url, errE := mediawiki.LatestWikidataEntitiesRun(context.Background(), client, nil)
 	require.NoError(t, errE, "% -+#.1v", errE)
 
 	cacheDir := t.TempDir()
@@ -10,24 +10,28 @@ import (
 
 // LatestWikipediaRun returns URL of the latest run of Wikimedia Enterprise HTML dump.
 // Use "enwiki" for English Wikipedia and namespace 0 for its articles.
-func LatestWikipediaRun(ctx context.Context, client *retryablehttp.Client, language string, namespace int) (string, errors.E) {
-	format := fmt.Sprintf("https://dumps.wikimedia.org/other/enterprise_html/runs/%%s/%s-NS%d-%%s-ENTERPRISE-HTML.json.tar.gz", language, namespace)
+// Source can be nil to use https://dumps.wikimedia.org/.
+func LatestWikipediaRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource, language string, namespace int) (string, errors.E) {
+	format := fmt.Sprintf("other/enterprise_html/runs/%%s/%s-NS%d-%%s-ENTERPRISE-HTML.json.tar.gz", language, namespace)
 	return latestRun(
 		ctx,
 		client,
-		"https://dumps.wikimedia.org/other/enterprise_html/runs/",
+		source,
+		"other/enterprise_html/runs/",
 		format,
 	)
 }
 
 // LatestWikipediaImageMetadataRun returns URL of the latest run of Wikipedia image table dump.
 // Use "enwiki" for English Wikipedia.
-func LatestWikipediaImageMetadataRun(ctx context.Context, client *retryablehttp.Client, language string) (string, errors.E) {
-	format := fmt.Sprintf("https://dumps.wikimedia.org/enwiki/%%s/%s-%%s-image.sql.gz", language)
+// Source can be nil to use https://dumps.wikimedia.org/.
+func LatestWikipediaImageMetadataRun(ctx context.Context, client *retryablehttp.Client, source *DumpSource, language string) (string, errors.E) {
+	format := fmt.Sprintf("%s/%%s/%s-%%s-image.sql.gz", language, language)
 	return latestRun(
 		ctx,
 		client,
-		fmt.Sprintf("https://dumps.wikimedia.org/%s/", language),
+		source,
+		language+"/",
 		format,
 	)
 }
@@ -49,5 +53,10 @@ func ProcessWikipediaDump(
 		Progress:               config.Progress,
 		FileType:               NDJSON,
 		Compression:            GZIPTar,
+		Checksum:               config.Checksum,
+		Mirrors:                config.Mirrors,
+		Sources:                config.Sources,
+		SourcesThreads:         config.SourcesThreads,
+		Index:                  config.Index,
 	})
 }
@@ -32,7 +32,7 @@ func TestProcessWikipediaDumpLatest(t *testing.T) {
 		req.Header.Set("User-Agent", testUserAgent)
 	}
 
-	url, errE := mediawiki.LatestWikipediaRun(context.Background(), client, "enwiki", 0)
+	url, errE := mediawiki.LatestWikipediaRun(context.Background(), client, nil, "enwiki", 0)
 	require.NoError(t, errE, "% -+#.1v", errE)
 
 	cacheDir := t.TempDir()
@@ -162,3 +162,20 @@ func TestProcessWikipediaDumpExplicit(t *testing.T) {
 	require.NoError(t, errE, "% -+#.1v", errE)
 	assert.Equal(t, int64(10), articleCounter)
 }
+
+func TestLatestWikipediaImageMetadataRun(t *testing.T) {
+	t.Parallel()
+
+	ts := newMirror(t, 0, map[string]string{
+		"/dewiki/": `<html><body><a href="../">../</a><a href="20240101/">20240101/</a></body></html>`,
+		"/dewiki/20240101/dewiki-20240101-image.sql.gz": "data",
+	})
+
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = 0
+
+	url, errE := mediawiki.LatestWikipediaImageMetadataRun(context.Background(), client, &mediawiki.DumpSource{BaseURL: ts.URL}, "dewiki")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, ts.URL+"/dewiki/20240101/dewiki-20240101-image.sql.gz", url)
+}